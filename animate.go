@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// animationLength is how long an animation takes to draw the whole plot,
+// however long the plot itself takes.
+const animationLength = 20 * time.Second
+
+// Animate writes paths as an SVG that draws them in the order a plotter
+// would, each taking the time PlotTime gives it: drawing at the plotter's
+// speed with the pen down, moving between paths with it up, and lowering
+// and raising it. The whole plot is sped up or slowed down to play in
+// animationLength. Each move with the pen up is left as a faint red line,
+// so a plot that zigzags across the page shows it.
+func Animate(paths []Polyline, page Page) ([]byte, error) {
+	secs := func(d time.Duration) float64 { return d.Seconds() }
+	var total float64
+	for i, p := range paths {
+		total += polylineLength(p)/plotDrawSpeed + secs(plotPenLift)
+		if i > 0 && len(p) > 0 && len(paths[i-1]) > 0 {
+			total += distance(paths[i-1][len(paths[i-1])-1], p[0]) / plotTravelSpeed
+		}
+	}
+	k := 1.0
+	if total > 0 {
+		k = secs(animationLength) / total
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%g" height="%g" viewBox="%g %g %g %g">`+"\n",
+		page.Size.X, page.Size.Y, page.Origin.X, page.Origin.Y, page.Size.X, page.Size.Y)
+	fmt.Fprintf(&b, `  <rect x="%g" y="%g" width="%g" height="%g" fill="white"/>`+"\n", page.Origin.X, page.Origin.Y, page.Size.X, page.Size.Y)
+
+	at := 0.0
+	for i, p := range paths {
+		if len(p) == 0 {
+			continue
+		}
+		if i > 0 && len(paths[i-1]) > 0 {
+			from := paths[i-1][len(paths[i-1])-1]
+			travel := distance(from, p[0]) / plotTravelSpeed * k
+			if travel > 0 {
+				fmt.Fprintf(&b, `  <line x1="%.2f" y1="%.2f" x2="%.2f" y2="%.2f" stroke="red" stroke-width="%g" opacity="0"><set attributeName="opacity" to="0.3" begin="%.3fs" fill="freeze"/></line>`+"\n",
+					from.X, from.Y, p[0].X, p[0].Y, page.Pen/2, at)
+			}
+			at += travel
+		}
+		at += secs(plotPenLift) / 2 * k
+
+		draw := polylineLength(p) / plotDrawSpeed * k
+		if len(p) == 1 {
+			p = Polyline{p[0], p[0]}
+		}
+		fmt.Fprintf(&b, `  <path d="%s" fill="none" stroke="black" stroke-width="%g" stroke-linecap="round" pathLength="1" stroke-dasharray="1" stroke-dashoffset="1">`, pathD(p), page.Pen)
+		fmt.Fprintf(&b, `<animate attributeName="stroke-dashoffset" from="1" to="0" begin="%.3fs" dur="%.3fs" fill="freeze"/></path>`+"\n", at, math.Max(draw, 0.001))
+		at += draw + secs(plotPenLift)/2*k
+	}
+	b.WriteString("</svg>\n")
+	return []byte(b.String()), nil
+}
+
+func polylineLength(p Polyline) float64 {
+	length := 0.0
+	for i := 1; i < len(p); i++ {
+		length += distance(p[i-1], p[i])
+	}
+	return length
+}
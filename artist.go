@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"regexp"
 	"strings"
@@ -8,24 +10,121 @@ import (
 
 const maxRetries = 3
 
-func Generate(client LLMClient, description string, log *Logger) (*SketchResult, error) {
-	messages := []Message{{Role: "user", Content: description}}
+// refusalPattern matches the usual openings of a declined request. It is
+// only consulted when a response carries no code at all.
+var refusalPattern = regexp.MustCompile(`(?i)^\s*(I'm sorry|I am sorry|I apologi[sz]e|I can(not|'t)|I won't|I will not|I'm (not able|unable)|I am (not able|unable)|I must decline)`)
+
+var fencePattern = regexp.MustCompile("(?s)```(?:sketchlang)?\\s*\\n(.*?)\\n```")
+
+// Sampling temperatures: a first attempt gets room to compose, while
+// re-asks repair an earlier answer and should change as little as possible.
+// -thinking gives the first attempt an extended thinking budget to plan
+// the composition with. Picking between variations should be repeatable.
+var (
+	composeOptions  = Temperature(1.0)
+	repairOptions   = Temperature(0.2)
+	finishOptions   = Temperature(0.5)
+	critiqueOptions = Temperature(0.5)
+	passOptions     = Temperature(0.7)
+	judgeOptions    = Temperature(0)
+)
+
+func attemptOptions(attempt int) RequestOptions {
+	if attempt == 0 {
+		return composeOptions
+	}
+	return repairOptions
+}
+
+// sketchSchema is the JSON shape of a sketch in JSON mode.
+var sketchSchema = map[string]any{
+	"type":     "object",
+	"required": []string{"title", "code"},
+	"properties": map[string]any{
+		"title":   map[string]any{"type": "string", "minLength": 1},
+		"summary": map[string]any{"type": "string"},
+		"code":    map[string]any{"type": "string", "minLength": 1},
+	},
+}
+
+// GenerateJSON is Generate with the sketch requested as a JSON object
+// instead of tagged text; pair it with a system prompt in jsonFormat. Invalid JSON is
+// re-asked with the exact problem.
+func GenerateJSON(ctx context.Context, client LLMClient, system, description string, log *Logger, refs ...Image) (*SketchResult, error) {
+	messages := []Message{{Role: "user", Content: description, Images: refs}}
+
+	for attempt := 0; ; attempt++ {
+		var out struct {
+			Title   string `json:"title"`
+			Summary string `json:"summary"`
+			Code    string `json:"code"`
+		}
+		err := CompleteJSON(ctx, client, system, messages, attemptOptions(attempt), sketchSchema, &out)
+
+		var invalid *JSONError
+		if errors.As(err, &invalid) {
+			if refusalPattern.MatchString(invalid.Text) {
+				return nil, refusal(invalid.Text)
+			}
+			if attempt < maxRetries {
+				log.Warn("JSON error (attempt %d/%d): %v", attempt+1, maxRetries+1, invalid.Err)
+				messages = append(messages,
+					Message{Role: "assistant", Content: invalid.Text},
+					Message{Role: "user", Content: fmt.Sprintf("That is not valid: %v. Reply with only the corrected JSON object.", invalid.Err)},
+				)
+				continue
+			}
+			return nil, fmt.Errorf("parse failed after %d attempts: %w", maxRetries+1, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &SketchResult{Title: strings.TrimSpace(out.Title), Summary: out.Summary, Code: strings.TrimSpace(out.Code)}, nil
+	}
+}
+
+// complete returns the text of a response, warning when it was cut off at
+// the output limit. The request is first fitted to the context window.
+func complete(ctx context.Context, client LLMClient, system string, messages []Message, opts RequestOptions) (string, error) {
+	messages, opts, err := fitContext(ctx, client, system, messages, opts)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Complete(ctx, system, messages, opts)
+	if err != nil {
+		return "", err
+	}
+	if resp.StopReason == StopLength {
+		printf("warning: %s response cut off at the output limit", client.Model())
+	}
+	return resp.Text, nil
+}
+
+// Generate asks for a sketch of description, showing the model any
+// reference images with it, and re-asks until the response parses.
+func Generate(ctx context.Context, client LLMClient, system, description string, log *Logger, refs ...Image) (*SketchResult, error) {
+	messages := []Message{{Role: "user", Content: description, Images: refs}}
+	var partial *SketchResult
 	var lastErr error
 
 	for attempt := 0; attempt <= maxRetries; attempt++ {
-		content, err := client.Complete(systemPrompt(), messages)
+		content, err := complete(ctx, client, system, messages, attemptOptions(attempt))
 		if err != nil {
 			return nil, err
 		}
 
-		result, err := parseResponse(content)
+		result, err := parseResponse(content, partial)
+		if err != nil && result.Code == "" && refusalPattern.MatchString(content) {
+			return nil, refusal(content)
+		}
 		if err != nil {
 			lastErr = err
+			partial = result
 			if attempt < maxRetries {
 				log.Warn("parse error (attempt %d/%d): %v", attempt+1, maxRetries+1, err)
 				messages = append(messages,
 					Message{Role: "assistant", Content: content},
-					Message{Role: "user", Content: fmt.Sprintf("Parse error: %v\n\nPlease fix and include <title>, <summary>, and <code> tags.", err)},
+					Message{Role: "user", Content: reask(err)},
 				)
 				continue
 			}
@@ -38,24 +137,29 @@ func Generate(client LLMClient, description string, log *Logger) (*SketchResult,
 	return nil, lastErr
 }
 
-func GenerateWithValidation(client LLMClient, description string, validate func(string) (bool, []string), log *Logger) (*SketchResult, error) {
+func GenerateWithValidation(ctx context.Context, client LLMClient, system, description string, validate func(string) (bool, []string), log *Logger) (*SketchResult, error) {
 	messages := []Message{{Role: "user", Content: description}}
+	var partial *SketchResult
 	var lastErr error
 
 	for attempt := 0; attempt <= maxRetries; attempt++ {
-		content, err := client.Complete(systemPrompt(), messages)
+		content, err := complete(ctx, client, system, messages, attemptOptions(attempt))
 		if err != nil {
 			return nil, err
 		}
 
-		result, err := parseResponse(content)
+		result, err := parseResponse(content, partial)
+		if err != nil && result.Code == "" && refusalPattern.MatchString(content) {
+			return nil, refusal(content)
+		}
 		if err != nil {
 			lastErr = err
+			partial = result
 			if attempt < maxRetries {
 				log.Warn("parse error (attempt %d/%d): %v", attempt+1, maxRetries+1, err)
 				messages = append(messages,
 					Message{Role: "assistant", Content: content},
-					Message{Role: "user", Content: fmt.Sprintf("Parse error: %v\n\nPlease fix.", err)},
+					Message{Role: "user", Content: reask(err)},
 				)
 				continue
 			}
@@ -83,65 +187,309 @@ func GenerateWithValidation(client LLMClient, description string, validate func(
 	return nil, lastErr
 }
 
-func systemPrompt() string {
-	return fmt.Sprintf(`You are an expert sketch artist using SketchLang.
+// Repair feeds compiler errors and the failing code back to the model for
+// up to attempts rounds, checking each fix with validate, and returns the
+// first sketch that compiles.
+func Repair(ctx context.Context, client LLMClient, system, description string, result *SketchResult, errs []string, validate func(string) (bool, []string), attempts int, log *Logger) (*SketchResult, error) {
+	return revise(ctx, client, system, "This sketch fails to compile.", "Compilation errors", "still fails to compile", description, result, errs, validate, attempts, log)
+}
+
+// Conform is Repair for a sketch that compiles but breaks some of its
+// constraints: violations go back to the model for up to attempts rounds,
+// and validate must check the constraints as well as compile the code.
+func Conform(ctx context.Context, client LLMClient, system, description string, result *SketchResult, violations []string, validate func(string) (bool, []string), attempts int, log *Logger) (*SketchResult, error) {
+	return revise(ctx, client, system, "This sketch breaks some of its constraints.", "Broken constraints", "constraints still broken", description, result, violations, validate, attempts, log)
+}
+
+// revise asks for a minimal fix of the problems errs lists under heading,
+// re-asking until validate accepts the code or attempts run out, when it
+// fails with the failure message.
+func revise(ctx context.Context, client LLMClient, system, intro, heading, failure, description string, result *SketchResult, errs []string, validate func(string) (bool, []string), attempts int, log *Logger) (*SketchResult, error) {
+	messages := []Message{{Role: "user", Content: fmt.Sprintf(`%s
+
+REQUEST: %s
+
+<code>
+%s
+</code>
 
+%s:
 %s
 
-Create a COMPLETE, EXTREMELY DETAILED sketch.
+Fix the problems, changing as little as possible. Reply with the complete
+corrected sketch in a <code> block.`, intro, description, result.Code, heading, strings.Join(errs, "\n"))}}
+
+	for attempt := 1; attempt <= attempts; attempt++ {
+		content, err := complete(ctx, client, system, messages, repairOptions)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, Message{Role: "assistant", Content: content})
+
+		code, err := extractCode(content)
+		if err != nil || code == "" {
+			log.Warn("repair %d/%d: no code in response", attempt, attempts)
+			messages = append(messages, Message{Role: "user", Content: "Reply with the complete corrected sketch in <code>...</code>."})
+			continue
+		}
+		if ok, e := validate(code); !ok {
+			errs = e
+			log.Warn("repair %d/%d: %v", attempt, attempts, errs)
+			messages = append(messages, Message{Role: "user", Content: fmt.Sprintf("Still failing:\n%s\n\nFix and provide the complete corrected code.", strings.Join(errs, "\n"))})
+			continue
+		}
+
+		repaired := *result
+		repaired.Code = code
+		log.Info("repaired after %d attempts", attempt)
+		return &repaired, nil
+	}
+	return nil, fmt.Errorf("%s after %d repair attempts: %s", failure, attempts, strings.Join(errs, "\n"))
+}
+
+// Finish asks for a few finishing touches to a complete sketch, given the
+// render statistics, and returns the sketch with them appended. validate
+// checks the combined code; if it fails, the touches are dropped and the
+// original result is returned with the error.
+func Finish(ctx context.Context, client LLMClient, system, description string, result *SketchResult, stats Stats, validate func(string) (bool, []string), log *Logger) (*SketchResult, error) {
+	prompt := fmt.Sprintf(`The sketch below is complete. Give it a final finishing pass.
+
+REQUEST: %s
+
+RENDER STATISTICS: %s
 
-FORMAT:
-<title>SKETCH TITLE</title>
-<summary>Description of the sketch.</summary>
 <code>
-# Complete SketchLang code
+%s
 </code>
 
-REQUIREMENTS:
-- Complete sketch with full detail
-- Meaningful anchor point names
-- Vector math: let pos : vec = (center of shape) + (offset_x, offset_y)
-- Use "center of" for derived positions
-- NO dot notation (vec.x is invalid)
-- NO variable reassignment
-- NO for loops or while loops
-- trace = precise lines, draw = organic, scribble = textured
-- Use dashes for shading
-- Types: number, vec, sketch`, LangSpec)
+Look for: unbalanced composition, missing shadows or ground contact,
+untidy or broken horizon and edge lines, empty areas that need texture.
+
+Reply with a <code> block containing ONLY new statements to append (at most
+20). You may reference any variable defined above. Do not repeat or
+redefine existing code. Reply with an empty <code></code> if nothing is needed.`, description, stats, result.Code)
+
+	return appendPass(ctx, client, system, prompt, finishOptions, result, "finishing pass", "Finishing touches", validate, log)
+}
+
+// A Pass is one stage of coarse-to-fine drawing.
+type Pass struct {
+	Name  string
+	Focus string
+}
+
+// Passes are the stages of a multi-pass sketch, in order. The first is
+// generated whole; each later one appends to the sketch so far.
+var Passes = []Pass{
+	{"contour", "only the main outlines and silhouettes of every element, in their final placement; no shading or texture"},
+	{"structure", "secondary shapes, inner edges, folds and construction detail within the contours"},
+	{"shading", "tone: shadows, cast shadows and ground contact, using dashes and hatching"},
+	{"texture", "surface texture and fine detail: grain, foliage, fabric, stone; keep highlights empty"},
 }
 
-func parseResponse(content string) (*SketchResult, error) {
-	code := extractCode(content)
-	if code == "" {
-		return nil, fmt.Errorf("no <code> block found")
+// PassPrompt asks for the first of n passes over description.
+func PassPrompt(description string, n int) string {
+	var later []string
+	for _, p := range Passes[1:n] {
+		later = append(later, p.Name)
 	}
+	return fmt.Sprintf(`%s
 
-	title := extractTag(content, "title")
-	if title == "" {
-		return nil, fmt.Errorf("no <title> found")
+This is the %s pass of a %d-pass drawing. Draw %s. Later passes will add %s.`,
+		description, Passes[0].Name, n, Passes[0].Focus, strings.Join(later, ", "))
+}
+
+// Refine runs a later pass over a sketch, given the render statistics of
+// the previous pass, and returns the sketch with the new statements
+// appended. As with Finish, statements that do not compile are dropped.
+func Refine(ctx context.Context, client LLMClient, system, description string, pass Pass, result *SketchResult, stats Stats, validate func(string) (bool, []string), log *Logger) (*SketchResult, error) {
+	prompt := fmt.Sprintf(`The sketch below is being drawn coarse to fine. Now do the %s pass.
+
+REQUEST: %s
+
+RENDER STATISTICS OF THE PREVIOUS PASS: %s
+
+<code>
+%s
+</code>
+
+Add %s.
+
+Reply with a <code> block containing ONLY new statements to append. You may
+reference any variable defined above. Do not repeat or redefine existing
+code. Reply with an empty <code></code> if nothing is needed.`, pass.Name, description, stats, result.Code, pass.Focus)
+
+	return appendPass(ctx, client, system, prompt, passOptions, result, pass.Name+" pass", strings.ToUpper(pass.Name[:1])+pass.Name[1:]+" pass", validate, log)
+}
+
+// appendPass sends prompt and appends the statements in the reply to
+// result under a "# header" comment.
+func appendPass(ctx context.Context, client LLMClient, system, prompt string, opts RequestOptions, result *SketchResult, name, header string, validate func(string) (bool, []string), log *Logger) (*SketchResult, error) {
+	content, err := complete(ctx, client, system, []Message{{Role: "user", Content: prompt}}, opts)
+	if err != nil {
+		return result, err
+	}
+
+	touches, err := extractCode(content)
+	if err != nil {
+		return result, fmt.Errorf("%s: %w", name, err)
+	}
+	if touches == "" {
+		log.Info("%s: nothing to add", name)
+		return result, nil
+	}
+
+	extended := *result
+	extended.Code = result.Code + "\n\n# " + header + "\n" + touches
+	if validate != nil {
+		if ok, errors := validate(extended.Code); !ok {
+			return result, fmt.Errorf("%s does not compile: %s", name, strings.Join(errors, "\n"))
+		}
+	}
+	log.Info("%s: added %d lines", name, strings.Count(touches, "\n")+1)
+	return &extended, nil
+}
+
+// Critique shows the model a rendering of the sketch next to the request
+// and asks for a revised sketch. It returns result itself when the model
+// is satisfied. validate checks the revision; if it fails, the original
+// result is returned with the error.
+func Critique(ctx context.Context, client LLMClient, system, description string, result *SketchResult, rendering Image, validate func(string) (bool, []string), log *Logger) (*SketchResult, error) {
+	prompt := fmt.Sprintf(`The image is a rendering of the sketch below. Critique it against the request.
+
+REQUEST: %s
+
+<code>
+%s
+</code>
+
+Look for: parts of the request that are missing or unrecognizable, wrong
+proportions or placement, shapes that overlap where they should not,
+clutter, and areas that need more or less detail.
+
+Reply with the complete revised sketch in a <code> block. Keep what already
+works. Reply with an empty <code></code> if the rendering matches the
+request well.`, description, result.Code)
+
+	messages := []Message{{Role: "user", Content: prompt, Images: []Image{rendering}}}
+	content, err := complete(ctx, client, system, messages, critiqueOptions)
+	if err != nil {
+		return result, err
 	}
 
-	return &SketchResult{
-		Code:    code,
-		Title:   title,
-		Summary: extractTag(content, "summary"),
-	}, nil
+	code, err := extractCode(content)
+	if err != nil {
+		return result, fmt.Errorf("critique: %w", err)
+	}
+	if code == "" || code == result.Code {
+		log.Info("critique: no changes")
+		return result, nil
+	}
+
+	revised := *result
+	revised.Code = code
+	if validate != nil {
+		if ok, errors := validate(revised.Code); !ok {
+			return result, fmt.Errorf("revised sketch does not compile: %s", strings.Join(errors, "\n"))
+		}
+	}
+	return &revised, nil
+}
+
+// ReferencePrompt asks for a sketch of an attached reference image, with
+// description as optional further direction.
+func ReferencePrompt(description string) string {
+	prompt := `Sketch the attached reference image. Trace its composition: the
+placement, outlines and proportions of its main shapes, then add detail and
+shading where the image shows it.`
+	if description != "" {
+		prompt += "\n\n" + description
+	}
+	return prompt
+}
+
+// refusal wraps a declining response, keeping its first line as the reason.
+func refusal(content string) *RefusalError {
+	reason, _, _ := strings.Cut(strings.TrimSpace(content), "\n")
+	return &RefusalError{Reason: reason}
+}
+
+// parseError lists what a response was missing, so the re-ask can target
+// just that instead of requesting the whole answer again.
+type parseError struct {
+	missing  []string
+	unclosed []string
 }
 
-func extractCode(content string) string {
-	if m := regexp.MustCompile(`(?s)<code>(.*?)</code>`).FindStringSubmatch(content); len(m) >= 2 {
-		return strings.TrimSpace(m[1])
+func (e *parseError) Error() string {
+	var parts []string
+	for _, t := range e.missing {
+		parts = append(parts, fmt.Sprintf("no <%s> found", t))
 	}
-	if m := regexp.MustCompile("(?s)```(?:sketchlang)?\\s*\\n(.*?)\\n```").FindStringSubmatch(content); len(m) >= 2 {
-		return strings.TrimSpace(m[1])
+	for _, t := range e.unclosed {
+		parts = append(parts, fmt.Sprintf("<%s> is not closed", t))
 	}
-	return ""
+	return strings.Join(parts, ", ")
 }
 
-func extractTag(content, tag string) string {
-	re := regexp.MustCompile(fmt.Sprintf(`(?si)<%s>(.*?)</%s>`, tag, tag))
-	if m := re.FindStringSubmatch(content); len(m) >= 2 {
-		return strings.TrimSpace(m[1])
+// reask is the follow-up prompt for a failed parse.
+func reask(err error) string {
+	pe, ok := err.(*parseError)
+	if !ok {
+		return fmt.Sprintf("Parse error: %v\n\nPlease fix and include <title>, <summary>, and <code> tags.", err)
+	}
+	if len(pe.unclosed) > 0 {
+		return fmt.Sprintf("Your response was cut off: %v. Resend the complete answer with every tag closed.", pe)
 	}
-	return ""
-}
\ No newline at end of file
+	if len(pe.missing) == 1 && pe.missing[0] == "title" {
+		return "The code was received. Reply with only <title>SKETCH TITLE</title>."
+	}
+	return fmt.Sprintf("Parse error: %v\n\nPlease include the complete sketch in <code>...</code> with a <title>.", pe)
+}
+
+// parseResponse extracts the sketch from a response. Parts missing from
+// content are taken from prev, the partial result of an earlier attempt,
+// so a targeted re-ask only has to supply what was missing.
+func parseResponse(content string, prev *SketchResult) (*SketchResult, error) {
+	result := &SketchResult{}
+	if prev != nil {
+		*result = *prev
+	}
+	pe := &parseError{}
+
+	code, err := extractCode(content)
+	switch {
+	case err == nil:
+		result.Code = code
+	case err == errTagUnclosed:
+		pe.unclosed = append(pe.unclosed, "code")
+	case result.Code == "":
+		pe.missing = append(pe.missing, "code")
+	}
+
+	if title, err := findTag(content, "title"); err == nil && title != "" {
+		result.Title = title
+	} else if result.Title == "" {
+		pe.missing = append(pe.missing, "title")
+	}
+
+	if summary, err := findTag(content, "summary"); err == nil {
+		result.Summary = summary
+	}
+
+	if len(pe.missing) > 0 || len(pe.unclosed) > 0 {
+		return result, pe
+	}
+	return result, nil
+}
+
+func extractCode(content string) (string, error) {
+	code, err := findTag(content, "code")
+	if err != errTagMissing {
+		return code, err
+	}
+	if m := fencePattern.FindStringSubmatch(content); len(m) >= 2 {
+		return strings.TrimSpace(m[1]), nil
+	}
+	return "", errTagMissing
+}
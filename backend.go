@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// Backend compiles SketchLang to SVG for Compile and Validate.
+type Backend interface {
+	// Compile renders code at pos on a canvas of size. If the code does
+	// not compile, stderr has the compiler's messages, one per line.
+	Compile(ctx context.Context, code, name string, pos, size Vec2, log *Logger) (svg, stderr string, err error)
+	// Check compiles code without placing it, or only parses and checks
+	// it if the compiler can.
+	Check(ctx context.Context, code string) (stderr string, err error)
+	// Version is the version of the compiler, for picking a spec.
+	Version() (string, error)
+}
+
+// cacheKeyer is a Backend whose compiles CompileCache may keep. The key
+// identifies the compiler, and changes when it does.
+type cacheKeyer interface {
+	CacheKey() string
+}
+
+// BackendFactory creates a backend, reading any settings it needs from the
+// environment.
+type BackendFactory func() (Backend, error)
+
+var backends = map[string]BackendFactory{}
+
+// RegisterBackend makes a backend selectable with -backend.
+func RegisterBackend(name string, factory BackendFactory) {
+	backends[name] = factory
+}
+
+// NewBackend creates the named backend.
+func NewBackend(name string) (Backend, error) {
+	factory, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q (want %s)", name, strings.Join(BackendNames(), ", "))
+	}
+	return factory()
+}
+
+// BackendNames lists the registered backends, sorted.
+func BackendNames() []string {
+	var names []string
+	for name := range backends {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func init() {
+	RegisterBackend("sketchlang", func() (Backend, error) {
+		if _, err := exec.LookPath(compilerBin); err != nil {
+			return nil, fmt.Errorf("%s not found in PATH", compilerBin)
+		}
+		return execBackend{}, nil
+	})
+	RegisterBackend("builtin", func() (Backend, error) {
+		return builtinBackend{}, nil
+	})
+	RegisterBackend("http", func() (Backend, error) {
+		url := os.Getenv("SKETCH_COMPILE_URL")
+		if url == "" {
+			return nil, fmt.Errorf("SKETCH_COMPILE_URL not set")
+		}
+		return &httpBackend{url: url}, nil
+	})
+}
+
+// backend is the one chosen with -backend, nil for the default.
+var backend Backend
+
+// compiler is the backend to compile with: the one chosen with -backend,
+// or else the sketchlang compiler if it is in PATH and the built-in
+// renderer if not.
+func compiler() Backend {
+	if backend != nil {
+		return backend
+	}
+	if usesBuiltin() {
+		return builtinBackend{}
+	}
+	return execBackend{}
+}
+
+// addSeedFlag registers -seed on fs. Call the returned func once fs is
+// parsed to compile with the seed, after choosing the backend.
+func addSeedFlag(fs *flag.FlagSet) func() {
+	seed := fs.Int64("seed", 0, "seed the noise of draw and scribble, so it is the same every run (0 leaves it to the compiler)")
+	return func() {
+		compileSeed = *seed
+		if compileSeed == 0 {
+			return
+		}
+		if _, ok := compiler().(execBackend); ok {
+			if caps, err := Probe(); err == nil && !caps.Seed {
+				printf("warning: %s takes no -seed, so draw and scribble will still vary between runs", compilerBin)
+			}
+		}
+	}
+}
+
+// addBackendFlag registers -backend on fs. Call the returned func once fs
+// is parsed to switch to the chosen backend.
+func addBackendFlag(fs *flag.FlagSet) func() {
+	name := fs.String("backend", "", "compile with "+strings.Join(BackendNames(), ", ")+" (default: sketchlang if it is in PATH, else builtin)")
+	return func() {
+		if *name == "" {
+			return
+		}
+		b, err := NewBackend(*name)
+		if err != nil {
+			fatal("backend: %v", err)
+		}
+		backend = b
+	}
+}
+
+// httpBackend compiles on a remote service. Each compile is a POST of
+// {"code": ..., "pos": [x, y], "size": [w, h], "seed": n} to url, without
+// seed unless -seed is set, and without pos and size for a check. The
+// service answers 200 with the SVG, or 422 with the compiler's messages as
+// plain text.
+type httpBackend struct {
+	url string
+}
+
+type compileRequest struct {
+	Code string      `json:"code"`
+	Pos  *[2]float64 `json:"pos,omitempty"`
+	Size *[2]float64 `json:"size,omitempty"`
+	Seed int64       `json:"seed,omitempty"`
+}
+
+func (b *httpBackend) Compile(ctx context.Context, code, name string, pos, size Vec2, log *Logger) (string, string, error) {
+	log.Debug("compiling %s on %s", name, b.url)
+	return b.post(ctx, compileRequest{Code: code, Pos: &[2]float64{pos.X, pos.Y}, Size: &[2]float64{size.X, size.Y}, Seed: compileSeed})
+}
+
+func (b *httpBackend) Check(ctx context.Context, code string) (string, error) {
+	_, stderr, err := b.post(ctx, compileRequest{Code: code})
+	return stderr, err
+}
+
+func (b *httpBackend) Version() (string, error) {
+	return "", errors.New("the compile service does not report one")
+}
+
+func (b *httpBackend) CacheKey() string {
+	return b.url
+}
+
+// post sends r, giving up after compileTimeout as a local compile would.
+func (b *httpBackend) post(ctx context.Context, r compileRequest) (svg, stderr string, err error) {
+	runCtx := ctx
+	if compileTimeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, compileTimeout)
+		defer cancel()
+	}
+
+	body, _ := json.Marshal(r)
+	req, err := http.NewRequestWithContext(runCtx, http.MethodPost, b.url, bytes.NewReader(body))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err == nil {
+		defer resp.Body.Close()
+		body, err = io.ReadAll(resp.Body)
+	}
+	switch {
+	case ctx.Err() != nil:
+		return "", "", ctx.Err()
+	case runCtx.Err() != nil:
+		return "", "", fmt.Errorf("%w after %v", errCompileTimeout, compileTimeout)
+	case err != nil:
+		return "", "", fmt.Errorf("compile service: %w", err)
+	case resp.StatusCode == http.StatusUnprocessableEntity:
+		return "", string(body), errors.New("compile service: " + resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return "", "", fmt.Errorf("compile service: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return string(body), "", nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// BatchRow is one request from a batch file, keyed by column name.
+// Recognized columns are description, url, image, constraints
+// (comma-separated), caption, pos, size, paper, output, style (as -style
+// lists them), requester and pen (as -pen sets depths); every column is
+// available to the description template.
+type BatchRow map[string]string
+
+// LoadBatch reads a .csv file (header row required) or a JSONL file with
+// one object per line.
+func LoadBatch(path string) ([]BatchRow, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return loadCSV(f)
+	}
+	return loadJSONL(f)
+}
+
+func loadCSV(r io.Reader) ([]BatchRow, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("no rows after header")
+	}
+
+	header := records[0]
+	var rows []BatchRow
+	for _, rec := range records[1:] {
+		row := BatchRow{}
+		for i, col := range header {
+			row[strings.TrimSpace(col)] = strings.TrimSpace(rec[i])
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func loadJSONL(r io.Reader) ([]BatchRow, error) {
+	var rows []BatchRow
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var fields map[string]any
+		if err := json.Unmarshal([]byte(text), &fields); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		row := BatchRow{}
+		for k, v := range fields {
+			row[k] = fmt.Sprint(v)
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("no rows")
+	}
+	return rows, nil
+}
+
+// Prompt renders the description column as a text/template over the row,
+// so "a {{.animal}} in {{.weather}}" picks up the animal and weather columns.
+// A url column takes precedence, matching the -url flag. With an image
+// column, the description is optional.
+func (r BatchRow) Prompt() (string, error) {
+	if r["url"] != "" {
+		return requestPrompt("", r["url"]), nil
+	}
+	if r["description"] == "" {
+		if r["image"] != "" {
+			return "", nil
+		}
+		return "", fmt.Errorf("no description, url or image")
+	}
+
+	tmpl, err := template.New("description").Option("missingkey=error").Parse(r["description"])
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, map[string]string(r)); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// Request builds the row's sketch request, with pos and size as defaults.
+func (r BatchRow) Request(pos, size Vec2) (SketchRequest, error) {
+	prompt, err := r.Prompt()
+	if err != nil {
+		return SketchRequest{}, err
+	}
+	req := SketchRequest{
+		Prompt:      prompt,
+		Image:       r["image"],
+		Output:      r["output"],
+		Constraints: SplitConstraints(r["constraints"]),
+		Caption:     r["caption"],
+		Style:       r["style"],
+		Requester:   r["requester"],
+	}
+	if req.Pos, err = r.Vec("pos", pos); err != nil {
+		return SketchRequest{}, err
+	}
+	if req.Size, err = r.Vec("size", size); err != nil {
+		return SketchRequest{}, err
+	}
+	if r["paper"] != "" {
+		if req.Size, err = ParsePaper(r["paper"]); err != nil {
+			return SketchRequest{}, err
+		}
+	}
+	if _, err := LookupStyles(req.Style); err != nil {
+		return SketchRequest{}, err
+	}
+	if r["pen"] != "" {
+		if req.Pens, err = ParsePenDepths(r["pen"]); err != nil {
+			return SketchRequest{}, err
+		}
+	}
+	return req, nil
+}
+
+// Vec parses column key as "x,y", falling back to def when it is empty.
+func (r BatchRow) Vec(key string, def Vec2) (Vec2, error) {
+	if r[key] == "" {
+		return def, nil
+	}
+	xs, ys, ok := strings.Cut(r[key], ",")
+	x, errX := strconv.ParseFloat(strings.TrimSpace(xs), 64)
+	y, errY := strconv.ParseFloat(strings.TrimSpace(ys), 64)
+	if !ok || errX != nil || errY != nil {
+		return Vec2{}, fmt.Errorf("%s %q is not x,y", key, r[key])
+	}
+	return Vec2{x, y}, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	breakerThreshold   = 3
+	breakerCooldown    = 30 * time.Second
+	breakerMaxCooldown = 5 * time.Minute
+	breakerMaxWait     = 30 * time.Minute
+)
+
+// BreakerClient stops calling a provider after repeated failures. While
+// the circuit is open, calls wait (pausing a batch rather than failing
+// every row); once the cooldown passes, the next call probes the provider.
+// A failed probe reopens the circuit with a doubled cooldown; a success
+// closes it.
+type BreakerClient struct {
+	inner LLMClient
+	log   *Logger
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	cooldown  time.Duration
+}
+
+func NewBreakerClient(inner LLMClient, log *Logger) *BreakerClient {
+	return &BreakerClient{inner: inner, log: log, cooldown: breakerCooldown}
+}
+
+func (b *BreakerClient) Model() string {
+	return b.inner.Model()
+}
+
+func (b *BreakerClient) CountTokens(ctx context.Context, system string, messages []Message) (int, error) {
+	return CountTokens(ctx, b.inner, system, messages)
+}
+
+// Complete calls the provider unless the circuit is open. A request
+// cancelled through ctx says nothing about the provider's health and is
+// not counted.
+func (b *BreakerClient) Complete(ctx context.Context, system string, messages []Message, opts RequestOptions) (Response, error) {
+	if err := b.wait(ctx); err != nil {
+		return Response{}, err
+	}
+	resp, err := b.inner.Complete(ctx, system, messages, opts)
+	if ctx.Err() == nil {
+		b.record(err)
+	}
+	return resp, err
+}
+
+// StreamComplete is Complete with streaming. Likewise, a request aborted
+// by onText is not counted.
+func (b *BreakerClient) StreamComplete(ctx context.Context, system string, messages []Message, opts RequestOptions, onText func(string) error) (Response, error) {
+	if err := b.wait(ctx); err != nil {
+		return Response{}, err
+	}
+	var aborted error
+	resp, err := b.inner.StreamComplete(ctx, system, messages, opts, func(text string) error {
+		aborted = onText(text)
+		return aborted
+	})
+	if aborted == nil && ctx.Err() == nil {
+		b.record(err)
+	}
+	return resp, err
+}
+
+func (b *BreakerClient) wait(ctx context.Context) error {
+	deadline := time.Now().Add(breakerMaxWait)
+	for {
+		b.mu.Lock()
+		until := b.openUntil
+		b.mu.Unlock()
+
+		if !time.Now().Before(until) {
+			return nil
+		}
+		if until.After(deadline) {
+			return fmt.Errorf("%s unavailable: circuit open until %s", b.inner.Model(), until.Format(time.TimeOnly))
+		}
+		b.log.Info("circuit open, waiting %s before probing %s", time.Until(until).Round(time.Second), b.inner.Model())
+		if err := sleep(ctx, time.Until(until)); err != nil {
+			return err
+		}
+	}
+}
+
+func (b *BreakerClient) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var refused *RefusalError
+	if err == nil || errors.As(err, &refused) {
+		b.failures = 0
+		b.cooldown = breakerCooldown
+		return
+	}
+
+	b.failures++
+	if b.failures < breakerThreshold {
+		return
+	}
+	b.openUntil = time.Now().Add(b.cooldown)
+	printf("warning: %s failed %d times in a row, pausing for %s: %v", b.inner.Model(), b.failures, b.cooldown, err)
+	b.cooldown = min(b.cooldown*2, breakerMaxCooldown)
+}
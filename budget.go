@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Budget caps what one sketch may spend. Zero fields are unlimited.
+type Budget struct {
+	MaxCost   float64 // in dollars
+	MaxTokens int     // input, output and cache tokens together
+}
+
+// BudgetError is returned instead of sending a request once the current
+// sketch has spent its budget.
+type BudgetError struct {
+	Budget Budget
+	Spent  Usage
+	Cost   float64
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("budget exceeded: spent %s", formatUsage(e.Spent, e.Cost))
+}
+
+// BudgetClient refuses requests once the usage recorded since the last
+// Reset reaches the budget. The check is made before each request, so
+// the request that crosses the limit still completes.
+type BudgetClient struct {
+	inner  LLMClient
+	budget Budget
+	usage  *UsageTracker
+
+	mu        sync.Mutex
+	start     Usage
+	startCost float64
+}
+
+func NewBudgetClient(inner LLMClient, budget Budget, usage *UsageTracker) *BudgetClient {
+	return &BudgetClient{inner: inner, budget: budget, usage: usage}
+}
+
+// Reset starts the budget of a new sketch. It is a no-op on nil.
+func (b *BudgetClient) Reset() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.start, b.startCost = b.usage.Total()
+}
+
+func (b *BudgetClient) Model() string {
+	return b.inner.Model()
+}
+
+func (b *BudgetClient) CountTokens(ctx context.Context, system string, messages []Message) (int, error) {
+	return CountTokens(ctx, b.inner, system, messages)
+}
+
+func (b *BudgetClient) Complete(ctx context.Context, system string, messages []Message, opts RequestOptions) (Response, error) {
+	if err := b.check(); err != nil {
+		return Response{}, err
+	}
+	return b.inner.Complete(ctx, system, messages, opts)
+}
+
+func (b *BudgetClient) StreamComplete(ctx context.Context, system string, messages []Message, opts RequestOptions, onText func(string) error) (Response, error) {
+	if err := b.check(); err != nil {
+		return Response{}, err
+	}
+	return b.inner.StreamComplete(ctx, system, messages, opts, onText)
+}
+
+func (b *BudgetClient) check() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	total, cost := b.usage.Total()
+	spent, spentCost := total.sub(b.start), cost-b.startCost
+	tokens := spent.InputTokens + spent.OutputTokens + spent.CacheWriteTokens + spent.CacheReadTokens
+
+	if (b.budget.MaxCost > 0 && spentCost >= b.budget.MaxCost) ||
+		(b.budget.MaxTokens > 0 && tokens >= b.budget.MaxTokens) {
+		return &BudgetError{Budget: b.budget, Spent: spent, Cost: spentCost}
+	}
+	return nil
+}
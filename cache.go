@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CachingClient serves repeated identical requests from disk instead of
+// re-spending tokens. Entries older than ttl are ignored and overwritten.
+type CachingClient struct {
+	inner LLMClient
+	dir   string
+	ttl   time.Duration
+	log   *Logger
+}
+
+func NewCachingClient(inner LLMClient, dir string, ttl time.Duration, log *Logger) *CachingClient {
+	return &CachingClient{inner: inner, dir: dir, ttl: ttl, log: log}
+}
+
+func (c *CachingClient) Model() string {
+	return c.inner.Model()
+}
+
+func (c *CachingClient) CountTokens(ctx context.Context, system string, messages []Message) (int, error) {
+	return CountTokens(ctx, c.inner, system, messages)
+}
+
+func (c *CachingClient) Complete(ctx context.Context, system string, messages []Message, opts RequestOptions) (Response, error) {
+	path := c.path(system, messages, opts)
+	if resp, ok := c.lookup(path); ok {
+		return resp, nil
+	}
+
+	resp, err := c.inner.Complete(ctx, system, messages, opts)
+	if err != nil {
+		return Response{}, err
+	}
+	c.store(path, resp)
+	return resp, nil
+}
+
+// StreamComplete streams cache misses; a hit is passed to onText whole.
+func (c *CachingClient) StreamComplete(ctx context.Context, system string, messages []Message, opts RequestOptions, onText func(string) error) (Response, error) {
+	path := c.path(system, messages, opts)
+	if resp, ok := c.lookup(path); ok {
+		if err := onText(resp.Text); err != nil {
+			return Response{}, err
+		}
+		return resp, nil
+	}
+
+	resp, err := c.inner.StreamComplete(ctx, system, messages, opts, onText)
+	if err != nil {
+		return Response{}, err
+	}
+	c.store(path, resp)
+	return resp, nil
+}
+
+func (c *CachingClient) path(system string, messages []Message, opts RequestOptions) string {
+	return filepath.Join(c.dir, c.key(system, messages, opts)+".txt")
+}
+
+// lookup returns a fresh cached response. Only complete responses are
+// stored, so a hit always ended normally.
+func (c *CachingClient) lookup(path string) (Response, bool) {
+	if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) < c.ttl {
+		if data, err := os.ReadFile(path); err == nil {
+			c.log.Debug("cache hit %s", filepath.Base(path))
+			return Response{Text: string(data), StopReason: StopEnd}, true
+		}
+	}
+	return Response{}, false
+}
+
+func (c *CachingClient) store(path string, resp Response) {
+	if resp.StopReason != StopEnd {
+		return
+	}
+	if err := writeFileAtomic(path, []byte(resp.Text)); err != nil {
+		c.log.Warn("cache write failed: %v", err)
+	}
+}
+
+func (c *CachingClient) key(system string, messages []Message, opts RequestOptions) string {
+	data, _ := json.Marshal(struct {
+		Model    string         `json:"model"`
+		System   string         `json:"system"`
+		Messages []Message      `json:"messages"`
+		Options  RequestOptions `json:"options"`
+	}{c.inner.Model(), system, messages, opts})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// CompileCache stores compiled SVGs keyed by code, placement, seed and the
+// backend's CacheKey, so recompiling identical code skips the compiler.
+type CompileCache struct {
+	dir string
+	ttl time.Duration
+}
+
+func NewCompileCache(dir string, ttl time.Duration) *CompileCache {
+	return &CompileCache{dir: dir, ttl: ttl}
+}
+
+// Compile is Compile through the cache. A nil cache compiles directly.
+func (c *CompileCache) Compile(ctx context.Context, code, outputName string, pos, size Vec2, log *Logger) (string, error) {
+	if c == nil {
+		return Compile(ctx, code, outputName, pos, size, log)
+	}
+
+	var key string
+	if k, ok := compiler().(cacheKeyer); ok {
+		key = k.CacheKey()
+	}
+	if key == "" {
+		return Compile(ctx, code, outputName, pos, size, log)
+	}
+
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%g,%g\x00%g,%g\x00%d\x00%s",
+		key, pos.X, pos.Y, size.X, size.Y, compileSeed, code)))
+	path := filepath.Join(c.dir, hex.EncodeToString(sum[:])+".svg")
+
+	if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) < c.ttl {
+		if data, err := os.ReadFile(path); err == nil {
+			log.Debug("compile cache hit %s", filepath.Base(path))
+			return string(data), nil
+		}
+	}
+
+	svg, err := Compile(ctx, code, outputName, pos, size, log)
+	if err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(path, []byte(svg)); err != nil {
+		log.Warn("compile cache write failed: %v", err)
+	}
+	return svg, nil
+}
+
+// CompileMany is CompileMany through the cache.
+func (c *CompileCache) CompileMany(ctx context.Context, jobs []CompileJob, log *Logger) ([]string, []error) {
+	return compileMany(jobs, func(j CompileJob) (string, error) {
+		return c.Compile(ctx, j.Code, j.Name, j.Pos, j.Size, log)
+	})
+}
+
+// CacheDir is the per-user cache location for one kind of artifact.
+func CacheDir(kind string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "sketch-studio", kind)
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"sketch-studio/tools/manifest"
+)
+
+// checkpointSuffix ends the file name of every checkpoint.
+const checkpointSuffix = ".checkpoint.json"
+
+// Checkpoint is the progress of a sketch that is not finished yet, saved
+// as <name>.checkpoint.json beside its outputs after each phase, so an
+// interrupted run can be resumed with -resume without paying for the
+// phases it finished again. A decomposed request's checkpoint is its
+// plan; each of its parts has its own. Checkpoints are removed once the
+// sketch is saved.
+type Checkpoint struct {
+	Request SketchRequest `json:"request"` // with the output name made absolute
+	Start   time.Time     `json:"start"`
+	Usage   Usage         `json:"usage"` // spent before the checkpoint
+	Cost    float64       `json:"cost"`
+
+	// The plan of a decomposed request.
+	Title string `json:"title,omitempty"`
+	Parts []Part `json:"parts,omitempty"`
+
+	// The latest version of a sketch and the phases it has been through.
+	Result   *SketchResult    `json:"result,omitempty"`
+	SVG      string           `json:"svg,omitempty"`
+	Steps    []string         `json:"steps,omitempty"` // phases finished
+	Versions []SheetEntry     `json:"versions,omitempty"`
+	Phases   []manifest.Phase `json:"phases,omitempty"`
+}
+
+func checkpointPath(outName string) string {
+	return outName + checkpointSuffix
+}
+
+// done reports whether the checkpoint has finished step.
+func (cp *Checkpoint) done(step string) bool {
+	return cp != nil && slices.Contains(cp.Steps, step)
+}
+
+// SaveCheckpoint saves cp for the sketch saved as outName, recording
+// outName as an absolute path so the run can be resumed from anywhere.
+func SaveCheckpoint(outName string, cp *Checkpoint) error {
+	abs, err := filepath.Abs(outName)
+	if err != nil {
+		return err
+	}
+	cp.Request.Output = abs
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(checkpointPath(outName), data)
+}
+
+// LoadCheckpoint reads the checkpoint of the sketch saved as outName.
+func LoadCheckpoint(outName string) (*Checkpoint, error) {
+	data, err := os.ReadFile(checkpointPath(outName))
+	if err != nil {
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// FindCheckpoints lists the checkpoints of the sketches in dir, not those
+// of the parts of decomposed requests, which resume with their plan.
+func FindCheckpoints(dir string) ([]*Checkpoint, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+checkpointSuffix))
+	if err != nil {
+		return nil, err
+	}
+	var cps []*Checkpoint
+	for _, path := range paths {
+		cp, err := LoadCheckpoint(path[:len(path)-len(checkpointSuffix)])
+		if err != nil {
+			printf("warning: %s: %v", path, err)
+			continue
+		}
+		cps = append(cps, cp)
+	}
+	return cps, nil
+}
+
+// resumed is the checkpoint of the sketch saved as outName when resuming,
+// or nil.
+func (s *studio) resumed(outName string) *Checkpoint {
+	if !s.resume || outName == "" {
+		return nil
+	}
+	cp, err := LoadCheckpoint(outName)
+	if err != nil {
+		return nil
+	}
+	return cp
+}
+
+// checkpoint saves cp for outName, with what has been spent since before
+// added to what it had spent already. It is only a warning if it can't.
+func (s *studio) checkpoint(outName string, cp *Checkpoint, spent Usage, cost float64) {
+	saved := *cp
+	saved.Usage, saved.Cost = cp.Usage.add(spent), cp.Cost+cost
+	if err := SaveCheckpoint(outName, &saved); err != nil {
+		s.log.Warn("checkpoint: %v", err)
+	}
+}
+
+// finishCheckpoint removes the checkpoint of a sketch that has been saved.
+func finishCheckpoint(outName string) {
+	os.Remove(checkpointPath(outName))
+}
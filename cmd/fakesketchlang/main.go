@@ -0,0 +1,233 @@
+// Command fakesketchlang is a stand-in for the sketchlang compiler with the
+// same command line (input, -o, -pos, -size, -seed, --svg, --gcode,
+// --check, with "-" as the input or output for stdin or stdout). It checks
+// the statement structure, rejects dot notation, and renders every render
+// command as a polyline through its literal points, which is enough to
+// drive sketch-studio end to end without the real toolchain.
+//
+//	go build -o /tmp/stub/sketchlang ./cmd/fakesketchlang
+//	PATH=/tmp/stub:$PATH sketch-studio -d "a cat"
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	letPattern   = regexp.MustCompile(`^let\s+[A-Za-z_]\w*\s*:\s*(number|vec|sketch)\s*=`)
+	dotPattern   = regexp.MustCompile(`[A-Za-z_]\w*\.[A-Za-z_]`)
+	pointPattern = regexp.MustCompile(`\(\s*(-?[\d.]+)\s*,\s*(-?[\d.]+)\s*\)`)
+)
+
+const usage = "usage: sketchlang <input.sketch|-> [-o name|-] [-pos x,y] [-size w,h] [-seed n] [--svg] [--gcode] [--check]"
+
+type point struct{ X, Y float64 }
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "--version" {
+		fmt.Println("sketchlang 0.0.0-fake")
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "--help" {
+		fmt.Println(usage)
+		return
+	}
+
+	var input, output string
+	var pos, size point
+	size = point{200, 200}
+	svg, gcode, checkOnly := false, false, false
+
+	args := os.Args[1:]
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-o":
+			i++
+			output = arg(args, i)
+		case "-pos":
+			i++
+			pos = parsePoint(arg(args, i))
+		case "-size":
+			i++
+			size = parsePoint(arg(args, i))
+		case "-seed":
+			i++ // nothing is drawn with noise
+		case "--svg":
+			svg = true
+		case "--gcode":
+			gcode = true
+		case "--check":
+			checkOnly = true
+		default:
+			input = args[i]
+		}
+	}
+	if input == "" {
+		fail(usage)
+	}
+	if output == "" {
+		output = strings.TrimSuffix(input, ".sketch")
+	}
+
+	var src []byte
+	var err error
+	if input == "-" {
+		src, err = io.ReadAll(os.Stdin)
+	} else {
+		src, err = os.ReadFile(input)
+	}
+	if err != nil {
+		fail("%v", err)
+	}
+
+	paths, err := check(string(src))
+	if err != nil {
+		fail("%s: %v", input, err)
+	}
+	if checkOnly {
+		return
+	}
+	for _, p := range paths {
+		for i := range p {
+			p[i] = point{pos.X + p[i].X, pos.Y + p[i].Y}
+		}
+	}
+
+	if svg {
+		write(output, ".svg", renderSVG(paths, pos, size))
+	}
+	if gcode || !svg {
+		write(output, ".txt", renderGcode(paths))
+	}
+}
+
+// write saves out as output+ext, or prints it if output is "-".
+func write(output, ext, out string) {
+	if output == "-" {
+		fmt.Print(out)
+		return
+	}
+	must(os.WriteFile(output+ext, []byte(out), 0644))
+}
+
+// check validates statement structure and returns one polyline per render
+// command, built from the literal points it mentions.
+func check(src string) ([][]point, error) {
+	var paths [][]point
+	var stmt strings.Builder
+	depth, start := 0, 0
+
+	for n, line := range strings.Split(src, "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if depth == 0 {
+			stmt.Reset()
+			start = n + 1
+		}
+		stmt.WriteString(line + " ")
+		depth += strings.Count(line, "[") + strings.Count(line, "(") - strings.Count(line, "]") - strings.Count(line, ")")
+		if depth < 0 {
+			return nil, fmt.Errorf("line %d: unbalanced brackets", n+1)
+		}
+		if depth > 0 {
+			continue
+		}
+
+		text := stmt.String()
+		if dotPattern.MatchString(text) {
+			return nil, fmt.Errorf("line %d: dot notation is not supported", start)
+		}
+
+		switch word, _, _ := strings.Cut(text, " "); word {
+		case "let":
+			if !letPattern.MatchString(text) {
+				return nil, fmt.Errorf("line %d: malformed let", start)
+			}
+		case "trace", "draw", "scribble":
+			var path []point
+			for _, m := range pointPattern.FindAllStringSubmatch(text, -1) {
+				path = append(path, parsePoint(m[1]+","+m[2]))
+			}
+			if len(path) > 0 {
+				paths = append(paths, path)
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unexpected %q", start, word)
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("line %d: unterminated statement", start)
+	}
+	return paths, nil
+}
+
+func renderSVG(paths [][]point, pos, size point) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%gmm" height="%gmm" viewBox="%g %g %g %g">`+"\n",
+		size.X, size.Y, pos.X, pos.Y, size.X, size.Y)
+	b.WriteString(`  <rect width="100%" height="100%" fill="white"/>` + "\n")
+	for _, p := range paths {
+		b.WriteString(`  <path d="`)
+		for i, pt := range p {
+			cmd := "L"
+			if i == 0 {
+				cmd = "M"
+			}
+			fmt.Fprintf(&b, "%s %.2f %.2f ", cmd, pt.X, pt.Y)
+		}
+		b.WriteString(`" fill="none" stroke="black" stroke-width="0.3"/>` + "\n")
+	}
+	b.WriteString("</svg>\n")
+	return b.String()
+}
+
+func renderGcode(paths [][]point) string {
+	var b strings.Builder
+	b.WriteString("; Generated by fakesketchlang\nG21\nG90\nM5\n")
+	fmt.Fprintf(&b, "; %d paths\n", len(paths))
+	for i, p := range paths {
+		fmt.Fprintf(&b, "; Path %d\nG0 X%.3f Y%.3f\nG0 Z2.2\n", i+1, p[0].X, p[0].Y)
+		for _, pt := range p[1:] {
+			fmt.Fprintf(&b, "G1 X%.3f Y%.3f\n", pt.X, pt.Y)
+		}
+		b.WriteString("G0 Z0\n")
+	}
+	b.WriteString("M5\nG0 X0 Y0\n; End\n")
+	return b.String()
+}
+
+func parsePoint(s string) point {
+	x, y, _ := strings.Cut(s, ",")
+	px, _ := strconv.ParseFloat(strings.TrimSpace(x), 64)
+	py, _ := strconv.ParseFloat(strings.TrimSpace(y), 64)
+	return point{px, py}
+}
+
+func arg(args []string, i int) string {
+	if i >= len(args) {
+		fail("missing value for %s", args[i-1])
+	}
+	return args[i]
+}
+
+func fail(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+func must(err error) {
+	if err != nil {
+		fail("%v", err)
+	}
+}
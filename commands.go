@@ -0,0 +1,768 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"sketch-studio/tools/gallery"
+	"sketch-studio/tools/gcode"
+	"sketch-studio/tools/manifest"
+	"sketch-studio/tools/plotter"
+	"sketch-studio/tools/sketchlang"
+	"sketch-studio/tools/svgimport"
+)
+
+// command is a subcommand. setup registers its flags on fs and returns the
+// action to run with the remaining arguments once fs has been parsed.
+type command struct {
+	name    string
+	args    string
+	summary string
+	setup   func(fs *flag.FlagSet) func(args []string)
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"generate", "", "generate sketches from a description, URL or batch file (default)", setupGenerate},
+		{"retry-failed", "", "re-run requests that failed earlier", setupRetryFailed},
+		{"render", "<file.sketch>", "compile a .sketch file to SVG", setupRender},
+		{"import", "<file.svg>", "convert the outlines of an SVG drawing to a .sketch file", setupImport},
+		{"validate", "<file.sketch>...", "check that .sketch files compile, and lint them", setupValidate},
+		{"fmt", "<file.sketch>...", "lay out .sketch files in the canonical style", setupFmt},
+		{"optimize", "<file.sketch>...", "merge, deduplicate and simplify the primitives of .sketch files", setupOptimize},
+		{"verify", "<dir>", "recompile stored sketches and compare against their SVGs", setupVerify},
+		{"export", "<file.svg>...", "convert compiled sketches for plotters, or to PNG or JPEG", setupExport},
+		{"tile", "<file.svg>...", "split compiled sketches into tiles that fit a plotter bed", setupTile},
+		{"plot", "<file.sketch|file.gcode>", "stream a sketch or G-code to a GRBL plotter over a serial port", setupPlot},
+		{"stats", "<file.svg>...", "measure compiled sketches, to compare versions", setupStats},
+		{"compare", "<name>@v<n> <name>[@v<n>]", "draw the paths added and removed between two versions of a sketch", setupCompare},
+		{"gallery", "[dir]", "list and search the sketches saved under a directory", setupGallery},
+		{"prompts", "<dir>", "write the built-in prompt templates to a directory for -prompts", setupPrompts},
+		{"doctor", "", "report which SketchLang features the compiler accepts", setupDoctor},
+		{"completion", "bash|zsh", "print a shell completion script", setupCompletion},
+	}
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+func usage(w io.Writer) {
+	prog := filepath.Base(os.Args[0])
+	fmt.Fprintf(w, "usage: %s <command> [flags] [args]\n\ncommands:\n", prog)
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-13s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nWith no command, flags are passed to generate. Run '%s <command> -h' for its flags.\n", prog)
+}
+
+func setupRetryFailed(fs *flag.FlagSet) func([]string) {
+	list := fs.Bool("list", false, "list queued failures without retrying")
+	sf := addStudioFlags(fs)
+
+	return func([]string) {
+		path := FailuresPath()
+		queue, err := LoadFailures(path)
+		if err != nil {
+			fatal("failure queue: %v", err)
+		}
+		if len(queue) == 0 {
+			fmt.Println("no failed requests")
+			return
+		}
+
+		if *list {
+			for _, f := range queue {
+				fmt.Printf("%s  x%d  %q\n      %s\n", f.Time.Format(time.DateTime), f.Attempts, f.Prompt, f.Error)
+			}
+			return
+		}
+
+		// Failures are tracked here rather than appended by run, so a
+		// request that fails again keeps its place and attempt count.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		st := sf.build()
+		var remaining []FailedRequest
+		for _, f := range queue {
+			if ctx.Err() != nil {
+				remaining = append(remaining, f)
+				continue
+			}
+			if result, _, err := st.run(ctx, SketchRequest{Prompt: f.Prompt, Image: f.Image, Output: f.Output, Constraints: f.Constraints, Caption: f.Caption, Pos: f.Pos, Size: f.Size, Style: f.Style, Requester: f.Requester, Pens: f.Pens}); err != nil {
+				printf("error: %q: %v", f.Prompt, err)
+				f.Attempts++
+				f.Error = err.Error()
+				if result != nil {
+					f.Code = result.Code
+				}
+				remaining = append(remaining, f)
+			}
+		}
+
+		st.reportUsage()
+		if err := SaveFailures(path, remaining); err != nil {
+			fatal("failure queue: %v", err)
+		}
+		if len(remaining) > 0 {
+			fatal("%d of %d requests still failing", len(remaining), len(queue))
+		}
+	}
+}
+
+func setupRender(fs *flag.FlagSet) func([]string) {
+	pos := fs.String("pos", "0,0", "position x,y in mm")
+	canvas := addSizeFlags(fs)
+	output := fs.String("o", "", "output name (default: input name)")
+	order := fs.Bool("optimize-travel", false, "reorder the paths of the SVG to cut the pen-up travel between them")
+	debug := fs.Bool("debug", false, "emit debug logs")
+	useBackend := addBackendFlag(fs)
+	useSeed := addSeedFlag(fs)
+
+	return func(args []string) {
+		if len(args) != 1 {
+			fatal("render takes one .sketch file")
+		}
+		useBackend()
+		useSeed()
+		code, err := os.ReadFile(args[0])
+		if err != nil {
+			fatal("%v", err)
+		}
+
+		outName := *output
+		if outName == "" {
+			outName = strings.TrimSuffix(filepath.Base(args[0]), ".sketch")
+		}
+
+		svg, err := Compile(context.Background(), string(code), outName, parseVec(*pos), canvas(), &Logger{enabled: *debug})
+		if err != nil {
+			fatal("%v", err)
+		}
+		if *order {
+			svg = orderPaths(svg)
+		}
+		if err := os.WriteFile(outName+".svg", []byte(svg), 0644); err != nil {
+			fatal("%v", err)
+		}
+		abs, _ := filepath.Abs(outName + ".svg")
+		fmt.Println(abs)
+	}
+}
+
+func setupValidate(fs *flag.FlagSet) func([]string) {
+	debug := fs.Bool("debug", false, "emit debug logs")
+	size := fs.String("size", "", "canvas size w,h in mm, to warn about points off it")
+	margin := fs.Float64("margin", 0, "border in mm to warn about points in, with -size")
+	useBackend := addBackendFlag(fs)
+
+	return func(args []string) {
+		if len(args) == 0 {
+			fatal("validate takes one or more .sketch files")
+		}
+		useBackend()
+
+		log := &Logger{enabled: *debug}
+		var canvas sketchlang.Canvas
+		if *size != "" {
+			v := parseVec(*size)
+			canvas = sketchlang.Canvas{Width: v.X, Height: v.Y, Margin: *margin}
+		}
+		passed := true
+		for _, path := range args {
+			code, err := os.ReadFile(path)
+			if err != nil {
+				fmt.Printf("FAIL %s: %v\n", path, err)
+				passed = false
+				continue
+			}
+			if ok, errors := Validate(context.Background(), string(code), log); !ok {
+				fmt.Printf("FAIL %s:\n     %s\n", path, strings.ReplaceAll(strings.TrimSpace(strings.Join(errors, "\n")), "\n", "\n     "))
+				passed = false
+				continue
+			}
+			fmt.Printf("ok   %s\n", path)
+			for _, d := range sketchlang.Lint(string(code), canvas) {
+				fmt.Printf("     %s: %s\n", d.Severity, d)
+			}
+		}
+		if !passed {
+			os.Exit(1)
+		}
+	}
+}
+
+func setupFmt(fs *flag.FlagSet) func([]string) {
+	list := fs.Bool("l", false, "list files whose layout differs instead of rewriting them")
+
+	return func(args []string) {
+		if len(args) == 0 {
+			fatal("fmt takes one or more .sketch files")
+		}
+
+		passed := true
+		for _, path := range args {
+			code, err := os.ReadFile(path)
+			if err != nil {
+				printf("%s: %v", path, err)
+				passed = false
+				continue
+			}
+			formatted, err := sketchlang.Format(string(code))
+			if err != nil {
+				printf("%s: %v", path, err)
+				passed = false
+				continue
+			}
+			if formatted == string(code) {
+				continue
+			}
+			fmt.Println(path)
+			if *list {
+				continue
+			}
+			if err := os.WriteFile(path, []byte(formatted), 0644); err != nil {
+				printf("%s: %v", path, err)
+				passed = false
+			}
+		}
+		if !passed {
+			os.Exit(1)
+		}
+	}
+}
+
+func setupOptimize(fs *flag.FlagSet) func([]string) {
+	tolerance := fs.Float64("tolerance", 0.1, "distance in mm within which points count as the same")
+	list := fs.Bool("l", false, "list what would change in each file instead of rewriting it")
+
+	return func(args []string) {
+		if len(args) == 0 {
+			fatal("optimize takes one or more .sketch files")
+		}
+
+		passed := true
+		for _, path := range args {
+			code, err := os.ReadFile(path)
+			if err != nil {
+				printf("%s: %v", path, err)
+				passed = false
+				continue
+			}
+			optimized, stats, err := sketchlang.Optimize(string(code), *tolerance)
+			if err != nil {
+				printf("%s: %v", path, err)
+				passed = false
+				continue
+			}
+			if !stats.Changed() {
+				continue
+			}
+			fmt.Printf("%s: %s\n", path, stats)
+			if *list {
+				continue
+			}
+			if err := os.WriteFile(path, []byte(optimized), 0644); err != nil {
+				printf("%s: %v", path, err)
+				passed = false
+			}
+		}
+		if !passed {
+			os.Exit(1)
+		}
+	}
+}
+
+func setupImport(fs *flag.FlagSet) func([]string) {
+	canvas := addSizeFlags(fs)
+	margin := fs.Float64("margin", 0, "border in mm to keep blank on every side")
+	output := fs.String("o", "", "output name (default: input name)")
+
+	return func(args []string) {
+		if len(args) != 1 {
+			fatal("import takes one .svg file")
+		}
+		svg, err := os.ReadFile(args[0])
+		if err != nil {
+			fatal("%v", err)
+		}
+		code, stats, err := svgimport.Import(string(svg), svgimport.Options{Size: sketchlang.Point(canvas()), Margin: *margin})
+		if err != nil {
+			fatal("%s: %v", args[0], err)
+		}
+
+		outName := *output
+		if outName == "" {
+			outName = strings.TrimSuffix(filepath.Base(args[0]), filepath.Ext(args[0]))
+		}
+		code = fmt.Sprintf("# Imported from %s\n\n%s", filepath.Base(args[0]), code)
+		if err := os.WriteFile(outName+".sketch", []byte(code), 0644); err != nil {
+			fatal("%v", err)
+		}
+		printf("imported %s", stats)
+		abs, _ := filepath.Abs(outName + ".sketch")
+		fmt.Println(abs)
+	}
+}
+
+func setupVerify(fs *flag.FlagSet) func([]string) {
+	pos := fs.String("pos", "0,0", "position x,y in mm")
+	canvas := addSizeFlags(fs)
+	tolerance := fs.Float64("tolerance", 0.5, "allowed path deviation, in SVG units")
+	jobs := fs.Int("j", compileWorkers, "max compiles to run at once")
+	order := fs.Bool("optimize-travel", false, "reorder the recompiled paths first, for sketches saved with -optimize-travel")
+	debug := fs.Bool("debug", false, "emit debug logs")
+	useBackend := addBackendFlag(fs)
+	useSeed := addSeedFlag(fs)
+
+	return func(args []string) {
+		if len(args) != 1 {
+			fatal("verify takes one directory")
+		}
+		useBackend()
+		useSeed()
+		compileWorkers = *jobs
+		if !verify(args[0], parseVec(*pos), canvas(), *tolerance, *order, &Logger{enabled: *debug}) {
+			os.Exit(1)
+		}
+	}
+}
+
+func setupDoctor(fs *flag.FlagSet) func([]string) {
+	debug := fs.Bool("debug", false, "emit debug logs")
+	useBackend := addBackendFlag(fs)
+
+	return func([]string) {
+		useBackend()
+		if !doctor(&Logger{enabled: *debug}) {
+			os.Exit(1)
+		}
+	}
+}
+
+func setupExport(fs *flag.FlagSet) func([]string) {
+	formats := fs.String("format", "hpgl", "formats to write beside each file: "+strings.Join(ExporterNames(), ", "))
+	order := fs.Bool("optimize-travel", false, "reorder the paths to cut the pen-up travel between them first")
+	addRasterFlags(fs)
+
+	return func(args []string) {
+		if len(args) == 0 {
+			fatal("export takes one or more .svg files")
+		}
+		exports, err := ParseExporters(*formats)
+		if err != nil {
+			fatal("%v", err)
+		}
+
+		passed := true
+		for _, path := range args {
+			svg, err := os.ReadFile(path)
+			if err != nil {
+				printf("%s: %v", path, err)
+				passed = false
+				continue
+			}
+			s := string(svg)
+			if *order {
+				s = orderPaths(s)
+			}
+			passed = exportSVG(strings.TrimSuffix(path, ".svg"), s, exports) && passed
+		}
+		if !passed {
+			os.Exit(1)
+		}
+	}
+}
+
+func setupTile(fs *flag.FlagSet) func([]string) {
+	bed := fs.String("bed", "", "plotter bed w,h in mm (required)")
+	overlap := fs.Float64("overlap", 10, "mm each tile overlaps its neighbours by")
+	formats := fs.String("format", "", "also write each tile in these formats: "+strings.Join(ExporterNames(), ", "))
+	addRasterFlags(fs)
+
+	return func(args []string) {
+		if len(args) == 0 {
+			fatal("tile takes one or more .svg files")
+		}
+		b := parseVec(*bed)
+		if b.X <= *overlap || b.Y <= *overlap {
+			fatal("bed: want w,h larger than -overlap")
+		}
+		exports, err := ParseExporters(*formats)
+		if err != nil {
+			fatal("%v", err)
+		}
+
+		passed := true
+		for _, path := range args {
+			svg, err := os.ReadFile(path)
+			if err != nil {
+				printf("%s: %v", path, err)
+				passed = false
+				continue
+			}
+			passed = writeTiles(strings.TrimSuffix(path, ".svg"), string(svg), b, *overlap, exports) && passed
+		}
+		if !passed {
+			os.Exit(1)
+		}
+	}
+}
+
+func setupPlot(fs *flag.FlagSet) func([]string) {
+	port := fs.String("port", "/dev/ttyUSB0", "serial port of the plotter")
+	baud := fs.Int("baud", 115200, "baud rate of the serial port")
+	pos := fs.String("pos", "0,0", "position x,y in mm, for a .sketch file")
+	canvas := addSizeFlags(fs)
+	pen := fs.String("pen", "", "pen depth for each render mode, for a .sketch file, e.g. trace=2.6,draw=2.2,scribble=1.6")
+	dryRun := fs.Bool("n", false, "print the G-code that would be sent, without plotting")
+	debug := fs.Bool("debug", false, "emit debug logs")
+
+	return func(args []string) {
+		if len(args) != 1 {
+			fatal("plot takes one .sketch or G-code file")
+		}
+		log := &Logger{enabled: *debug}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			fatal("%v", err)
+		}
+		pens, err := ParsePenDepths(*pen)
+		if err != nil {
+			fatal("pen: %v", err)
+		}
+		program := string(data)
+		if strings.HasSuffix(args[0], ".sketch") {
+			name := strings.TrimSuffix(filepath.Base(args[0]), ".sketch")
+			if program, err = CompilePenGcode(ctx, program, name, parseVec(*pos), canvas(), pens, log); err != nil {
+				fatal("%v", err)
+			}
+		} else if len(pens) > 0 {
+			fatal("-pen needs a .sketch file, to tell which mode drew each path")
+		}
+		lines, err := plotter.Lines(strings.NewReader(program))
+		if err != nil {
+			fatal("%v", err)
+		}
+		if *dryRun {
+			fmt.Println(strings.Join(lines, "\n"))
+			return
+		}
+
+		f, err := plotter.OpenSerial(*port, *baud)
+		if err != nil {
+			fatal("%v", err)
+		}
+		defer f.Close()
+		p := plotter.New(f)
+
+		// GRBL restarts as the port opens; wait for it, but carry on for
+		// boards that don't.
+		waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		banner, err := p.Wait(waitCtx)
+		cancel()
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			printf("warning: no GRBL banner from %s, plotting anyway", *port)
+		default:
+			log.Info("%s", banner)
+		}
+
+		printf("plotting %d lines (%s); enter p to pause, r to resume, a to abort", len(lines), gcode.Analyze(program, gcode.DefaultSpeeds))
+		go plotControls(os.Stdin, p)
+		p.Progress = func(pr plotter.Progress) {
+			fmt.Fprintf(os.Stderr, "\r\033[Kplotting... %d/%d lines (%d%%)", pr.Done, pr.Total, 100*pr.Done/pr.Total)
+		}
+		err = p.Stream(ctx, lines)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			fatal("plot: %v", err)
+		}
+	}
+}
+
+// plotControls pauses, resumes or aborts p as p, r or a lines arrive on
+// r.
+func plotControls(r io.Reader, p *plotter.Plotter) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var err error
+		switch strings.ToLower(strings.TrimSpace(scanner.Text())) {
+		case "p", "pause":
+			err = p.Pause()
+		case "r", "resume":
+			err = p.Resume()
+		case "a", "abort":
+			err = p.Abort()
+		}
+		if err != nil {
+			printf("warning: %v", err)
+		}
+	}
+}
+
+func setupGallery(fs *flag.FlagSet) func([]string) {
+	text := fs.String("q", "", "words the title, subject or summary must all contain")
+	style := fs.String("style", "", "only sketches in this style")
+	requester := fs.String("requester", "", "only sketches this user ran")
+	since := fs.String("since", "", "only sketches made on or after this date, YYYY-MM-DD")
+	until := fs.String("until", "", "only sketches made before this date, YYYY-MM-DD")
+	maxCost := fs.Float64("max-cost", 0, "only sketches that cost at most this many dollars")
+	show := fs.String("show", "", "print the manifest of the sketch with this id")
+	asJSON := fs.Bool("json", false, "print one JSON object per sketch")
+	asHTML := fs.Bool("html", false, "also write gallery.html in the directory, showing the sketches' thumbnails")
+
+	return func(args []string) {
+		if len(args) > 1 {
+			fatal("gallery takes at most one directory")
+		}
+		root := "."
+		if len(args) == 1 {
+			root = args[0]
+		}
+		q := gallery.Query{Text: *text, Style: *style, Requester: *requester, MaxCost: *maxCost}
+		for _, d := range []struct {
+			flag, value string
+			to          *time.Time
+		}{{"since", *since, &q.Since}, {"until", *until, &q.Until}} {
+			if d.value == "" {
+				continue
+			}
+			t, err := time.ParseInLocation(time.DateOnly, d.value, time.Local)
+			if err != nil {
+				fatal("%s: want a date like 2025-01-31", d.flag)
+			}
+			*d.to = t
+		}
+
+		ix, err := gallery.Scan(root)
+		if err != nil {
+			fatal("gallery: %v", err)
+		}
+		for _, err := range ix.Errors {
+			printf("warning: %v", err)
+		}
+
+		if *show != "" {
+			m, err := ix.Get(*show)
+			if err != nil {
+				fatal("%v", err)
+			}
+			data, _ := json.MarshalIndent(m, "", "  ")
+			fmt.Println(string(data))
+			return
+		}
+
+		entries := ix.Search(q)
+		if *asHTML {
+			var page strings.Builder
+			if err := gallery.WriteHTML(&page, entries); err != nil {
+				fatal("gallery: %v", err)
+			}
+			path := filepath.Join(root, "gallery.html")
+			if err := os.WriteFile(path, []byte(page.String()), 0644); err != nil {
+				fatal("%v", err)
+			}
+			printf("wrote %s", path)
+		}
+		for _, e := range entries {
+			if *asJSON {
+				data, _ := json.Marshal(e)
+				fmt.Println(string(data))
+				continue
+			}
+			what := e.Title
+			if e.Style != "" {
+				what += " (" + e.Style + ")"
+			}
+			fmt.Printf("%s  $%.4f  %-24s %s\n", e.Date.Local().Format("2006-01-02 15:04"), e.Cost, e.ID, what)
+		}
+		if len(entries) == 0 && !*asJSON {
+			fmt.Printf("no matching sketches of %d\n", len(ix.Entries))
+		}
+	}
+}
+
+func setupStats(fs *flag.FlagSet) func([]string) {
+	heatmap := fs.Bool("heatmap", true, "draw where the ink is under each file's stats")
+
+	return func(args []string) {
+		if len(args) == 0 {
+			fatal("stats takes one or more .svg files")
+		}
+
+		passed := true
+		for _, path := range args {
+			svg, err := os.ReadFile(path)
+			if err != nil {
+				printf("%s: %v", path, err)
+				passed = false
+				continue
+			}
+			// The code beside the SVG, if any, gives the primitive counts.
+			code, _ := os.ReadFile(strings.TrimSuffix(path, ".svg") + ".sketch")
+			stats, err := SketchStats(string(code), string(svg))
+			if err != nil {
+				printf("%s: %v", path, err)
+				passed = false
+				continue
+			}
+			fmt.Printf("%s: %s\n", path, stats)
+			if *heatmap {
+				fmt.Print(stats.Heatmap())
+			}
+		}
+		if !passed {
+			os.Exit(1)
+		}
+	}
+}
+
+func setupCompare(fs *flag.FlagSet) func([]string) {
+	out := fs.String("o", "", "output SVG (default <name>_v<a>_v<b>.compare.svg)")
+	side := fs.Bool("side", false, "draw the versions side by side instead of over each other")
+	tolerance := fs.Float64("tolerance", 0.5, "how far a path may move and still be unchanged, in SVG units")
+
+	return func(args []string) {
+		if len(args) != 2 {
+			fatal("compare takes two versions of a sketch, like art@v1 art@v2")
+		}
+		var labels, svgs [2]string
+		var paths [2][]Polyline
+		for i, arg := range args {
+			m, err := loadVersion(arg)
+			if err != nil {
+				fatal("%s: %v", arg, err)
+			}
+			svg, err := os.ReadFile(m.File(m.SVG))
+			if err == nil {
+				paths[i], err = ParseSVGPaths(string(svg))
+			}
+			if err != nil {
+				fatal("%s: %v", arg, err)
+			}
+			labels[i], svgs[i] = fmt.Sprintf("v%d", max(m.Version, 1)), string(svg)
+		}
+
+		d := DiffPaths(paths[0], paths[1], *tolerance)
+		viewBox, _ := splitSVG(svgs[1])
+		path := *out
+		if path == "" {
+			name, _, _ := strings.Cut(args[0], "@")
+			name = strings.TrimSuffix(strings.TrimSuffix(name, ".svg"), ".sketch")
+			path = fmt.Sprintf("%s_%s_%s.compare.svg", name, labels[0], labels[1])
+		}
+		if err := os.WriteFile(path, []byte(DiffSVG(viewBox, d, strokeWidth(svgs[1]), *side)), 0644); err != nil {
+			fatal("%v", err)
+		}
+		abs, _ := filepath.Abs(path)
+		fmt.Println(abs)
+		printf("%s to %s: %s", labels[0], labels[1], d)
+	}
+}
+
+// loadVersion reads the manifest of a sketch written as name@v<n>, or
+// name for its current version.
+func loadVersion(arg string) (*manifest.Sketch, error) {
+	name, version, ok := strings.Cut(arg, "@")
+	if !ok {
+		return manifest.LoadSketch(name)
+	}
+	v, err := strconv.Atoi(strings.TrimPrefix(version, "v"))
+	if err != nil || v < 1 {
+		return nil, fmt.Errorf("version %q is not v<n>", version)
+	}
+	return manifest.LoadVersion(name, v)
+}
+
+func setupPrompts(fs *flag.FlagSet) func([]string) {
+	return func(args []string) {
+		if len(args) != 1 {
+			fatal("prompts takes one directory")
+		}
+		written, err := WritePrompts(args[0])
+		for _, path := range written {
+			abs, _ := filepath.Abs(path)
+			fmt.Println(abs)
+		}
+		if err != nil {
+			fatal("%v", err)
+		}
+	}
+}
+
+func setupCompletion(fs *flag.FlagSet) func([]string) {
+	return func(args []string) {
+		if len(args) != 1 || (args[0] != "bash" && args[0] != "zsh") {
+			fatal("completion takes bash or zsh")
+		}
+		fmt.Print(completionScript(args[0], filepath.Base(os.Args[0])))
+	}
+}
+
+// completionScript builds a bash completion function from the command
+// table and each command's flags. zsh loads it through bashcompinit.
+func completionScript(shell, prog string) string {
+	fn := "_" + strings.NewReplacer("-", "_", ".", "_").Replace(prog)
+
+	var names []string
+	var cases strings.Builder
+	for _, c := range commands {
+		names = append(names, c.name)
+
+		fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+		c.setup(fs)
+		var flags []string
+		fs.VisitAll(func(f *flag.Flag) { flags = append(flags, "-"+f.Name) })
+		sort.Strings(flags)
+		fmt.Fprintf(&cases, "    %s) words=\"%s\" ;;\n", c.name, strings.Join(flags, " "))
+	}
+
+	var b strings.Builder
+	if shell == "zsh" {
+		b.WriteString("autoload -U +X bashcompinit && bashcompinit\n")
+	}
+	fmt.Fprintf(&b, `%s() {
+  local cur=${COMP_WORDS[COMP_CWORD]} words
+  if [ "$COMP_CWORD" -eq 1 ] && [[ "$cur" != -* ]]; then
+    COMPREPLY=($(compgen -W "%s" -- "$cur"))
+    return
+  fi
+  local cmd=${COMP_WORDS[1]}
+  [[ "$cmd" == -* ]] && cmd=generate
+  case $cmd in
+%s  esac
+  if [[ "$cur" == -* ]]; then
+    COMPREPLY=($(compgen -W "$words" -- "$cur"))
+  else
+    COMPREPLY=($(compgen -f -- "$cur"))
+  fi
+}
+complete -F %s %s
+`, fn, strings.Join(names, " "), cases.String(), fn, prog)
+	return b.String()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Colours of the paths in a comparison.
+const (
+	keptColour    = "#bbb"
+	removedColour = "#d62728"
+	addedColour   = "#2ca02c"
+)
+
+// PathDiff is how the paths of two versions of a sketch differ.
+type PathDiff struct {
+	Removed []Polyline // only in the older version
+	Added   []Polyline // only in the newer
+	Kept    []Polyline // in both, as the newer has them
+}
+
+func (d PathDiff) String() string {
+	return fmt.Sprintf("%d paths added, %d removed, %d unchanged", len(d.Added), len(d.Removed), len(d.Kept))
+}
+
+// DiffPaths matches the paths of an older and a newer version one to one:
+// a path of the newer within tol of one of the older, in both directions
+// and whichever way it is drawn, is kept, and the rest are added or
+// removed.
+func DiffPaths(older, newer []Polyline, tol float64) PathDiff {
+	var d PathDiff
+	matched := make([]bool, len(older))
+	for _, p := range newer {
+		found := false
+		for i, q := range older {
+			if !matched[i] && math.Max(polylineDistance(p, q), polylineDistance(q, p)) <= tol {
+				matched[i], found = true, true
+				break
+			}
+		}
+		if found {
+			d.Kept = append(d.Kept, p)
+		} else {
+			d.Added = append(d.Added, p)
+		}
+	}
+	for i, q := range older {
+		if !matched[i] {
+			d.Removed = append(d.Removed, q)
+		}
+	}
+	return d
+}
+
+// DiffSVG draws d on a canvas of viewBox: unchanged paths in grey, removed
+// ones in red and added ones in green, over each other, or with side set,
+// the older version on the left and the newer on the right.
+func DiffSVG(viewBox string, d PathDiff, pen float64, side bool) string {
+	v := parseNumbers(viewBox)
+	if len(v) != 4 {
+		v = []float64{0, 0, 100, 100}
+	}
+	gap := v[2] / 20
+	width := v[2]
+	if side {
+		width = 2*v[2] + gap
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%gmm" height="%gmm" viewBox="0 0 %g %g">`+"\n", width, v[3], width, v[3])
+	b.WriteString(`  <rect width="100%" height="100%" fill="white"/>` + "\n")
+	panel := func(x float64, groups ...[]Polyline) {
+		fmt.Fprintf(&b, `  <svg x="%g" y="0" width="%g" height="%g" viewBox="%g %g %g %g">`+"\n", x, v[2], v[3], v[0], v[1], v[2], v[3])
+		colours := []string{keptColour, removedColour, addedColour}
+		for i, paths := range groups {
+			for _, p := range paths {
+				fmt.Fprintf(&b, `    <path d="%s" fill="none" stroke="%s" stroke-width="%g"/>`+"\n", pathD(p), colours[i], pen)
+			}
+		}
+		b.WriteString("  </svg>\n")
+	}
+	if side {
+		panel(0, d.Kept, d.Removed)
+		panel(v[2]+gap, d.Kept, nil, d.Added)
+	} else {
+		panel(0, d.Kept, d.Removed, d.Added)
+	}
+	b.WriteString("</svg>\n")
+	return b.String()
+}
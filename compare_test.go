@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffPaths(t *testing.T) {
+	older := []Polyline{
+		{{0, 0}, {10, 0}},
+		{{0, 5}, {10, 5}},
+		{{0, 9}, {10, 9}},
+	}
+	newer := []Polyline{
+		{{10, 5.2}, {0, 5.2}}, // moved a little and drawn the other way
+		{{0, 0}, {10, 0}},
+		{{5, 0}, {5, 10}},
+	}
+	d := DiffPaths(older, newer, 0.5)
+	if len(d.Kept) != 2 || len(d.Added) != 1 || len(d.Removed) != 1 {
+		t.Fatalf("DiffPaths() = %s", d)
+	}
+	if d.Added[0][0] != (Vec2{5, 0}) || d.Removed[0][0] != (Vec2{0, 9}) {
+		t.Errorf("added %v, removed %v", d.Added, d.Removed)
+	}
+
+	// A path matches only one path of the other version.
+	d = DiffPaths(older[:1], []Polyline{older[0], older[0]}, 0.5)
+	if len(d.Kept) != 1 || len(d.Added) != 1 {
+		t.Errorf("a duplicated path: %s", d)
+	}
+
+	overlay := DiffSVG("0 0 10 10", PathDiff{Removed: older[2:], Added: newer[2:]}, 0.3, false)
+	side := DiffSVG("0 0 10 10", PathDiff{Removed: older[2:], Added: newer[2:]}, 0.3, true)
+	for _, svg := range []string{overlay, side} {
+		if !strings.Contains(svg, removedColour) || !strings.Contains(svg, addedColour) {
+			t.Errorf("removed and added paths not coloured:\n%s", svg)
+		}
+	}
+	if strings.Count(side, "<svg") != 3 || !strings.Contains(side, `width="20.5mm"`) {
+		t.Errorf("side by side is not two panels:\n%s", side)
+	}
+}
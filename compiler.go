@@ -2,74 +2,452 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sketch-studio/tools/sketchlang"
 )
 
 const compilerBin = "sketchlang" // assumes in PATH
 
-func Compile(code, outputName string, pos, size Vec2, log *Logger) (string, error) {
-	tmpDir, err := os.MkdirTemp("", "sketch-")
+// compileTimeout bounds each run of the compiler, 0 for no limit. A huge
+// sketch, such as hundreds of thousands of dashes, can otherwise stall a
+// whole run.
+var compileTimeout = time.Minute
+
+// compileWorkers caps how many compiles CompileMany runs at once.
+var compileWorkers = runtime.NumCPU()
+
+// compileSeed seeds the noise of draw and scribble, so the same code
+// wobbles the same way every run. 0 leaves it to the compiler, which may
+// pick a new one each time; the built-in renderer then uses 0.
+var compileSeed int64
+
+func Compile(ctx context.Context, code, outputName string, pos, size Vec2, log *Logger) (string, error) {
+	svg, stderr, err := compiler().Compile(ctx, code, outputName, pos, size, log)
+	if err != nil {
+		if errors.Is(err, errCompileTimeout) || ctx.Err() != nil || stderr == "" {
+			return "", err
+		}
+		return "", fmt.Errorf("compile error: %s", stderr)
+	}
+	if !strings.Contains(svg, "<svg") {
+		return "", fmt.Errorf("SVG not generated")
+	}
+	return svg, nil
+}
+
+// CompileGcode compiles code at pos on a canvas of size to G-code for a
+// plotter, with the compiler's --gcode. The built-in renderer draws only
+// SVG, so this always runs the compiler.
+func CompileGcode(ctx context.Context, code, outputName string, pos, size Vec2, log *Logger) (string, error) {
+	caps, err := Probe()
 	if err != nil {
 		return "", err
 	}
-	defer os.RemoveAll(tmpDir)
+	noSVG := caps
+	noSVG.SVG = false
+	args := append(noSVG.flags(pos, size), "--gcode")
 
-	inputPath := filepath.Join(tmpDir, outputName+".sketch")
-	if err := os.WriteFile(inputPath, []byte(code), 0644); err != nil {
+	var gcode, stderr string
+	if caps.InMemory {
+		args = append([]string{"-", "-o", "-"}, args...)
+		log.Debug("running: %s %v", compilerBin, args)
+		gcode, stderr, err = runCompiler(ctx, "", code, args...)
+	} else {
+		gcode, stderr, err = compileFiles(ctx, code, outputName, ".txt", args, log)
+	}
+	if err != nil {
+		if errors.Is(err, errCompileTimeout) || ctx.Err() != nil || stderr == "" {
+			return "", err
+		}
+		return "", fmt.Errorf("compile error: %s", stderr)
+	}
+	if strings.TrimSpace(gcode) == "" {
+		return "", fmt.Errorf("G-code not generated")
+	}
+	return gcode, nil
+}
+
+// execBackend runs the sketchlang compiler in PATH, through stdin and
+// stdout if it can, and files in a temp dir if not.
+type execBackend struct{}
+
+func (execBackend) Compile(ctx context.Context, code, name string, pos, size Vec2, log *Logger) (string, string, error) {
+	caps, err := Probe()
+	if err != nil {
+		return "", "", err
+	}
+	if !caps.InMemory {
+		return compileFiles(ctx, code, name, ".svg", caps.flags(pos, size), log)
+	}
+	args := append([]string{"-", "-o", "-"}, caps.flags(pos, size)...)
+	log.Debug("running: %s %v", compilerBin, args)
+	return runCompiler(ctx, "", code, args...)
+}
+
+func (execBackend) Check(ctx context.Context, code string) (string, error) {
+	caps, err := Probe()
+	if err != nil {
+		return "", err
+	}
+	args := append([]string{"-o", "-"}, caps.svgFlag()...)
+	switch {
+	case caps.CheckOnly:
+		args = []string{"--check"}
+	case !caps.InMemory:
+		args = append([]string{"-o", "_validate"}, caps.svgFlag()...)
+	}
+	if !caps.InMemory {
+		return validateFiles(ctx, code, args...)
+	}
+	_, stderr, err := runCompiler(ctx, "", code, append([]string{"-"}, args...)...)
+	return stderr, err
+}
+
+func (execBackend) Version() (string, error) {
+	caps, err := Probe()
+	if err != nil {
 		return "", err
 	}
+	if caps.Version == "" {
+		return "", fmt.Errorf("no version from %s --version", compilerBin)
+	}
+	return caps.Version, nil
+}
+
+// CacheKey is the compiler's path, size and modification time, so a
+// rebuilt compiler misses the cache.
+func (execBackend) CacheKey() string {
+	bin, err := exec.LookPath(compilerBin)
+	if err != nil {
+		return ""
+	}
+	info, err := os.Stat(bin)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s\x00%d\x00%d", bin, info.Size(), info.ModTime().UnixNano())
+}
+
+// compileFiles compiles code through files in a temp dir, for compilers
+// that cannot use stdin and stdout, and returns the output with ext: .svg,
+// or .txt for G-code. It returns "" if none was written.
+func compileFiles(ctx context.Context, code, outputName, ext string, args []string, log *Logger) (out, stderr string, err error) {
+	tmpDir, err := os.MkdirTemp("", "sketch-")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(tmpDir)
 
-	args := []string{
-		outputName + ".sketch",
-		"-o", outputName,
-		"-pos", fmt.Sprintf("%g,%g", pos.X, pos.Y),
-		"-size", fmt.Sprintf("%g,%g", size.X, size.Y),
-		"--svg",
+	// Only the base name matters inside the temp dir; callers place the
+	// outputs themselves.
+	outputName = filepath.Base(outputName)
+	inputPath := filepath.Join(tmpDir, outputName+".sketch")
+	if err := os.WriteFile(inputPath, []byte(code), 0644); err != nil {
+		return "", "", err
 	}
 
+	args = append([]string{outputName + ".sketch", "-o", outputName}, args...)
 	log.Debug("running: %s %v", compilerBin, args)
+	if _, stderr, err := runCompiler(ctx, tmpDir, "", args...); err != nil {
+		return "", stderr, err
+	}
 
-	cmd := exec.Command(compilerBin, args...)
-	cmd.Dir = tmpDir
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+	data, err := os.ReadFile(filepath.Join(tmpDir, outputName+ext))
+	if err != nil {
+		return "", "", nil
+	}
+	return string(data), "", nil
+}
 
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("compile error: %s", stderr.String())
+// Validate checks that code compiles. Code the linter finds errors in is
+// turned away without running the compiler, with a suggestion for each.
+func Validate(ctx context.Context, code string, log *Logger) (bool, []string) {
+	var errs []string
+	for _, d := range sketchlang.Lint(code, sketchlang.Canvas{}) {
+		if d.Severity == "error" {
+			errs = append(errs, d.String())
+		}
+	}
+	if len(errs) > 0 {
+		log.Debug("lint: %d errors", len(errs))
+		return false, errs
 	}
 
-	svgPath := filepath.Join(tmpDir, outputName+".svg")
-	svg, err := os.ReadFile(svgPath)
+	stderr, err := compiler().Check(ctx, code)
 	if err != nil {
-		return "", fmt.Errorf("SVG not generated")
+		if errors.Is(err, errCompileTimeout) {
+			return false, []string{err.Error() + "; the sketch is too large, draw it with far fewer primitives"}
+		}
+		if errs := CompileErrors(stderr, code); len(errs) > 0 && ctx.Err() == nil {
+			return false, errs
+		}
+		return false, []string{err.Error()}
 	}
 
-	return string(svg), nil
+	return true, nil
 }
 
-func Validate(code string, log *Logger) (bool, []string) {
+// validateFiles runs the compiler with args on code in a file in a temp
+// dir.
+func validateFiles(ctx context.Context, code string, args ...string) (string, error) {
 	tmpDir, err := os.MkdirTemp("", "sketch-validate-")
 	if err != nil {
-		return false, []string{err.Error()}
+		return "", err
 	}
 	defer os.RemoveAll(tmpDir)
 
 	inputPath := filepath.Join(tmpDir, "_validate.sketch")
 	if err := os.WriteFile(inputPath, []byte(code), 0644); err != nil {
-		return false, []string{err.Error()}
+		return "", err
 	}
+	_, stderr, err := runCompiler(ctx, tmpDir, "", append([]string{"_validate.sketch"}, args...)...)
+	return stderr, err
+}
+
+// builtin records whether the compiler is missing from PATH, in which case
+// sketches are rendered by tools/sketchlang instead.
+var builtin struct {
+	once sync.Once
+	ok   bool
+}
+
+func usesBuiltin() bool {
+	builtin.once.Do(func() {
+		_, err := exec.LookPath(compilerBin)
+		builtin.ok = err != nil
+	})
+	return builtin.ok
+}
 
-	cmd := exec.Command(compilerBin, "_validate.sketch", "-o", "_validate", "--svg")
-	cmd.Dir = tmpDir
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+// builtinBackend renders with tools/sketchlang, without a compiler. Its
+// compiles are quick enough not to cache.
+type builtinBackend struct{}
 
-	if err := cmd.Run(); err != nil {
-		return false, []string{stderr.String()}
+func (builtinBackend) Compile(ctx context.Context, code, name string, pos, size Vec2, log *Logger) (string, string, error) {
+	log.Debug("rendering with the built-in renderer")
+	return renderBuiltin(code, pos, size)
+}
+
+func (builtinBackend) Check(ctx context.Context, code string) (string, error) {
+	_, stderr, err := renderBuiltin(code, Vec2{}, Vec2{})
+	return stderr, err
+}
+
+func (builtinBackend) Version() (string, error) {
+	return "", errors.New("the built-in renderer follows the built-in spec")
+}
+
+// renderBuiltin renders code with the built-in renderer, giving its errors
+// one per line, as the compiler would.
+func renderBuiltin(code string, pos, size Vec2) (svg, stderr string, err error) {
+	svg, err = sketchlang.RenderSVG(code, sketchlang.RenderOptions{
+		Pos:  sketchlang.Point{X: pos.X, Y: pos.Y},
+		Size: sketchlang.Point{X: size.X, Y: size.Y},
+		Seed: compileSeed,
+	})
+	var errs sketchlang.ErrorList
+	if errors.As(err, &errs) {
+		return "", strings.Join(errs.Strings(), "\n"), err
 	}
+	return svg, "", err
+}
 
-	return true, nil
+// Capabilities are what the compiler in PATH takes, as Probe finds them.
+type Capabilities struct {
+	Version   string // from --version, "" if it printed none
+	SVG       bool   // takes --svg; a compiler without it writes SVG anyway
+	Placement bool   // takes -pos and -size
+	Seed      bool   // takes -seed, only if its help says so
+	// CheckOnly is set if the compiler takes --check, to parse and check
+	// code without rendering or writing anything, which Check uses.
+	CheckOnly bool
+	// InMemory is set if the compiler reads code from stdin and writes the
+	// SVG to stdout when given "-" as its input and output name, which
+	// saves writing and reading a file for every compile.
+	InMemory bool
+}
+
+var probed struct {
+	once sync.Once
+	caps Capabilities
+	err  error
+}
+
+// Probe works out what the compiler in PATH takes from its --version and
+// --help, and compiles a trivial program to see whether it can use stdin
+// and stdout. A compiler whose help names none of the flags is taken to
+// accept them all, as sketchlang always has, except -seed and --check,
+// which are newer.
+// Probe fails if the program
+// cannot compile that trivial program either way, such as another tool of
+// the same name. It runs once, on first use.
+func Probe() (Capabilities, error) {
+	probed.once.Do(func() {
+		probed.caps, probed.err = probe(context.Background())
+	})
+	return probed.caps, probed.err
+}
+
+const probeProgram = "trace dot at (1, 1)\n"
+
+var (
+	svgFlagPattern   = regexp.MustCompile(`(^|[^\w-])--svg\b`)
+	posFlagPattern   = regexp.MustCompile(`(^|[^\w-])-pos\b`)
+	sizeFlagPattern  = regexp.MustCompile(`(^|[^\w-])-size\b`)
+	seedFlagPattern  = regexp.MustCompile(`(^|[^\w-])-seed\b`)
+	checkFlagPattern = regexp.MustCompile(`(^|[^\w-])--check\b`)
+)
+
+func probe(ctx context.Context) (Capabilities, error) {
+	if usesBuiltin() {
+		return Capabilities{}, fmt.Errorf("%s not found in PATH", compilerBin)
+	}
+	stdout, stderr, _ := runCompiler(ctx, "", "", "--version")
+	about := strings.TrimSpace(stdout + stderr)
+	caps := Capabilities{Version: versionPattern.FindString(about)}
+
+	stdout, stderr, _ = runCompiler(ctx, "", "", "--help")
+	caps.flagsFromHelp(stdout + stderr)
+
+	svg, _, err := runCompiler(ctx, "", probeProgram, append([]string{"-", "-o", "-"}, caps.svgFlag()...)...)
+	caps.InMemory = err == nil && strings.Contains(svg, "<svg")
+	if caps.InMemory {
+		return caps, nil
+	}
+	if svg, _, _ := compileFiles(ctx, probeProgram, "_probe", ".svg", caps.svgFlag(), &Logger{}); svg != "" {
+		return caps, nil
+	}
+
+	if about == "" {
+		about = "nothing"
+	} else {
+		about = fmt.Sprintf("%q", strings.SplitN(about, "\n", 2)[0])
+	}
+	path, _ := exec.LookPath(compilerBin)
+	return caps, fmt.Errorf("%s is not the SketchLang compiler: it compiles nothing, and --version printed %s", path, about)
+}
+
+// flagsFromHelp sets the flags c takes from the compiler's --help.
+// Placement needs both -pos and -size.
+func (c *Capabilities) flagsFromHelp(help string) {
+	svg := svgFlagPattern.MatchString(help)
+	pos, size := posFlagPattern.MatchString(help), sizeFlagPattern.MatchString(help)
+	if svg || pos || size {
+		c.SVG, c.Placement = svg, pos && size
+	} else {
+		c.SVG, c.Placement = true, true
+	}
+	c.Seed = seedFlagPattern.MatchString(help)
+	c.CheckOnly = checkFlagPattern.MatchString(help)
+}
+
+// svgFlag is --svg, if the compiler takes it.
+func (c Capabilities) svgFlag() []string {
+	if !c.SVG {
+		return nil
+	}
+	return []string{"--svg"}
+}
+
+// flags are the -pos and -size of a compile and -seed, as far as the
+// compiler takes them, and --svg.
+func (c Capabilities) flags(pos, size Vec2) []string {
+	var args []string
+	if c.Placement {
+		args = []string{
+			"-pos", fmt.Sprintf("%g,%g", pos.X, pos.Y),
+			"-size", fmt.Sprintf("%g,%g", size.X, size.Y),
+		}
+	}
+	if c.Seed && compileSeed != 0 {
+		args = append(args, "-seed", strconv.FormatInt(compileSeed, 10))
+	}
+	return append(args, c.svgFlag()...)
+}
+
+// CompileJob is one sketch for CompileMany.
+type CompileJob struct {
+	Code      string
+	Name      string
+	Pos, Size Vec2
+}
+
+// CompileMany compiles jobs on a pool of up to compileWorkers at once. Each
+// compile runs in a temp dir of its own, so jobs may share a name. A job
+// that fails has "" for its SVG, with its error at the same index in errs.
+func CompileMany(ctx context.Context, jobs []CompileJob, log *Logger) (svgs []string, errs []error) {
+	return compileMany(jobs, func(j CompileJob) (string, error) {
+		return Compile(ctx, j.Code, j.Name, j.Pos, j.Size, log)
+	})
+}
+
+func compileMany(jobs []CompileJob, compile func(CompileJob) (string, error)) (svgs []string, errs []error) {
+	svgs, errs = make([]string, len(jobs)), make([]error, len(jobs))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(max(compileWorkers, 1), len(jobs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				svgs[i], errs[i] = compile(jobs[i])
+			}
+		}()
+	}
+	for i := range jobs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return svgs, errs
+}
+
+var errCompileTimeout = errors.New("compile timed out")
+
+// runCompiler runs the compiler in dir, with input on its stdin, and
+// returns what it wrote to stdout and stderr. A run that outlasts
+// compileTimeout or ctx is killed, along with any processes it started.
+func runCompiler(ctx context.Context, dir, input string, args ...string) (stdout, stderr string, err error) {
+	runCtx := ctx
+	if compileTimeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, compileTimeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(runCtx, compilerBin, args...)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(input)
+	var out, errOut bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	killProcessGroup(cmd)
+	// Don't wait on pipes held open by processes that outlive the kill.
+	cmd.WaitDelay = 5 * time.Second
+
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		return out.String(), errOut.String(), ctx.Err()
+	}
+	if runCtx.Err() != nil {
+		return out.String(), errOut.String(), fmt.Errorf("%w after %v", errCompileTimeout, compileTimeout)
+	}
+	return out.String(), errOut.String(), err
+}
+
+// CompilerVersion reports the version of the compiler in use, such as the
+// one printed by `sketchlang --version`.
+func CompilerVersion() (string, error) {
+	return compiler().Version()
 }
\ No newline at end of file
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// killProcessGroup leaves cmd to be killed on its own where there are no
+// process groups.
+func killProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestFlagsFromHelp(t *testing.T) {
+	tests := []struct {
+		name, help string
+		want       Capabilities
+	}{
+		{"no flags named", "usage: sketchlang <file>", Capabilities{SVG: true, Placement: true}},
+		{"all", "usage: sketchlang <file> [-o name] [-pos x,y] [-size w,h] [-seed n] [--svg] [--check]", Capabilities{SVG: true, Placement: true, Seed: true, CheckOnly: true}},
+		{"svg only", "usage: sketchlang <file> [--svg]", Capabilities{SVG: true}},
+		{"placement without svg", "usage: sketchlang <file> [-pos x,y] [-size w,h]", Capabilities{Placement: true}},
+		{"pos twice, no size", "usage: sketchlang <file> [--svg] [-pos x,y]\n  -pos  where to put the drawing", Capabilities{SVG: true}},
+		{"size only", "usage: sketchlang <file> [--svg] [-size w,h]", Capabilities{SVG: true}},
+		{"longer flags", "usage: sketchlang <file> [--svg] [-position x,y] [-sizes w,h] [--no-svg]", Capabilities{SVG: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Capabilities
+			got.flagsFromHelp(tt.help)
+			if got != tt.want {
+				t.Errorf("flagsFromHelp(%q) = %+v, want %+v", tt.help, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Constraint is one requirement on a sketch, like "no text" or "max 300
+// strokes". Every constraint is put to the model in the prompt; the ones
+// about primitives are also checked against the code it returns.
+type Constraint struct {
+	Text  string
+	check func(Primitives) string // "" when satisfied; nil for prompt-only constraints
+}
+
+// Primitives counts the primitives written in a sketch's code. A primitive
+// bound with let and drawn twice counts once.
+type Primitives struct {
+	Strokes int
+	Dots    int
+	Dashes  int
+	Splines int // strokes with via points
+}
+
+// Total is the number of strokes, dots and dashes.
+func (p Primitives) Total() int {
+	return p.Strokes + p.Dots + p.Dashes
+}
+
+var (
+	strokePattern = regexp.MustCompile(`\bstroke\s+from\b`)
+	dotPattern    = regexp.MustCompile(`\bdot\s+at\b`)
+	dashPattern   = regexp.MustCompile(`\bdash\s+at\b`)
+	viaPattern    = regexp.MustCompile(`\bvia\s*\[`)
+)
+
+// CountPrimitives counts the primitives in code, ignoring comments.
+func CountPrimitives(code string) Primitives {
+	var p Primitives
+	for _, line := range strings.Split(code, "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		p.Strokes += len(strokePattern.FindAllString(line, -1))
+		p.Dots += len(dotPattern.FindAllString(line, -1))
+		p.Dashes += len(dashPattern.FindAllString(line, -1))
+		p.Splines += len(viaPattern.FindAllString(line, -1))
+	}
+	return p
+}
+
+var (
+	maxPattern        = regexp.MustCompile(`^(?:max|maximum|at most|no more than)\s+(\d+)\s+(strokes|dots|dashes|primitives)$`)
+	noPattern         = regexp.MustCompile(`^no\s+(dots|dashes|splines|curves)$`)
+	continuousPattern = regexp.MustCompile(`^(?:a\s+)?(?:single|one)\s+continuous\s+(?:line|stroke)$`)
+)
+
+// SplitConstraints splits a comma-separated list of constraints.
+func SplitConstraints(list string) []string {
+	var texts []string
+	for _, text := range strings.Split(list, ",") {
+		if text = strings.TrimSpace(text); text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return texts
+}
+
+// ParseConstraints recognizes the constraints the studio can check:
+// "max N strokes|dots|dashes|primitives", "no dots|dashes|splines|curves"
+// and "single continuous line". Anything else is left to the model.
+func ParseConstraints(texts []string) []Constraint {
+	var cs []Constraint
+	for _, text := range texts {
+		c := Constraint{Text: text}
+		norm := strings.Join(strings.Fields(strings.ToLower(text)), " ")
+
+		if m := maxPattern.FindStringSubmatch(norm); m != nil {
+			limit, _ := strconv.Atoi(m[1])
+			kind := m[2]
+			c.check = func(p Primitives) string {
+				n := map[string]int{"strokes": p.Strokes, "dots": p.Dots, "dashes": p.Dashes, "primitives": p.Total()}[kind]
+				if n > limit {
+					return fmt.Sprintf("%s: the sketch has %d %s", text, n, kind)
+				}
+				return ""
+			}
+		} else if m := noPattern.FindStringSubmatch(norm); m != nil {
+			kind := m[1]
+			c.check = func(p Primitives) string {
+				n := map[string]int{"dots": p.Dots, "dashes": p.Dashes, "splines": p.Splines, "curves": p.Splines}[kind]
+				if n > 0 {
+					return fmt.Sprintf("%s: the sketch has %d", text, n)
+				}
+				return ""
+			}
+		} else if continuousPattern.MatchString(norm) {
+			c.check = func(p Primitives) string {
+				if p.Strokes != 1 || p.Dots+p.Dashes > 0 {
+					return fmt.Sprintf("%s: the sketch has %d strokes, %d dots and %d dashes; use one stroke with via points", text, p.Strokes, p.Dots, p.Dashes)
+				}
+				return ""
+			}
+		}
+		cs = append(cs, c)
+	}
+	return cs
+}
+
+// ConstraintPrompt adds the constraints to a sketch description.
+func ConstraintPrompt(description string, cs []Constraint) string {
+	if len(cs) == 0 {
+		return description
+	}
+	var b strings.Builder
+	b.WriteString(description)
+	b.WriteString("\n\nCONSTRAINTS: the sketch must obey all of these:\n")
+	for _, c := range cs {
+		fmt.Fprintf(&b, "- %s\n", c.Text)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// CheckConstraints returns a description of every checkable constraint
+// that code breaks.
+func CheckConstraints(code string, cs []Constraint) []string {
+	p := CountPrimitives(code)
+	var violations []string
+	for _, c := range cs {
+		if c.check == nil {
+			continue
+		}
+		if v := c.check(p); v != "" {
+			violations = append(violations, v)
+		}
+	}
+	return violations
+}
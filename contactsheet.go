@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	sheetCell  = 240.0
+	sheetLabel = 28.0
+	sheetGap   = 16.0
+)
+
+// SheetEntry is one sketch placed on a contact sheet.
+type SheetEntry struct {
+	Title string
+	SVG   string
+}
+
+// ContactSheet lays the sketches out in a near-square grid, each scaled
+// into its cell with the title underneath.
+func ContactSheet(entries []SheetEntry) string {
+	cols := int(math.Ceil(math.Sqrt(float64(len(entries)))))
+	rows := (len(entries) + cols - 1) / cols
+	width := float64(cols)*(sheetCell+sheetGap) + sheetGap
+	height := float64(rows)*(sheetCell+sheetLabel+sheetGap) + sheetGap
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%g" height="%g" viewBox="0 0 %g %g">`+"\n", width, height, width, height)
+	b.WriteString(`  <rect width="100%" height="100%" fill="white"/>` + "\n")
+
+	for i, e := range entries {
+		x := sheetGap + float64(i%cols)*(sheetCell+sheetGap)
+		y := sheetGap + float64(i/cols)*(sheetCell+sheetLabel+sheetGap)
+		viewBox, inner := splitSVG(e.SVG)
+
+		fmt.Fprintf(&b, `  <rect x="%g" y="%g" width="%g" height="%g" fill="none" stroke="#ccc"/>`+"\n", x, y, sheetCell, sheetCell)
+		fmt.Fprintf(&b, `  <svg x="%g" y="%g" width="%g" height="%g" viewBox="%s" preserveAspectRatio="xMidYMid meet">`+"\n", x, y, sheetCell, sheetCell, viewBox)
+		b.WriteString(inner)
+		b.WriteString("\n  </svg>\n")
+
+		var title bytes.Buffer
+		xml.EscapeText(&title, []byte(e.Title))
+		fmt.Fprintf(&b, `  <text x="%g" y="%g" font-family="sans-serif" font-size="14" text-anchor="middle">%s</text>`+"\n",
+			x+sheetCell/2, y+sheetCell+sheetLabel-8, title.String())
+	}
+
+	b.WriteString("</svg>\n")
+	return b.String()
+}
+
+// splitSVG returns the root element's viewBox (derived from width/height
+// when absent) and the markup inside it.
+func splitSVG(svg string) (string, string) {
+	open := strings.Index(svg, "<svg")
+	end := strings.Index(svg[max(open, 0):], ">")
+	last := strings.LastIndex(svg, "</svg>")
+	if open < 0 || end < 0 || last < 0 {
+		return "0 0 100 100", ""
+	}
+	end += open
+
+	dec := xml.NewDecoder(strings.NewReader(svg[open : end+1]))
+	dec.Strict = false
+	viewBox := "0 0 100 100"
+	if tok, err := dec.Token(); err == nil {
+		if el, ok := tok.(xml.StartElement); ok {
+			if v := attr(el, "viewBox"); v != "" {
+				viewBox = v
+			} else if w, h := parseNumbers(attr(el, "width")), parseNumbers(attr(el, "height")); len(w) > 0 && len(h) > 0 {
+				viewBox = fmt.Sprintf("0 0 %g %g", w[0], h[0])
+			}
+		}
+	}
+	return viewBox, svg[end+1 : last]
+}
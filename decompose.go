@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Part is one independent subject of a decomposed request, with its
+// region of the canvas as fractions of the canvas size, from the top left.
+// Where regions overlap, the part with the larger Z is in front.
+type Part struct {
+	Description string  `json:"description"`
+	X           float64 `json:"x"`
+	Y           float64 `json:"y"`
+	Width       float64 `json:"width"`
+	Height      float64 `json:"height"`
+	Z           int     `json:"z,omitempty"`
+}
+
+var decomposeSchema = map[string]any{
+	"type":     "object",
+	"required": []string{"title", "parts"},
+	"properties": map[string]any{
+		"title": map[string]any{"type": "string"},
+		"parts": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type":     "object",
+				"required": []string{"description", "x", "y", "width", "height"},
+				"properties": map[string]any{
+					"description": map[string]any{"type": "string", "minLength": 1},
+					"x":           map[string]any{"type": "number"},
+					"y":           map[string]any{"type": "number"},
+					"width":       map[string]any{"type": "number"},
+					"height":      map[string]any{"type": "number"},
+					"z":           map[string]any{"type": "integer"},
+				},
+			},
+		},
+	},
+}
+
+const decomposeSystem = "You plan the layout of sketch commissions for an artist who draws one subject at a time."
+
+// Decompose asks whether description is several subjects that can be
+// drawn separately, like "a triptych of mountain, lake and forest", and
+// if so splits it into parts. A request for one subject or scene has no
+// parts.
+func Decompose(ctx context.Context, client LLMClient, description string, log *Logger) (string, []Part, error) {
+	prompt := fmt.Sprintf(`Decide whether this request asks for several separate subjects that can
+be drawn independently, side by side or in panels, for example "a triptych
+of mountain, lake and forest".
+
+If it does, split it into parts. Describe each part fully enough to be drawn
+on its own, in the style the request asks for, and give it a region of the
+canvas: x and y of its top left corner, width and height, all as fractions
+of the canvas between 0 and 1. Regions may overlap only where one subject
+stands in front of another: give each part a z, larger in front, and the
+lines of the parts behind are hidden where those in front cover them. Give
+the whole composition a title.
+
+If it is one subject or one scene, reply with an empty parts list.
+
+REQUEST: %s`, description)
+
+	var out struct {
+		Title string `json:"title"`
+		Parts []Part `json:"parts"`
+	}
+	messages := []Message{{Role: "user", Content: prompt}}
+	if err := CompleteJSON(ctx, client, decomposeSystem, messages, judgeOptions, decomposeSchema, &out); err != nil {
+		return "", nil, fmt.Errorf("decompose: %w", err)
+	}
+	if len(out.Parts) < 2 {
+		return "", nil, nil
+	}
+	for i, p := range out.Parts {
+		if err := p.check(); err != nil {
+			return "", nil, fmt.Errorf("decompose: part %d: %w", i+1, err)
+		}
+	}
+	log.Info("decomposed into %d parts", len(out.Parts))
+	return strings.TrimSpace(out.Title), out.Parts, nil
+}
+
+func (p Part) check() error {
+	if p.X < 0 || p.Y < 0 || p.Width <= 0 || p.Height <= 0 || p.X+p.Width > 1.001 || p.Y+p.Height > 1.001 {
+		return fmt.Errorf("region %g,%g %gx%g is outside the canvas", p.X, p.Y, p.Width, p.Height)
+	}
+	return nil
+}
+
+// Region places p on a canvas at pos of size, to a hundredth of a mm.
+func (p Part) Region(pos, size Vec2) (Vec2, Vec2) {
+	mm := func(v float64) float64 { return math.Round(v*100) / 100 }
+	return Vec2{mm(pos.X + p.X*size.X), mm(pos.Y + p.Y*size.Y)}, Vec2{mm(p.Width * size.X), mm(p.Height * size.Y)}
+}
+
+// PartPrompt is the request for one part of a decomposed description.
+func PartPrompt(description string, part Part) string {
+	return fmt.Sprintf("%s\n\nThis is one part of a larger composition (%q), drawn on its own canvas. Draw only this part, filling the canvas.", part.Description, description)
+}
+
+// PlacedSVG is a compiled part and the region of the canvas it fills.
+type PlacedSVG struct {
+	SVG       string
+	Pos, Size Vec2
+	Z         int // larger is in front
+}
+
+// MergeSVG combines parts compiled at their own positions into one SVG of
+// the canvas at pos of size, each nested in its region.
+func MergeSVG(parts []PlacedSVG, pos, size Vec2) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%gmm" height="%gmm" viewBox="%g %g %g %g">`+"\n", size.X, size.Y, pos.X, pos.Y, size.X, size.Y)
+	fmt.Fprintf(&b, `  <rect x="%g" y="%g" width="%g" height="%g" fill="white"/>`+"\n", pos.X, pos.Y, size.X, size.Y)
+	for _, p := range parts {
+		viewBox, inner := splitSVG(p.SVG)
+		fmt.Fprintf(&b, `  <svg x="%g" y="%g" width="%g" height="%g" viewBox="%s">`, p.Pos.X, p.Pos.Y, p.Size.X, p.Size.Y, viewBox)
+		b.WriteString(inner)
+		b.WriteString("</svg>\n")
+	}
+	b.WriteString("</svg>\n")
+	return b.String()
+}
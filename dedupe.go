@@ -0,0 +1,123 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const number = `-?\d+(?:\.\d+)?`
+
+// strokePoints matches a stroke written with literal coordinates, with any
+// via points in group 5.
+var strokePoints = regexp.MustCompile(`stroke\s+from\s+\(\s*(` + number + `)\s*,\s*(` + number + `)\s*\)\s+to\s+\(\s*(` + number + `)\s*,\s*(` + number + `)\s*\)(?:\s+via\s*\[([^\]]*)\])?`)
+
+// strokeStatement matches a line that draws nothing but one stroke.
+var strokeStatement = regexp.MustCompile(`^\s*(?:trace|draw|scribble)\s+$`)
+
+// DedupeStrokes removes strokes that redraw an earlier stroke: the same
+// end points, in either direction, and the same via points, all within
+// eps. It returns the code and the number of strokes removed.
+//
+// Only strokes written with literal coordinates are compared, and a
+// duplicate is only removed where that leaves valid code: an item of a
+// list that has other items, or a render statement of its own.
+func DedupeStrokes(code string, eps float64) (string, int) {
+	removed := 0
+	for {
+		start, end, ok := findDuplicateStroke(code, eps)
+		if !ok {
+			return code, removed
+		}
+		code = code[:start] + code[end:]
+		removed++
+	}
+}
+
+// findDuplicateStroke returns the span to cut to remove the first
+// removable duplicate stroke in code.
+func findDuplicateStroke(code string, eps float64) (start, end int, ok bool) {
+	var seen [][]Vec2
+	for _, m := range strokePoints.FindAllStringSubmatchIndex(code, -1) {
+		lineStart := strings.LastIndexByte(code[:m[0]], '\n') + 1
+		if strings.Contains(code[lineStart:m[0]], "#") {
+			continue
+		}
+		points, literal := strokeKey(code, m)
+		if !literal {
+			continue
+		}
+
+		duplicate := slices.ContainsFunc(seen, func(p []Vec2) bool {
+			return samePoints(p, points, eps) || samePoints(p, reversed(points), eps)
+		})
+		if !duplicate {
+			seen = append(seen, points)
+			continue
+		}
+		if start, end, ok := removableSpan(code, m[0], m[1], lineStart); ok {
+			return start, end, true
+		}
+	}
+	return 0, 0, false
+}
+
+// strokeKey is the points of the stroke matched at m, from, via and to.
+// literal is false if a via point is not a literal.
+func strokeKey(code string, m []int) (points []Vec2, literal bool) {
+	coord := func(i int) float64 {
+		v, _ := strconv.ParseFloat(code[m[2*i]:m[2*i+1]], 64)
+		return v
+	}
+	points = []Vec2{{coord(1), coord(2)}}
+	if m[10] >= 0 {
+		via := code[m[10]:m[11]]
+		if strings.Trim(vecLiteral.ReplaceAllString(via, ""), ", \t\n") != "" {
+			return nil, false
+		}
+		for _, v := range vecLiteral.FindAllStringSubmatch(via, -1) {
+			x, _ := strconv.ParseFloat(v[1], 64)
+			y, _ := strconv.ParseFloat(v[2], 64)
+			points = append(points, Vec2{x, y})
+		}
+	}
+	return append(points, Vec2{coord(3), coord(4)}), true
+}
+
+// removableSpan is the text to cut to remove the stroke at [start, end):
+// with its separating comma inside a list, or its whole line as a
+// statement.
+func removableSpan(code string, start, end, lineStart int) (int, int, bool) {
+	before := strings.TrimRight(code[:start], " \t\n")
+	after := strings.TrimLeft(code[end:], " \t\n")
+	switch {
+	case strings.HasSuffix(before, ",") && (strings.HasPrefix(after, ",") || strings.HasPrefix(after, "]")):
+		return len(before) - 1, end, true
+	case strings.HasSuffix(before, "[") && strings.HasPrefix(after, ","):
+		return start, len(code) - len(strings.TrimLeft(after[1:], " \t\n")), true
+	case strokeStatement.MatchString(code[lineStart:start]):
+		lineEnd := strings.IndexByte(code[end:], '\n')
+		if lineEnd < 0 {
+			return lineStart, len(code), true
+		}
+		if rest := code[end : end+lineEnd]; strings.TrimSpace(rest) != "" && !strings.HasPrefix(strings.TrimSpace(rest), "#") {
+			return 0, 0, false
+		}
+		return lineStart, end + lineEnd + 1, true
+	}
+	return 0, 0, false
+}
+
+func samePoints(a, b []Vec2, eps float64) bool {
+	return slices.EqualFunc(a, b, func(p, q Vec2) bool {
+		return math.Abs(p.X-q.X) <= eps && math.Abs(p.Y-q.Y) <= eps
+	})
+}
+
+func reversed(points []Vec2) []Vec2 {
+	r := slices.Clone(points)
+	slices.Reverse(r)
+	return r
+}
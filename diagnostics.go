@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Diagnostic is one message from the compiler.
+type Diagnostic struct {
+	File     string
+	Line     int // 1-based; 0 if the message has no line
+	Col      int // 1-based; 0 if the message has no column
+	Severity string
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	pos := fmt.Sprintf("line %d", d.Line)
+	if d.Col > 0 {
+		pos += fmt.Sprintf(":%d", d.Col)
+	}
+	return fmt.Sprintf("%s: %s: %s", pos, d.Severity, d.Message)
+}
+
+// defaultDiagnosticPatterns match "file:line:col: severity: message", as
+// from most compilers, and "file: line N: message", as from sketchlang.
+// Patterns name their groups file, line, col, severity and message; line
+// and message are required.
+var defaultDiagnosticPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^(?P<file>[^\s:]+):(?P<line>\d+)(?::(?P<col>\d+))?:\s*(?:(?P<severity>error|warning|note):\s*)?(?P<message>.+)$`),
+	regexp.MustCompile(`^(?:(?P<file>[^\s:]+):\s*)?line (?P<line>\d+)(?::(?P<col>\d+))?:\s*(?:(?P<severity>error|warning|note):\s*)?(?P<message>.+)$`),
+}
+
+// diagnosticPatterns are the patterns for the installed compiler: a spec
+// profile's own, or the defaults.
+var diagnosticPatterns = defaultDiagnosticPatterns
+
+// ParseDiagnostics reads compiler output line by line into diagnostics,
+// with the first of patterns that matches each line. It also returns the
+// lines none of them match.
+func ParseDiagnostics(output string, patterns []*regexp.Regexp) ([]Diagnostic, []string) {
+	var diags []Diagnostic
+	var other []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		d, ok := matchDiagnostic(line, patterns)
+		if !ok {
+			other = append(other, line)
+			continue
+		}
+		diags = append(diags, d)
+	}
+	return diags, other
+}
+
+func matchDiagnostic(line string, patterns []*regexp.Regexp) (Diagnostic, bool) {
+	for _, re := range patterns {
+		m := re.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		group := func(name string) string {
+			if i := re.SubexpIndex(name); i > 0 {
+				return m[i]
+			}
+			return ""
+		}
+		d := Diagnostic{File: group("file"), Severity: group("severity"), Message: group("message")}
+		d.Line, _ = strconv.Atoi(group("line"))
+		d.Col, _ = strconv.Atoi(group("col"))
+		if d.Severity == "" {
+			d.Severity = "error"
+		}
+		return d, true
+	}
+	return Diagnostic{}, false
+}
+
+// CompileErrors turns compiler output on code into one entry per error,
+// each quoting the line of code it is about, for a repair prompt. Output
+// no pattern matches is kept as it is.
+func CompileErrors(output, code string) []string {
+	diags, other := ParseDiagnostics(output, diagnosticPatterns)
+	lines := strings.Split(code, "\n")
+
+	var errs []string
+	for _, d := range diags {
+		s := d.String()
+		if d.Line > 0 && d.Line <= len(lines) {
+			s += fmt.Sprintf("\n  %d | %s", d.Line, strings.TrimRight(lines[d.Line-1], " \t"))
+			if d.Col > 0 {
+				s += "\n  " + strings.Repeat(" ", len(strconv.Itoa(d.Line))) + " | " + caret(lines[d.Line-1], d.Col)
+			}
+		}
+		errs = append(errs, s)
+	}
+	return append(errs, other...)
+}
+
+// caret points at column col of line, keeping its tabs so it lines up.
+func caret(line string, col int) string {
+	var b strings.Builder
+	for i, r := range []rune(line) {
+		if i >= col-1 {
+			break
+		}
+		if r == '\t' {
+			b.WriteRune('\t')
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+	return b.String() + "^"
+}
+
+// LoadDiagnosticPatterns reads a .diag file: one regular expression per
+// line, with blank lines and lines starting with # ignored.
+func LoadDiagnosticPatterns(path string) ([]*regexp.Regexp, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var patterns []*regexp.Regexp
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		re, err := regexp.Compile(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n+1, err)
+		}
+		if re.SubexpIndex("line") < 0 || re.SubexpIndex("message") < 0 {
+			return nil, fmt.Errorf("%s:%d: pattern needs line and message groups", path, n+1)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// corePrograms exercise the parts of SketchLang every prompt relies on.
+var corePrograms = []struct{ Name, Code string }{
+	{"let number", "let n : number = (2 + 3) * 4 / 2 - 1\ntrace dot at (n, n)"},
+	{"let vec", "let v : vec = (10, 10) + (5, 5) * 2 - origin\ntrace dot at v"},
+	{"dot", "trace dot at (5, 5)"},
+	{"dash", "trace dash at (5, 5)"},
+	{"stroke", "trace stroke from (0, 0) to (10, 10)"},
+	{"sketch list", "let s : sketch = [dot at (1, 1), stroke from (0, 0) to (5, 5)]\ntrace s"},
+	{"draw", "draw stroke from (0, 0) to (10, 10)"},
+	{"scribble", "scribble stroke from (0, 0) to (10, 10)"},
+	{"comments", "# comment\ntrace dot at (1, 1) # trailing"},
+}
+
+// ConformanceResult is the outcome of compiling one probe program.
+type ConformanceResult struct {
+	Name     string
+	Optional bool
+	OK       bool
+	Errors   []string
+}
+
+// RunConformance compiles every core and optional-feature probe.
+func RunConformance(log *Logger) []ConformanceResult {
+	var results []ConformanceResult
+	for _, p := range corePrograms {
+		ok, errors := Validate(context.Background(), p.Code, log)
+		results = append(results, ConformanceResult{Name: p.Name, OK: ok, Errors: errors})
+	}
+	for _, f := range specFeatures {
+		ok, errors := Validate(context.Background(), f.Probe, log)
+		results = append(results, ConformanceResult{Name: f.Name, Optional: true, OK: ok, Errors: errors})
+	}
+	return results
+}
+
+// doctor prints a conformance report for the installed compiler and
+// returns false if any core feature is rejected.
+func doctor(log *Logger) bool {
+	switch b := compiler().(type) {
+	case builtinBackend:
+		if backend == nil {
+			fmt.Printf("compiler: %s not found in PATH, using the built-in renderer\n", compilerBin)
+		} else {
+			fmt.Println("compiler: the built-in renderer")
+		}
+	case *httpBackend:
+		fmt.Printf("compiler: compile service at %s\n", b.url)
+	case execBackend:
+		path, _ := exec.LookPath(compilerBin)
+		caps, err := Probe()
+		if err != nil {
+			fmt.Printf("compiler: %v\n", err)
+			return false
+		}
+		version := caps.Version
+		if version == "" {
+			version = "unknown"
+		}
+		fmt.Printf("compiler: %s (version %s)\n", path, version)
+		if caps.InMemory {
+			fmt.Println("io: stdin and stdout")
+		} else {
+			fmt.Println("io: temp files")
+		}
+		if caps.CheckOnly {
+			fmt.Println("check: --check, without rendering")
+		} else {
+			fmt.Println("check: full compile")
+		}
+		var missing []string
+		if !caps.SVG {
+			missing = append(missing, "--svg")
+		}
+		if !caps.Placement {
+			missing = append(missing, "-pos", "-size")
+		}
+		if !caps.Seed {
+			missing = append(missing, "-seed")
+		}
+		if len(missing) > 0 {
+			fmt.Printf("flags: no %s\n", strings.Join(missing, ", "))
+		}
+	}
+
+	healthy := true
+	for _, r := range RunConformance(log) {
+		status := "ok"
+		switch {
+		case !r.OK && r.Optional:
+			status = "off"
+		case !r.OK:
+			status = "FAIL"
+			healthy = false
+		}
+		fmt.Printf("%-5s %s\n", status, r.Name)
+		if !r.OK {
+			fmt.Printf("      %s\n", strings.TrimSpace(strings.Join(r.Errors, " ")))
+		}
+	}
+	return healthy
+}
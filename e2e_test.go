@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sketch-studio/tools/gallery"
+	"sketch-studio/tools/manifest"
+)
+
+// buildTools builds sketch-studio and the fakesketchlang stub, as
+// sketchlang, into one directory for PATH.
+func buildTools(t *testing.T) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("builds binaries")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("no go tool to build with")
+	}
+	bin := t.TempDir()
+	for _, b := range []struct{ out, pkg string }{
+		{"sketch-studio", "."},
+		{"sketchlang", "./cmd/fakesketchlang"},
+	} {
+		out, err := exec.Command("go", "build", "-o", filepath.Join(bin, b.out), b.pkg).CombinedOutput()
+		if err != nil {
+			t.Fatalf("go build %s: %v\n%s", b.pkg, err, out)
+		}
+	}
+	return bin
+}
+
+// runStudio runs sketch-studio from bin in dir, with the stub compiler
+// and the mock provider answering from the fixtures, and a cache of its
+// own.
+func runStudio(t *testing.T, bin, dir, fixtures string, args ...string) (string, string) {
+	t.Helper()
+	fixtures, _ = filepath.Abs(fixtures)
+	cmd := exec.Command(filepath.Join(bin, "sketch-studio"), args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"),
+		"SKETCH_FIXTURES="+fixtures,
+		"XDG_CACHE_HOME="+t.TempDir(),
+		"HOME="+t.TempDir(),
+	)
+	var stdout, stderr strings.Builder
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("sketch-studio %s: %v\n%s", strings.Join(args, " "), err, stderr.String())
+	}
+	return stdout.String(), stderr.String()
+}
+
+func TestGenerateEndToEnd(t *testing.T) {
+	bin := buildTools(t)
+	dir := t.TempDir()
+	stdout, stderr := runStudio(t, bin, dir, "testdata/e2e", "-d", "a cat", "-provider", "mock", "-size", "80,80", "-debug")
+
+	if strings.Contains(stderr, "version unknown") {
+		t.Errorf("the stub's version was not recognised:\n%s", stderr)
+	}
+	for _, name := range []string{"cat.sketch", "cat.svg", "cat.thumb.png", "cat.sketch.json"} {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s not written: %v", name, err)
+		}
+		if !strings.Contains(stdout, path) {
+			t.Errorf("%s not printed:\n%s", path, stdout)
+		}
+	}
+
+	code, _ := os.ReadFile(filepath.Join(dir, "cat.sketch"))
+	if !strings.Contains(string(code), "trace stroke from (2, 2) to (40, 40)") {
+		t.Errorf("cat.sketch is not the fixture's code:\n%s", code)
+	}
+	svg, _ := os.ReadFile(filepath.Join(dir, "cat.svg"))
+	if !strings.Contains(string(svg), "<path") {
+		t.Errorf("cat.svg draws nothing:\n%s", svg)
+	}
+	if !strings.Contains(stderr, "plot: pen-down ") || !strings.Contains(stderr, " 1 pen lifts") {
+		t.Errorf("no plot estimate from the compiler's G-code:\n%s", stderr)
+	}
+
+	m, err := manifest.LoadSketch(filepath.Join(dir, "cat.svg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Title != "Cat" || m.Prompt != "a cat" || m.Model != "mock" || m.Size != (manifest.Point{X: 80, Y: 80}) {
+		t.Errorf("manifest = %+v", m)
+	}
+	if len(m.Phases) != 1 || m.Phases[0].Name != "draft" || m.Final == nil || m.Final.Paths != 1 {
+		t.Errorf("manifest phases = %+v, final = %+v", m.Phases, m.Final)
+	}
+	if m.Thumbnail != "cat.thumb.png" {
+		t.Errorf("manifest thumbnail = %q", m.Thumbnail)
+	}
+	thumb, _ := os.ReadFile(filepath.Join(dir, "cat.thumb.png"))
+	if c, err := png.DecodeConfig(bytes.NewReader(thumb)); err != nil || max(c.Width, c.Height) != thumbnailSize {
+		t.Errorf("thumbnail is %dx%d, %v; want %d on its longer side", c.Width, c.Height, err, thumbnailSize)
+	}
+}
+
+// specDir is a -specs directory with one spec the stub's version
+// matches and one newer.
+func specDir(t *testing.T) string {
+	t.Helper()
+	specs := t.TempDir()
+	for name, spec := range map[string]string{
+		"sketchlang-0.0.md": LangSpec,
+		"sketchlang-9.9.md": LangSpec + "\nNEWER",
+	} {
+		if err := os.WriteFile(filepath.Join(specs, name), []byte(spec), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return specs
+}
+
+func TestSpecSelectionEndToEnd(t *testing.T) {
+	bin := buildTools(t)
+	_, stderr := runStudio(t, bin, t.TempDir(), "testdata/e2e", "-d", "a cat", "-provider", "mock", "-size", "80,80", "-specs", specDir(t), "-debug")
+	if !strings.Contains(stderr, "using spec 0.0 for compiler 0.0.0") {
+		t.Errorf("the spec for the stub's version was not picked:\n%s", stderr)
+	}
+}
+
+func TestBuiltinSpecSelectionEndToEnd(t *testing.T) {
+	bin := buildTools(t)
+	_, stderr := runStudio(t, bin, t.TempDir(), "testdata/e2e", "-d", "a cat", "-provider", "mock", "-size", "80,80", "-specs", specDir(t), "-backend", "builtin", "-debug")
+	if !strings.Contains(stderr, "using spec 9.9 for the built-in renderer") {
+		t.Errorf("-specs was not used with the built-in renderer:\n%s", stderr)
+	}
+}
+
+func TestPenEndToEnd(t *testing.T) {
+	bin := buildTools(t)
+	dir := t.TempDir()
+	stdout, _ := runStudio(t, bin, dir, "testdata/e2e", "-d", "a cat", "-provider", "mock", "-size", "80,80", "-pen", "trace=3")
+
+	path := filepath.Join(dir, "cat.gcode")
+	program, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout, path) {
+		t.Errorf("%s not printed:\n%s", path, stdout)
+	}
+	if !strings.Contains(string(program), "G0 Z3\n") || strings.Contains(string(program), "G0 Z2.2") {
+		t.Errorf("the trace paths are not lowered to Z3:\n%s", program)
+	}
+}
+
+func TestBatchRequesterEndToEnd(t *testing.T) {
+	bin := buildTools(t)
+	dir := t.TempDir()
+	batch := filepath.Join(dir, "cats.csv")
+	if err := os.WriteFile(batch, []byte("description,requester,style\na cat,ada,hatching\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runStudio(t, bin, dir, "testdata/e2e", "-batch", batch, "-provider", "mock", "-size", "80,80")
+
+	m, err := manifest.LoadSketch(filepath.Join(dir, "cat.svg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Requester != "ada" || m.Style != "hatching" {
+		t.Errorf("manifest requester %q, style %q; want the row's ada and hatching", m.Requester, m.Style)
+	}
+}
+
+func TestVersionsEndToEnd(t *testing.T) {
+	bin := buildTools(t)
+	dir := t.TempDir()
+	for range 3 {
+		runStudio(t, bin, dir, "testdata/e2e", "-d", "a cat", "-provider", "mock", "-size", "80,80", "-force")
+	}
+
+	m, err := manifest.LoadSketch(filepath.Join(dir, "cat.svg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Version != 3 {
+		t.Errorf("current version %d, want 3", m.Version)
+	}
+	for v := 1; v <= 2; v++ {
+		old, err := manifest.LoadSketch(filepath.Join(dir, manifest.VersionPath("cat", v)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if old.Version != v {
+			t.Errorf("v%d manifest says version %d", v, old.Version)
+		}
+		for _, name := range []string{old.Sketch, old.SVG, old.Thumbnail} {
+			if _, err := os.Stat(old.File(name)); err != nil {
+				t.Errorf("v%d: %v", v, err)
+			}
+		}
+	}
+
+	ix, err := gallery.Scan(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ix.Entries) != 1 || ix.Entries[0].Version != 3 {
+		t.Errorf("gallery lists %+v, want only version 3", ix.Entries)
+	}
+}
+
+func TestCompareEndToEnd(t *testing.T) {
+	bin := buildTools(t)
+	dir := t.TempDir()
+	for range 2 {
+		runStudio(t, bin, dir, "testdata/e2e", "-d", "a cat", "-provider", "mock", "-size", "80,80", "-force")
+	}
+	stdout, stderr := runStudio(t, bin, dir, "testdata/e2e", "compare", "cat@v1", "cat")
+
+	path := filepath.Join(dir, "cat_v1_v2.compare.svg")
+	if !strings.Contains(stdout, path) {
+		t.Errorf("%s not printed:\n%s", path, stdout)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	// The fixture answers the same both times.
+	if !strings.Contains(stderr, "v1 to v2: 0 paths added, 0 removed") {
+		t.Errorf("stderr:\n%s", stderr)
+	}
+}
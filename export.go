@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Exporter writes compiled geometry in another format, for a plotter or
+// as a picture. The paths are in SVG units, taken as mm.
+type Exporter struct {
+	Ext    string // the extension of the files it writes, with the dot
+	Export func(paths []Polyline, page Page) ([]byte, error)
+}
+
+// Page is the canvas of a compiled SVG.
+type Page struct {
+	Origin, Size Vec2    // the viewBox
+	Pen          float64 // the stroke width of the paths
+}
+
+var exporters = map[string]Exporter{}
+
+// RegisterExporter makes a format selectable with -export.
+func RegisterExporter(name string, e Exporter) {
+	exporters[name] = e
+}
+
+// ExporterNames lists the registered formats, sorted.
+func ExporterNames() []string {
+	var names []string
+	for name := range exporters {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// NewExporter looks up the named format.
+func NewExporter(name string) (Exporter, error) {
+	e, ok := exporters[name]
+	if !ok {
+		return Exporter{}, fmt.Errorf("unknown export format %q (want %s)", name, strings.Join(ExporterNames(), ", "))
+	}
+	return e, nil
+}
+
+// ParseExporters looks up a comma-separated list of formats.
+func ParseExporters(list string) ([]Exporter, error) {
+	var out []Exporter
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		e, err := NewExporter(name)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// ExportSVG converts the paths of a compiled SVG, in the order drawn, on
+// the page its viewBox describes.
+func ExportSVG(e Exporter, svg string) ([]byte, error) {
+	paths, err := ParseSVGPaths(svg)
+	if err != nil {
+		return nil, err
+	}
+	viewBox, _ := splitSVG(svg)
+	v := parseNumbers(viewBox)
+	if len(v) != 4 {
+		return nil, fmt.Errorf("viewBox %q is not four numbers", viewBox)
+	}
+	return e.Export(paths, Page{Origin: Vec2{v[0], v[1]}, Size: Vec2{v[2], v[3]}, Pen: strokeWidth(svg)})
+}
+
+// defaultPen is the stroke width of paths that don't give one, the
+// compiler's 0.3 mm.
+const defaultPen = 0.3
+
+// strokeWidth is the stroke-width of the first path in svg that has one.
+func strokeWidth(svg string) float64 {
+	dec := xml.NewDecoder(strings.NewReader(svg))
+	dec.Strict = false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return defaultPen
+		}
+		if el, ok := tok.(xml.StartElement); ok && el.Name.Local == "path" {
+			if w := parseNumbers(attr(el, "stroke-width")); len(w) > 0 && w[0] > 0 {
+				return w[0]
+			}
+		}
+	}
+}
+
+func init() {
+	RegisterExporter("hpgl", Exporter{Ext: ".hpgl", Export: HPGL})
+	RegisterExporter("axidraw", Exporter{Ext: ".py", Export: AxiDraw})
+	RegisterExporter("animation", Exporter{Ext: ".plot.svg", Export: Animate})
+	RegisterExporter("png", Exporter{Ext: ".png", Export: func(paths []Polyline, page Page) ([]byte, error) {
+		img, err := RasterizePage(paths, page, rasterOptions)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		err = png.Encode(&buf, img)
+		return buf.Bytes(), err
+	}})
+	RegisterExporter("jpeg", Exporter{Ext: ".jpg", Export: func(paths []Polyline, page Page) ([]byte, error) {
+		img, err := RasterizePage(paths, page, rasterOptions)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+		return buf.Bytes(), err
+	}})
+}
+
+// hpglUnits is the HP-GL plotter units in a mm.
+const hpglUnits = 40
+
+// HPGL writes paths as HP-GL for pen 1, in plotter units with the origin
+// at the bottom left of the page and y going up, as HP plotters have it.
+func HPGL(paths []Polyline, page Page) ([]byte, error) {
+	at := func(p Vec2) string {
+		x := math.Round((p.X - page.Origin.X) * hpglUnits)
+		y := math.Round((page.Origin.Y + page.Size.Y - p.Y) * hpglUnits)
+		return fmt.Sprintf("%.0f,%.0f", x, y)
+	}
+	var b strings.Builder
+	b.WriteString("IN;SP1;\n")
+	for _, p := range paths {
+		if len(p) == 0 {
+			continue
+		}
+		down := p[1:]
+		if len(down) == 0 {
+			down = p
+		}
+		points := make([]string, len(down))
+		for i, pt := range down {
+			points[i] = at(pt)
+		}
+		fmt.Fprintf(&b, "PU%s;PD%s;\n", at(p[0]), strings.Join(points, ","))
+	}
+	b.WriteString("PU0,0;SP0;\n")
+	return []byte(b.String()), nil
+}
+
+// AxiDraw writes paths as a Python script that plots them with the
+// interactive mode of the AxiDraw Python API, in mm from the top left of
+// the page as the AxiDraw takes them.
+func AxiDraw(paths []Polyline, page Page) ([]byte, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, `# Plots a %g x %g mm sketch on an AxiDraw: python3 <this file>
+# Needs the AxiDraw Python API (pyaxidraw).
+from pyaxidraw import axidraw
+
+paths = [
+`, page.Size.X, page.Size.Y)
+	for _, p := range paths {
+		if len(p) == 0 {
+			continue
+		}
+		points := make([]string, len(p))
+		for i, pt := range p {
+			points[i] = fmt.Sprintf("(%.2f, %.2f)", pt.X-page.Origin.X, pt.Y-page.Origin.Y)
+		}
+		fmt.Fprintf(&b, "    [%s],\n", strings.Join(points, ", "))
+	}
+	b.WriteString(`]
+
+ad = axidraw.AxiDraw()
+ad.interactive()
+ad.options.units = 2  # mm
+if not ad.connect():
+    raise SystemExit("no AxiDraw found")
+for path in paths:
+    ad.draw_path(path if len(path) > 1 else path * 2)
+ad.moveto(0, 0)
+ad.disconnect()
+`)
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+)
+
+// FailoverClient sends requests to the first of its clients and moves on
+// to the next, for the rest of the run, when the current one fails in a
+// way that suggests an outage: a server error, overload, exhausted rate
+// limit retries or no connection. Refusals, cancellations and caller
+// aborts are returned as they are. Text a failed stream has already
+// passed to onText is not retracted.
+type FailoverClient struct {
+	clients []LLMClient
+
+	mu      sync.Mutex
+	current int
+}
+
+func NewFailoverClient(clients ...LLMClient) *FailoverClient {
+	return &FailoverClient{clients: clients}
+}
+
+func (f *FailoverClient) Model() string {
+	return f.active().Model()
+}
+
+func (f *FailoverClient) CountTokens(ctx context.Context, system string, messages []Message) (int, error) {
+	return CountTokens(ctx, f.active(), system, messages)
+}
+
+func (f *FailoverClient) Complete(ctx context.Context, system string, messages []Message, opts RequestOptions) (Response, error) {
+	return f.try(ctx, func(c LLMClient) (Response, error) {
+		return c.Complete(ctx, system, messages, opts)
+	})
+}
+
+func (f *FailoverClient) StreamComplete(ctx context.Context, system string, messages []Message, opts RequestOptions, onText func(string) error) (Response, error) {
+	return f.try(ctx, func(c LLMClient) (Response, error) {
+		return c.StreamComplete(ctx, system, messages, opts, onText)
+	})
+}
+
+func (f *FailoverClient) active() LLMClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.clients[f.current]
+}
+
+// try runs call against the active client, failing over while clients
+// remain and the error calls for it.
+func (f *FailoverClient) try(ctx context.Context, call func(LLMClient) (Response, error)) (Response, error) {
+	for {
+		c := f.active()
+		resp, err := call(c)
+		if err == nil || ctx.Err() != nil || !outage(err) {
+			return resp, err
+		}
+
+		f.mu.Lock()
+		if f.clients[f.current] == c && f.current+1 < len(f.clients) {
+			f.current++
+		}
+		next := f.clients[f.current]
+		f.mu.Unlock()
+		if next == c {
+			return resp, err
+		}
+		printf("warning: %s failed (%v), switching to %s", c.Model(), err, next.Model())
+	}
+}
+
+// outage reports whether err means the provider itself is unavailable.
+func outage(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Status >= 500 || apiErr.Temporary()
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FailedRequest is a request that could not be turned into a sketch, kept
+// with its diagnostics so it can be retried once the cause is fixed.
+type FailedRequest struct {
+	Time        time.Time `json:"time"`
+	Prompt      string    `json:"prompt"`
+	Image       string    `json:"image,omitempty"`
+	Output      string    `json:"output,omitempty"`
+	Constraints []string  `json:"constraints,omitempty"`
+	Caption     string    `json:"caption,omitempty"`
+	Pos         Vec2      `json:"pos"`
+	Size        Vec2      `json:"size"`
+	Style       string    `json:"style,omitempty"`
+	Requester   string    `json:"requester,omitempty"`
+	Pens        PenDepths `json:"pens,omitempty"`
+	Error       string    `json:"error"`
+	Code        string    `json:"code,omitempty"`
+	Attempts    int       `json:"attempts"`
+}
+
+// FailuresPath is the per-user queue of failed requests.
+func FailuresPath() string {
+	return filepath.Join(CacheDir(""), "failed.jsonl")
+}
+
+// RecordFailure appends f to the queue at path.
+func RecordFailure(path string, f FailedRequest) error {
+	failures, err := LoadFailures(path)
+	if err != nil {
+		return err
+	}
+	f.Time = time.Now()
+	f.Attempts = 1
+	return SaveFailures(path, append(failures, f))
+}
+
+// LoadFailures reads the queue; a missing file is an empty queue.
+func LoadFailures(path string) ([]FailedRequest, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var failures []FailedRequest
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var r FailedRequest
+		if json.Unmarshal(scanner.Bytes(), &r) == nil {
+			failures = append(failures, r)
+		}
+	}
+	return failures, scanner.Err()
+}
+
+// SaveFailures replaces the queue at path.
+func SaveFailures(path string, failures []FailedRequest) error {
+	var b strings.Builder
+	for _, f := range failures {
+		data, err := json.Marshal(f)
+		if err != nil {
+			return err
+		}
+		b.Write(data)
+		b.WriteByte('\n')
+	}
+	return writeFileAtomic(path, []byte(b.String()))
+}
@@ -25,7 +25,8 @@ Vectors:
 Sketches:
   dot at vec
   dash at vec
-  stroke from vec to vec [via [vec, ...]]
+  stroke from vec to vec
+  stroke from vec to vec via [vec, ...]   -- spline through points
   [sketch, sketch, ...]   -- list
 
 ## Render Commands
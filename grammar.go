@@ -0,0 +1,63 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// SketchGrammar is a GBNF grammar for the artist's tagged replies: any
+// <title>, <summary> and <code> blocks among plain text, with the code held
+// to SketchLang. Servers that support grammars (llama.cpp, LM Studio) then
+// cannot produce dot notation, loops or malformed statements. Features in
+// disabled are left out of the grammar as they are left out of the spec.
+//
+// The grammar covers syntax only: undeclared names and type errors still
+// come back from the compiler.
+func SketchGrammar(disabled []SpecFeature) string {
+	off := func(key string) bool {
+		return slices.ContainsFunc(disabled, func(f SpecFeature) bool { return f.Key == key })
+	}
+
+	terms := []string{
+		`number`,
+		`"-" ws term`,
+		`"origin"`,
+		`ident`,
+		`paren`,
+		`list`,
+		`"dot" sp "at" sp term`,
+		`"dash" sp "at" sp term`,
+	}
+	stroke := `"stroke" sp "from" sp expr sp "to" sp expr`
+	if !off("via") {
+		stroke += ` (sp "via" ws list)?`
+	}
+	terms = append(terms, stroke)
+	if !off("center") {
+		terms = append(terms, `"center" sp "of" sp term`)
+	}
+	if !off("flow") {
+		terms = append(terms, `"flow" sp "at" sp term`)
+	}
+
+	rules := []string{
+		`root ::= text (part text)*`,
+		`part ::= "<title>" text "</title>" | "<summary>" text "</summary>" | "<code>" program "</code>"`,
+		`text ::= [^<]*`,
+		`program ::= (line "\n")* line`,
+		`line ::= ws (statement ws)? comment?`,
+		`statement ::= "let" sp ident ws ":" ws type ws "=" ws expr | ("trace" | "draw" | "scribble") sp expr`,
+		`type ::= "number" | "vec" | "sketch"`,
+		`expr ::= term (ws [-+*/] ws term)*`,
+		`term ::= ` + strings.Join(terms, " | "),
+		`paren ::= "(" gap expr gap ("," gap expr gap)? ")"`,
+		`list ::= "[" gap (expr gap ("," gap expr gap)* ("," gap)?)? "]"`,
+		`ident ::= [a-zA-Z_] [a-zA-Z0-9_]*`,
+		`number ::= [0-9]+ ("." [0-9]+)?`,
+		`comment ::= "#" [^\n]*`,
+		`ws ::= [ \t]*`,
+		`sp ::= [ \t]+`,
+		`gap ::= ([ \t\n] | comment "\n")*`,
+	}
+	return strings.Join(rules, "\n") + "\n"
+}
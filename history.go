@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// HistoryEntry records one generated sketch so a repeated request can be
+// answered with the existing files.
+type HistoryEntry struct {
+	Prompt string    `json:"prompt"`
+	Title  string    `json:"title"`
+	Time   time.Time `json:"time"`
+	Sketch string    `json:"sketch"`
+	SVG    string    `json:"svg"`
+}
+
+// HistoryPath is the per-user request history file.
+func HistoryPath() string {
+	return filepath.Join(CacheDir(""), "history.jsonl")
+}
+
+// normalizePrompt folds case, punctuation and whitespace so trivially
+// different retries of a request compare equal.
+func normalizePrompt(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return ' '
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// FindRecent returns the newest entry for prompt made within window whose
+// files still exist, or nil.
+func FindRecent(path, prompt string, window time.Duration) *HistoryEntry {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	key := normalizePrompt(prompt)
+	var found *HistoryEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e HistoryEntry
+		if json.Unmarshal(scanner.Bytes(), &e) != nil || e.Prompt != key || time.Since(e.Time) > window {
+			continue
+		}
+		if _, err := os.Stat(e.SVG); err != nil {
+			continue
+		}
+		found = &e
+	}
+	return found
+}
+
+// RecordHistory appends an entry for prompt.
+func RecordHistory(path, prompt string, e HistoryEntry) error {
+	e.Prompt = normalizePrompt(prompt)
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return appendLine(path, data)
+}
+
+// appendLine appends data and a newline to path, creating it if needed.
+func appendLine(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// strokeFont is a single-stroke font for lettering, in the manner of the
+// Hershey fonts. Each glyph is polylines on a grid 4 wide and 6 tall, y
+// down from the cap line to the baseline, written as "x,y x,y;x,y ...". A
+// polyline of one point is a dot. Lower case is drawn as upper case.
+var strokeFont = map[rune]string{
+	'A':  "0,6 2,0 4,6;0.8,3.5 3.2,3.5",
+	'B':  "0,6 0,0 3,0 4,1 4,2 3,3 0,3;3,3 4,4 4,5 3,6 0,6",
+	'C':  "4,1 3,0 1,0 0,1 0,5 1,6 3,6 4,5",
+	'D':  "0,0 0,6 2.5,6 4,4.5 4,1.5 2.5,0 0,0",
+	'E':  "4,0 0,0 0,6 4,6;0,3 3,3",
+	'F':  "4,0 0,0 0,6;0,3 3,3",
+	'G':  "4,1 3,0 1,0 0,1 0,5 1,6 3,6 4,5 4,3 2,3",
+	'H':  "0,0 0,6;4,0 4,6;0,3 4,3",
+	'I':  "1,0 3,0;2,0 2,6;1,6 3,6",
+	'J':  "4,0 4,5 3,6 1,6 0,5",
+	'K':  "0,0 0,6;4,0 0,4;1.3,2.7 4,6",
+	'L':  "0,0 0,6 4,6",
+	'M':  "0,6 0,0 2,3 4,0 4,6",
+	'N':  "0,6 0,0 4,6 4,0",
+	'O':  "1,0 3,0 4,1 4,5 3,6 1,6 0,5 0,1 1,0",
+	'P':  "0,6 0,0 3,0 4,1 4,2 3,3 0,3",
+	'Q':  "1,0 3,0 4,1 4,5 3,6 1,6 0,5 0,1 1,0;2.5,4.5 4,6",
+	'R':  "0,6 0,0 3,0 4,1 4,2 3,3 0,3;2,3 4,6",
+	'S':  "4,1 3,0 1,0 0,1 0,2 1,3 3,3 4,4 4,5 3,6 1,6 0,5",
+	'T':  "0,0 4,0;2,0 2,6",
+	'U':  "0,0 0,5 1,6 3,6 4,5 4,0",
+	'V':  "0,0 2,6 4,0",
+	'W':  "0,0 1,6 2,3 3,6 4,0",
+	'X':  "0,0 4,6;4,0 0,6",
+	'Y':  "0,0 2,3 4,0;2,3 2,6",
+	'Z':  "0,0 4,0 0,6 4,6",
+	'0':  "1,0 3,0 4,1 4,5 3,6 1,6 0,5 0,1 1,0;4,1 0,5",
+	'1':  "1,1 2,0 2,6;1,6 3,6",
+	'2':  "0,1 1,0 3,0 4,1 4,2 0,6 4,6",
+	'3':  "0,1 1,0 3,0 4,1 4,2 3,3 1.5,3;3,3 4,4 4,5 3,6 1,6 0,5",
+	'4':  "3,6 3,0 0,4 4,4",
+	'5':  "4,0 0,0 0,3 3,3 4,4 4,5 3,6 1,6 0,5",
+	'6':  "4,1 3,0 1,0 0,1 0,5 1,6 3,6 4,5 4,4 3,3 0,3",
+	'7':  "0,0 4,0 1.5,6",
+	'8':  "1,3 0,2 0,1 1,0 3,0 4,1 4,2 3,3 1,3 0,4 0,5 1,6 3,6 4,5 4,4 3,3",
+	'9':  "4,3 1,3 0,2 0,1 1,0 3,0 4,1 4,5 3,6 1,6 0,5",
+	'.':  "2,6",
+	',':  "2,5.5 1.5,7",
+	'!':  "2,0 2,4.5;2,6",
+	'?':  "0,1 1,0 3,0 4,1 4,2 2,3.5 2,4.5;2,6",
+	'\'': "2,0 2,1.5",
+	':':  "2,2;2,5",
+	'-':  "1,3 3,3",
+	'/':  "0,6 4,0",
+}
+
+// Glyph metrics in grid units: the height of a capital and the advance
+// from one glyph to the next.
+const (
+	glyphHeight  = 6.0
+	glyphAdvance = 5.5
+)
+
+// Lettering draws text in strokeFont as SketchLang, with the top left of
+// the first capital at pos and capitals height tall. Characters the font
+// lacks are left as spaces.
+func Lettering(text string, pos Vec2, height float64) string {
+	scale := height / glyphHeight
+	point := func(p Vec2, x float64) string {
+		return fmt.Sprintf("(%s, %s)", formatCoord(pos.X+(x+p.X)*scale), formatCoord(pos.Y+p.Y*scale))
+	}
+
+	var items []string
+	for i, r := range []rune(strings.ToUpper(text)) {
+		x := float64(i) * glyphAdvance
+		for _, line := range glyphLines(r) {
+			if len(line) == 1 {
+				items = append(items, "dot at "+point(line[0], x))
+			}
+			for j := 1; j < len(line); j++ {
+				items = append(items, fmt.Sprintf("stroke from %s to %s", point(line[j-1], x), point(line[j], x)))
+			}
+		}
+	}
+	if len(items) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("# Caption: %q\nlet caption_lettering : sketch = [\n  %s\n]\ntrace caption_lettering", text, strings.Join(items, ",\n  "))
+}
+
+func glyphLines(r rune) [][]Vec2 {
+	var lines [][]Vec2
+	for _, part := range strings.Split(strokeFont[r], ";") {
+		var line []Vec2
+		for _, pt := range strings.Fields(part) {
+			xs, ys, _ := strings.Cut(pt, ",")
+			x, _ := strconv.ParseFloat(xs, 64)
+			y, _ := strconv.ParseFloat(ys, 64)
+			line = append(line, Vec2{x, y})
+		}
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func formatCoord(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
+
+var vecLiteral = regexp.MustCompile(`\(\s*(-?\d+(?:\.\d+)?)\s*,\s*(-?\d+(?:\.\d+)?)\s*\)`)
+
+// CaptionPlacement finds room for a caption under the drawing in code:
+// centred below the bounds of its coordinate literals, at most a tenth of
+// the drawing's width tall and no wider than it. The bounds are only
+// approximate, since offsets added to anchors count as points too.
+func CaptionPlacement(code, text string) (pos Vec2, height float64) {
+	minX, maxX, maxY := math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, m := range vecLiteral.FindAllStringSubmatch(code, -1) {
+		x, _ := strconv.ParseFloat(m[1], 64)
+		y, _ := strconv.ParseFloat(m[2], 64)
+		minX, maxX, maxY = math.Min(minX, x), math.Max(maxX, x), math.Max(maxY, y)
+	}
+	if math.IsInf(minX, 1) || maxX <= minX {
+		minX, maxX, maxY = 0, 100, 100
+	}
+
+	n := float64(len([]rune(text)))
+	width := maxX - minX
+	height = math.Min(width/10, width*glyphHeight/(n*glyphAdvance))
+	textWidth := (n*glyphAdvance - (glyphAdvance - 4)) * height / glyphHeight
+	return Vec2{minX + (width-textWidth)/2, maxY + height/2}, height
+}
+
+// CaptionPrompt tells the model a caption will be lettered under the
+// drawing, so it leaves room and draws no text of its own.
+func CaptionPrompt(description, caption string) string {
+	if caption == "" {
+		return description
+	}
+	return fmt.Sprintf("%s\n\nCAPTION: the caption %q will be lettered below the drawing afterwards. Do not draw any text yourself, and keep the space under the drawing clear.", description, caption)
+}
@@ -1,108 +1,497 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type LLMClient interface {
-	Complete(system string, messages []Message) (string, error)
+	Complete(ctx context.Context, system string, messages []Message, opts RequestOptions) (Response, error)
+	// StreamComplete is Complete, calling onText with each piece of text
+	// as it arrives. An error from onText aborts the request and is
+	// returned, possibly wrapped.
+	StreamComplete(ctx context.Context, system string, messages []Message, opts RequestOptions, onText func(string) error) (Response, error)
+	Model() string
+}
+
+// Response is a model's reply.
+type Response struct {
+	Text       string
+	StopReason StopReason
+	Thinking   string // extended thinking, kept apart from Text; Anthropic only
+}
+
+// StopReason says why the model stopped, in the same terms for every
+// provider. Unknown provider reasons are passed through as is.
+type StopReason string
+
+const (
+	StopEnd      StopReason = "end"           // the answer is complete
+	StopLength   StopReason = "length"        // cut off at MaxTokens
+	StopSequence StopReason = "stop_sequence" // hit one of StopSequences
+)
+
+// normalizeStop maps a provider's stop or finish reason. OpenAI-compatible
+// servers report a stop sequence as a normal stop.
+func normalizeStop(reason string) StopReason {
+	switch reason {
+	case "end_turn", "stop", "":
+		return StopEnd
+	case "max_tokens", "length":
+		return StopLength
+	case "stop_sequence":
+		return StopSequence
+	}
+	return StopReason(reason)
 }
 
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string  `json:"role"`
+	Content string  `json:"content"`
+	Images  []Image `json:"images,omitempty"` // shown to vision models before Content
+}
+
+// Image is an attachment to a message.
+type Image struct {
+	MediaType string `json:"media_type"` // e.g. image/png
+	Data      []byte `json:"data"`
+}
+
+// LoadImage reads an image file for a message.
+func LoadImage(path string) (Image, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Image{}, err
+	}
+	mediaType := http.DetectContentType(data)
+	switch mediaType {
+	case "image/png", "image/jpeg", "image/gif", "image/webp":
+		return Image{MediaType: mediaType, Data: data}, nil
+	}
+	return Image{}, fmt.Errorf("%s: unsupported image type %s", path, mediaType)
+}
+
+func (m Message) equal(n Message) bool {
+	return m.Role == n.Role && m.Content == n.Content &&
+		slices.EqualFunc(m.Images, n.Images, func(a, b Image) bool {
+			return a.MediaType == b.MediaType && bytes.Equal(a.Data, b.Data)
+		})
+}
+
+// anthropicMessages puts images into content blocks.
+func anthropicMessages(messages []Message) []any {
+	var out []any
+	for _, m := range messages {
+		if len(m.Images) == 0 {
+			out = append(out, m)
+			continue
+		}
+		var blocks []map[string]any
+		for _, img := range m.Images {
+			blocks = append(blocks, map[string]any{
+				"type":   "image",
+				"source": map[string]any{"type": "base64", "media_type": img.MediaType, "data": img.Data},
+			})
+		}
+		blocks = append(blocks, map[string]any{"type": "text", "text": m.Content})
+		out = append(out, map[string]any{"role": m.Role, "content": blocks})
+	}
+	return out
+}
+
+// openAIMessages prepends the system prompt and puts images into content
+// parts as data URLs.
+func openAIMessages(system string, messages []Message) []any {
+	out := []any{Message{Role: "system", Content: system}}
+	for _, m := range messages {
+		if len(m.Images) == 0 {
+			out = append(out, m)
+			continue
+		}
+		var parts []map[string]any
+		for _, img := range m.Images {
+			url := "data:" + img.MediaType + ";base64," + base64.StdEncoding.EncodeToString(img.Data)
+			parts = append(parts, map[string]any{"type": "image_url", "image_url": map[string]string{"url": url}})
+		}
+		parts = append(parts, map[string]any{"type": "text", "text": m.Content})
+		out = append(out, map[string]any{"role": m.Role, "content": parts})
+	}
+	return out
+}
+
+// RefusalError reports that the model declined the request. Retrying the
+// same prompt will not change the answer.
+type RefusalError struct {
+	Reason string
+}
+
+func (e *RefusalError) Error() string {
+	return "model declined the request: " + e.Reason
+}
+
+// RequestOptions tune a request. Zero values leave the provider's default
+// in place, and providers ignore options they have no equivalent for.
+// Options given to a provider factory apply to every request it sends.
+type RequestOptions struct {
+	// CacheSystemPrompt marks the system prompt, which carries the whole
+	// SketchLang spec, for provider-side prompt caching.
+	CacheSystemPrompt bool
+
+	MaxTokens     int
+	Temperature   *float64
+	TopP          *float64 // some Anthropic models reject TopP together with Temperature
+	StopSequences []string
+	Model         string // overrides the client's model
+
+	// ThinkingBudget enables Anthropic extended thinking with this many
+	// tokens, on top of MaxTokens. Sampling options are dropped, since
+	// they cannot be combined with thinking.
+	ThinkingBudget int
+
+	// JSONSchema asks providers with a native JSON mode for output
+	// matching it. Use CompleteJSON rather than setting it directly.
+	JSONSchema map[string]any
+
+	// Grammar is a GBNF grammar the whole response must match, for local
+	// servers that support constrained decoding. Other providers ignore it.
+	Grammar string
+}
+
+// Temperature is shorthand for RequestOptions{Temperature: &t}.
+func Temperature(t float64) RequestOptions {
+	return RequestOptions{Temperature: &t}
+}
+
+func (o RequestOptions) maxTokens() int {
+	if o.MaxTokens > 0 {
+		return o.MaxTokens
+	}
+	return 16384
+}
+
+func (o RequestOptions) model(def string) string {
+	if o.Model != "" {
+		return o.Model
+	}
+	return def
+}
+
+// APIError is a non-200 response from a provider.
+type APIError struct {
+	Status     int
+	Body       string
+	RetryAfter time.Duration // from the Retry-After header, 0 if absent
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error %d: %s", e.Status, e.Body)
+}
+
+// Temporary reports whether the request may succeed if sent again later:
+// rate limited (429), unavailable (503) or overloaded (529).
+func (e *APIError) Temporary() bool {
+	return e.Status == 429 || e.Status == 503 || e.Status == 529
+}
+
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	e := &APIError{Status: resp.StatusCode, Body: string(body)}
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			e.RetryAfter = time.Duration(secs) * time.Second
+		} else if t, err := http.ParseTime(v); err == nil {
+			e.RetryAfter = max(time.Until(t), 0)
+		}
+	}
+	return e
 }
 
 // Anthropic client
 type AnthropicClient struct {
-	key string
-	log *Logger
+	usageSink
+	key   string
+	model string
+	opts  RequestOptions
+	log   *Logger
 }
 
-func NewAnthropicClient(key string, log *Logger) *AnthropicClient {
-	return &AnthropicClient{key: key, log: log}
+func NewAnthropicClient(key string, opts RequestOptions, log *Logger) *AnthropicClient {
+	return &AnthropicClient{key: key, model: "claude-sonnet-4-5", opts: opts, log: log}
 }
 
-func (c *AnthropicClient) Complete(system string, messages []Message) (string, error) {
+func (c *AnthropicClient) Model() string {
+	return c.model
+}
+
+func (c *AnthropicClient) request(system string, messages []Message, opts RequestOptions) (map[string]any, map[string]string) {
 	body := map[string]any{
-		"model":      "claude-sonnet-4-5",
-		"max_tokens": 16384,
+		"model":      opts.model(c.model),
+		"max_tokens": opts.maxTokens() + opts.ThinkingBudget,
 		"system":     system,
-		"messages":   messages,
+		"messages":   anthropicMessages(messages),
 	}
+	if opts.ThinkingBudget > 0 {
+		body["thinking"] = map[string]any{"type": "enabled", "budget_tokens": opts.ThinkingBudget}
+	} else {
+		if opts.Temperature != nil {
+			body["temperature"] = *opts.Temperature
+		}
+		if opts.TopP != nil {
+			body["top_p"] = *opts.TopP
+		}
+	}
+	if len(opts.StopSequences) > 0 {
+		body["stop_sequences"] = opts.StopSequences
+	}
+	if c.opts.CacheSystemPrompt || opts.CacheSystemPrompt {
+		body["system"] = []map[string]any{{
+			"type":          "text",
+			"text":          system,
+			"cache_control": map[string]string{"type": "ephemeral"},
+		}}
+	}
+	return body, c.headers()
+}
 
-	data, _ := json.Marshal(body)
-	req, _ := http.NewRequest("POST", "https://api.anthropic.com/v1/messages", bytes.NewReader(data))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("x-api-key", c.key)
-	req.Header.Set("anthropic-version", "2023-06-01")
-
-	client := &http.Client{Timeout: 120 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
+func (c *AnthropicClient) headers() map[string]string {
+	return map[string]string{
+		"x-api-key":         c.key,
+		"anthropic-version": "2023-06-01",
 	}
-	defer resp.Body.Close()
+}
 
-	respBody, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("API error %d: %s", resp.StatusCode, string(respBody))
+func (c *AnthropicClient) Complete(ctx context.Context, system string, messages []Message, opts RequestOptions) (Response, error) {
+	body, headers := c.request(system, messages, opts)
+	respBody, err := postJSON(ctx, "https://api.anthropic.com/v1/messages", headers, body, 120*time.Second)
+	if err != nil {
+		return Response{}, err
 	}
 
 	var result struct {
 		Content []struct {
-			Text string `json:"text"`
+			Type     string `json:"type"`
+			Text     string `json:"text"`
+			Thinking string `json:"thinking"`
 		} `json:"content"`
+		StopReason string         `json:"stop_reason"`
+		Usage      anthropicUsage `json:"usage"`
 	}
 	if err := json.Unmarshal(respBody, &result); err != nil {
-		return "", err
+		return Response{}, err
+	}
+	c.record(opts.model(c.model), result.Usage)
+
+	if result.StopReason == "refusal" {
+		return Response{}, &RefusalError{Reason: "stopped by the provider's safety system"}
+	}
+
+	var text, thinking strings.Builder
+	for _, block := range result.Content {
+		switch block.Type {
+		case "text":
+			text.WriteString(block.Text)
+		case "thinking":
+			thinking.WriteString(block.Thinking)
+		}
+	}
+	if text.Len() == 0 {
+		return Response{}, fmt.Errorf("empty response")
+	}
+
+	c.log.Debug("received %d chars after %d chars of thinking", text.Len(), thinking.Len())
+	return Response{Text: text.String(), StopReason: normalizeStop(result.StopReason), Thinking: thinking.String()}, nil
+}
+
+func (c *AnthropicClient) StreamComplete(ctx context.Context, system string, messages []Message, opts RequestOptions, onText func(string) error) (Response, error) {
+	body, headers := c.request(system, messages, opts)
+	body["stream"] = true
+
+	var text, thinking strings.Builder
+	var stopReason string
+	var usage anthropicUsage
+	err := streamSSE(ctx, "https://api.anthropic.com/v1/messages", headers, body, 10*time.Minute, func(data []byte) error {
+		var event struct {
+			Type    string `json:"type"`
+			Message struct {
+				Usage anthropicUsage `json:"usage"`
+			} `json:"message"`
+			Usage anthropicUsage `json:"usage"`
+			Delta struct {
+				Type       string `json:"type"`
+				Text       string `json:"text"`
+				Thinking   string `json:"thinking"`
+				StopReason string `json:"stop_reason"`
+			} `json:"delta"`
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(data, &event); err != nil {
+			return err
+		}
+
+		switch event.Type {
+		case "message_start":
+			usage = event.Message.Usage
+		case "content_block_delta":
+			switch event.Delta.Type {
+			case "thinking_delta":
+				thinking.WriteString(event.Delta.Thinking)
+			case "text_delta":
+				text.WriteString(event.Delta.Text)
+				return onText(event.Delta.Text)
+			}
+		case "message_delta":
+			stopReason = event.Delta.StopReason
+			usage.OutputTokens = event.Usage.OutputTokens
+		case "error":
+			return fmt.Errorf("API error: %s", event.Error.Message)
+		}
+		return nil
+	})
+	c.record(opts.model(c.model), usage)
+	if err != nil {
+		return Response{}, err
 	}
 
-	if len(result.Content) == 0 {
-		return "", fmt.Errorf("empty response")
+	if stopReason == "refusal" {
+		return Response{}, &RefusalError{Reason: "stopped by the provider's safety system"}
+	}
+	if text.Len() == 0 {
+		return Response{}, fmt.Errorf("empty response")
 	}
 
-	c.log.Debug("received %d chars", len(result.Content[0].Text))
-	return result.Content[0].Text, nil
+	c.log.Debug("streamed %d chars after %d chars of thinking", text.Len(), thinking.Len())
+	return Response{Text: text.String(), StopReason: normalizeStop(stopReason), Thinking: thinking.String()}, nil
 }
 
-// Local LMStudio client (OpenAI-compatible)
-type LocalClient struct {
-	log *Logger
+type anthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
 }
 
-func NewLocalClient(log *Logger) *LocalClient {
-	return &LocalClient{log: log}
+func (c *AnthropicClient) record(model string, u anthropicUsage) {
+	c.log.Debug("input tokens: %d uncached, %d written to cache, %d read from cache; %d output tokens",
+		u.InputTokens, u.CacheCreationInputTokens, u.CacheReadInputTokens, u.OutputTokens)
+	c.usage.Record(model, Usage{
+		InputTokens:      u.InputTokens,
+		OutputTokens:     u.OutputTokens,
+		CacheWriteTokens: u.CacheCreationInputTokens,
+		CacheReadTokens:  u.CacheReadInputTokens,
+	})
 }
 
-func (c *LocalClient) Complete(system string, messages []Message) (string, error) {
-	msgs := []Message{{Role: "system", Content: system}}
-	msgs = append(msgs, messages...)
+// OpenAI-compatible chat completions client: OpenAI itself, or a local
+// server such as LMStudio.
+type OpenAIClient struct {
+	usageSink
+	name     string // for error messages
+	url      string
+	key      string
+	model    string // "" lets the server use whichever model it has loaded
+	timeout  time.Duration
+	grammars bool // the server takes a GBNF grammar
+	log      *Logger
+}
 
-	body := map[string]any{
-		"messages":   msgs,
-		"max_tokens": 16384,
+func NewOpenAIClient(key string, log *Logger) *OpenAIClient {
+	return &OpenAIClient{
+		name:    "OpenAI",
+		url:     "https://api.openai.com/v1/chat/completions",
+		key:     key,
+		model:   "gpt-4o",
+		timeout: 120 * time.Second,
+		log:     log,
 	}
+}
 
-	data, _ := json.Marshal(body)
-	req, _ := http.NewRequest("POST", "http://localhost:1234/v1/chat/completions", bytes.NewReader(data))
-	req.Header.Set("Content-Type", "application/json")
+func NewLocalClient(log *Logger) *OpenAIClient {
+	return &OpenAIClient{
+		name:     "LMStudio",
+		url:      "http://localhost:1234/v1/chat/completions",
+		timeout:  300 * time.Second,
+		grammars: true,
+		log:      log,
+	}
+}
 
-	client := &http.Client{Timeout: 300 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("LMStudio connection failed: %w", err)
+// NewLlamaCppClient talks to a llama.cpp server's OpenAI-compatible
+// endpoint, at LLAMACPP_URL or on its default port.
+func NewLlamaCppClient(log *Logger) *OpenAIClient {
+	url := os.Getenv("LLAMACPP_URL")
+	if url == "" {
+		url = "http://localhost:8080"
 	}
-	defer resp.Body.Close()
+	return &OpenAIClient{
+		name:     "llama.cpp",
+		url:      strings.TrimSuffix(url, "/") + "/v1/chat/completions",
+		timeout:  300 * time.Second,
+		grammars: true,
+		log:      log,
+	}
+}
 
-	respBody, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("API error %d: %s", resp.StatusCode, string(respBody))
+// Model names the endpoint when no model is set: LMStudio serves whichever
+// model is loaded.
+func (c *OpenAIClient) Model() string {
+	if c.model == "" {
+		return strings.ToLower(c.name)
+	}
+	return c.model
+}
+
+func (c *OpenAIClient) request(system string, messages []Message, opts RequestOptions) (map[string]any, map[string]string) {
+	body := map[string]any{
+		"messages":   openAIMessages(system, messages),
+		"max_tokens": opts.maxTokens(),
+	}
+	if model := opts.model(c.model); model != "" {
+		body["model"] = model
+	}
+	if opts.Temperature != nil {
+		body["temperature"] = *opts.Temperature
+	}
+	if opts.TopP != nil {
+		body["top_p"] = *opts.TopP
+	}
+	if len(opts.StopSequences) > 0 {
+		body["stop"] = opts.StopSequences
+	}
+	if opts.JSONSchema != nil {
+		body["response_format"] = map[string]any{
+			"type":        "json_schema",
+			"json_schema": map[string]any{"name": "response", "schema": opts.JSONSchema},
+		}
+	}
+	if opts.Grammar != "" && opts.JSONSchema == nil && c.grammars {
+		body["grammar"] = opts.Grammar
+	}
+
+	var headers map[string]string
+	if c.key != "" {
+		headers = map[string]string{"Authorization": "Bearer " + c.key}
+	}
+	return body, headers
+}
+
+func (c *OpenAIClient) Complete(ctx context.Context, system string, messages []Message, opts RequestOptions) (Response, error) {
+	body, headers := c.request(system, messages, opts)
+	respBody, err := postJSON(ctx, c.url, headers, body, c.timeout)
+	if err != nil {
+		return Response{}, fmt.Errorf("%s: %w", c.name, err)
 	}
 
 	var result struct {
@@ -110,16 +499,260 @@ func (c *LocalClient) Complete(system string, messages []Message) (string, error
 			Message struct {
 				Content string `json:"content"`
 			} `json:"message"`
+			FinishReason string `json:"finish_reason"`
 		} `json:"choices"`
+		Usage *openAIUsage `json:"usage"`
 	}
 	if err := json.Unmarshal(respBody, &result); err != nil {
-		return "", err
+		return Response{}, err
 	}
+	c.record(opts.model(c.Model()), result.Usage)
 
 	if len(result.Choices) == 0 {
-		return "", fmt.Errorf("empty response")
+		return Response{}, fmt.Errorf("empty response")
+	}
+	if result.Choices[0].FinishReason == "content_filter" {
+		return Response{}, &RefusalError{Reason: "blocked by the model's content filter"}
 	}
 
 	c.log.Debug("received %d chars", len(result.Choices[0].Message.Content))
-	return result.Choices[0].Message.Content, nil
-}
\ No newline at end of file
+	return Response{Text: result.Choices[0].Message.Content, StopReason: normalizeStop(result.Choices[0].FinishReason)}, nil
+}
+
+func (c *OpenAIClient) StreamComplete(ctx context.Context, system string, messages []Message, opts RequestOptions, onText func(string) error) (Response, error) {
+	body, headers := c.request(system, messages, opts)
+	body["stream"] = true
+	body["stream_options"] = map[string]any{"include_usage": true}
+
+	var text strings.Builder
+	var finishReason string
+	var usage *openAIUsage
+	err := streamSSE(ctx, c.url, headers, body, 10*time.Minute, func(data []byte) error {
+		if string(data) == "[DONE]" {
+			return nil
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+				FinishReason string `json:"finish_reason"`
+			} `json:"choices"`
+			Usage *openAIUsage `json:"usage"`
+		}
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return err
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		if len(chunk.Choices) == 0 {
+			return nil
+		}
+		if r := chunk.Choices[0].FinishReason; r != "" {
+			finishReason = r
+		}
+		if delta := chunk.Choices[0].Delta.Content; delta != "" {
+			text.WriteString(delta)
+			return onText(delta)
+		}
+		return nil
+	})
+	c.record(opts.model(c.Model()), usage)
+	if err != nil {
+		return Response{}, fmt.Errorf("%s: %w", c.name, err)
+	}
+
+	if finishReason == "content_filter" {
+		return Response{}, &RefusalError{Reason: "blocked by the model's content filter"}
+	}
+	if text.Len() == 0 {
+		return Response{}, fmt.Errorf("empty response")
+	}
+
+	c.log.Debug("streamed %d chars", text.Len())
+	return Response{Text: text.String(), StopReason: normalizeStop(finishReason)}, nil
+}
+
+type openAIUsage struct {
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	PromptTokensDetails struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+}
+
+// record reports usage, when the server sent any.
+func (c *OpenAIClient) record(model string, u *openAIUsage) {
+	if u == nil {
+		return
+	}
+	cached := u.PromptTokensDetails.CachedTokens
+	c.log.Debug("%d prompt tokens (%d cached), %d output tokens", u.PromptTokens, cached, u.CompletionTokens)
+	c.usage.Record(model, Usage{
+		InputTokens:     u.PromptTokens - cached,
+		OutputTokens:    u.CompletionTokens,
+		CacheReadTokens: cached,
+	})
+}
+
+// httpClient is shared by all providers so connections are pooled across
+// requests. Per-request deadlines come from the caller's context.
+// ConfigureHTTP and SetHTTPClient replace it.
+var httpClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	},
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// postJSON sends body as JSON and returns the response body, treating any
+// non-200 status as an error.
+func postJSON(ctx context.Context, url string, headers map[string]string, body any, timeout time.Duration) ([]byte, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("encode request: %w", err)
+	}
+
+	if requestTimeout > 0 {
+		timeout = requestTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode != 200 {
+		return nil, newAPIError(resp, respBody)
+	}
+	return respBody, nil
+}
+
+// getBody fetches url and returns the response body, treating any non-200
+// status as an error.
+func getBody(ctx context.Context, url string, headers map[string]string, timeout time.Duration) ([]byte, error) {
+	if requestTimeout > 0 {
+		timeout = requestTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode != 200 {
+		return nil, newAPIError(resp, respBody)
+	}
+	return respBody, nil
+}
+
+// streamSSE sends body as JSON and calls onData with the payload of each
+// server-sent "data:" line. An error from onData cancels the request and
+// is returned unchanged.
+func streamSSE(ctx context.Context, url string, headers map[string]string, body any, timeout time.Duration, onData func([]byte) error) error {
+	return streamLines(ctx, url, headers, body, timeout, "text/event-stream", func(line []byte) error {
+		payload, ok := bytes.CutPrefix(line, []byte("data:"))
+		if !ok {
+			return nil
+		}
+		return onData(bytes.TrimSpace(payload))
+	})
+}
+
+// streamLines sends body as JSON and calls onLine with each line of the
+// response as it arrives.
+func streamLines(ctx context.Context, url string, headers map[string]string, body any, timeout time.Duration, accept string, onLine func([]byte) error) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("encode request: %w", err)
+	}
+
+	if requestTimeout > 0 {
+		timeout = requestTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", accept)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return newAPIError(resp, respBody)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if err := onLine(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read stream: %w", err)
+	}
+	return nil
+}
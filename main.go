@@ -1,74 +1,1694 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
+
+	"sketch-studio/tools/gcode"
+	"sketch-studio/tools/manifest"
+	"sketch-studio/tools/sketchlang"
 )
 
 func main() {
-	desc := flag.String("d", "", "image description")
-	url := flag.String("url", "", "image URL")
-	pos := flag.String("pos", "0,0", "position x,y in mm")
-	size := flag.String("size", "80,80", "size w,h in mm")
-	local := flag.Bool("local", false, "use local LMStudio")
-	debug := flag.Bool("debug", false, "emit debug logs")
-	output := flag.String("o", "", "output name (default: derived from input)")
-	flag.Parse()
+	args := os.Args[1:]
+	name := "generate"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage(os.Stdout)
+		return
+	}
 
-	if *desc == "" && *url == "" {
-		fatal("provide -d or -url")
+	cmd := findCommand(name)
+	if cmd == nil {
+		usage(os.Stderr)
+		fatal("unknown command %q", name)
 	}
 
-	log := &Logger{enabled: *debug}
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	run := cmd.setup(fs)
+	fs.Parse(args)
+	run(fs.Args())
+}
 
-	var client LLMClient
-	if *local {
-		client = NewLocalClient(log)
-	} else {
-		key := os.Getenv("ANTHROPIC_API_KEY")
-		if key == "" {
-			fatal("ANTHROPIC_API_KEY not set")
+func setupGenerate(fs *flag.FlagSet) func([]string) {
+	desc := fs.String("d", "", "image description")
+	url := fs.String("url", "", "image URL")
+	image := fs.String("image", "", "reference image file (PNG, JPEG, GIF or WebP) to trace")
+	constraints := fs.String("constraints", "", `comma-separated requirements, e.g. "no text, max 300 strokes"`)
+	caption := fs.String("caption", "", "text to letter under the drawing in a single-stroke font")
+	batch := fs.String("batch", "", "CSV or JSONL file of requests")
+	pos := fs.String("pos", "0,0", "position x,y in mm")
+	canvas := addSizeFlags(fs)
+	output := fs.String("o", "", "output name (default: derived from input)")
+	preview := fs.String("preview", "", "serve the latest compiled SVG at this address, e.g. :8080")
+	batchAPI := fs.Bool("batch-api", false, "send the first request of every -batch row through the provider's batch API, at half price")
+	resume := fs.String("resume", "", "finish the interrupted sketches in this directory from their checkpoints")
+	sf := addStudioFlags(fs)
+
+	return func([]string) {
+		posVec := parseVec(*pos)
+		sizeVec := canvas()
+
+		if *desc == "" && *url == "" && *image == "" && *batch == "" && *resume == "" {
+			fatal("provide -d, -url, -image, -batch or -resume")
+		}
+		if *batchAPI && *batch == "" {
+			fatal("-batch-api needs -batch")
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		st := sf.build()
+		st.failures = FailuresPath()
+		if *preview != "" {
+			p, err := startPreview(*preview)
+			if err != nil {
+				fatal("preview: %v", err)
+			}
+			st.preview = p
+		}
+
+		if *resume != "" {
+			cps, err := FindCheckpoints(*resume)
+			if err != nil {
+				fatal("resume: %v", err)
+			}
+			if len(cps) == 0 {
+				fatal("resume: no checkpoints in %s", *resume)
+			}
+			st.resume = true
+			failed := 0
+			for _, cp := range cps {
+				if _, _, err := st.run(ctx, cp.Request); err != nil {
+					printf("error: %s: %v", filepath.Base(cp.Request.Output), err)
+					failed++
+				}
+			}
+			st.reportUsage()
+			if failed > 0 {
+				fatal("%d of %d resumed sketches failed", failed, len(cps))
+			}
+			return
+		}
+
+		if *batch != "" {
+			rows, err := LoadBatch(*batch)
+			if err != nil {
+				fatal("batch: %v", err)
+			}
+
+			if *batchAPI {
+				if st.batcher == nil {
+					fatal("batch API: %s has no batch API", st.client.Model())
+				}
+				reqs := make([]SketchRequest, len(rows))
+				for i, row := range rows {
+					if reqs[i], err = row.Request(posVec, sizeVec); err != nil {
+						fatal("batch: row %d: %v", i+1, err)
+					}
+					reqs[i].Constraints = append(SplitConstraints(*constraints), reqs[i].Constraints...)
+				}
+				if err := st.prefetch(ctx, reqs); err != nil {
+					if ctx.Err() != nil {
+						fatal("batch API: %v", err)
+					}
+					// The rows go to the provider, or its fallbacks, directly.
+					printf("warning: batch API: %v; sending the requests directly", err)
+				}
+			}
+
+			var sheet []SheetEntry
+			failed := 0
+			for i, row := range rows {
+				if ctx.Err() != nil {
+					fatal("interrupted after %d of %d batch rows", i, len(rows))
+				}
+				req, err := row.Request(posVec, sizeVec)
+				if err == nil {
+					req.Constraints = append(SplitConstraints(*constraints), req.Constraints...)
+					var result *SketchResult
+					var svg string
+					result, svg, err = st.run(ctx, req)
+					if err == nil {
+						sheet = append(sheet, SheetEntry{Title: result.Title, SVG: svg})
+					}
+				}
+				if err != nil {
+					printf("error: row %d: %v", i+1, err)
+					failed++
+				}
+			}
+
+			st.reportUsage()
+			if len(sheet) > 0 {
+				sheetPath := strings.TrimSuffix(filepath.Base(*batch), filepath.Ext(*batch)) + "_contact_sheet.svg"
+				if err := os.WriteFile(sheetPath, []byte(ContactSheet(sheet)), 0644); err != nil {
+					fatal("contact sheet: %v", err)
+				}
+				abs, _ := filepath.Abs(sheetPath)
+				fmt.Println(abs)
+			}
+			if failed > 0 {
+				fatal("%d of %d batch rows failed", failed, len(rows))
+			}
+			return
+		}
+
+		req := SketchRequest{Prompt: requestPrompt(*desc, *url), Image: *image, Output: *output, Constraints: SplitConstraints(*constraints), Caption: *caption, Pos: posVec, Size: sizeVec}
+		if _, _, err := st.run(ctx, req); err != nil {
+			fatal("%v", err)
 		}
-		client = NewAnthropicClient(key, log)
 	}
+}
 
-	posVec := parseVec(*pos)
-	sizeVec := parseVec(*size)
+// studioFlags configure how sketches are generated. They are shared by
+// generate and retry-failed.
+type studioFlags struct {
+	provider    *string
+	local       *bool
+	debug       *bool
+	specs       *string
+	prompts     *string
+	disable     *string
+	cacheTTL    *time.Duration
+	dedupe      *time.Duration
+	force       *bool
+	finish      *bool
+	critique    *int
+	variations  *int
+	strokeEps   *float64
+	fills       *bool
+	phases      *bool
+	optimize    *float64
+	orderPaths  *bool
+	pen         *string
+	export      *string
+	bed         *string
+	overlap     *float64
+	format      *bool
+	decompose   *bool
+	series      *string
+	passes      *int
+	style       *string
+	repair      *int
+	promptCache *bool
+	rpm         *int
+	tpm         *int
+	concurrency *int
+	json        *bool
+	grammar     *bool
+	fallback    *string
+	record      *bool
+	maxCost     *float64
+	maxTokens   *int
+	thinking    *int
+	proxy       *string
+	caCert      *string
+	pin         *string
+	timeout     *time.Duration
+	compileWait *time.Duration
+	compileJobs *int
+	margin      *float64
+	noAutofit   *bool
+	useBackend  func()
+	useSeed     func()
+}
+
+func addStudioFlags(fs *flag.FlagSet) *studioFlags {
+	addRasterFlags(fs)
+	return &studioFlags{
+		provider:    fs.String("provider", "anthropic", "LLM provider: "+strings.Join(ProviderNames(), ", ")),
+		local:       fs.Bool("local", false, "use local LMStudio (same as -provider lmstudio)"),
+		debug:       fs.Bool("debug", false, "emit debug logs"),
+		specs:       fs.String("specs", "", "directory of versioned SketchLang spec files"),
+		prompts:     fs.String("prompts", "", "directory of prompt templates (system.tmpl, request.tmpl) overriding the built-in ones"),
+		disable:     fs.String("disable", "", "language features to keep out of prompts: via,flow,center"),
+		cacheTTL:    fs.Duration("cache-ttl", 0, "reuse identical LLM responses this long (0 disables)"),
+		dedupe:      fs.Duration("dedupe-window", 10*time.Minute, "return the existing sketch for a repeated description within this window (0 disables)"),
+		force:       fs.Bool("force", false, "generate even if the description was just sketched"),
+		finish:      fs.Bool("finish", false, "run a finishing pass over the complete sketch"),
+		repair:      fs.Int("repair", 2, "attempts at fixing a sketch that fails to compile (0 disables)"),
+		style:       fs.String("style", "", "drawing styles to apply: "+strings.Join(StyleNames(), ", ")),
+		passes:      fs.Int("passes", 1, fmt.Sprintf("draw coarse to fine in this many passes, up to %d", len(Passes))),
+		series:      fs.String("series", "", "name of a series to keep this sketch consistent with, and add it to"),
+		decompose:   fs.Bool("decompose", false, "split requests for several separate subjects into parts sketched on their own regions"),
+		phases:      fs.Bool("phases", false, "also save <name>_phases.svg and .png, the sketch after each phase side by side"),
+		fills:       fs.Bool("fills", false, "let the model ask for regions to be hatched, cross-hatched or stippled with fill comments, drawn for it"),
+		strokeEps:   fs.Float64("dedupe-strokes", 0.5, "remove strokes that redraw an earlier one with end points this close (0 disables)"),
+		optimize:    fs.Float64("optimize", 0, "merge, deduplicate and simplify primitives to within this many mm before saving, as the optimize command does (0 disables)"),
+		orderPaths:  fs.Bool("optimize-travel", false, "reorder the paths of the saved SVG to cut the pen-up travel between them"),
+		pen:         fs.String("pen", "", "also save <name>.gcode, with the pen lowered to a depth for each render mode, e.g. trace=2.6,draw=2.2,scribble=1.6 (needs the sketchlang compiler)"),
+		bed:         fs.String("bed", "", "plotter bed w,h in mm; canvases larger than it are also saved as tiles that fit it"),
+		overlap:     fs.Float64("tile-overlap", 10, "mm each tile overlaps its neighbours by, with -bed"),
+		export:      fs.String("export", "", "also write the saved SVG in these formats, for plotters or as pictures: "+strings.Join(ExporterNames(), ", ")),
+		format:      fs.Bool("format", false, "lay out the saved code in the canonical style, as the fmt command does"),
+		variations:  fs.Int("variations", 1, "generate this many drafts at once and keep the best, saving all of them"),
+		critique:    fs.Int("critique", 0, "rounds of revising the sketch after showing the model its rendering"),
+		promptCache: fs.Bool("prompt-cache", true, "ask the provider to cache the system prompt between requests"),
+		rpm:         fs.Int("rpm", 0, "max LLM requests per minute (0 for no limit)"),
+		tpm:         fs.Int("tpm", 0, "max estimated LLM input tokens per minute (0 for no limit)"),
+		concurrency: fs.Int("concurrency", 0, "max LLM requests in flight at once (0 for no limit)"),
+		fallback:    fs.String("fallback", "", "providers to switch to, in order, if the main one goes down, e.g. ollama"),
+		json:        fs.Bool("json", false, "request the sketch as a JSON object instead of tagged text"),
+		grammar:     fs.Bool("grammar", false, "constrain sketch replies to the SketchLang grammar (llamacpp and lmstudio only)"),
+		record:      fs.Bool("record", false, "write every LLM request and response to a transcript-*.jsonl file"),
+		maxCost:     fs.Float64("max-cost", 0, "max dollars to spend on LLM requests per sketch (0 for no limit)"),
+		maxTokens:   fs.Int("max-tokens", 0, "max LLM tokens to spend per sketch (0 for no limit)"),
+		proxy:       fs.String("proxy", "", "proxy URL for LLM requests (default: from HTTPS_PROXY)"),
+		caCert:      fs.String("ca-cert", "", "PEM file of extra root certificates to trust for LLM requests"),
+		pin:         fs.String("pin", "", "base64 SHA-256 public key hashes the LLM server certificate must match"),
+		timeout:     fs.Duration("timeout", 0, "timeout for each LLM request (0 keeps the provider default)"),
+		compileWait: fs.Duration("compile-timeout", compileTimeout, "kill a compile that runs longer than this (0 for no limit)"),
+		compileJobs: fs.Int("compile-jobs", compileWorkers, "max compiles to run at once"),
+		margin:      fs.Float64("margin", 0, "border in mm to keep blank on every side of the canvas"),
+		noAutofit:   fs.Bool("no-autofit", false, "keep sketches that run off the canvas as drawn instead of scaling them to fit"),
+		useBackend:  addBackendFlag(fs),
+		useSeed:     addSeedFlag(fs),
+		thinking:    fs.Int("thinking", 0, "extended thinking budget in tokens for composing a sketch (Anthropic only, 0 disables)"),
+	}
+}
+
+// build sets up the provider, caches and system prompt.
+func (f *studioFlags) build() *studio {
+	log := &Logger{enabled: *f.debug}
+	f.useBackend()
+	f.useSeed()
 
-	prompt := *desc
-	if *url != "" {
-		prompt = fmt.Sprintf("Create an extremely detailed sketch of the image at this URL: %s", *url)
+	httpOpts := HTTPOptions{Proxy: *f.proxy, CACert: *f.caCert, Timeout: *f.timeout}
+	if *f.pin != "" {
+		httpOpts.Pins = strings.Split(*f.pin, ",")
+	}
+	if err := ConfigureHTTP(httpOpts); err != nil {
+		fatal("http: %v", err)
 	}
 
-	log.Info("generating sketch...")
-	result, err := Generate(client, prompt, log)
+	provider := *f.provider
+	if *f.local {
+		provider = "lmstudio"
+	}
+	usage := NewUsageTracker()
+	client, batcher := f.client(provider, usage, log)
+	if *f.fallback != "" {
+		clients := []LLMClient{client}
+		for _, name := range strings.Split(*f.fallback, ",") {
+			fallback, _ := f.client(strings.TrimSpace(name), usage, log)
+			clients = append(clients, fallback)
+		}
+		client = NewFailoverClient(clients...)
+	}
+
+	var compiles *CompileCache
+	if *f.cacheTTL > 0 {
+		client = NewCachingClient(client, CacheDir("llm"), *f.cacheTTL, log)
+		compiles = NewCompileCache(CacheDir("compile"), *f.cacheTTL)
+	}
+	if *f.record {
+		path := TranscriptPath(".")
+		printf("recording transcript to %s", path)
+		client = NewRecordingClient(client, path, log)
+	}
+	// Batch answers go in under the budget, to count toward the sketches
+	// they are for.
+	prefilled := NewPrefilledClient(client, usage)
+	client = prefilled
+	var budget *BudgetClient
+	if *f.maxCost > 0 || *f.maxTokens > 0 {
+		budget = NewBudgetClient(client, Budget{MaxCost: *f.maxCost, MaxTokens: *f.maxTokens}, usage)
+		client = budget
+	}
+
+	disabled, err := LookupFeatures(*f.disable)
+	if err != nil {
+		fatal("disable: %v", err)
+	}
+	style, err := LookupStyles(*f.style)
 	if err != nil {
-		fatal("generation failed: %v", err)
+		fatal("style: %v", err)
+	}
+	spec, unsupported := loadSpec(*f.specs, log)
+	for _, u := range unsupported {
+		if !slices.ContainsFunc(disabled, func(d SpecFeature) bool { return d.Key == u.Key }) {
+			disabled = append(disabled, u)
+		}
 	}
 
-	outName := *output
-	if outName == "" {
-		outName = sanitize(result.Title)
+	if *f.passes < 1 || *f.passes > len(Passes) {
+		fatal("passes: want 1 to %d", len(Passes))
+	}
+	if *f.variations < 1 {
+		fatal("variations: want at least 1")
+	}
+	if *f.margin < 0 {
+		fatal("margin: want 0 or more")
+	}
+	if *f.thinking > 0 && *f.thinking < 1024 {
+		fatal("thinking: budget must be at least 1024 tokens")
+	}
+	composeOptions.ThinkingBudget = *f.thinking
+	compileTimeout = *f.compileWait
+	compileWorkers = *f.compileJobs
+	pens, err := ParsePenDepths(*f.pen)
+	if err != nil {
+		fatal("pen: %v", err)
+	}
+	exports, err := ParseExporters(*f.export)
+	if err != nil {
+		fatal("export: %v", err)
+	}
+	var bed Vec2
+	if *f.bed != "" {
+		if bed = parseVec(*f.bed); bed.X <= *f.overlap || bed.Y <= *f.overlap {
+			fatal("bed: want w,h larger than -tile-overlap")
+		}
+	}
+	if *f.grammar && !*f.json {
+		grammar := SketchGrammar(disabled)
+		for _, opts := range []*RequestOptions{&composeOptions, &repairOptions, &finishOptions, &critiqueOptions, &passOptions} {
+			opts.Grammar = grammar
+		}
+	}
+
+	var series string
+	var seriesPrompt string
+	if *f.series != "" {
+		series = SeriesPath(*f.series)
+		entries, err := LoadSeries(series)
+		if err != nil {
+			fatal("series: %v", err)
+		}
+		log.Info("series %s: %d earlier sketches", *f.series, len(entries))
+		seriesPrompt = SeriesPrompt(entries)
+	}
+
+	prompts, err := LoadPrompts(*f.prompts)
+	if err != nil {
+		fatal("prompts: %v", err)
+	}
+	format := textFormat
+	if *f.json {
+		format = jsonFormat
+	}
+	systemPrompt := func(style []Style) (string, error) {
+		system, err := prompts.System(PromptData{Spec: spec, Format: format, Style: StyleText(style...)})
+		if err != nil {
+			return "", err
+		}
+		if *f.fills {
+			system += FillPrompt
+		}
+		return StripFeatures(system, disabled) + seriesPrompt, nil
+	}
+	system, err := systemPrompt(style)
+	if err != nil {
+		fatal("%v", err)
+	}
+	s := &studio{
+		system:     system,
+		prompts:    prompts,
+		json:       *f.json,
+		compiles:   compiles,
+		dedupe:     *f.dedupe,
+		force:      *f.force,
+		finish:     *f.finish,
+		critique:   *f.critique,
+		passes:     *f.passes,
+		variations: *f.variations,
+		strokeEps:  *f.strokeEps,
+		fills:      *f.fills,
+		phases:     *f.phases,
+		optimize:   *f.optimize,
+		orderPaths: *f.orderPaths,
+		pens:       pens,
+		exports:    exports,
+		bed:        bed,
+		overlap:    *f.overlap,
+		format:     *f.format,
+		decompose:  *f.decompose,
+		margin:     *f.margin,
+		autofit:    !*f.noAutofit,
+		style:      *f.style,
+		series:     series,
+		repair:     *f.repair,
+		usage:      usage,
+		budget:     budget,
+		batcher:    batcher,
+		prefilled:  prefilled,
+		log:        log,
+	}
+	s.systemPrompt = systemPrompt
+	s.client = NewProgressClient(client, s.progress)
+	return s
+}
+
+// client creates the named provider with its rate limit and circuit
+// breaker, and returns its batch API, if it has one, behind the same.
+func (f *studioFlags) client(name string, usage *UsageTracker, log *Logger) (LLMClient, Batcher) {
+	client, err := NewProvider(name, RequestOptions{CacheSystemPrompt: *f.promptCache}, log)
+	if err != nil {
+		fatal("%v", err)
+	}
+	if r, ok := client.(UsageReporter); ok {
+		r.TrackUsage(usage)
+	}
+	limit := NewRateLimitClient(client, *f.rpm, *f.tpm, *f.concurrency, log)
+	breaker := NewBreakerClient(limit, log)
+	if b, ok := client.(Batcher); ok {
+		return breaker, &limitedBatcher{inner: b, limit: limit, breaker: breaker}
+	}
+	return breaker, nil
+}
+
+// studio holds what every sketch in a run shares.
+type studio struct {
+	client     LLMClient
+	system     string
+	compiles   *CompileCache
+	dedupe     time.Duration
+	force      bool
+	finish     bool
+	critique   int       // rounds of visual critique
+	passes     int       // coarse-to-fine Passes; 1 generates in one go
+	variations int       // drafts to pick the first version from
+	strokeEps  float64   // -dedupe-strokes; 0 keeps duplicate strokes
+	fills      bool      // expand the model's fill directives
+	resume     bool      // carry on from the checkpoints of sketches
+	phases     bool      // save a sheet of the sketch after each phase
+	optimize   float64   // -optimize tolerance in mm; 0 skips the optimizer
+	orderPaths bool      // reorder the saved SVG's paths for less pen travel
+	pens       PenDepths // -pen; empty saves no G-code
+	exports    []Exporter
+	bed        Vec2    // -bed; zero leaves the canvas whole
+	overlap    float64 // mm tiles overlap by, with bed
+	format     bool
+	decompose  bool
+	margin     float64 // mm to keep blank on each side of the canvas
+	autofit    bool    // scale sketches that run off the canvas to fit
+	style      string
+	series     string // portfolio file of -series, "" for none
+	prompts    *Prompts
+	repair     int  // attempts at fixing a compile failure
+	json       bool // generate with GenerateJSON
+	usage      *UsageTracker
+	budget     *BudgetClient // nil without -max-cost or -max-tokens
+	batcher    Batcher       // the provider's batch API, if it has one
+	failures   string        // queue file for failed requests, "" to skip
+	preview    *previewServer
+	prefilled  *PrefilledClient // answers from the batch API
+	log        *Logger
+
+	// systemPrompt builds the system prompt for drawing in styles, for
+	// requests with styles of their own.
+	systemPrompt func(styles []Style) (string, error)
+}
+
+// withStyle returns s drawing in the styles of list, a copy with their
+// system prompt if they are not the run's.
+func (s *studio) withStyle(list string) (*studio, error) {
+	if list == "" || list == s.style {
+		return s, nil
+	}
+	style, err := LookupStyles(list)
+	if err != nil {
+		return nil, err
+	}
+	system, err := s.systemPrompt(style)
+	if err != nil {
+		return nil, err
+	}
+	styled := *s
+	styled.system, styled.style = system, list
+	return &styled, nil
+}
+
+// run generates and compiles a single sketch, writing <name>.sketch and
+// <name>.svg and printing their absolute paths. It returns the parsed
+// result and the compiled SVG. Failed requests are queued for
+// retry-failed.
+func (s *studio) run(ctx context.Context, req SketchRequest) (*SketchResult, string, error) {
+	result, svg, err := s.sketch(ctx, req)
+	if err != nil && s.failures != "" {
+		f := FailedRequest{Prompt: req.Prompt, Image: req.Image, Output: req.Output, Constraints: req.Constraints, Caption: req.Caption, Pos: req.Pos, Size: req.Size, Style: req.Style, Requester: req.Requester, Pens: req.Pens, Error: err.Error()}
+		if result != nil {
+			f.Code = result.Code
+		}
+		if err := RecordFailure(s.failures, f); err != nil {
+			s.log.Warn("failure queue: %v", err)
+		}
+	}
+	return result, svg, err
+}
+
+// sketch does the work of run. On a compile failure it still returns the
+// generated result, for diagnostics.
+func (s *studio) sketch(ctx context.Context, req SketchRequest) (*SketchResult, string, error) {
+	s, err := s.withStyle(req.Style)
+	if err != nil {
+		return nil, "", err
+	}
+	if s.dedupe > 0 && !s.force && !s.resume {
+		if e := FindRecent(HistoryPath(), s.historyKey(req), s.dedupe); e != nil {
+			printf("already sketched %s ago, returning it (use -force to regenerate)", time.Since(e.Time).Round(time.Second))
+			return s.existing(e)
+		}
+	}
+	if plan := s.resumed(req.Output); plan != nil && len(plan.Parts) > 1 {
+		printf("resuming the plan of %s", req.Output)
+		return s.sketchParts(ctx, req, plan.Title, plan.Parts)
+	}
+	if s.decompose && req.Prompt != "" && req.Image == "" && req.Caption == "" {
+		s.usage.SetPhase("decompose")
+		title, parts, err := Decompose(ctx, s.client, req.Prompt, s.log)
+		if err != nil {
+			printf("warning: %v; sketching the request whole", err)
+		}
+		if len(parts) > 1 {
+			return s.sketchParts(ctx, req, title, parts)
+		}
+	}
+	return s.sketchOne(ctx, req)
+}
+
+// sketchParts sketches each part of a decomposed request in its region of
+// the canvas, saving them as <name>_parts/part_<n>, and merges their SVGs
+// into <name>.svg. A part that fails is left out.
+func (s *studio) sketchParts(ctx context.Context, req SketchRequest, title string, parts []Part) (*SketchResult, string, error) {
+	outName := cmp.Or(req.Output, sanitize(title), "composition")
+	version := archiveVersion(outName)
+	dir := outName + "_parts"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, "", err
+	}
+
+	plan := &Checkpoint{Request: req, Start: time.Now(), Title: title, Parts: parts}
+	if old := s.resumed(outName); old != nil {
+		plan.Start = old.Start
+	}
+	if err := SaveCheckpoint(outName, plan); err != nil {
+		s.log.Warn("checkpoint: %v", err)
+	}
+
+	start := plan.Start
+	whole := &SketchResult{Title: cmp.Or(title, req.Prompt)}
+	var placed []PlacedSVG
+	var titles []string
+	var manifests []manifest.Part
+	for i, part := range parts {
+		pos, size := part.Region(req.Pos, req.Size)
+		partOut := filepath.Join(dir, fmt.Sprintf("part_%d", i+1))
+		result, svg, done := s.finishedPart(partOut, start)
+		if done {
+			printf("part %d of %d: already sketched", i+1, len(parts))
+		} else {
+			printf("part %d of %d: %s", i+1, len(parts), part.Description)
+		}
+		partReq := SketchRequest{
+			Prompt:      PartPrompt(req.Prompt, part),
+			Output:      partOut,
+			Constraints: req.Constraints,
+			Pos:         pos,
+			Size:        size,
+			Requester:   req.Requester,
+			Pens:        req.Pens,
+		}
+		if !done {
+			var err error
+			result, svg, err = s.sketchOne(ctx, partReq)
+			if err != nil && ctx.Err() != nil {
+				return nil, "", fmt.Errorf("part %d: %w", i+1, err)
+			}
+			if err != nil {
+				printf("warning: part %d: %v", i+1, err)
+				continue
+			}
+		}
+		placed = append(placed, PlacedSVG{SVG: svg, Pos: pos, Size: size, Z: part.Z})
+		titles = append(titles, result.Title)
+		manifests = append(manifests, manifest.Part{
+			Description: part.Description,
+			Pos:         manifestPoint(pos),
+			Size:        manifestPoint(size),
+			Z:           part.Z,
+			Manifest:    filepath.Join(filepath.Base(dir), fmt.Sprintf("part_%d", i+1)+manifest.Suffix),
+		})
+		whole.BudgetExceeded = whole.BudgetExceeded || result.BudgetExceeded
+		whole.Usage, whole.Cost = whole.Usage.add(result.Usage), whole.Cost+result.Cost
+	}
+	if len(placed) == 0 {
+		return nil, "", fmt.Errorf("all %d parts failed", len(parts))
+	}
+	whole.Summary = strings.Join(titles, "; ")
+
+	if occluded, hidden, err := Occlude(placed); err != nil {
+		printf("warning: hiding lines behind parts: %v", err)
+	} else if hidden > 0 {
+		placed = occluded
+		printf("hid %.0f mm of lines behind parts in front", hidden)
 	}
+	svg := MergeSVG(placed, req.Pos, req.Size)
+	svgPath := outName + ".svg"
+	if err := os.WriteFile(svgPath, []byte(svg), 0644); err != nil {
+		return nil, "", err
+	}
+	abs, _ := filepath.Abs(svgPath)
+	fmt.Println(abs)
+	thumb := writeThumbnail(outName, svg)
+	writeManifest(outName, &manifest.Sketch{
+		Title:          whole.Title,
+		Summary:        whole.Summary,
+		Prompt:         req.Prompt,
+		Style:          s.style,
+		Model:          s.client.Model(),
+		Requester:      requester(req.Requester),
+		Time:           start,
+		Seconds:        time.Since(start).Seconds(),
+		Pos:            manifestPoint(req.Pos),
+		Size:           manifestPoint(req.Size),
+		SVG:            filepath.Base(svgPath),
+		Thumbnail:      thumb,
+		Usage:          manifestTokens(whole.Usage),
+		Cost:           whole.Cost,
+		BudgetExceeded: whole.BudgetExceeded,
+		Parts:          manifests,
+		Version:        version,
+	})
+	finishCheckpoint(outName)
 
-	log.Info("compiling to SVG...")
-	svg, err := Compile(result.Code, outName, posVec, sizeVec, log)
+	entry := HistoryEntry{Title: whole.Title, Time: time.Now(), SVG: abs}
+	if err := RecordHistory(HistoryPath(), s.historyKey(req), entry); err != nil {
+		s.log.Warn("history: %v", err)
+	}
+	return whole, svg, nil
+}
+
+// finishedPart loads the part of a decomposed request saved as outName
+// since the plan was made, when resuming, and reports whether there was
+// one. A part with a checkpoint of its own is not finished.
+func (s *studio) finishedPart(outName string, since time.Time) (*SketchResult, string, bool) {
+	if !s.resume {
+		return nil, "", false
+	}
+	if _, err := os.Stat(checkpointPath(outName)); err == nil {
+		return nil, "", false
+	}
+	m, err := manifest.LoadSketch(manifest.Path(outName))
+	if err != nil || m.Time.Before(since) {
+		return nil, "", false
+	}
+	svg, err := os.ReadFile(m.File(m.SVG))
 	if err != nil {
-		fatal("compile failed: %v", err)
+		return nil, "", false
+	}
+	u := Usage{InputTokens: m.Usage.Input, OutputTokens: m.Usage.Output, CacheWriteTokens: m.Usage.CacheWrite, CacheReadTokens: m.Usage.CacheRead}
+	return &SketchResult{Code: m.Code, Title: m.Title, Summary: m.Summary, Usage: u, Cost: m.Cost, BudgetExceeded: m.BudgetExceeded}, string(svg), true
+}
+
+// logStats logs the measurements of one version of a sketch, so the
+// versions a run goes through can be compared.
+func (s *studio) logStats(version string, result *SketchResult, svg string) {
+	if stats, err := SketchStats(result.Code, svg); err == nil {
+		s.log.Info("%s: %s", version, stats)
+	}
+}
+
+// compileGcode compiles code to G-code with the pen depths of pens, for
+// the summary and <name>.gcode. The built-in renderer makes no G-code, so
+// there is none with it.
+func (s *studio) compileGcode(ctx context.Context, code, outName string, pos, size Vec2, pens PenDepths) (string, bool) {
+	if _, ok := compiler().(execBackend); !ok {
+		if len(pens) > 0 {
+			s.log.Warn("-pen: the built-in renderer makes no G-code; not saving %s.gcode", outName)
+		}
+		return "", false
+	}
+	program, err := CompilePenGcode(ctx, code, outName, pos, size, pens, s.log)
+	if err != nil {
+		s.log.Warn("G-code: %v", err)
+		return "", false
+	}
+	return program, true
+}
+
+// sketchOne generates, compiles and saves one sketch.
+func (s *studio) sketchOne(ctx context.Context, req SketchRequest) (*SketchResult, string, error) {
+	prompt, refs, err := s.describe(req)
+	if err != nil {
+		return nil, "", err
+	}
+	cs := ParseConstraints(req.Constraints)
+	description := prompt
+	prompt = s.firstPass(prompt)
+	pos, size := req.Pos, req.Size
+	start := time.Now()
+
+	before, beforeCost := s.usage.Total()
+	s.budget.Reset()
+	var result *SketchResult
+	var svg, outName string
+	// versions are the sketch after each phase, for -phases, and phases
+	// the same for its manifest.
+	var versions []SheetEntry
+	var phases []manifest.Phase
+	cp := s.resumed(req.Output)
+	if cp != nil && cp.Result != nil {
+		printf("resuming %s after the %s", req.Output, strings.Join(cp.Steps, ", "))
+		start, result, svg, outName = cp.Start, cp.Result, cp.SVG, req.Output
+		versions, phases = cp.Versions, cp.Phases
+	} else {
+		cp = &Checkpoint{Request: req, Start: start}
+		s.usage.SetPhase("generate")
+		s.preview.setStatus("generating: " + cmp.Or(req.Prompt, req.Image))
+		draft := s.draft
+		if s.variations > 1 {
+			draft = s.vary
+		}
+		result, svg, outName, err = draft(ctx, prompt, req.Output, cs, refs, pos, size)
+		if err != nil {
+			return result, "", err
+		}
+	}
+	s.preview.show(result.Title, svg)
+	last := time.Now()
+	version := func(name string) {
+		s.logStats(name, result, svg)
+		versions = append(versions, SheetEntry{Title: name, SVG: svg})
+		phases = append(phases, manifest.Phase{Name: name, Seconds: time.Since(last).Seconds(), Compiled: compiledStats(result.Code, svg)})
+		last = time.Now()
+	}
+	// finished checkpoints the sketch after step, unless the run was
+	// interrupted: then the step may have been cut short, and the run
+	// stops to be resumed from the checkpoint before it.
+	finished := func(step string) error {
+		if ctx.Err() != nil {
+			return fmt.Errorf("interrupted; resume with -resume %s", filepath.Dir(outName))
+		}
+		cp.Steps = append(cp.Steps, step)
+		cp.Result, cp.SVG, cp.Versions, cp.Phases = result, svg, versions, phases
+		spent, spentCost := s.usage.Total()
+		s.checkpoint(outName, cp, spent.sub(before), spentCost-beforeCost)
+		return nil
+	}
+	first := "draft"
+	if s.passes > 1 {
+		first = Passes[0].Name + " pass"
+	}
+	if !cp.done(first) {
+		version(first)
+		if err := finished(first); err != nil {
+			return result, "", err
+		}
+	}
+
+	for _, pass := range Passes[1:max(s.passes, 1)] {
+		name := pass.Name + " pass"
+		if cp.done(name) {
+			continue
+		}
+		result, svg = s.refinePass(ctx, description, outName, cs, pass, result, svg, pos, size)
+		version(name)
+		if err := finished(name); err != nil {
+			return result, "", err
+		}
+	}
+	if s.finish && !cp.done("finishing pass") {
+		result, svg = s.finishPass(ctx, description, outName, cs, result, svg, pos, size)
+		version("finishing pass")
+		if err := finished("finishing pass"); err != nil {
+			return result, "", err
+		}
+	}
+	for round := 1; round <= s.critique && !cp.done("critique"); round++ {
+		name := fmt.Sprintf("critique round %d", round)
+		if cp.done(name) {
+			continue
+		}
+		revised, revisedSVG := s.critiquePass(ctx, description, outName, cs, result, svg, pos, size)
+		if revised == result {
+			// No more revisions: "critique" marks the rounds done.
+			if err := finished("critique"); err != nil {
+				return result, "", err
+			}
+			break
+		}
+		s.log.Info("critique round %d: revised", round)
+		result, svg = revised, revisedSVG
+		version(name)
+		if err := finished(name); err != nil {
+			return result, "", err
+		}
+	}
+	if s.fills {
+		result, svg = s.fill(ctx, outName, result, svg, pos, size)
+	}
+	if s.strokeEps > 0 {
+		result, svg = s.dedupeStrokes(ctx, outName, result, svg, pos, size)
+	}
+	if req.Caption != "" {
+		result, svg = s.letter(ctx, req.Caption, outName, result, svg, pos, size)
+	}
+	if s.autofit {
+		result, svg = s.fitCanvas(ctx, outName, result, svg, pos, size)
+	}
+	if s.optimize > 0 {
+		result, svg = s.optimizeCode(ctx, outName, result, svg, pos, size)
+	}
+	if s.format {
+		result, svg = s.formatCode(ctx, outName, result, svg, pos, size)
+	}
+	if s.orderPaths {
+		svg = orderPaths(svg)
+	}
+	var lint []string
+	for _, d := range sketchlang.Lint(result.Code, sketchlang.Canvas{Width: size.X, Height: size.Y, Margin: s.margin}) {
+		s.log.Warn("lint: %s", d)
+		lint = append(lint, d.String())
 	}
 
 	sketchPath := outName + ".sketch"
 	svgPath := outName + ".svg"
 
-	must(os.WriteFile(sketchPath, []byte(result.Code), 0644))
-	must(os.WriteFile(svgPath, []byte(svg), 0644))
+	number := archiveVersion(outName)
+	if err := os.WriteFile(sketchPath, []byte(result.Code), 0644); err != nil {
+		return nil, "", err
+	}
+	if err := os.WriteFile(svgPath, []byte(svg), 0644); err != nil {
+		return nil, "", err
+	}
 
 	abs1, _ := filepath.Abs(sketchPath)
 	abs2, _ := filepath.Abs(svgPath)
 	fmt.Printf("%s\n%s\n", abs1, abs2)
+	exportSVG(outName, svg, s.exports)
+	thumb := writeThumbnail(outName, svg)
+	if s.bed != (Vec2{}) {
+		writeTiles(outName, svg, s.bed, s.overlap, s.exports)
+	}
+	if s.phases {
+		writePhases(outName, append(versions, SheetEntry{Title: "final", SVG: svg}))
+	}
+
+	if stats, err := SketchStats(result.Code, svg); err == nil {
+		printf("stats: %s", stats)
+	}
+	pens := s.pens
+	if req.Pens != nil {
+		pens = req.Pens
+	}
+	if program, ok := s.compileGcode(ctx, result.Code, outName, pos, size, pens); ok {
+		if len(pens) > 0 {
+			if err := os.WriteFile(outName+".gcode", []byte(program), 0644); err != nil {
+				return nil, "", err
+			}
+			abs, _ := filepath.Abs(outName + ".gcode")
+			fmt.Println(abs)
+		}
+		printf("plot: %s", gcode.Analyze(program, gcode.DefaultSpeeds))
+	}
+	after, afterCost := s.usage.Total()
+	result.Usage, result.Cost = cp.Usage.add(after.sub(before)), cp.Cost+afterCost-beforeCost
+	if result.Usage != (Usage{}) {
+		printf("usage: %s", formatUsage(result.Usage, result.Cost))
+	}
+	final := compiledStats(result.Code, svg)
+	writeManifest(outName, &manifest.Sketch{
+		Title:          result.Title,
+		Summary:        result.Summary,
+		Prompt:         req.Prompt,
+		Image:          req.Image,
+		Style:          s.style,
+		Model:          s.client.Model(),
+		Requester:      requester(req.Requester),
+		Time:           start,
+		Seconds:        time.Since(start).Seconds(),
+		Pos:            manifestPoint(pos),
+		Size:           manifestPoint(size),
+		Code:           result.Code,
+		Sketch:         filepath.Base(sketchPath),
+		SVG:            filepath.Base(svgPath),
+		Thumbnail:      thumb,
+		Usage:          manifestTokens(result.Usage),
+		Cost:           result.Cost,
+		BudgetExceeded: result.BudgetExceeded,
+		Phases:         phases,
+		Final:          &final,
+		Lint:           lint,
+		Version:        number,
+	})
+	finishCheckpoint(outName)
+
+	entry := HistoryEntry{Title: result.Title, Time: time.Now(), Sketch: abs1, SVG: abs2}
+	if err := RecordHistory(HistoryPath(), s.historyKey(req), entry); err != nil {
+		s.log.Warn("history: %v", err)
+	}
+	if s.series != "" {
+		e := SeriesEntry{Time: time.Now(), Prompt: req.Prompt, Title: result.Title, Summary: result.Summary, Style: s.style, Code: result.Code}
+		if err := RecordSeries(s.series, e); err != nil {
+			s.log.Warn("series: %v", err)
+		}
+	}
+	return result, svg, nil
+}
+
+// draft generates and compiles the first version of a sketch, repairing
+// it if it fails to compile or breaks its constraints. It returns the
+// output name, which defaults to the sketch's title.
+func (s *studio) draft(ctx context.Context, prompt, outName string, cs []Constraint, refs []Image, pos, size Vec2) (*SketchResult, string, string, error) {
+	s.log.Info("generating sketch...")
+	result, err := s.generate(ctx, s.client, prompt, refs...)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("generation failed: %w", err)
+	}
+
+	if outName == "" {
+		outName = sanitize(result.Title)
+	}
+
+	s.log.Info("compiling to SVG...")
+	svg, err := s.compiles.Compile(ctx, result.Code, outName, pos, size, s.log)
+	if err != nil && s.repair > 0 {
+		result, svg, err = s.repairPass(ctx, prompt, outName, cs, result, err, pos, size)
+	}
+	if err != nil {
+		return result, "", "", fmt.Errorf("compile failed: %w", err)
+	}
+	if violations := CheckConstraints(result.Code, cs); len(violations) > 0 {
+		result, svg, err = s.conformPass(ctx, prompt, outName, cs, result, violations, pos, size)
+		if err != nil {
+			return result, "", "", err
+		}
+	}
+	return result, svg, outName, nil
+}
+
+// vary is draft with s.variations drafts generated at once. Each one that
+// compiles and keeps to the constraints is saved under
+// <name>_variants/variant_<n>, and the model picks the best from their
+// renderings. Drafts are not repaired.
+func (s *studio) vary(ctx context.Context, prompt, outName string, cs []Constraint, refs []Image, pos, size Vec2) (*SketchResult, string, string, error) {
+	s.log.Info("generating %d variations...", s.variations)
+	drafts, errs := Variations(ctx, s.variations, prompt, func(ctx context.Context, p string) (*SketchResult, error) {
+		return s.generate(ctx, s.client, p, refs...)
+	})
+
+	var jobs []CompileJob
+	var compiled []int // the index in drafts of each job
+	for i, d := range drafts {
+		if errs[i] != nil {
+			printf("warning: variation %d: %v", i+1, errs[i])
+			continue
+		}
+		jobs = append(jobs, CompileJob{Code: d.Code, Name: cmp.Or(outName, sanitize(d.Title)), Pos: pos, Size: size})
+		compiled = append(compiled, i)
+	}
+	svgs, compileErrs := s.compiles.CompileMany(ctx, jobs, s.log)
+
+	var variants []variant
+	for j, i := range compiled {
+		d, svg := drafts[i], svgs[j]
+		if err := compileErrs[j]; err != nil {
+			printf("warning: variation %d failed to compile: %v", i+1, err)
+			continue
+		}
+		if violations := CheckConstraints(d.Code, cs); len(violations) > 0 {
+			printf("warning: variation %d: constraints not met: %s", i+1, strings.Join(violations, "; "))
+			continue
+		}
+		variants = append(variants, variant{n: i + 1, result: d, svg: svg})
+	}
+	if len(variants) == 0 {
+		return nil, "", "", fmt.Errorf("none of %d variations compiled", s.variations)
+	}
+
+	best := variants[s.pickVariation(ctx, prompt, variants)]
+	if outName == "" {
+		outName = sanitize(best.result.Title)
+	}
+	for _, v := range variants {
+		dir := filepath.Join(outName+"_variants", fmt.Sprintf("variant_%d", v.n))
+		if err := v.save(dir, filepath.Base(outName)); err != nil {
+			printf("warning: variation %d: %v", v.n, err)
+		}
+	}
+	return best.result, best.svg, outName, nil
+}
+
+// variant is one compiled draft of vary, numbered from 1.
+type variant struct {
+	n      int
+	result *SketchResult
+	svg    string
+}
+
+func (v variant) save(dir, name string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".sketch"), []byte(v.result.Code), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, name+".svg"), []byte(v.svg), 0644)
+}
+
+// pickVariation returns the index of the best of variants: the model's
+// choice from their renderings or, if that fails, the one with the most
+// of its bounds inked.
+func (s *studio) pickVariation(ctx context.Context, prompt string, variants []variant) int {
+	if len(variants) == 1 {
+		return 0
+	}
+
+	var renderings []Image
+	best, bestCoverage := 0, -1.0
+	for i, v := range variants {
+		paths, err := ParseSVGPaths(v.svg)
+		if err != nil {
+			s.log.Warn("variation %d: %v", v.n, err)
+			continue
+		}
+		if c := ComputeStats(paths).Coverage; c > bestCoverage {
+			best, bestCoverage = i, c
+		}
+		if png, err := RasterizePNG(paths, 512); err == nil {
+			renderings = append(renderings, Image{MediaType: "image/png", Data: png})
+		}
+	}
+	if len(renderings) != len(variants) {
+		return best
+	}
+
+	s.usage.SetPhase("pick")
+	i, err := PickVariation(ctx, s.client, s.system, prompt, renderings, s.log)
+	if err != nil {
+		printf("warning: %v; keeping the variation with the most coverage", err)
+		return best
+	}
+	return i
+}
+
+// historyKey is the request as recorded in the history, with the style,
+// reference image, constraints and caption so that a request differing in
+// any of them is not answered with an earlier sketch.
+func (s *studio) historyKey(req SketchRequest) string {
+	key := req.Prompt
+	if s.style != "" {
+		key += " style " + s.style
+	}
+	if req.Image != "" {
+		abs, _ := filepath.Abs(req.Image)
+		key += " image " + abs
+	}
+	if len(req.Constraints) > 0 {
+		key += " constraints " + strings.Join(req.Constraints, ", ")
+	}
+	if req.Caption != "" {
+		key += " caption " + req.Caption
+	}
+	return key
+}
+
+// describe returns the prompt for req, with its constraints, and its
+// reference images.
+func (s *studio) describe(req SketchRequest) (string, []Image, error) {
+	description, refs := req.Prompt, []Image(nil)
+	if req.Image != "" {
+		img, err := LoadImage(req.Image)
+		if err != nil {
+			return "", nil, fmt.Errorf("reference image: %w", err)
+		}
+		description, refs = ReferencePrompt(req.Prompt), []Image{img}
+	}
+	description = CaptionPrompt(ConstraintPrompt(description, ParseConstraints(req.Constraints)), req.Caption)
+
+	prompt, err := s.prompts.Request(PromptData{Description: description, Canvas: req.Size, Margin: s.margin})
+	if err != nil {
+		return "", nil, err
+	}
+	return prompt, refs, nil
+}
+
+// validator checks that code compiles and keeps to the constraints cs.
+func (s *studio) validator(ctx context.Context, cs []Constraint) func(string) (bool, []string) {
+	return func(code string) (bool, []string) {
+		if ok, errs := Validate(ctx, code, s.log); !ok {
+			return false, errs
+		}
+		if violations := CheckConstraints(code, cs); len(violations) > 0 {
+			return false, violations
+		}
+		return true, nil
+	}
+}
+
+// firstPass is the prompt that generates the sketch: with -passes, only
+// its first pass.
+func (s *studio) firstPass(prompt string) string {
+	if s.passes > 1 {
+		return PassPrompt(prompt, s.passes)
+	}
+	return prompt
+}
+
+func (s *studio) generate(ctx context.Context, client LLMClient, prompt string, refs ...Image) (*SketchResult, error) {
+	if s.json {
+		return GenerateJSON(ctx, client, s.system, prompt, s.log, refs...)
+	}
+	return Generate(ctx, client, s.system, prompt, s.log, refs...)
+}
+
+// prefetch sends the first request of every prompt that needs generating
+// as one provider batch, at the batch discount, and waits for the results.
+// The rows then run as usual, with those requests answered from the batch;
+// re-asks and finishing passes go to the provider directly. It needs
+// s.batcher.
+func (s *studio) prefetch(ctx context.Context, requests []SketchRequest) error {
+	var reqs []BatchRequest
+	for i, req := range requests {
+		rs, err := s.withStyle(req.Style)
+		if err != nil || (req.Prompt == "" && req.Image == "") || (s.dedupe > 0 && !s.force && FindRecent(HistoryPath(), rs.historyKey(req), s.dedupe) != nil) {
+			continue
+		}
+		prompt, refs, err := s.describe(req)
+		if err != nil {
+			continue
+		}
+		capture := &captureClient{LLMClient: s.client}
+		if _, err := rs.generate(ctx, capture, rs.firstPass(prompt), refs...); capture.req == nil {
+			return err
+		}
+		capture.req.ID = fmt.Sprintf("row-%d", i+1)
+		reqs = append(reqs, *capture.req)
+	}
+	if len(reqs) == 0 {
+		return nil
+	}
+
+	id, err := s.batcher.SubmitBatch(ctx, reqs)
+	if err != nil {
+		return err
+	}
+	printf("submitted batch %s with %d requests, waiting for results (this can take hours; Ctrl-C cancels)", id, len(reqs))
+	results, err := WaitBatch(ctx, s.batcher, id, time.Minute, s.log)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range reqs {
+		res, ok := results[r.ID]
+		switch {
+		case !ok:
+			printf("warning: batch has no result for %s", r.ID)
+		case res.Err != nil:
+			printf("warning: %s: %v", r.ID, res.Err)
+		default:
+			s.prefilled.Add(r.System, r.Messages, r.Options, res)
+		}
+	}
+	return nil
+}
+
+// repairPass asks the model to fix a sketch that failed to compile. On
+// failure it returns the original result and compile error.
+func (s *studio) repairPass(ctx context.Context, prompt, outName string, cs []Constraint, result *SketchResult, compileErr error, pos, size Vec2) (*SketchResult, string, error) {
+	printf("warning: sketch failed to compile, asking for a fix")
+	s.usage.SetPhase("repair")
+	s.preview.setStatus("repairing: " + result.Title)
+	validate := s.validator(ctx, cs)
+	errs := []string{strings.TrimPrefix(compileErr.Error(), "compile error: ")}
+	repaired, err := Repair(ctx, s.client, s.system, prompt, result, errs, validate, s.repair, s.log)
+	if err != nil {
+		printf("warning: repair failed: %v", err)
+		return result, "", compileErr
+	}
+
+	svg, err := s.compiles.Compile(ctx, repaired.Code, outName, pos, size, s.log)
+	if err != nil {
+		return result, "", compileErr
+	}
+	return repaired, svg, nil
+}
+
+// conformPass asks the model to fix a compiled sketch that breaks some of
+// its constraints. If it cannot, the sketch fails with the violations.
+func (s *studio) conformPass(ctx context.Context, prompt, outName string, cs []Constraint, result *SketchResult, violations []string, pos, size Vec2) (*SketchResult, string, error) {
+	broken := fmt.Errorf("constraints not met: %s", strings.Join(violations, "; "))
+	if s.repair == 0 {
+		return result, "", broken
+	}
+	printf("warning: %v, asking for a fix", broken)
+	s.usage.SetPhase("repair")
+	s.preview.setStatus("repairing: " + result.Title)
+	conformed, err := Conform(ctx, s.client, s.system, prompt, result, violations, s.validator(ctx, cs), s.repair, s.log)
+	if err != nil {
+		printf("warning: %v", err)
+		return result, "", broken
+	}
+
+	svg, err := s.compiles.Compile(ctx, conformed.Code, outName, pos, size, s.log)
+	if err != nil {
+		return result, "", fmt.Errorf("compile failed: %w", err)
+	}
+	return conformed, svg, nil
+}
+
+// critiquePass shows the model a rendering of the sketch and returns its
+// revision, or result itself if there is none.
+func (s *studio) critiquePass(ctx context.Context, prompt, outName string, cs []Constraint, result *SketchResult, svg string, pos, size Vec2) (*SketchResult, string) {
+	paths, err := ParseSVGPaths(svg)
+	if err != nil {
+		s.log.Warn("critique skipped: %v", err)
+		return result, svg
+	}
+	png, err := RasterizePNG(paths, 768)
+	if err != nil {
+		s.log.Warn("critique skipped: %v", err)
+		return result, svg
+	}
+
+	s.log.Info("critique...")
+	s.usage.SetPhase("critique")
+	s.preview.setStatus("critiquing: " + result.Title)
+	validate := s.validator(ctx, cs)
+	revised, err := Critique(ctx, s.client, s.system, prompt, result, Image{MediaType: "image/png", Data: png}, validate, s.log)
+	var overBudget *BudgetError
+	if errors.As(err, &overBudget) {
+		printf("warning: critique skipped: %v", err)
+		result.BudgetExceeded = true
+		return result, svg
+	}
+	if err != nil {
+		printf("warning: %v", err)
+		return result, svg
+	}
+	if revised == result {
+		return result, svg
+	}
+
+	revisedSVG, err := s.compiles.Compile(ctx, revised.Code, outName, pos, size, s.log)
+	if err != nil {
+		printf("warning: revised sketch failed to compile, keeping the previous one: %v", err)
+		return result, svg
+	}
+	s.preview.show(revised.Title, revisedSVG)
+	return revised, revisedSVG
+}
+
+// fill draws the regions the sketch's fill directives ask for and
+// recompiles.
+func (s *studio) fill(ctx context.Context, outName string, result *SketchResult, svg string, pos, size Vec2) (*SketchResult, string) {
+	code, errs := ExpandFills(result.Code)
+	for _, err := range errs {
+		printf("warning: fill: %v", err)
+	}
+	if code == result.Code {
+		return result, svg
+	}
+
+	filled := *result
+	filled.Code = code
+	filledSVG, err := s.compiles.Compile(ctx, filled.Code, outName, pos, size, s.log)
+	if err != nil {
+		printf("warning: sketch failed to compile with its fills, leaving them out: %v", err)
+		return result, svg
+	}
+	s.log.Info("drew the fills the sketch asked for")
+	s.preview.show(filled.Title, filledSVG)
+	return &filled, filledSVG
+}
+
+// dedupeStrokes removes strokes the sketch draws twice and recompiles.
+// If that fails, the sketch is kept as it was.
+func (s *studio) dedupeStrokes(ctx context.Context, outName string, result *SketchResult, svg string, pos, size Vec2) (*SketchResult, string) {
+	code, n := DedupeStrokes(result.Code, s.strokeEps)
+	if n == 0 {
+		return result, svg
+	}
+
+	deduped := *result
+	deduped.Code = code
+	dedupedSVG, err := s.compiles.Compile(ctx, deduped.Code, outName, pos, size, s.log)
+	if err != nil {
+		printf("warning: sketch failed to compile without its duplicate strokes, keeping them: %v", err)
+		return result, svg
+	}
+	s.log.Info("removed %d duplicate strokes", n)
+	s.preview.show(deduped.Title, dedupedSVG)
+	return &deduped, dedupedSVG
+}
+
+// fitCanvas scales and centres the sketch inside the margin if it runs off
+// the canvas, so it is not clipped when plotted, and recompiles it. If that
+// fails, the sketch is kept as drawn.
+func (s *studio) fitCanvas(ctx context.Context, outName string, result *SketchResult, svg string, pos, size Vec2) (*SketchResult, string) {
+	code, fitted, err := sketchlang.Fit(result.Code, sketchlang.Canvas{Width: size.X, Height: size.Y, Margin: s.margin})
+	if err != nil {
+		printf("warning: keeping the sketch as drawn: %v", err)
+		return result, svg
+	}
+	if !fitted {
+		return result, svg
+	}
+
+	fit := *result
+	fit.Code = code
+	fitSVG, err := s.compiles.Compile(ctx, fit.Code, outName, pos, size, s.log)
+	if err != nil {
+		printf("warning: fitted sketch failed to compile, keeping it as drawn: %v", err)
+		return result, svg
+	}
+	s.log.Info("sketch ran off the canvas: scaled and centred it to fit")
+	s.preview.show(fit.Title, fitSVG)
+	return &fit, fitSVG
+}
+
+// optimizeCode runs the optimizer over the sketch and recompiles it. If
+// that fails, the sketch is kept as it was.
+func (s *studio) optimizeCode(ctx context.Context, outName string, result *SketchResult, svg string, pos, size Vec2) (*SketchResult, string) {
+	code, stats, err := sketchlang.Optimize(result.Code, s.optimize)
+	if err != nil {
+		printf("warning: keeping the sketch unoptimized: %v", err)
+		return result, svg
+	}
+	if !stats.Changed() {
+		return result, svg
+	}
+
+	optimized := *result
+	optimized.Code = code
+	optimizedSVG, err := s.compiles.Compile(ctx, optimized.Code, outName, pos, size, s.log)
+	if err != nil {
+		printf("warning: optimized sketch failed to compile, keeping it unoptimized: %v", err)
+		return result, svg
+	}
+	s.log.Info("optimizer %s", stats)
+	return &optimized, optimizedSVG
+}
+
+// orderPaths reorders the paths of svg for less pen travel, reporting how
+// much less. If that fails, svg is kept as it is.
+func orderPaths(svg string) string {
+	ordered, travel, err := OrderPaths(svg)
+	if err != nil {
+		printf("warning: keeping the paths in the order drawn: %v", err)
+		return svg
+	}
+	printf("path order: %s", travel)
+	return ordered
+}
+
+// exportSVG writes svg beside <outName>.svg in each of the formats,
+// printing the path of each file written.
+func exportSVG(outName, svg string, exports []Exporter) bool {
+	ok := true
+	for _, e := range exports {
+		data, err := ExportSVG(e, svg)
+		if err == nil {
+			err = os.WriteFile(outName+e.Ext, data, 0644)
+		}
+		if err != nil {
+			printf("warning: %s: %v", outName+e.Ext, err)
+			ok = false
+			continue
+		}
+		abs, _ := filepath.Abs(outName + e.Ext)
+		fmt.Println(abs)
+	}
+	return ok
+}
+
+// writeThumbnail saves <outName>.thumb.png, a small PNG of svg, and
+// returns its name for the manifest, or "" if it couldn't be drawn.
+func writeThumbnail(outName, svg string) string {
+	path := outName + thumbnail.Ext
+	data, err := ExportSVG(thumbnail, svg)
+	if err == nil {
+		err = os.WriteFile(path, data, 0644)
+	}
+	if err != nil {
+		printf("warning: %s: %v", path, err)
+		return ""
+	}
+	abs, _ := filepath.Abs(path)
+	fmt.Println(abs)
+	return filepath.Base(path)
+}
+
+// writeTiles splits svg into tiles that fit bed, saving each as
+// <outName>_tile_<row>_<col>.svg and in each of the formats, with
+// <outName>_tiles.svg to assemble them by. It does nothing if the canvas
+// fits.
+func writeTiles(outName, svg string, bed Vec2, overlap float64, exports []Exporter) bool {
+	tiles, err := TileSVG(svg, bed, overlap)
+	if err != nil {
+		printf("warning: %s: tiles: %v", outName, err)
+		return false
+	}
+	if len(tiles) == 0 {
+		return true
+	}
+	ok := true
+	for _, t := range tiles {
+		name := outName + t.Name()
+		if err := os.WriteFile(name+".svg", []byte(t.SVG), 0644); err != nil {
+			printf("warning: %v", err)
+			ok = false
+			continue
+		}
+		ok = exportSVG(name, t.SVG, exports) && ok
+	}
+	if err := os.WriteFile(outName+"_tiles.svg", []byte(TileMap(svg, tiles)), 0644); err != nil {
+		printf("warning: %v", err)
+		return false
+	}
+	abs, _ := filepath.Abs(outName + "_tiles.svg")
+	printf("%d tiles of %gx%g mm; assembly map: %s", len(tiles), bed.X, bed.Y, abs)
+	return ok
+}
+
+// formatCode lays out the sketch's code in the canonical style and
+// recompiles it, since render statements may have moved.
+func (s *studio) formatCode(ctx context.Context, outName string, result *SketchResult, svg string, pos, size Vec2) (*SketchResult, string) {
+	code, err := sketchlang.Format(result.Code)
+	if err != nil {
+		printf("warning: keeping the sketch unformatted: %v", err)
+		return result, svg
+	}
+	if code == result.Code {
+		return result, svg
+	}
+
+	formatted := *result
+	formatted.Code = code
+	formattedSVG, err := s.compiles.Compile(ctx, formatted.Code, outName, pos, size, s.log)
+	if err != nil {
+		printf("warning: formatted sketch failed to compile, keeping it unformatted: %v", err)
+		return result, svg
+	}
+	return &formatted, formattedSVG
+}
+
+// letter adds caption under the drawing in the stroke font and recompiles.
+// If that fails, the sketch is kept without it.
+func (s *studio) letter(ctx context.Context, caption, outName string, result *SketchResult, svg string, pos, size Vec2) (*SketchResult, string) {
+	at, height := CaptionPlacement(result.Code, caption)
+	lettering := Lettering(caption, at, height)
+	if lettering == "" {
+		printf("warning: caption %q has no characters the stroke font can letter", caption)
+		return result, svg
+	}
+
+	captioned := *result
+	captioned.Code = strings.TrimRight(result.Code, "\n") + "\n\n" + lettering
+	captionedSVG, err := s.compiles.Compile(ctx, captioned.Code, outName, pos, size, s.log)
+	if err != nil {
+		printf("warning: sketch failed to compile with its caption, leaving it out: %v", err)
+		return result, svg
+	}
+	s.preview.show(captioned.Title, captionedSVG)
+	return &captioned, captionedSVG
+}
+
+// finishPass applies the artist's finishing touches and recompiles. Any
+// failure keeps the sketch as it was.
+func (s *studio) finishPass(ctx context.Context, prompt, outName string, cs []Constraint, result *SketchResult, svg string, pos, size Vec2) (*SketchResult, string) {
+	return s.extend(ctx, "finish", outName, cs, result, svg, pos, size, func(stats Stats, validate func(string) (bool, []string)) (*SketchResult, error) {
+		return Finish(ctx, s.client, s.system, prompt, result, stats, validate, s.log)
+	})
+}
+
+// refinePass runs one of the later Passes over the sketch.
+func (s *studio) refinePass(ctx context.Context, prompt, outName string, cs []Constraint, pass Pass, result *SketchResult, svg string, pos, size Vec2) (*SketchResult, string) {
+	return s.extend(ctx, pass.Name, outName, cs, result, svg, pos, size, func(stats Stats, validate func(string) (bool, []string)) (*SketchResult, error) {
+		return Refine(ctx, s.client, s.system, prompt, pass, result, stats, validate, s.log)
+	})
+}
+
+// extend runs a pass that appends to the compiled sketch, given its
+// render statistics, and compiles the result. If anything fails, the
+// sketch is kept as it was, including when the pass breaks constraints cs.
+func (s *studio) extend(ctx context.Context, phase, outName string, cs []Constraint, result *SketchResult, svg string, pos, size Vec2, pass func(Stats, func(string) (bool, []string)) (*SketchResult, error)) (*SketchResult, string) {
+	paths, err := ParseSVGPaths(svg)
+	if err != nil {
+		s.log.Warn("%s pass skipped: %v", phase, err)
+		return result, svg
+	}
+
+	s.log.Info("%s pass...", phase)
+	s.usage.SetPhase(phase)
+	s.preview.setStatus(phase + ": " + result.Title)
+	validate := s.validator(ctx, cs)
+	extended, err := pass(ComputeStats(paths), validate)
+	var overBudget *BudgetError
+	if errors.As(err, &overBudget) {
+		printf("warning: %s pass skipped: %v", phase, err)
+		result.BudgetExceeded = true
+		return result, svg
+	}
+	if err != nil {
+		printf("warning: %v", err)
+		return result, svg
+	}
+	if extended == result {
+		return result, svg
+	}
+
+	extendedSVG, err := s.compiles.Compile(ctx, extended.Code, outName, pos, size, s.log)
+	if err != nil {
+		printf("warning: sketch failed to compile after the %s pass, keeping the previous one: %v", phase, err)
+		return result, svg
+	}
+	s.preview.show(extended.Title, extendedSVG)
+	return extended, extendedSVG
+}
+
+// progress shows how much of a response has arrived, on the preview page
+// and, when stderr is a terminal, on a self-erasing status line.
+func (s *studio) progress(chars int, done bool) error {
+	if !done {
+		s.preview.setStatus(fmt.Sprintf("generating: %d chars received", chars))
+	}
+	if info, err := os.Stderr.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	if done {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	} else {
+		fmt.Fprintf(os.Stderr, "\rreceiving... %d chars", chars)
+	}
+	return nil
+}
+
+// reportUsage prints the run's token usage by model and phase.
+func (s *studio) reportUsage() {
+	if total, _ := s.usage.Total(); total == (Usage{}) {
+		return
+	}
+	printf("usage by model and phase:")
+	s.usage.Report(os.Stderr)
+}
+
+// existing answers a repeated request with a previous run's files.
+func (s *studio) existing(e *HistoryEntry) (*SketchResult, string, error) {
+	svg, err := os.ReadFile(e.SVG)
+	if err != nil {
+		return nil, "", err
+	}
+	if e.Sketch == "" {
+		// A decomposed request has only the merged SVG.
+		fmt.Println(e.SVG)
+		return &SketchResult{Title: e.Title}, string(svg), nil
+	}
+	code, err := os.ReadFile(e.Sketch)
+	if err != nil {
+		return nil, "", err
+	}
+	fmt.Printf("%s\n%s\n", e.Sketch, e.SVG)
+	return &SketchResult{Code: string(code), Title: e.Title}, string(svg), nil
+}
+
+// loadSpec picks the SketchLang spec for the installed compiler and
+// returns the documented features the compiler rejects.
+func loadSpec(dir string, log *Logger) (string, []SpecFeature) {
+	switch compiler().(type) {
+	case builtinBackend:
+		if backend == nil {
+			log.Warn("%s not found, using the built-in spec and renderer", compilerBin)
+		}
+		if dir == "" {
+			return LangSpec, nil
+		}
+		return loadBuiltinSpec(dir, log)
+	case execBackend:
+		if _, err := Probe(); err != nil {
+			fatal("%v", err)
+		}
+	}
+
+	version, err := CompilerVersion()
+	if err != nil {
+		log.Warn("compiler version unknown: %v", err)
+	}
+
+	var profiles []SpecProfile
+	if dir != "" {
+		if profiles, err = LoadSpecProfiles(dir); err != nil {
+			fatal("specs: %v", err)
+		}
+	}
+
+	profile := SelectSpec(profiles, version)
+	if profile.Version != "" {
+		log.Info("using spec %s for compiler %s", profile.Version, version)
+	}
+	if profile.Diagnostics != nil {
+		diagnosticPatterns = profile.Diagnostics
+	}
+
+	unsupported := UnsupportedFeatures(profile.Spec, log)
+	for _, f := range unsupported {
+		printf("warning: %s %s rejects %s, leaving it out of prompts", compilerBin, version, f.Name)
+	}
+	return profile.Spec, unsupported
+}
+
+// loadBuiltinSpec picks the newest spec in dir for the built-in renderer,
+// which has no version to match. Features it documents that the renderer
+// can't draw are left out of prompts, as for a compiler. The .diag files
+// are for compiler messages, so they are not used.
+func loadBuiltinSpec(dir string, log *Logger) (string, []SpecFeature) {
+	profiles, err := LoadSpecProfiles(dir)
+	if err != nil {
+		fatal("specs: %v", err)
+	}
+	profile := SelectSpec(profiles, "")
+	if profile.Version == "" {
+		log.Warn("no versioned specs in %s, using the built-in spec", dir)
+		return LangSpec, nil
+	}
+	log.Info("using spec %s for the built-in renderer", profile.Version)
+
+	unsupported := UnsupportedFeatures(profile.Spec, log)
+	for _, f := range unsupported {
+		printf("warning: the built-in renderer rejects %s, leaving it out of prompts", f.Name)
+	}
+	return profile.Spec, unsupported
+}
+
+func requestPrompt(desc, url string) string {
+	if url != "" {
+		return fmt.Sprintf("Create an extremely detailed sketch of the image at this URL: %s", url)
+	}
+	return desc
 }
 
 func parseVec(s string) Vec2 {
@@ -95,9 +1715,3 @@ func fatal(format string, args ...any) {
 	fmt.Fprintf(os.Stderr, "error: "+format+"\n", args...)
 	os.Exit(1)
 }
-
-func must(err error) {
-	if err != nil {
-		fatal("%v", err)
-	}
-}
\ No newline at end of file
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"sketch-studio/tools/manifest"
+)
+
+// writeManifest saves m as the manifest of the sketch saved as outName,
+// and prints where.
+func writeManifest(outName string, m *manifest.Sketch) bool {
+	path := manifest.Path(outName)
+	if err := manifest.Write(path, m); err != nil {
+		printf("warning: %s: manifest: %v", outName, err)
+		return false
+	}
+	abs, _ := filepath.Abs(path)
+	fmt.Println(abs)
+	return true
+}
+
+// archiveVersion moves the sketch saved as outName, if there is one, to
+// the directory of its version, so that a new version can be saved in its
+// place, and returns the new version's number. The files its manifest
+// names move, with the parts of a composition; exports are overwritten.
+func archiveVersion(outName string) int {
+	latest := 0
+	dirs, _ := filepath.Glob(filepath.Join(manifest.VersionsDir(outName), "v*"))
+	for _, dir := range dirs {
+		if n, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(dir), "v")); err == nil {
+			latest = max(latest, n)
+		}
+	}
+	old, err := manifest.LoadSketch(manifest.Path(outName))
+	if err != nil {
+		return latest + 1
+	}
+	v := latest + 1
+	dir := filepath.Dir(manifest.VersionPath(outName, v))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		printf("warning: %s: keeping version %d: %v", outName, v, err)
+		return v
+	}
+	files := []string{old.Sketch, old.SVG, old.Thumbnail, filepath.Base(manifest.Path(outName))}
+	if len(old.Parts) > 0 {
+		// Every part is in the same directory.
+		files = append(files, filepath.Dir(old.Parts[0].Manifest))
+	}
+	for _, name := range files {
+		if name == "" {
+			continue
+		}
+		if err := os.Rename(old.File(name), filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			printf("warning: %s: keeping version %d: %v", outName, v, err)
+		}
+	}
+	printf("kept version %d in %s", v, dir)
+	return v + 1
+}
+
+// compiledStats measures a version of a sketch for its manifest.
+func compiledStats(code, svg string) manifest.Compiled {
+	stats, err := SketchStats(code, svg)
+	if err != nil {
+		return manifest.Compiled{}
+	}
+	return manifest.Compiled{Paths: stats.Paths, Points: stats.Points, Length: stats.Length, Coverage: stats.Coverage}
+}
+
+func manifestTokens(u Usage) manifest.Tokens {
+	return manifest.Tokens{Input: u.InputTokens, Output: u.OutputTokens, CacheWrite: u.CacheWriteTokens, CacheRead: u.CacheReadTokens}
+}
+
+func manifestPoint(v Vec2) manifest.Point {
+	return manifest.Point{X: v.X, Y: v.Y}
+}
+
+// requester is who asked for a sketch, for manifests: name, from the
+// request, or the user running the studio if it has none.
+func requester(name string) string {
+	if name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return cmp.Or(os.Getenv("USER"), os.Getenv("USERNAME"))
+}
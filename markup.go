@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	errTagMissing  = errors.New("tag missing")
+	errTagUnclosed = errors.New("tag not closed")
+)
+
+// findTag returns the text inside the first <tag>...</tag> pair of an LLM
+// response. Matching is case-insensitive, allows attributes on the opening
+// tag and balances nested tags of the same name; everything in between,
+// stray angle brackets included, is kept verbatim. When the closing tag is
+// missing it returns the remainder with errTagUnclosed, since that usually
+// means the response was cut off.
+func findTag(content, tag string) (string, error) {
+	lower := asciiLower(content)
+	tag = asciiLower(tag)
+	closing := "</" + tag + ">"
+
+	_, start := openTag(lower, tag, 0)
+	if start < 0 {
+		return "", errTagMissing
+	}
+
+	depth := 1
+	for i := start; ; {
+		c := strings.Index(lower[i:], closing)
+		if c < 0 {
+			return strings.TrimSpace(content[start:]), errTagUnclosed
+		}
+		c += i
+
+		if o, past := openTag(lower, tag, i); o >= 0 && o < c {
+			depth++
+			i = past
+			continue
+		}
+
+		depth--
+		if depth == 0 {
+			return strings.TrimSpace(content[start:c]), nil
+		}
+		i = c + len(closing)
+	}
+}
+
+// asciiLower lowercases only ASCII letters, so every offset into the
+// result is the same offset into s. strings.ToLower can change the length
+// of other characters, such as 'Ⱥ'.
+func asciiLower(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+// openTag finds the next opening <tag ...> at or after from, returning its
+// start and the offset just past its '>', or -1, -1.
+func openTag(lower, tag string, from int) (int, int) {
+	for i := from; ; {
+		j := strings.Index(lower[i:], "<"+tag)
+		if j < 0 {
+			return -1, -1
+		}
+		j += i
+		k := j + len(tag) + 1
+		if k < len(lower) && (lower[k] == '>' || lower[k] == ' ' || lower[k] == '\t' || lower[k] == '\n') {
+			end := strings.IndexByte(lower[k:], '>')
+			if end < 0 {
+				return -1, -1
+			}
+			return j, k + end + 1
+		}
+		i = k
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFindTag(t *testing.T) {
+	tests := []struct {
+		name, content, tag string
+		want               string
+		err                error
+	}{
+		{"plain", "<title>Cat</title>", "title", "Cat", nil},
+		{"surrounding text", "Here it is:\n<title> Cat </title>\nthanks", "title", "Cat", nil},
+		{"mixed case", "<TiTle>Cat</TITLE>", "title", "Cat", nil},
+		{"mixed case tag name", "<title>Cat</title>", "TITLE", "Cat", nil},
+		{"attributes", `<code lang="sketch">trace a</code>`, "code", "trace a", nil},
+		{"stray brackets", "<summary>a < b and c > d</summary>", "summary", "a < b and c > d", nil},
+		{"nested", "<code><code>a</code> b</code> c", "code", "<code>a</code> b", nil},
+		{"repeated", "<title>One</title><title>Two</title>", "title", "One", nil},
+		{"longer tag name", "<titles>x</titles><title>Cat</title>", "title", "Cat", nil},
+		{"unclosed", "<code>trace a\nlet b", "code", "trace a\nlet b", errTagUnclosed},
+		{"unclosed nested", "<code><code>a</code>", "code", "<code>a</code>", errTagUnclosed},
+		{"unfinished opening", "<code lang=x", "code", "", errTagMissing},
+		{"missing", "no tags here", "title", "", errTagMissing},
+		{"empty", "<title></title>", "title", "", nil},
+		{"non-ASCII body", "<title>Café ☕ 猫</title>", "title", "Café ☕ 猫", nil},
+		// Ⱥ is 2 bytes and its lowercase 3, which once shifted the
+		// offsets into content.
+		{"length-changing case", "<title>" + strings.Repeat("Ⱥ", 20) + "</title> t", "title", strings.Repeat("Ⱥ", 20), nil},
+		{"length-changing case unclosed", strings.Repeat("Ⱥ", 10) + "<code>ȺȺ", "code", "ȺȺ", errTagUnclosed},
+		{"invalid UTF-8", "<title>\xff\xfeCat</title>", "title", "\xff\xfeCat", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findTag(tt.content, tt.tag)
+			if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+				t.Fatalf("findTag(%q, %q) error = %v, want %v", tt.content, tt.tag, err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("findTag(%q, %q) = %q, want %q", tt.content, tt.tag, got, tt.want)
+			}
+		})
+	}
+}
+
+func FuzzFindTag(f *testing.F) {
+	for _, seed := range []string{
+		"<title>Cat</title>",
+		"<CODE>trace a</code>",
+		"<code><code>a</code></code>",
+		"<summary>a < b</summary",
+		"ȺȺȺ<title>ȺȺ</title>",
+		"<title lang='x'>\xff</title>",
+	} {
+		f.Add(seed, "title")
+		f.Add(seed, "code")
+	}
+	f.Fuzz(func(t *testing.T, content, tag string) {
+		if tag == "" || strings.ContainsAny(tag, "<>") {
+			return
+		}
+		got, err := findTag(content, tag)
+		if err == nil || errors.Is(err, errTagUnclosed) {
+			// What is found is always part of the response, as it was.
+			if !strings.Contains(content, got) {
+				t.Errorf("findTag(%q, %q) = %q, not in the content", content, tag, got)
+			}
+		} else if !errors.Is(err, errTagMissing) {
+			t.Errorf("findTag(%q, %q): unexpected error %v", content, tag, err)
+		}
+	})
+}
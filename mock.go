@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// MockClient answers requests from fixture transcripts instead of a
+// provider, for running the whole pipeline offline and deterministically.
+// Fixtures are the JSONL files written by -record; hand-written ones need
+// only "messages" and "response" (or "error"). A request matches an entry
+// with the same messages, and the same system prompt if the entry has one.
+// Repeated matches are served in file order, the last one repeating.
+type MockClient struct {
+	mu       sync.Mutex
+	entries  []TranscriptEntry
+	served   map[int]bool
+	requests int
+}
+
+// NewMockClient loads every *.jsonl file in dir, in name order.
+func NewMockClient(dir string) (*MockClient, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no *.jsonl fixtures in %s", dir)
+	}
+	slices.Sort(paths)
+
+	m := &MockClient{served: map[int]bool{}}
+	for _, path := range paths {
+		if err := m.load(path); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *MockClient) load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e TranscriptEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		m.entries = append(m.entries, e)
+	}
+	return scanner.Err()
+}
+
+func (m *MockClient) Model() string {
+	return "mock"
+}
+
+func (m *MockClient) Complete(ctx context.Context, system string, messages []Message, opts RequestOptions) (Response, error) {
+	if err := ctx.Err(); err != nil {
+		return Response{}, err
+	}
+	e, err := m.match(system, messages)
+	if err != nil {
+		return Response{}, err
+	}
+	if e.Error != "" {
+		return Response{}, errors.New(e.Error)
+	}
+	stop := e.StopReason
+	if stop == "" {
+		stop = StopEnd
+	}
+	return Response{Text: e.Response, StopReason: stop, Thinking: e.Thinking}, nil
+}
+
+// StreamComplete passes the whole fixture response to onText at once.
+func (m *MockClient) StreamComplete(ctx context.Context, system string, messages []Message, opts RequestOptions, onText func(string) error) (Response, error) {
+	resp, err := m.Complete(ctx, system, messages, opts)
+	if err != nil {
+		return Response{}, err
+	}
+	if err := onText(resp.Text); err != nil {
+		return Response{}, err
+	}
+	return resp, nil
+}
+
+// Requests is how many requests the client has been sent, answered or not.
+func (m *MockClient) Requests() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.requests
+}
+
+func (m *MockClient) match(system string, messages []Message) (TranscriptEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests++
+
+	last := -1
+	for i, e := range m.entries {
+		if (e.System != "" && e.System != system) || !slices.EqualFunc(e.Messages, messages, Message.equal) {
+			continue
+		}
+		if !m.served[i] {
+			m.served[i] = true
+			return e, nil
+		}
+		last = i
+	}
+	if last < 0 {
+		return TranscriptEntry{}, fmt.Errorf("mock: no fixture for request ending %q", lastContent(messages))
+	}
+	return m.entries[last], nil
+}
+
+func lastContent(messages []Message) string {
+	if len(messages) == 0 {
+		return ""
+	}
+	s := messages[len(messages)-1].Content
+	if len(s) > 60 {
+		s = s[:60] + "..."
+	}
+	return s
+}
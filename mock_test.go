@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// mockStudio is a studio that compiles with the built-in backend and
+// answers from the fixtures in testdata/mock through NewProvider("mock"),
+// with a cache of its own.
+func mockStudio(t *testing.T, args ...string) (*studio, *MockClient) {
+	t.Helper()
+	t.Setenv("SKETCH_FIXTURES", filepath.Join("testdata", "mock"))
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	sf := addStudioFlags(fs)
+	if err := fs.Parse(append([]string{"-provider", "mock", "-backend", "builtin"}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { backend = nil })
+	st := sf.build()
+
+	client, err := NewProvider("mock", RequestOptions{}, st.log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.client = client
+	return st, client.(*MockClient)
+}
+
+func mockRequest(t *testing.T, prompt string) SketchRequest {
+	return SketchRequest{Prompt: prompt, Output: filepath.Join(t.TempDir(), "out"), Size: Vec2{80, 80}}
+}
+
+func TestMockGenerate(t *testing.T) {
+	st, mock := mockStudio(t)
+	req := mockRequest(t, "a cat")
+	result, svg, err := st.run(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "let a : vec = (10, 10)\ntrace stroke from (2, 2) to (40, 40)"; result.Code != want {
+		t.Errorf("code = %q, want %q", result.Code, want)
+	}
+	if result.Title != "Cat" || result.Summary != "s" {
+		t.Errorf("title, summary = %q, %q", result.Title, result.Summary)
+	}
+	if !strings.Contains(svg, "<path") {
+		t.Errorf("svg draws nothing:\n%s", svg)
+	}
+	if n := mock.Requests(); n != 1 {
+		t.Errorf("%d requests, want 1", n)
+	}
+	if _, err := os.Stat(req.Output + ".sketch"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMockRepair(t *testing.T) {
+	st, mock := mockStudio(t, "-repair", "2")
+	result, _, err := st.run(context.Background(), mockRequest(t, "a broken cat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "let head : sketch = [stroke from (20, 20) to (60, 20), stroke from (60, 20) to (40, 50)]\ntrace head"
+	if result.Code != want {
+		t.Errorf("code = %q, want the repaired %q", result.Code, want)
+	}
+	// The draft, and one repair that compiles.
+	if n := mock.Requests(); n != 2 {
+		t.Errorf("%d requests, want 2", n)
+	}
+}
+
+func TestMockRepairDisabled(t *testing.T) {
+	st, mock := mockStudio(t, "-repair", "0")
+	_, _, err := st.run(context.Background(), mockRequest(t, "a broken cat"))
+	if err == nil || !strings.Contains(err.Error(), "compile failed") {
+		t.Errorf("err = %v, want a compile failure", err)
+	}
+	if n := mock.Requests(); n != 1 {
+		t.Errorf("%d requests, want 1", n)
+	}
+}
+
+func TestMockNoFixture(t *testing.T) {
+	st, mock := mockStudio(t)
+	_, _, err := st.run(context.Background(), mockRequest(t, "a dog"))
+	if err == nil || !strings.Contains(err.Error(), "no fixture") {
+		t.Errorf("err = %v, want no fixture", err)
+	}
+	if n := mock.Requests(); n == 0 {
+		t.Error("no requests made")
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BatchRequest is one request of a provider batch.
+type BatchRequest struct {
+	ID       string // unique within the batch: letters, digits, - and _
+	System   string
+	Messages []Message
+	Options  RequestOptions
+}
+
+// BatchResult is the outcome of one BatchRequest. Its usage is left for
+// whoever uses the response to record, so that it counts toward the
+// sketch it is for.
+type BatchResult struct {
+	Response Response
+	Model    string // as usage is recorded under, at the batch rate
+	Usage    Usage
+	Err      error
+}
+
+// Batcher is implemented by providers with an asynchronous batch API,
+// which answers within hours at a discount.
+type Batcher interface {
+	SubmitBatch(ctx context.Context, reqs []BatchRequest) (string, error)
+	// BatchDone reports whether the batch has finished, with a progress
+	// summary for logging.
+	BatchDone(ctx context.Context, id string) (bool, string, error)
+	// BatchResults returns the finished batch's results by request ID.
+	BatchResults(ctx context.Context, id string) (map[string]BatchResult, error)
+}
+
+// WaitBatch polls a submitted batch every interval until it has finished,
+// then returns its results.
+func WaitBatch(ctx context.Context, b Batcher, id string, interval time.Duration, log *Logger) (map[string]BatchResult, error) {
+	for {
+		done, progress, err := b.BatchDone(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return b.BatchResults(ctx, id)
+		}
+		log.Info("batch %s: %s", id, progress)
+		if err := sleep(ctx, interval); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// limitedBatcher makes a provider's batch API calls within the rate limit
+// and circuit breaker of its other requests. They count as requests but
+// not as tokens, which providers limit separately for batches.
+type limitedBatcher struct {
+	inner   Batcher
+	limit   *RateLimitClient
+	breaker *BreakerClient
+}
+
+func (b *limitedBatcher) SubmitBatch(ctx context.Context, reqs []BatchRequest) (id string, err error) {
+	err = b.call(ctx, func() error {
+		id, err = b.inner.SubmitBatch(ctx, reqs)
+		return err
+	})
+	return id, err
+}
+
+func (b *limitedBatcher) BatchDone(ctx context.Context, id string) (done bool, progress string, err error) {
+	err = b.call(ctx, func() error {
+		done, progress, err = b.inner.BatchDone(ctx, id)
+		return err
+	})
+	return done, progress, err
+}
+
+func (b *limitedBatcher) BatchResults(ctx context.Context, id string) (results map[string]BatchResult, err error) {
+	err = b.call(ctx, func() error {
+		results, err = b.inner.BatchResults(ctx, id)
+		return err
+	})
+	return results, err
+}
+
+// call makes a batch API call once the breaker is closed and the rate
+// limit has room, retrying temporary errors as other requests are.
+func (b *limitedBatcher) call(ctx context.Context, f func() error) error {
+	if err := b.breaker.wait(ctx); err != nil {
+		return err
+	}
+	_, err := b.limit.retry(ctx, 0, func() (Response, error) {
+		return Response{}, f()
+	})
+	if ctx.Err() == nil {
+		b.breaker.record(err)
+	}
+	return err
+}
+
+const anthropicBatches = "https://api.anthropic.com/v1/messages/batches"
+
+// batchSuffix marks usage billed at the batch rate.
+const batchSuffix = " (batch)"
+
+type anthropicBatch struct {
+	ID               string `json:"id"`
+	ProcessingStatus string `json:"processing_status"`
+	ResultsURL       string `json:"results_url"`
+	RequestCounts    struct {
+		Processing int `json:"processing"`
+		Succeeded  int `json:"succeeded"`
+		Errored    int `json:"errored"`
+		Canceled   int `json:"canceled"`
+		Expired    int `json:"expired"`
+	} `json:"request_counts"`
+}
+
+// SubmitBatch creates a Message Batch and returns its ID.
+func (c *AnthropicClient) SubmitBatch(ctx context.Context, reqs []BatchRequest) (string, error) {
+	var items []map[string]any
+	var headers map[string]string
+	for _, r := range reqs {
+		var body map[string]any
+		body, headers = c.request(r.System, r.Messages, r.Options)
+		items = append(items, map[string]any{"custom_id": r.ID, "params": body})
+	}
+	if len(items) == 0 {
+		return "", errors.New("empty batch")
+	}
+
+	respBody, err := postJSON(ctx, anthropicBatches, headers, map[string]any{"requests": items}, 5*time.Minute)
+	if err != nil {
+		return "", err
+	}
+	var batch anthropicBatch
+	if err := json.Unmarshal(respBody, &batch); err != nil {
+		return "", err
+	}
+	return batch.ID, nil
+}
+
+func (c *AnthropicClient) BatchDone(ctx context.Context, id string) (bool, string, error) {
+	respBody, err := getBody(ctx, anthropicBatches+"/"+id, c.headers(), time.Minute)
+	if err != nil {
+		return false, "", err
+	}
+	var batch anthropicBatch
+	if err := json.Unmarshal(respBody, &batch); err != nil {
+		return false, "", err
+	}
+	n := batch.RequestCounts
+	progress := fmt.Sprintf("%s, %d processing, %d succeeded, %d errored", batch.ProcessingStatus, n.Processing, n.Succeeded, n.Errored)
+	return batch.ProcessingStatus == "ended", progress, nil
+}
+
+// BatchResults downloads the results of an ended batch, with their usage
+// at the batch rate.
+func (c *AnthropicClient) BatchResults(ctx context.Context, id string) (map[string]BatchResult, error) {
+	respBody, err := getBody(ctx, anthropicBatches+"/"+id+"/results", c.headers(), 10*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	results := map[string]BatchResult{}
+	scanner := bufio.NewScanner(bytes.NewReader(respBody))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var line struct {
+			CustomID string `json:"custom_id"`
+			Result   struct {
+				Type    string `json:"type"`
+				Message struct {
+					Model   string `json:"model"`
+					Content []struct {
+						Type     string `json:"type"`
+						Text     string `json:"text"`
+						Thinking string `json:"thinking"`
+					} `json:"content"`
+					StopReason string         `json:"stop_reason"`
+					Usage      anthropicUsage `json:"usage"`
+				} `json:"message"`
+				Error struct {
+					Error struct {
+						Message string `json:"message"`
+					} `json:"error"`
+				} `json:"error"`
+			} `json:"result"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return nil, fmt.Errorf("batch results: %w", err)
+		}
+
+		r := line.Result
+		switch r.Type {
+		case "succeeded":
+			var resp Response
+			for _, block := range r.Message.Content {
+				switch block.Type {
+				case "text":
+					resp.Text += block.Text
+				case "thinking":
+					resp.Thinking += block.Thinking
+				}
+			}
+			resp.StopReason = normalizeStop(r.Message.StopReason)
+			u := r.Message.Usage
+			results[line.CustomID] = BatchResult{
+				Response: resp,
+				Model:    r.Message.Model + batchSuffix,
+				Usage: Usage{
+					InputTokens:      u.InputTokens,
+					OutputTokens:     u.OutputTokens,
+					CacheWriteTokens: u.CacheCreationInputTokens,
+					CacheReadTokens:  u.CacheReadInputTokens,
+				},
+			}
+		case "errored":
+			results[line.CustomID] = BatchResult{Err: fmt.Errorf("batch request failed: %s", r.Error.Error.Message)}
+		default:
+			results[line.CustomID] = BatchResult{Err: fmt.Errorf("batch request %s", r.Type)}
+		}
+	}
+	return results, scanner.Err()
+}
+
+// PrefilledClient answers requests it has a response for, such as the
+// results of a batch, once each, and passes everything else to inner. The
+// usage of a response is recorded when it answers.
+type PrefilledClient struct {
+	inner LLMClient
+	usage *UsageTracker
+
+	mu        sync.Mutex
+	responses map[string]BatchResult
+}
+
+func NewPrefilledClient(inner LLMClient, usage *UsageTracker) *PrefilledClient {
+	return &PrefilledClient{inner: inner, usage: usage, responses: map[string]BatchResult{}}
+}
+
+// Add makes the response of res the answer to the given request.
+func (p *PrefilledClient) Add(system string, messages []Message, opts RequestOptions, res BatchResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.responses[requestKey(system, messages, opts)] = res
+}
+
+func (p *PrefilledClient) Model() string {
+	return p.inner.Model()
+}
+
+func (p *PrefilledClient) CountTokens(ctx context.Context, system string, messages []Message) (int, error) {
+	return CountTokens(ctx, p.inner, system, messages)
+}
+
+func (p *PrefilledClient) Complete(ctx context.Context, system string, messages []Message, opts RequestOptions) (Response, error) {
+	if resp, ok := p.take(system, messages, opts); ok {
+		return resp, nil
+	}
+	return p.inner.Complete(ctx, system, messages, opts)
+}
+
+func (p *PrefilledClient) StreamComplete(ctx context.Context, system string, messages []Message, opts RequestOptions, onText func(string) error) (Response, error) {
+	if resp, ok := p.take(system, messages, opts); ok {
+		if err := onText(resp.Text); err != nil {
+			return Response{}, err
+		}
+		return resp, nil
+	}
+	return p.inner.StreamComplete(ctx, system, messages, opts, onText)
+}
+
+func (p *PrefilledClient) take(system string, messages []Message, opts RequestOptions) (Response, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := requestKey(system, messages, opts)
+	res, ok := p.responses[key]
+	delete(p.responses, key)
+	if ok && res.Model != "" {
+		p.usage.Record(res.Model, res.Usage)
+	}
+	return res.Response, ok
+}
+
+func requestKey(system string, messages []Message, opts RequestOptions) string {
+	data, _ := json.Marshal(struct {
+		System   string         `json:"system"`
+		Messages []Message      `json:"messages"`
+		Options  RequestOptions `json:"options"`
+	}{system, messages, opts})
+	return string(data)
+}
+
+// errCaptured stops a request captured by captureClient.
+var errCaptured = errors.New("request captured")
+
+// captureClient records the first request sent through it instead of
+// sending it, to learn what a generator would ask without running it.
+type captureClient struct {
+	LLMClient
+	req *BatchRequest
+}
+
+func (c *captureClient) Complete(ctx context.Context, system string, messages []Message, opts RequestOptions) (Response, error) {
+	c.req = &BatchRequest{System: system, Messages: messages, Options: opts}
+	return Response{}, errCaptured
+}
+
+func (c *captureClient) StreamComplete(ctx context.Context, system string, messages []Message, opts RequestOptions, onText func(string) error) (Response, error) {
+	return c.Complete(ctx, system, messages, opts)
+}
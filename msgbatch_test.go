@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// A batch answer's usage counts toward the budget of the sketch that
+// uses it, not of the run that downloaded it.
+func TestPrefilledBudget(t *testing.T) {
+	mock, err := NewMockClient(filepath.Join("testdata", "mock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage := NewUsageTracker()
+	prefilled := NewPrefilledClient(mock, usage)
+	budget := NewBudgetClient(prefilled, Budget{MaxTokens: 100}, usage)
+
+	messages := []Message{{Role: "user", Content: "a cat"}}
+	prefilled.Add("system", messages, RequestOptions{}, BatchResult{
+		Response: Response{Text: "batched"},
+		Model:    "claude" + batchSuffix,
+		Usage:    Usage{InputTokens: 80, OutputTokens: 40},
+	})
+	if total, _ := usage.Total(); total != (Usage{}) {
+		t.Errorf("usage %+v recorded before the answer was used", total)
+	}
+
+	budget.Reset()
+	resp, err := budget.Complete(context.Background(), "system", messages, RequestOptions{})
+	if err != nil || resp.Text != "batched" {
+		t.Fatalf("Complete() = %q, %v; want the batch answer", resp.Text, err)
+	}
+	if total, _ := usage.Total(); total.InputTokens != 80 || total.OutputTokens != 40 {
+		t.Errorf("usage = %+v, want the batch answer's", total)
+	}
+	var over *BudgetError
+	if _, err := budget.Complete(context.Background(), "system", messages, RequestOptions{}); !errors.As(err, &over) {
+		t.Errorf("second request: err = %v, want the budget exceeded", err)
+	}
+	if n := mock.Requests(); n != 0 {
+		t.Errorf("%d requests reached the provider", n)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Silhouettes are worked out on a grid of silhouetteCell mm, so the lines
+// behind end within about a cell of the outline in front. Gaps in an
+// outline up to about twice silhouetteClose mm wide are closed.
+const (
+	silhouetteCell  = 0.5
+	silhouetteClose = 1.5
+)
+
+// Occlude hides the lines of each part behind the parts in front of it,
+// those with a larger Z whose regions overlap its own, so overlapping
+// subjects are not drawn as if transparent. A part in front covers what
+// its lines enclose, with gaps in its outline of up to 3 mm closed. The
+// SVGs of the parts behind are redrawn without the hidden lines; the
+// others are returned as they are. It returns the length of line hidden,
+// in mm.
+func Occlude(parts []PlacedSVG) ([]PlacedSVG, float64, error) {
+	out := append([]PlacedSVG(nil), parts...)
+	silhouettes := make([]*silhouette, len(parts))
+	hidden := 0.0
+	for i, back := range parts {
+		var front []*silhouette
+		for j, p := range parts {
+			if p.Z <= back.Z || !overlaps(back, p) {
+				continue
+			}
+			if silhouettes[j] == nil {
+				paths, err := ParseSVGPaths(p.SVG)
+				if err != nil {
+					return nil, 0, fmt.Errorf("part %d: %w", j+1, err)
+				}
+				silhouettes[j] = silhouetteOf(paths, p.Pos, Vec2{p.Pos.X + p.Size.X, p.Pos.Y + p.Size.Y})
+			}
+			front = append(front, silhouettes[j])
+		}
+		if len(front) == 0 {
+			continue
+		}
+
+		paths, err := ParseSVGPaths(back.SVG)
+		if err != nil {
+			return nil, 0, fmt.Errorf("part %d: %w", i+1, err)
+		}
+		covered := func(p Vec2) bool {
+			for _, s := range front {
+				if s.covers(p) {
+					return true
+				}
+			}
+			return false
+		}
+		var visible []Polyline
+		for _, p := range paths {
+			before := polylineLength(p)
+			pieces := uncovered(p, covered)
+			for _, piece := range pieces {
+				before -= polylineLength(piece)
+			}
+			hidden += before
+			visible = append(visible, pieces...)
+		}
+		viewBox, _ := splitSVG(back.SVG)
+		out[i].SVG = pathsSVG(viewBox, visible, strokeWidth(back.SVG))
+	}
+	return out, hidden, nil
+}
+
+func overlaps(a, b PlacedSVG) bool {
+	return a.Pos.X < b.Pos.X+b.Size.X && b.Pos.X < a.Pos.X+a.Size.X &&
+		a.Pos.Y < b.Pos.Y+b.Size.Y && b.Pos.Y < a.Pos.Y+a.Size.Y
+}
+
+// silhouette is the area a drawing covers, on a grid of silhouetteCell.
+type silhouette struct {
+	origin Vec2
+	w, h   int
+	inside []bool
+}
+
+// silhouetteOf works out what the paths drawn in the box from lo to hi
+// cover: their ink, thickened to close small gaps, and everything it
+// encloses, thinned again to the ink.
+func silhouetteOf(paths []Polyline, lo, hi Vec2) *silhouette {
+	r := int(math.Ceil(silhouetteClose / silhouetteCell))
+	// A border of r+1 cells keeps thickened ink off the edge, so the
+	// flood from the edge gets all the way round.
+	border := float64(r+1) * silhouetteCell
+	s := &silhouette{origin: Vec2{lo.X - border, lo.Y - border}}
+	s.w = int(math.Ceil((hi.X-lo.X+2*border)/silhouetteCell)) + 1
+	s.h = int(math.Ceil((hi.Y-lo.Y+2*border)/silhouetteCell)) + 1
+
+	ink := make([]bool, s.w*s.h)
+	for _, p := range paths {
+		if len(p) == 1 {
+			p = Polyline{p[0], p[0]}
+		}
+		for i := 1; i < len(p); i++ {
+			steps := int(math.Ceil(distance(p[i-1], p[i])/(silhouetteCell/2))) + 1
+			for k := 0; k <= steps; k++ {
+				t := float64(k) / float64(steps)
+				if x, y, ok := s.cell(Vec2{p[i-1].X + t*(p[i].X-p[i-1].X), p[i-1].Y + t*(p[i].Y-p[i-1].Y)}); ok {
+					ink[y*s.w+x] = true
+				}
+			}
+		}
+	}
+	ink = s.morph(ink, r, true)
+
+	// Flood the outside from the edge; what it can't reach is enclosed.
+	outside := make([]bool, s.w*s.h)
+	var queue []int
+	push := func(x, y int) {
+		if x < 0 || y < 0 || x >= s.w || y >= s.h {
+			return
+		}
+		if i := y*s.w + x; !outside[i] && !ink[i] {
+			outside[i] = true
+			queue = append(queue, i)
+		}
+	}
+	for x := range s.w {
+		push(x, 0)
+		push(x, s.h-1)
+	}
+	for y := range s.h {
+		push(0, y)
+		push(s.w-1, y)
+	}
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		x, y := i%s.w, i/s.w
+		push(x-1, y)
+		push(x+1, y)
+		push(x, y-1)
+		push(x, y+1)
+	}
+
+	s.inside = make([]bool, s.w*s.h)
+	for i := range s.inside {
+		s.inside[i] = !outside[i]
+	}
+	s.inside = s.morph(s.inside, r, false)
+	return s
+}
+
+// morph grows the cells set in grid by r cells if grow, or shrinks them
+// by r if not.
+func (s *silhouette) morph(grid []bool, r int, grow bool) []bool {
+	out := make([]bool, len(grid))
+	for y := range s.h {
+		for x := range s.w {
+			v := !grow
+			for dy := -r; dy <= r && v != grow; dy++ {
+				for dx := -r; dx <= r; dx++ {
+					if dx*dx+dy*dy > r*r {
+						continue
+					}
+					nx, ny := x+dx, y+dy
+					set := nx >= 0 && ny >= 0 && nx < s.w && ny < s.h && grid[ny*s.w+nx]
+					if set == grow {
+						v = grow
+						break
+					}
+				}
+			}
+			out[y*s.w+x] = v
+		}
+	}
+	return out
+}
+
+func (s *silhouette) cell(p Vec2) (int, int, bool) {
+	x := int(math.Floor((p.X - s.origin.X) / silhouetteCell))
+	y := int(math.Floor((p.Y - s.origin.Y) / silhouetteCell))
+	return x, y, x >= 0 && y >= 0 && x < s.w && y < s.h
+}
+
+func (s *silhouette) covers(p Vec2) bool {
+	x, y, ok := s.cell(p)
+	return ok && s.inside[y*s.w+x]
+}
+
+// uncovered is the parts of p that covered doesn't cover, found to within
+// a quarter of a silhouette cell.
+func uncovered(p Polyline, covered func(Vec2) bool) []Polyline {
+	if len(p) == 1 {
+		if covered(p[0]) {
+			return nil
+		}
+		return []Polyline{p}
+	}
+	var out []Polyline
+	var cur Polyline
+	if !covered(p[0]) {
+		cur = Polyline{p[0]}
+	}
+	for i := 1; i < len(p); i++ {
+		a, b := p[i-1], p[i]
+		steps := int(math.Ceil(distance(a, b)/(silhouetteCell/4))) + 1
+		at := func(k int) Vec2 {
+			t := float64(k) / float64(steps)
+			return Vec2{a.X + t*(b.X-a.X), a.Y + t*(b.Y-a.Y)}
+		}
+		for k := 1; k <= steps; k++ {
+			q := at(k)
+			switch hidden := covered(q); {
+			case hidden && cur != nil:
+				cur = append(cur, at(k-1))
+				if len(cur) > 1 && polylineLength(cur) > 0 {
+					out = append(out, cur)
+				}
+				cur = nil
+			case !hidden && cur == nil:
+				cur = Polyline{q}
+			}
+		}
+		if cur != nil && cur[len(cur)-1] != b {
+			cur = append(cur, b)
+		}
+	}
+	if len(cur) > 1 && polylineLength(cur) > 0 {
+		out = append(out, cur)
+	}
+	return out
+}
+
+// pathsSVG writes paths on a white canvas of viewBox, in mm.
+func pathsSVG(viewBox string, paths []Polyline, pen float64) string {
+	v := parseNumbers(viewBox)
+	var b strings.Builder
+	if len(v) == 4 {
+		fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%gmm" height="%gmm" viewBox="%s">`+"\n", v[2], v[3], viewBox)
+	} else {
+		fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="%s">`+"\n", viewBox)
+	}
+	b.WriteString(`  <rect width="100%" height="100%" fill="white"/>` + "\n")
+	for _, p := range paths {
+		fmt.Fprintf(&b, `  <path d="%s" fill="none" stroke="black" stroke-width="%g"/>`+"\n", pathD(p), pen)
+	}
+	b.WriteString("</svg>\n")
+	return b.String()
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const ollamaRetries = 2
+
+// ollamaRetryWait is the wait before the first retry, doubled for each
+// one after it, when the server gives no Retry-After.
+var ollamaRetryWait = 2 * time.Second
+
+// OllamaClient talks to Ollama's native chat API, for fully offline
+// generation. The server address and model come from OLLAMA_HOST and
+// OLLAMA_MODEL.
+type OllamaClient struct {
+	usageSink
+	url   string
+	model string
+	log   *Logger
+}
+
+func NewOllamaClient(log *Logger) *OllamaClient {
+	host := os.Getenv("OLLAMA_HOST")
+	if host == "" {
+		host = "http://localhost:11434"
+	} else if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	model := os.Getenv("OLLAMA_MODEL")
+	if model == "" {
+		model = "llama3.1"
+	}
+	return &OllamaClient{url: strings.TrimRight(host, "/") + "/api/chat", model: model, log: log}
+}
+
+func (c *OllamaClient) Model() string {
+	return c.model
+}
+
+// ollamaResponse is a whole response, or one line of a streamed one.
+type ollamaResponse struct {
+	Message struct {
+		Content string `json:"content"`
+	} `json:"message"`
+	Done            bool   `json:"done"`
+	DoneReason      string `json:"done_reason"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+	Error           string `json:"error"`
+}
+
+func (c *OllamaClient) request(system string, messages []Message, opts RequestOptions, stream bool) map[string]any {
+	msgs := []map[string]any{{"role": "system", "content": system}}
+	for _, m := range messages {
+		msg := map[string]any{"role": m.Role, "content": m.Content}
+		if len(m.Images) > 0 {
+			var images [][]byte
+			for _, img := range m.Images {
+				images = append(images, img.Data)
+			}
+			msg["images"] = images
+		}
+		msgs = append(msgs, msg)
+	}
+
+	options := map[string]any{"num_predict": opts.maxTokens()}
+	if opts.Temperature != nil {
+		options["temperature"] = *opts.Temperature
+	}
+	if opts.TopP != nil {
+		options["top_p"] = *opts.TopP
+	}
+	if len(opts.StopSequences) > 0 {
+		options["stop"] = opts.StopSequences
+	}
+
+	body := map[string]any{
+		"model":    opts.model(c.model),
+		"messages": msgs,
+		"stream":   stream,
+		"options":  options,
+	}
+	if opts.JSONSchema != nil {
+		body["format"] = opts.JSONSchema
+	}
+	return body
+}
+
+func (c *OllamaClient) Complete(ctx context.Context, system string, messages []Message, opts RequestOptions) (Response, error) {
+	return c.retry(ctx, func() (Response, error) {
+		respBody, err := postJSON(ctx, c.url, nil, c.request(system, messages, opts, false), 300*time.Second)
+		if err != nil {
+			return Response{}, err
+		}
+
+		var result ollamaResponse
+		if err := json.Unmarshal(respBody, &result); err != nil {
+			return Response{}, err
+		}
+		if result.Error != "" {
+			return Response{}, fmt.Errorf("API error: %s", result.Error)
+		}
+		if result.Message.Content == "" {
+			return Response{}, fmt.Errorf("empty response")
+		}
+
+		c.account(opts.model(c.model), result)
+		return Response{Text: result.Message.Content, StopReason: normalizeStop(result.DoneReason)}, nil
+	})
+}
+
+func (c *OllamaClient) StreamComplete(ctx context.Context, system string, messages []Message, opts RequestOptions, onText func(string) error) (Response, error) {
+	return c.retry(ctx, func() (Response, error) {
+		var text strings.Builder
+		var doneReason string
+		err := streamLines(ctx, c.url, nil, c.request(system, messages, opts, true), 10*time.Minute, "application/x-ndjson", func(line []byte) error {
+			var chunk ollamaResponse
+			if err := json.Unmarshal(line, &chunk); err != nil {
+				return err
+			}
+			if chunk.Error != "" {
+				return fmt.Errorf("API error: %s", chunk.Error)
+			}
+			if chunk.Done {
+				doneReason = chunk.DoneReason
+				c.account(opts.model(c.model), chunk)
+			}
+			if chunk.Message.Content == "" {
+				return nil
+			}
+			text.WriteString(chunk.Message.Content)
+			if err := onText(chunk.Message.Content); err != nil {
+				return &abortError{err}
+			}
+			return nil
+		})
+		if err != nil && text.Len() > 0 {
+			return Response{}, &partialError{err}
+		}
+		if err != nil {
+			return Response{}, err
+		}
+		if text.Len() == 0 {
+			return Response{}, fmt.Errorf("empty response")
+		}
+		return Response{Text: text.String(), StopReason: normalizeStop(doneReason)}, nil
+	})
+}
+
+// account reports a finished response's eval counts as token usage.
+func (c *OllamaClient) account(model string, r ollamaResponse) {
+	c.usage.Record(model, Usage{InputTokens: r.PromptEvalCount, OutputTokens: r.EvalCount})
+	c.log.Debug("ollama: %d prompt tokens, %d output tokens", r.PromptEvalCount, r.EvalCount)
+}
+
+// abortError marks a stream stopped by the caller, which is not retried.
+type abortError struct{ err error }
+
+func (e *abortError) Error() string { return e.err.Error() }
+func (e *abortError) Unwrap() error { return e.err }
+
+// partialError marks a stream that failed after some of its text reached
+// the caller. It is not retried, since that would send the caller the same
+// text again.
+type partialError struct{ err error }
+
+func (e *partialError) Error() string { return e.err.Error() }
+func (e *partialError) Unwrap() error { return e.err }
+
+// retry runs call, retrying transient failures: the server unreachable,
+// still loading the model (503) or otherwise failing (5xx), or rate
+// limiting (429). Anything else, such as a missing model (404) or a
+// response that can't be decoded, would only fail again. Caller aborts
+// and cancellation are returned as they came.
+func (c *OllamaClient) retry(ctx context.Context, call func() (Response, error)) (Response, error) {
+	for attempt := 0; ; attempt++ {
+		content, err := call()
+		var aborted *abortError
+		var partial *partialError
+		switch {
+		case err == nil:
+			return content, nil
+		case errors.As(err, &aborted):
+			return Response{}, aborted.err
+		case ctx.Err() != nil:
+			return Response{}, ctx.Err()
+		case errors.As(err, &partial):
+			return Response{}, fmt.Errorf("Ollama: stream broke off: %w", partial.err)
+		case !outage(err) || attempt == ollamaRetries:
+			return Response{}, fmt.Errorf("Ollama: %w", err)
+		}
+		wait := ollamaRetryWait << attempt
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			wait = apiErr.RetryAfter
+		}
+		c.log.Warn("ollama request failed (attempt %d/%d): %v", attempt+1, ollamaRetries+1, err)
+		if err := sleep(ctx, wait); err != nil {
+			return Response{}, err
+		}
+	}
+}
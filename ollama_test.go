@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// ollamaServer serves each request to /api/chat with handle, counting
+// them, and points OLLAMA_HOST at itself.
+func ollamaServer(t *testing.T, handle http.HandlerFunc) (*OllamaClient, *int) {
+	t.Helper()
+	wait := ollamaRetryWait
+	ollamaRetryWait = 0
+	t.Cleanup(func() { ollamaRetryWait = wait })
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		handle(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("OLLAMA_HOST", srv.URL)
+	return NewOllamaClient(&Logger{}), &requests
+}
+
+func TestOllamaRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		requests int
+	}{
+		{"loading model", http.StatusServiceUnavailable, ollamaRetries + 1},
+		{"server error", http.StatusInternalServerError, ollamaRetries + 1},
+		{"rate limited", http.StatusTooManyRequests, ollamaRetries + 1},
+		{"missing model", http.StatusNotFound, 1},
+		{"bad request", http.StatusBadRequest, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, requests := ollamaServer(t, func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, `{"error":"no"}`, tt.status)
+			})
+			if _, err := c.Complete(context.Background(), "", []Message{{Role: "user", Content: "hi"}}, RequestOptions{}); err == nil {
+				t.Fatal("no error")
+			}
+			if *requests != tt.requests {
+				t.Errorf("%d requests, want %d", *requests, tt.requests)
+			}
+		})
+	}
+}
+
+func TestOllamaRetryRecovers(t *testing.T) {
+	first := true
+	c, requests := ollamaServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if first {
+			first = false
+			http.Error(w, `{"error":"loading model"}`, http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"message":{"content":"trace a"},"done":true,"done_reason":"stop"}`))
+	})
+	resp, err := c.Complete(context.Background(), "", []Message{{Role: "user", Content: "hi"}}, RequestOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != "trace a" || *requests != 2 {
+		t.Errorf("text %q after %d requests, want %q after 2", resp.Text, *requests, "trace a")
+	}
+}
+
+func TestOllamaStreamNotRetriedAfterText(t *testing.T) {
+	c, requests := ollamaServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"message":{"content":"trace "}}` + "\n"))
+		w.(http.Flusher).Flush()
+		// The connection drops partway through.
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	})
+	var got strings.Builder
+	_, err := c.StreamComplete(context.Background(), "", []Message{{Role: "user", Content: "hi"}}, RequestOptions{}, func(s string) error {
+		got.WriteString(s)
+		return nil
+	})
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if *requests != 1 || got.String() != "trace " {
+		t.Errorf("%d requests delivered %q, want 1 delivering %q once", *requests, got.String(), "trace ")
+	}
+}
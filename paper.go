@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// papers are the sizes -paper knows, upright, in mm.
+var papers = map[string]Vec2{
+	"a5":       {148, 210},
+	"a4":       {210, 297},
+	"a3":       {297, 420},
+	"letter":   {215.9, 279.4},
+	"postcard": {101.6, 152.4}, // 4 x 6 in
+}
+
+// PaperNames lists the paper sizes, sorted.
+func PaperNames() []string {
+	var names []string
+	for name := range papers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// ParsePaper is the canvas of a paper named like "a4", upright, or
+// "a4,landscape", on its side. Names are not case-sensitive.
+func ParsePaper(s string) (Vec2, error) {
+	name, orientation, _ := strings.Cut(s, ",")
+	size, ok := papers[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return Vec2{}, fmt.Errorf("unknown paper %q (want %s)", name, strings.Join(PaperNames(), ", "))
+	}
+	switch strings.ToLower(strings.TrimSpace(orientation)) {
+	case "", "portrait":
+		return size, nil
+	case "landscape":
+		return Vec2{size.Y, size.X}, nil
+	}
+	return Vec2{}, fmt.Errorf("unknown orientation %q (want portrait or landscape)", orientation)
+}
+
+// addSizeFlags registers -size and -paper on fs. Call the returned func
+// once fs is parsed for the canvas: the paper's if -paper is set, else
+// -size.
+func addSizeFlags(fs *flag.FlagSet) func() Vec2 {
+	size := fs.String("size", "80,80", "size w,h in mm")
+	paper := fs.String("paper", "", "size the canvas to a sheet of "+strings.Join(PaperNames(), ", ")+", with \",landscape\" to turn it on its side, e.g. a4,landscape")
+	return func() Vec2 {
+		if *paper == "" {
+			return parseVec(*size)
+		}
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == "size" {
+				fatal("-paper and -size both set the canvas; give one")
+			}
+		})
+		v, err := ParsePaper(*paper)
+		if err != nil {
+			fatal("paper: %v", err)
+		}
+		return v
+	}
+}
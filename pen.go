@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sketch-studio/tools/gcode"
+	"sketch-studio/tools/sketchlang"
+)
+
+// penModes are the render modes a pen depth can be set for.
+var penModes = []string{"trace", "draw", "scribble"}
+
+// PenDepths are how deep to lower the pen, in the compiler's Z units, for
+// each render mode: deeper for a heavier line from a pen that responds to
+// pressure. Modes left out keep the compiler's depth.
+type PenDepths map[string]float64
+
+// ParsePenDepths reads depths written like "trace=2.6,scribble=1.6".
+func ParsePenDepths(s string) (PenDepths, error) {
+	depths := PenDepths{}
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		mode, value, ok := strings.Cut(part, "=")
+		mode = strings.TrimSpace(mode)
+		if !ok || !slices.Contains(penModes, mode) {
+			return nil, fmt.Errorf("pen depth %q is not mode=depth, for mode %s", part, strings.Join(penModes, ", "))
+		}
+		z, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, fmt.Errorf("pen depth for %s: %q is not a number", mode, value)
+		}
+		depths[mode] = z
+	}
+	return depths, nil
+}
+
+func (d PenDepths) String() string {
+	var parts []string
+	for _, mode := range penModes {
+		if z, ok := d[mode]; ok {
+			parts = append(parts, mode+"="+strconv.FormatFloat(z, 'f', -1, 64))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// CompilePenGcode compiles code to G-code like CompileGcode, lowering the
+// pen to the depth set for each render mode. The compiler lowers it the
+// same for every path, so each mode is compiled on its own and the
+// programs run in turn, in the order the code first uses each mode.
+func CompilePenGcode(ctx context.Context, code, outputName string, pos, size Vec2, depths PenDepths, log *Logger) (string, error) {
+	if len(depths) == 0 {
+		return CompileGcode(ctx, code, outputName, pos, size, log)
+	}
+	layers, err := sketchlang.Layers(code)
+	if err != nil {
+		return "", err
+	}
+	programs := make([]string, len(layers))
+	for i, l := range layers {
+		program, err := CompileGcode(ctx, l.Src, outputName, pos, size, log)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", l.Mode, err)
+		}
+		if z, ok := depths[l.Mode]; ok {
+			log.Debug("pen: %s paths down to Z%g", l.Mode, z)
+			program = gcode.PenDown(program, z)
+		}
+		programs[i] = program
+	}
+	return gcode.Join(programs...), nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PhaseSheet lays the versions of a sketch out side by side, in order,
+// each on its own canvas with its name lettered underneath, to compare
+// what each phase of generating it changed. It is drawn in paths alone,
+// in the same mm as the sketches, so it exports like a compiled sketch.
+func PhaseSheet(versions []SheetEntry) (string, error) {
+	if len(versions) == 0 {
+		return "", fmt.Errorf("no versions to compare")
+	}
+	viewBox, _ := splitSVG(versions[0].SVG)
+	v := parseNumbers(viewBox)
+	if len(v) != 4 {
+		return "", fmt.Errorf("viewBox %q is not four numbers", viewBox)
+	}
+	canvas := Vec2{v[2], v[3]}
+	gap := math.Max(canvas.X, canvas.Y) / 10
+	label := math.Max(canvas.Y/16, 2)
+	width := float64(len(versions))*(canvas.X+gap) + gap
+	height := canvas.Y + 2*gap + 2*label
+	pen := strokeWidth(versions[0].SVG)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%gmm" height="%gmm" viewBox="0 0 %g %g">`+"\n", width, height, width, height)
+	fmt.Fprintf(&b, `  <rect width="100%%" height="100%%" fill="white"/>`+"\n")
+	path := func(p Polyline, width float64) {
+		if len(p) == 1 {
+			p = Polyline{p[0], p[0]}
+		}
+		fmt.Fprintf(&b, `  <path d="%s" fill="none" stroke="black" stroke-width="%g" stroke-linecap="round"/>`+"\n", pathD(p), width)
+	}
+	for i, version := range versions {
+		paths, err := ParseSVGPaths(version.SVG)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", version.Title, err)
+		}
+		viewBox, _ := splitSVG(version.SVG)
+		v := parseNumbers(viewBox)
+		if len(v) != 4 {
+			return "", fmt.Errorf("%s: viewBox %q is not four numbers", version.Title, viewBox)
+		}
+		at := Vec2{gap + float64(i)*(canvas.X+gap), gap}
+		for _, p := range paths {
+			moved := make(Polyline, len(p))
+			for j, pt := range p {
+				moved[j] = Vec2{pt.X - v[0] + at.X, pt.Y - v[1] + at.Y}
+			}
+			path(moved, pen)
+		}
+		// The edge of the canvas, so blank space shows.
+		path(Polyline{at, {at.X + canvas.X, at.Y}, {at.X + canvas.X, at.Y + canvas.Y}, {at.X, at.Y + canvas.Y}, at}, pen/3)
+		for _, line := range letterLines(version.Title, Vec2{at.X + canvas.X/2, at.Y + canvas.Y + gap/2}, label) {
+			path(line, pen)
+		}
+	}
+	b.WriteString("</svg>\n")
+	return b.String(), nil
+}
+
+// letterLines letters text in strokeFont as polylines, centred on top,
+// with capitals height tall.
+func letterLines(text string, top Vec2, height float64) []Polyline {
+	scale := height / glyphHeight
+	runes := []rune(strings.ToUpper(text))
+	left := top.X - (float64(len(runes))*glyphAdvance-(glyphAdvance-4))*scale/2
+	var lines []Polyline
+	for i, r := range runes {
+		for _, glyph := range glyphLines(r) {
+			line := make(Polyline, len(glyph))
+			for j, p := range glyph {
+				line[j] = Vec2{left + (float64(i)*glyphAdvance+p.X)*scale, top.Y + p.Y*scale}
+			}
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// writePhases saves the phase sheet of a sketch as <outName>_phases.svg
+// and .png, and prints where.
+func writePhases(outName string, versions []SheetEntry) bool {
+	sheet, err := PhaseSheet(versions)
+	if err == nil {
+		err = os.WriteFile(outName+"_phases.svg", []byte(sheet), 0644)
+	}
+	if err != nil {
+		printf("warning: %s: phase sheet: %v", outName, err)
+		return false
+	}
+	abs, _ := filepath.Abs(outName + "_phases.svg")
+	fmt.Println(abs)
+	return exportSVG(outName+"_phases", sheet, []Exporter{exporters["png"]})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+const previewPage = `<!DOCTYPE html>
+<html><head><title>sketch-studio preview</title>
+<style>body{font-family:sans-serif;margin:1em}#svg svg{max-width:95vw;max-height:85vh;border:1px solid #ccc}</style>
+</head><body>
+<p id="status">waiting...</p><div id="svg"></div>
+<script>
+let version = -1;
+async function poll() {
+  try {
+    const r = await (await fetch("/latest.json")).json();
+    document.getElementById("status").textContent = r.status;
+    if (r.version !== version) {
+      version = r.version;
+      document.getElementById("svg").innerHTML = r.svg;
+    }
+  } catch (e) {
+    document.getElementById("status").textContent = "studio stopped";
+  }
+  setTimeout(poll, 1000);
+}
+poll();
+</script>
+</body></html>`
+
+// previewServer serves the most recently compiled SVG on a page that polls
+// for updates. A nil server ignores updates.
+type previewServer struct {
+	mu      sync.Mutex
+	status  string
+	svg     string
+	version int
+}
+
+func startPreview(addr string) (*previewServer, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &previewServer{status: "waiting..."}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, previewPage)
+	})
+	mux.HandleFunc("/latest.json", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"status": p.status, "svg": p.svg, "version": p.version})
+	})
+	go http.Serve(ln, mux)
+
+	printf("preview at http://%s/", ln.Addr())
+	return p, nil
+}
+
+func (p *previewServer) setStatus(status string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.status = status
+}
+
+func (p *previewServer) show(status, svg string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.status = status
+	p.svg = svg
+	p.version++
+}
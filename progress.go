@@ -0,0 +1,41 @@
+package main
+
+import "context"
+
+// ProgressClient streams every request so callers of the plain Complete
+// still see progress. report gets the characters received so far, and a
+// final call with done set once the request ends; an error from report
+// aborts the request.
+type ProgressClient struct {
+	inner  LLMClient
+	report func(chars int, done bool) error
+}
+
+func NewProgressClient(inner LLMClient, report func(chars int, done bool) error) *ProgressClient {
+	return &ProgressClient{inner: inner, report: report}
+}
+
+func (p *ProgressClient) Model() string {
+	return p.inner.Model()
+}
+
+func (p *ProgressClient) CountTokens(ctx context.Context, system string, messages []Message) (int, error) {
+	return CountTokens(ctx, p.inner, system, messages)
+}
+
+func (p *ProgressClient) Complete(ctx context.Context, system string, messages []Message, opts RequestOptions) (Response, error) {
+	return p.StreamComplete(ctx, system, messages, opts, func(string) error { return nil })
+}
+
+func (p *ProgressClient) StreamComplete(ctx context.Context, system string, messages []Message, opts RequestOptions, onText func(string) error) (Response, error) {
+	chars := 0
+	resp, err := p.inner.StreamComplete(ctx, system, messages, opts, func(text string) error {
+		chars += len(text)
+		if err := p.report(chars, false); err != nil {
+			return err
+		}
+		return onText(text)
+	})
+	p.report(chars, true)
+	return resp, err
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// PromptData is what the prompt templates can refer to. The system
+// template is rendered once per run with Spec, Format and Style; the
+// request template gets Description, Canvas and Margin.
+type PromptData struct {
+	Spec        string  // the SketchLang spec
+	Format      string  // how to lay out the reply: tagged text or JSON
+	Style       string  // the -style instructions, "" for none
+	Description string  // the request, with any reference or constraint text
+	Canvas      Vec2    // size of the sketch in mm
+	Margin      float64 // border to leave blank on every side, in mm
+}
+
+// Limit is the far corner of the area to draw in: the canvas less its
+// margin. The near corner is (Margin, Margin).
+func (d PromptData) Limit() Vec2 {
+	return Vec2{d.Canvas.X - d.Margin, d.Canvas.Y - d.Margin}
+}
+
+// The built-in templates. "sketchstudio prompts <dir>" writes them out as
+// a starting point for -prompts.
+const (
+	defaultSystemTemplate = `You are an expert sketch artist using SketchLang.
+
+{{.Spec}}
+
+Create a COMPLETE, EXTREMELY DETAILED sketch.
+
+{{.Format}}
+
+REQUIREMENTS:
+- Complete sketch with full detail
+- Meaningful anchor point names
+- Vector math: let pos : vec = (center of shape) + (offset_x, offset_y)
+- Use "center of" for derived positions
+- NO dot notation (vec.x is invalid)
+- NO variable reassignment
+- NO for loops or while loops
+- trace = precise lines, draw = organic, scribble = textured
+- Use dashes for shading
+- Types: number, vec, sketch
+{{- if .Style}}
+
+{{.Style}}
+{{- end}}`
+
+	defaultRequestTemplate = `{{.Description}}
+{{- if and .Canvas.X .Canvas.Y}}
+
+CANVAS: {{.Canvas.X}} x {{.Canvas.Y}} mm. Keep every point between ({{.Margin}}, {{.Margin}}) and ({{.Limit.X}}, {{.Limit.Y}}).
+{{- end}}`
+)
+
+const (
+	textFormat = `FORMAT:
+<title>SKETCH TITLE</title>
+<summary>Description of the sketch.</summary>
+<code>
+# Complete SketchLang code
+</code>`
+
+	jsonFormat = `FORMAT: a JSON object with "title" (the sketch title), "summary" (a
+description of the sketch) and "code" (the complete SketchLang code).`
+)
+
+// promptFiles maps each template to its file name in a -prompts directory.
+var promptFiles = []struct {
+	name, text string
+}{
+	{"system.tmpl", defaultSystemTemplate},
+	{"request.tmpl", defaultRequestTemplate},
+}
+
+// Prompts renders the system prompt and each request from templates.
+type Prompts struct {
+	system  *template.Template
+	request *template.Template
+}
+
+// LoadPrompts reads system.tmpl and request.tmpl from dir, using the
+// built-in template for any file that is missing. An empty dir uses only
+// the built-in templates.
+func LoadPrompts(dir string) (*Prompts, error) {
+	var tmpls []*template.Template
+	for _, f := range promptFiles {
+		text := f.text
+		if dir != "" {
+			data, err := os.ReadFile(filepath.Join(dir, f.name))
+			if err == nil {
+				text = string(data)
+			} else if !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+		tmpl, err := template.New(f.name).Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		tmpls = append(tmpls, tmpl)
+	}
+	return &Prompts{system: tmpls[0], request: tmpls[1]}, nil
+}
+
+// WritePrompts writes the built-in templates to dir for editing, leaving
+// existing files alone. It returns the paths it wrote.
+func WritePrompts(dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	var written []string
+	for _, f := range promptFiles {
+		path := filepath.Join(dir, f.name)
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		if err := os.WriteFile(path, []byte(f.text+"\n"), 0644); err != nil {
+			return written, err
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
+
+// System renders the system prompt.
+func (p *Prompts) System(data PromptData) (string, error) {
+	return render(p.system, data)
+}
+
+// Request renders the first message of a sketch request.
+func (p *Prompts) Request(data PromptData) (string, error) {
+	return render(p.request, data)
+}
+
+func render(tmpl *template.Template, data PromptData) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("prompt template: %w", err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// StyleText is the instructions of styles as given to the system template.
+func StyleText(styles ...Style) string {
+	return strings.TrimSpace(WithStyle("", styles...))
+}
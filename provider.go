@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ProviderFactory creates a client for one LLM provider, reading any
+// credentials it needs from the environment.
+type ProviderFactory func(opts RequestOptions, log *Logger) (LLMClient, error)
+
+var providers = map[string]ProviderFactory{}
+
+// RegisterProvider makes a provider selectable with -provider.
+func RegisterProvider(name string, factory ProviderFactory) {
+	providers[name] = factory
+}
+
+// NewProvider creates the named provider's client.
+func NewProvider(name string, opts RequestOptions, log *Logger) (LLMClient, error) {
+	factory, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q (want %s)", name, strings.Join(ProviderNames(), ", "))
+	}
+	return factory(opts, log)
+}
+
+// ProviderNames lists the registered providers, sorted.
+func ProviderNames() []string {
+	var names []string
+	for name := range providers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func init() {
+	RegisterProvider("anthropic", func(opts RequestOptions, log *Logger) (LLMClient, error) {
+		key, err := apiKey("ANTHROPIC_API_KEY")
+		if err != nil {
+			return nil, err
+		}
+		return NewAnthropicClient(key, opts, log), nil
+	})
+	RegisterProvider("openai", func(opts RequestOptions, log *Logger) (LLMClient, error) {
+		key, err := apiKey("OPENAI_API_KEY")
+		if err != nil {
+			return nil, err
+		}
+		return NewOpenAIClient(key, log), nil
+	})
+	RegisterProvider("lmstudio", func(opts RequestOptions, log *Logger) (LLMClient, error) {
+		return NewLocalClient(log), nil
+	})
+	RegisterProvider("llamacpp", func(opts RequestOptions, log *Logger) (LLMClient, error) {
+		return NewLlamaCppClient(log), nil
+	})
+	RegisterProvider("ollama", func(opts RequestOptions, log *Logger) (LLMClient, error) {
+		return NewOllamaClient(log), nil
+	})
+	RegisterProvider("mock", func(opts RequestOptions, log *Logger) (LLMClient, error) {
+		dir := os.Getenv("SKETCH_FIXTURES")
+		if dir == "" {
+			return nil, fmt.Errorf("SKETCH_FIXTURES not set")
+		}
+		return NewMockClient(dir)
+	})
+}
+
+func apiKey(env string) (string, error) {
+	key := os.Getenv(env)
+	if key == "" {
+		return "", fmt.Errorf("%s not set", env)
+	}
+	return key, nil
+}
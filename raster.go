@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+)
+
+// RasterizePNG draws paths in black on white, scaled to fit size pixels
+// on the longer side with a small margin, and encodes the image as PNG.
+func RasterizePNG(paths []Polyline, size int) ([]byte, error) {
+	stats := ComputeStats(paths)
+	w, h := stats.Max.X-stats.Min.X, stats.Max.Y-stats.Min.Y
+	margin := float64(size) / 20
+	scale := (float64(size) - 2*margin) / math.Max(math.Max(w, h), 1e-9)
+
+	img := image.NewGray(image.Rect(0, 0, int(w*scale+2*margin)+1, int(h*scale+2*margin)+1))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	toPixel := func(p Vec2) Vec2 {
+		return Vec2{(p.X-stats.Min.X)*scale + margin, (p.Y-stats.Min.Y)*scale + margin}
+	}
+	for _, p := range paths {
+		for i := 1; i < len(p); i++ {
+			drawLine(img, toPixel(p[i-1]), toPixel(p[i]))
+		}
+		if len(p) == 1 {
+			drawLine(img, toPixel(p[0]), toPixel(p[0]))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// thumbnailSize is the longer side of a thumbnail, in pixels.
+const thumbnailSize = 400
+
+// thumbnail draws a compiled SVG's whole page as a PNG thumbnailSize
+// pixels on its longer side, for list views, where drawing every full
+// SVG would be slow.
+var thumbnail = Exporter{Ext: ".thumb.png", Export: func(paths []Polyline, page Page) ([]byte, error) {
+	opts := RasterOptions{
+		DPI:      thumbnailSize / math.Max(page.Size.X, page.Size.Y) * 25.4,
+		Textured: rasterOptions.Textured,
+	}
+	img, err := RasterizePage(paths, page, opts)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = png.Encode(&buf, img)
+	return buf.Bytes(), err
+}}
+
+// RasterOptions set how RasterizePage draws a page.
+type RasterOptions struct {
+	DPI      float64 // pixels per inch, taking SVG units as mm
+	Textured bool    // draw on grained off-white paper instead of white
+}
+
+// rasterOptions are the settings of -dpi and -textured, for the png and jpeg
+// exports.
+var rasterOptions = RasterOptions{DPI: 96}
+
+// maxRasterPixels keeps a high -dpi on a large canvas from using
+// gigabytes.
+const maxRasterPixels = 100_000_000
+
+// addRasterFlags registers -dpi and -textured on fs.
+func addRasterFlags(fs *flag.FlagSet) {
+	fs.Float64Var(&rasterOptions.DPI, "dpi", rasterOptions.DPI, "resolution of png and jpeg exports, taking SVG units as mm")
+	fs.BoolVar(&rasterOptions.Textured, "textured", rasterOptions.Textured, "draw png and jpeg exports on textured paper instead of white")
+}
+
+var (
+	inkColor   = color.RGBA{24, 24, 28, 0xff}
+	paperColor = color.RGBA{246, 241, 229, 0xff}
+)
+
+// RasterizePage draws paths on the whole of page at opts.DPI, with lines
+// as wide as the page's pen, smoothed at their edges.
+func RasterizePage(paths []Polyline, page Page, opts RasterOptions) (*image.RGBA, error) {
+	if opts.DPI <= 0 {
+		return nil, fmt.Errorf("dpi: want more than 0")
+	}
+	scale := opts.DPI / 25.4
+	w, h := int(math.Ceil(page.Size.X*scale)), int(math.Ceil(page.Size.Y*scale))
+	if w <= 0 || h <= 0 || w*h > maxRasterPixels {
+		return nil, fmt.Errorf("%dx%d pixels at %g dpi: lower -dpi", w, h, opts.DPI)
+	}
+
+	// ink is how much of each pixel the pen covers, from 0 to 1.
+	ink := make([]float64, w*h)
+	r := math.Max(page.Pen*scale/2, 0.5)
+	toPixel := func(p Vec2) Vec2 {
+		return Vec2{(p.X - page.Origin.X) * scale, (p.Y - page.Origin.Y) * scale}
+	}
+	for _, p := range paths {
+		for i := 1; i < len(p); i++ {
+			inkSegment(ink, w, h, toPixel(p[i-1]), toPixel(p[i]), r)
+		}
+		if len(p) == 1 {
+			inkSegment(ink, w, h, toPixel(p[0]), toPixel(p[0]), r)
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			bg := color.RGBA{0xff, 0xff, 0xff, 0xff}
+			if opts.Textured {
+				bg = paper(x, y)
+			}
+			a := ink[y*w+x]
+			mix := func(from, to uint8) uint8 {
+				return uint8(float64(from)*(1-a) + float64(to)*a + 0.5)
+			}
+			img.SetRGBA(x, y, color.RGBA{mix(bg.R, inkColor.R), mix(bg.G, inkColor.G), mix(bg.B, inkColor.B), 0xff})
+		}
+	}
+	return img, nil
+}
+
+// inkSegment covers the pixels within r of the segment from a to b, in
+// pieces of a few pixels so a long diagonal doesn't scan a large box.
+func inkSegment(ink []float64, w, h int, a, b Vec2, r float64) {
+	const piece = 16
+	n := max(int(math.Ceil(math.Hypot(b.X-a.X, b.Y-a.Y)/piece)), 1)
+	for s := range n {
+		t0, t1 := float64(s)/float64(n), float64(s+1)/float64(n)
+		p := Vec2{a.X + (b.X-a.X)*t0, a.Y + (b.Y-a.Y)*t0}
+		q := Vec2{a.X + (b.X-a.X)*t1, a.Y + (b.Y-a.Y)*t1}
+		x0, x1 := max(int(math.Min(p.X, q.X)-r-1), 0), min(int(math.Max(p.X, q.X)+r+1), w-1)
+		y0, y1 := max(int(math.Min(p.Y, q.Y)-r-1), 0), min(int(math.Max(p.Y, q.Y)+r+1), h-1)
+		for y := y0; y <= y1; y++ {
+			for x := x0; x <= x1; x++ {
+				d := segmentDistance(Vec2{float64(x) + 0.5, float64(y) + 0.5}, p, q)
+				if c := math.Min(1, r+0.5-d); c > ink[y*w+x] {
+					ink[y*w+x] = c
+				}
+			}
+		}
+	}
+}
+
+// paper is the colour of textured paper at a pixel: a fine grain over
+// soft blotches, the same for the same pixel every time.
+func paper(x, y int) color.RGBA {
+	const blotch = 48 // pixels
+	fx, fy := float64(x)/blotch, float64(y)/blotch
+	x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+	tx, ty := fx-float64(x0), fy-float64(y0)
+	lerp := func(a, b, t float64) float64 { return a + (b-a)*t*t*(3-2*t) }
+	soft := lerp(lerp(noise(x0, y0), noise(x0+1, y0), tx), lerp(noise(x0, y0+1), noise(x0+1, y0+1), tx), ty)
+	v := 5*soft + 7*noise(x+(1<<20), y)
+	shade := func(c uint8) uint8 { return uint8(math.Max(0, math.Min(255, float64(c)+v))) }
+	return color.RGBA{shade(paperColor.R), shade(paperColor.G), shade(paperColor.B), 0xff}
+}
+
+// noise hashes a lattice point to a value from -1 to 1.
+func noise(x, y int) float64 {
+	h := uint32(x)*374761393 + uint32(y)*668265263
+	h = (h ^ h>>13) * 1274126177
+	h ^= h >> 16
+	return float64(h)/math.MaxUint32*2 - 1
+}
+
+// drawLine steps along a segment in half-pixel increments, setting each
+// pixel it crosses.
+func drawLine(img *image.Gray, a, b Vec2) {
+	steps := int(math.Ceil(2*math.Hypot(b.X-a.X, b.Y-a.Y))) + 1
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / float64(steps)
+		img.SetGray(int(a.X+(b.X-a.X)*t), int(a.Y+(b.Y-a.Y)*t), color.Gray{})
+	}
+}
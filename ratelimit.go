@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const rateLimitRetries = 5
+
+// RateLimitClient keeps requests under per-minute request and input-token
+// limits and caps how many run at once; zero disables a limit. Responses
+// the provider marks as temporary (429, 503, 529) are retried after its
+// Retry-After delay, or with exponential backoff when it gives none.
+type RateLimitClient struct {
+	inner LLMClient
+	rpm   int
+	tpm   int
+	slots chan struct{} // nil for no concurrency cap
+	log   *Logger
+
+	mu   sync.Mutex
+	sent []rateEvent // requests started in the last minute
+}
+
+type rateEvent struct {
+	at     time.Time
+	tokens int
+}
+
+func NewRateLimitClient(inner LLMClient, rpm, tpm, concurrency int, log *Logger) *RateLimitClient {
+	c := &RateLimitClient{inner: inner, rpm: rpm, tpm: tpm, log: log}
+	if concurrency > 0 {
+		c.slots = make(chan struct{}, concurrency)
+	}
+	return c
+}
+
+func (c *RateLimitClient) Model() string {
+	return c.inner.Model()
+}
+
+func (c *RateLimitClient) CountTokens(ctx context.Context, system string, messages []Message) (int, error) {
+	return CountTokens(ctx, c.inner, system, messages)
+}
+
+func (c *RateLimitClient) Complete(ctx context.Context, system string, messages []Message, opts RequestOptions) (Response, error) {
+	return c.retry(ctx, estimateTokens(system, messages), func() (Response, error) {
+		return c.inner.Complete(ctx, system, messages, opts)
+	})
+}
+
+func (c *RateLimitClient) StreamComplete(ctx context.Context, system string, messages []Message, opts RequestOptions, onText func(string) error) (Response, error) {
+	return c.retry(ctx, estimateTokens(system, messages), func() (Response, error) {
+		return c.inner.StreamComplete(ctx, system, messages, opts, onText)
+	})
+}
+
+// retry sends call within the limits, retrying temporary API errors. Those
+// arrive as the response status, before any text has streamed.
+func (c *RateLimitClient) retry(ctx context.Context, tokens int, call func() (Response, error)) (Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := c.acquire(ctx, tokens); err != nil {
+			return Response{}, err
+		}
+		resp, err := call()
+		c.release()
+
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || !apiErr.Temporary() || attempt == rateLimitRetries {
+			return resp, err
+		}
+
+		wait := apiErr.RetryAfter
+		if wait == 0 {
+			wait = time.Duration(1<<attempt) * 5 * time.Second
+		}
+		printf("warning: %s returned %d, retrying in %s", c.inner.Model(), apiErr.Status, wait)
+		if err := sleep(ctx, wait); err != nil {
+			return Response{}, err
+		}
+	}
+}
+
+// acquire waits for a concurrency slot and for room in the last minute's
+// request and token budget. A single request larger than the token limit
+// is let through once the window is empty.
+func (c *RateLimitClient) acquire(ctx context.Context, tokens int) error {
+	if c.slots != nil {
+		select {
+		case c.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	for {
+		c.mu.Lock()
+		now := time.Now()
+		for len(c.sent) > 0 && now.Sub(c.sent[0].at) >= time.Minute {
+			c.sent = c.sent[1:]
+		}
+		used := 0
+		for _, e := range c.sent {
+			used += e.tokens
+		}
+
+		if (c.rpm == 0 || len(c.sent) < c.rpm) && (c.tpm == 0 || used+tokens <= c.tpm || len(c.sent) == 0) {
+			c.sent = append(c.sent, rateEvent{at: now, tokens: tokens})
+			c.mu.Unlock()
+			return nil
+		}
+
+		wait := time.Minute - now.Sub(c.sent[0].at)
+		c.mu.Unlock()
+		c.log.Info("rate limit reached, waiting %s", wait.Round(time.Second))
+		if err := sleep(ctx, wait); err != nil {
+			c.release()
+			return err
+		}
+	}
+}
+
+func (c *RateLimitClient) release() {
+	if c.slots != nil {
+		<-c.slots
+	}
+}
+
+// estimateTokens guesses a request's input tokens at four characters per
+// token.
+func estimateTokens(system string, messages []Message) int {
+	n := len(system)
+	for _, m := range messages {
+		n += len(m.Content)
+	}
+	return n / 4
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// TranscriptEntry is one request and its outcome, as written by
+// RecordingClient.
+type TranscriptEntry struct {
+	Time       time.Time      `json:"time"`
+	Model      string         `json:"model"`
+	System     string         `json:"system"`
+	Messages   []Message      `json:"messages"`
+	Options    RequestOptions `json:"options"`
+	Response   string         `json:"response,omitempty"`
+	StopReason StopReason     `json:"stop_reason,omitempty"`
+	Thinking   string         `json:"thinking,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	DurationMS int64          `json:"duration_ms"`
+}
+
+// RecordingClient appends every request and raw response to a JSONL
+// transcript, for replaying and debugging prompts. A failed write is
+// logged and does not fail the request.
+type RecordingClient struct {
+	inner LLMClient
+	path  string
+	log   *Logger
+
+	mu sync.Mutex
+}
+
+func NewRecordingClient(inner LLMClient, path string, log *Logger) *RecordingClient {
+	return &RecordingClient{inner: inner, path: path, log: log}
+}
+
+// TranscriptPath names a new transcript in dir after the current time.
+func TranscriptPath(dir string) string {
+	return filepath.Join(dir, "transcript-"+time.Now().Format("20060102-150405")+".jsonl")
+}
+
+func (r *RecordingClient) Model() string {
+	return r.inner.Model()
+}
+
+func (r *RecordingClient) CountTokens(ctx context.Context, system string, messages []Message) (int, error) {
+	return CountTokens(ctx, r.inner, system, messages)
+}
+
+func (r *RecordingClient) Complete(ctx context.Context, system string, messages []Message, opts RequestOptions) (Response, error) {
+	start := time.Now()
+	resp, err := r.inner.Complete(ctx, system, messages, opts)
+	r.record(start, system, messages, opts, resp, err)
+	return resp, err
+}
+
+func (r *RecordingClient) StreamComplete(ctx context.Context, system string, messages []Message, opts RequestOptions, onText func(string) error) (Response, error) {
+	start := time.Now()
+	resp, err := r.inner.StreamComplete(ctx, system, messages, opts, onText)
+	r.record(start, system, messages, opts, resp, err)
+	return resp, err
+}
+
+func (r *RecordingClient) record(start time.Time, system string, messages []Message, opts RequestOptions, resp Response, err error) {
+	e := TranscriptEntry{
+		Time:       start,
+		Model:      r.inner.Model(),
+		System:     system,
+		Messages:   messages,
+		Options:    opts,
+		Response:   resp.Text,
+		StopReason: resp.StopReason,
+		Thinking:   resp.Thinking,
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	data, err := json.Marshal(e)
+	if err == nil {
+		r.mu.Lock()
+		err = appendLine(r.path, data)
+		r.mu.Unlock()
+	}
+	if err != nil {
+		r.log.Warn("transcript: %v", err)
+	}
+}
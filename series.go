@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// seriesContext is how many earlier sketches of a series go into the
+// prompt, and seriesCodeLines how much of the latest one's code.
+const (
+	seriesContext   = 5
+	seriesCodeLines = 60
+)
+
+// SeriesEntry remembers one sketch of a series, so later sketches can
+// match it.
+type SeriesEntry struct {
+	Time    time.Time `json:"time"`
+	Prompt  string    `json:"prompt"`
+	Title   string    `json:"title"`
+	Summary string    `json:"summary,omitempty"`
+	Style   string    `json:"style,omitempty"`
+	Code    string    `json:"code"`
+}
+
+// SeriesPath is the per-user portfolio file of the named series.
+func SeriesPath(name string) string {
+	return filepath.Join(CacheDir("series"), sanitize(name)+".jsonl")
+}
+
+// LoadSeries reads a series, oldest first. A missing file is an empty
+// series.
+func LoadSeries(path string) ([]SeriesEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []SeriesEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e SeriesEntry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// RecordSeries appends e to the series at path.
+func RecordSeries(path string, e SeriesEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return appendLine(path, data)
+}
+
+// SeriesPrompt describes the latest entries of a series for the system
+// prompt, with the code of the newest as a reference for its style.
+func SeriesPrompt(entries []SeriesEntry) string {
+	if len(entries) == 0 {
+		return ""
+	}
+	entries = entries[max(len(entries)-seriesContext, 0):]
+
+	var b strings.Builder
+	b.WriteString("\n\nSERIES: this sketch belongs to a series. Match the earlier sketches in style, level of detail, line work and composition:\n")
+	for _, e := range entries {
+		fmt.Fprintf(&b, "- %q (%s)", e.Title, e.Time.Format(time.DateOnly))
+		if e.Summary != "" {
+			fmt.Fprintf(&b, ": %s", e.Summary)
+		}
+		if e.Style != "" {
+			fmt.Fprintf(&b, " [style: %s]", e.Style)
+		}
+		b.WriteString("\n")
+	}
+
+	latest := entries[len(entries)-1]
+	lines := strings.Split(latest.Code, "\n")
+	if len(lines) > seriesCodeLines {
+		lines = append(lines[:seriesCodeLines], "# ...")
+	}
+	fmt.Fprintf(&b, "\nCode of %q, for reference (do not copy it):\n<reference>\n%s\n</reference>", latest.Title, strings.Join(lines, "\n"))
+	return b.String()
+}
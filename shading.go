@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"sketch-studio/tools/fills"
+	"sketch-studio/tools/sketchlang"
+)
+
+// Defaults of a fill directive that gives no spacing or angle.
+const (
+	fillSpacing = 2.0 // mm
+	fillAngle   = 45  // degrees
+)
+
+// fillDirective matches a comment asking for a region to be shaded:
+// "# fill NAME with STYLE", then optionally "spacing MM" and "angle DEG".
+var fillDirective = regexp.MustCompile(`(?m)^[ \t]*#[ \t]*fill[ \t]+([A-Za-z_]\w*)[ \t]+with[ \t]+(\w+)((?:[ \t]+(?:spacing|angle)[ \t]+-?[\d.]+)*)[ \t]*$`)
+
+var fillOption = regexp.MustCompile(`(spacing|angle)[ \t]+(-?[\d.]+)`)
+
+// FillPrompt tells the model it can leave shading to fill directives,
+// for -fills.
+const FillPrompt = `
+
+FILLS: do not place hatching or stippling stroke by stroke. Ask for it
+with a comment on a line of its own, and it is drawn for you:
+# fill NAME with hatch|crosshatch|stipple [spacing MM] [angle DEGREES]
+NAME is a sketch declared with let whose strokes join end to end into a
+closed outline, e.g. let roof : sketch = [stroke from a to b, stroke from
+b to c, stroke from c to a]. hatch is parallel lines SPACING mm apart
+(default 2) at ANGLE degrees (default 45); crosshatch adds a second set
+at right angles; stipple is dots about SPACING mm apart. Closer spacing
+is darker.`
+
+// ExpandFills replaces each fill directive in code with the strokes or
+// dots that shade its region, after a comment saying what they are. A
+// directive that can't be filled is left as it is, and returned as an
+// error with the others.
+func ExpandFills(code string) (string, []error) {
+	var errs []error
+	expanded := fillDirective.ReplaceAllStringFunc(code, func(line string) string {
+		shading, err := fillCode(code, fillDirective.FindStringSubmatch(line))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", strings.TrimSpace(line), err))
+			return line
+		}
+		return shading
+	})
+	return expanded, errs
+}
+
+// fillCode shades the region named by a match of fillDirective in code.
+func fillCode(code string, m []string) (string, error) {
+	name := m[1]
+	f := fills.Fill{Style: m[2], Spacing: fillSpacing, Angle: fillAngle}
+	for _, opt := range fillOption.FindAllStringSubmatch(m[3], -1) {
+		v, err := strconv.ParseFloat(opt[2], 64)
+		if err != nil {
+			return "", fmt.Errorf("%s %q is not a number", opt[1], opt[2])
+		}
+		if opt[1] == "spacing" {
+			f.Spacing = v
+		} else {
+			f.Angle = v
+		}
+	}
+	// Each region's dots fall the same way every run.
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	f.Seed = int64(h.Sum64())
+
+	strokes, err := sketchlang.Shape(code, name)
+	if err != nil {
+		return "", err
+	}
+	outline, err := fills.Outline(strokes)
+	if err != nil {
+		return "", err
+	}
+	shading, err := f.Code(outline)
+	if err != nil {
+		return "", err
+	}
+	what := fmt.Sprintf("%s, %g mm apart", f.Style, f.Spacing)
+	if f.Style != fills.Stipple {
+		what += fmt.Sprintf(" at %g degrees", f.Angle)
+	}
+	return fmt.Sprintf("# %s: %s\n%s", name, what, shading), nil
+}
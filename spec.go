@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var versionPattern = regexp.MustCompile(`\d+(\.\d+)*`)
+
+// SpecProfile is a SketchLang spec written against one compiler version.
+type SpecProfile struct {
+	Version string
+	Spec    string
+
+	// Diagnostics parse the compiler's error messages, from a .diag file
+	// with the same version. nil uses defaultDiagnosticPatterns.
+	Diagnostics []*regexp.Regexp
+}
+
+// SpecFeature is an optional language feature: Pattern matches the spec
+// and prompt text that documents it, Probe is a minimal program using it.
+type SpecFeature struct {
+	Key     string
+	Name    string
+	Pattern *regexp.Regexp
+	Probe   string
+}
+
+var specFeatures = []SpecFeature{
+	{
+		Key:     "via",
+		Name:    "via splines",
+		Pattern: regexp.MustCompile(`(?i)\bvia\b`),
+		Probe:   "trace stroke from (0, 0) to (10, 0) via [(5, 5)]",
+	},
+	{
+		Key:     "flow",
+		Name:    "flow field",
+		Pattern: regexp.MustCompile(`(?i)\bflow\b`),
+		Probe:   "let f : vec = flow at (5, 5)\ntrace dash at f",
+	},
+	{
+		Key:     "center",
+		Name:    "center of",
+		Pattern: regexp.MustCompile(`(?i)\bcenter of\b`),
+		Probe:   "let s : sketch = stroke from (0, 0) to (10, 10)\ntrace stroke from origin to center of s",
+	},
+}
+
+// LookupFeatures resolves comma-separated feature keys (via, flow, center).
+func LookupFeatures(keys string) ([]SpecFeature, error) {
+	var features []SpecFeature
+	for _, key := range strings.Split(keys, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		i := slices.IndexFunc(specFeatures, func(f SpecFeature) bool { return f.Key == key })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown feature %q (want via, flow or center)", key)
+		}
+		features = append(features, specFeatures[i])
+	}
+	return features, nil
+}
+
+// StripFeatures removes the parts of a spec or prompt that document the
+// given features: whole "###" example paragraphs that use one, otherwise
+// just the mentioning lines. A closing rule forbids the features outright.
+func StripFeatures(text string, features []SpecFeature) string {
+	if len(features) == 0 {
+		return text
+	}
+
+	var kept, names []string
+	for _, para := range strings.Split(text, "\n\n") {
+		if strings.HasPrefix(para, "###") && mentionsAny(para, features) {
+			continue
+		}
+		var lines []string
+		for _, line := range strings.Split(para, "\n") {
+			if !mentionsAny(line, features) {
+				lines = append(lines, line)
+			}
+		}
+		if len(lines) > 0 {
+			kept = append(kept, strings.Join(lines, "\n"))
+		}
+	}
+	for _, f := range features {
+		names = append(names, f.Name)
+	}
+
+	return strings.Join(kept, "\n\n") + fmt.Sprintf("\n- NOT supported by this compiler, never use: %s", strings.Join(names, ", "))
+}
+
+func mentionsAny(text string, features []SpecFeature) bool {
+	for _, f := range features {
+		if f.Pattern.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadSpecProfiles reads every file in dir whose name carries a version
+// tag, e.g. sketchlang-0.3.md. A .diag file, e.g. sketchlang-0.3.diag,
+// holds the diagnostic patterns for its version; one without a spec of
+// the same version is a profile with no Spec.
+func LoadSpecProfiles(dir string) ([]SpecProfile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []SpecProfile
+	diagnostics := map[string][]*regexp.Regexp{}
+	for _, e := range entries {
+		version := versionPattern.FindString(e.Name())
+		if e.IsDir() || version == "" {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if filepath.Ext(e.Name()) == ".diag" {
+			patterns, err := LoadDiagnosticPatterns(path)
+			if err != nil {
+				return nil, err
+			}
+			diagnostics[version] = patterns
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, SpecProfile{Version: version, Spec: string(data)})
+	}
+
+	for version, patterns := range diagnostics {
+		i := slices.IndexFunc(profiles, func(p SpecProfile) bool { return p.Version == version })
+		if i < 0 {
+			profiles = append(profiles, SpecProfile{Version: version})
+			i = len(profiles) - 1
+		}
+		profiles[i].Diagnostics = patterns
+	}
+	return profiles, nil
+}
+
+// SelectSpec picks the newest spec not newer than the compiler, and
+// separately the newest diagnostic patterns. With no compiler version it
+// picks the newest; with no match at all it falls back to the built-in
+// LangSpec and no patterns.
+func SelectSpec(profiles []SpecProfile, compilerVersion string) SpecProfile {
+	best := SpecProfile{Spec: LangSpec}
+	diagVersion := ""
+	for _, p := range profiles {
+		if compilerVersion != "" && compareVersions(p.Version, compilerVersion) > 0 {
+			continue
+		}
+		if p.Spec != "" && (best.Version == "" || compareVersions(p.Version, best.Version) > 0) {
+			best.Version, best.Spec = p.Version, p.Spec
+		}
+		if p.Diagnostics != nil && (diagVersion == "" || compareVersions(p.Version, diagVersion) > 0) {
+			diagVersion, best.Diagnostics = p.Version, p.Diagnostics
+		}
+	}
+	return best
+}
+
+// UnsupportedFeatures returns the features documented in spec whose probe
+// programs the installed compiler rejects.
+func UnsupportedFeatures(spec string, log *Logger) []SpecFeature {
+	var unsupported []SpecFeature
+	for _, f := range specFeatures {
+		if !f.Pattern.MatchString(spec) {
+			continue
+		}
+		if ok, errors := Validate(context.Background(), f.Probe, log); !ok {
+			log.Debug("probe %q failed: %v", f.Name, errors)
+			unsupported = append(unsupported, f)
+		}
+	}
+	return unsupported
+}
+
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			return x - y
+		}
+	}
+	return 0
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Stats summarizes compiled geometry.
+type Stats struct {
+	Paths  int
+	Points int
+	Length float64 // total pen-down length, in SVG units
+	Travel float64 // pen-up length from the end of each path to the next
+	Min    Vec2
+	Max    Vec2
+
+	// Coverage is the fraction of a coverageGrid x coverageGrid grid over
+	// the bounds that has ink in it.
+	Coverage float64
+	// Heat is the pen-down length in each cell of the same grid, by row.
+	Heat [coverageGrid][coverageGrid]float64
+
+	// Primitives counts what the code draws; it is only set by
+	// SketchStats.
+	Primitives Primitives
+}
+
+const coverageGrid = 10
+
+// Plotter speeds for PlotTime, taking SVG units as mm.
+const (
+	plotDrawSpeed   = 25.0 // mm/s with the pen down
+	plotTravelSpeed = 75.0 // mm/s with the pen up
+	plotPenLift     = 300 * time.Millisecond
+)
+
+// ComputeStats measures the given paths.
+func ComputeStats(paths []Polyline) Stats {
+	s := Stats{Min: Vec2{math.Inf(1), math.Inf(1)}, Max: Vec2{math.Inf(-1), math.Inf(-1)}}
+	for n, p := range paths {
+		s.Paths++
+		if n > 0 && len(p) > 0 && len(paths[n-1]) > 0 {
+			prev := paths[n-1][len(paths[n-1])-1]
+			s.Travel += math.Hypot(p[0].X-prev.X, p[0].Y-prev.Y)
+		}
+		for i, pt := range p {
+			s.Points++
+			s.Min = Vec2{math.Min(s.Min.X, pt.X), math.Min(s.Min.Y, pt.Y)}
+			s.Max = Vec2{math.Max(s.Max.X, pt.X), math.Max(s.Max.Y, pt.Y)}
+			if i > 0 {
+				s.Length += math.Hypot(pt.X-p[i-1].X, pt.Y-p[i-1].Y)
+			}
+		}
+	}
+	if s.Points == 0 {
+		s.Min, s.Max = Vec2{}, Vec2{}
+		return s
+	}
+
+	var inked [coverageGrid][coverageGrid]bool
+	w, h := math.Max(s.Max.X-s.Min.X, 1e-9), math.Max(s.Max.Y-s.Min.Y, 1e-9)
+	cell := func(p Vec2, length float64) {
+		x := min(int((p.X-s.Min.X)/w*coverageGrid), coverageGrid-1)
+		y := min(int((p.Y-s.Min.Y)/h*coverageGrid), coverageGrid-1)
+		inked[y][x] = true
+		s.Heat[y][x] += length
+	}
+	for _, p := range paths {
+		for i, pt := range p {
+			if i == 0 {
+				cell(pt, 0)
+				continue
+			}
+			// Sample long segments so the cells they cross count too.
+			prev := p[i-1]
+			steps := max(int(math.Max(math.Abs(pt.X-prev.X)/w, math.Abs(pt.Y-prev.Y)/h)*coverageGrid*2), 1)
+			length := math.Hypot(pt.X-prev.X, pt.Y-prev.Y) / float64(steps)
+			for j := 1; j <= steps; j++ {
+				t := float64(j) / float64(steps)
+				cell(Vec2{prev.X + (pt.X-prev.X)*t, prev.Y + (pt.Y-prev.Y)*t}, length)
+			}
+		}
+	}
+	n := 0
+	for _, row := range inked {
+		for _, c := range row {
+			if c {
+				n++
+			}
+		}
+	}
+	s.Coverage = float64(n) / (coverageGrid * coverageGrid)
+	return s
+}
+
+// SketchStats measures a compiled sketch: the paths of svg and the
+// primitives of code.
+func SketchStats(code, svg string) (Stats, error) {
+	paths, err := ParseSVGPaths(svg)
+	if err != nil {
+		return Stats{}, err
+	}
+	s := ComputeStats(paths)
+	s.Primitives = CountPrimitives(code)
+	return s, nil
+}
+
+// PlotTime estimates how long a pen plotter takes to draw the paths:
+// drawing, travelling between them, and lowering and raising the pen for
+// each.
+func (s Stats) PlotTime() time.Duration {
+	secs := s.Length/plotDrawSpeed + s.Travel/plotTravelSpeed
+	return time.Duration(secs*float64(time.Second)) + time.Duration(s.Paths)*plotPenLift
+}
+
+func (s Stats) String() string {
+	var counts string
+	if p := s.Primitives; p.Total() > 0 {
+		counts = fmt.Sprintf("%d strokes, %d dots, %d dashes, ", p.Strokes, p.Dots, p.Dashes)
+	}
+	return fmt.Sprintf("%s%d paths, %d points, pen-down length %.0f, pen-up %.0f, plot time %s, bounds (%.1f, %.1f)-(%.1f, %.1f), %.0f%% of the bounds inked",
+		counts, s.Paths, s.Points, s.Length, s.Travel, s.PlotTime().Round(time.Second), s.Min.X, s.Min.Y, s.Max.X, s.Max.Y, s.Coverage*100)
+}
+
+// heatShades go from no ink to the most ink in any cell.
+const heatShades = " .:-=+*#%@"
+
+// Heatmap draws Heat as a grid of characters, darker for more ink, two
+// per cell so it keeps its shape in a terminal.
+func (s Stats) Heatmap() string {
+	most := 0.0
+	for _, row := range s.Heat {
+		for _, v := range row {
+			most = math.Max(most, v)
+		}
+	}
+	var b strings.Builder
+	for _, row := range s.Heat {
+		for _, v := range row {
+			shade := heatShades[0]
+			if v > 0 {
+				shade = heatShades[1+int(v/most*float64(len(heatShades)-2))]
+			}
+			b.WriteByte(shade)
+			b.WriteByte(shade)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// JSONError reports a response that was not a JSON object matching the
+// requested schema. Text is the response, for re-asking.
+type JSONError struct {
+	Text string
+	Err  error
+}
+
+func (e *JSONError) Error() string {
+	return "invalid JSON response: " + e.Err.Error()
+}
+
+func (e *JSONError) Unwrap() error { return e.Err }
+
+// CompleteJSON asks for a single JSON object matching schema and decodes
+// it into out. Providers with a native JSON mode get the schema through
+// RequestOptions; every provider also gets it in the system prompt.
+// Output that does not parse or match the schema is a *JSONError.
+func CompleteJSON(ctx context.Context, client LLMClient, system string, messages []Message, opts RequestOptions, schema map[string]any, out any) error {
+	schemaText, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode schema: %w", err)
+	}
+	system += "\n\nReply with a single JSON object matching this JSON schema, and nothing else:\n" + string(schemaText)
+	opts.JSONSchema = schema
+
+	text, err := complete(ctx, client, system, messages, opts)
+	if err != nil {
+		return err
+	}
+
+	raw := jsonObject(text)
+	var value any
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return &JSONError{Text: text, Err: err}
+	}
+	if err := checkSchema(schema, value, "$"); err != nil {
+		return &JSONError{Text: text, Err: err}
+	}
+	if err := json.Unmarshal([]byte(raw), out); err != nil {
+		return &JSONError{Text: text, Err: err}
+	}
+	return nil
+}
+
+// jsonObject cuts the outermost object out of text, dropping code fences
+// and any prose around it.
+func jsonObject(text string) string {
+	start := strings.Index(text, "{")
+	end := strings.LastIndex(text, "}")
+	if start < 0 || end < start {
+		return text
+	}
+	return text[start : end+1]
+}
+
+// checkSchema validates v against the subset of JSON Schema the studio
+// uses: type, properties, required, items and minLength.
+func checkSchema(schema map[string]any, v any, path string) error {
+	switch schema["type"] {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: want an object", path)
+		}
+		required, _ := schema["required"].([]string)
+		for _, name := range required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s: missing %q", path, name)
+			}
+		}
+		props, _ := schema["properties"].(map[string]any)
+		for name, sub := range props {
+			if field, ok := obj[name]; ok {
+				if err := checkSchema(sub.(map[string]any), field, path+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%s: want an array", path)
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range arr {
+				if err := checkSchema(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: want a string", path)
+		}
+		if min, ok := schema["minLength"].(int); ok && len(strings.TrimSpace(s)) < min {
+			if min == 1 {
+				return fmt.Errorf("%s: must not be empty", path)
+			}
+			return fmt.Errorf("%s: want at least %d characters", path, min)
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return fmt.Errorf("%s: want a number", path)
+		}
+	case "integer":
+		if n, ok := v.(float64); !ok || n != float64(int64(n)) {
+			return fmt.Errorf("%s: want an integer", path)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: want a boolean", path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Style is a named set of drawing instructions added to the system prompt.
+type Style struct {
+	Name         string
+	Instructions string // one instruction per line
+}
+
+var styles = map[string]Style{}
+
+// RegisterStyle makes a style selectable with -style.
+func RegisterStyle(s Style) {
+	styles[s.Name] = s
+}
+
+// LookupStyles resolves a comma-separated list of style names.
+func LookupStyles(list string) ([]Style, error) {
+	var out []Style
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		s, ok := styles[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown style %q (want %s)", name, strings.Join(StyleNames(), ", "))
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// StyleNames lists the registered styles, sorted.
+func StyleNames() []string {
+	var names []string
+	for name := range styles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// WithStyle appends the instructions of each style to a system prompt.
+// They take precedence over the general requirements.
+func WithStyle(system string, styles ...Style) string {
+	for _, s := range styles {
+		system += fmt.Sprintf("\n\nSTYLE: %s (overrides the requirements above where they conflict)\n%s", s.Name, s.Instructions)
+	}
+	return system
+}
+
+func init() {
+	RegisterStyle(Style{
+		Name: "hatching",
+		Instructions: `- Build all tone from groups of short parallel strokes, evenly spaced
+- Cross-hatch (a second group at about 45 degrees) for the darkest areas
+- Keep outlines in trace; hatch strokes in draw
+- Leave highlights completely empty`,
+	})
+	RegisterStyle(Style{
+		Name: "stippling",
+		Instructions: `- Build all tone from dots: dense clusters for shadows, sparse for midtones
+- Use very few strokes, only for essential outlines, in trace
+- Never shade with strokes or dashes
+- Leave highlights completely empty`,
+	})
+	RegisterStyle(Style{
+		Name: "blind-contour",
+		Instructions: `- Draw the subject as a handful of long continuous strokes with many via points
+- Follow edges and folds without lifting the pen; overlaps and distortions are welcome
+- No shading, dots or dashes
+- Use draw or scribble, never trace
+- Detail comes from how the line wanders, not from the number of lines`,
+	})
+	RegisterStyle(Style{
+		Name: "architectural",
+		Instructions: `- Use trace only: straight, precise, ruled lines
+- Construct with a clear horizon line and consistent vanishing points
+- Extend construction lines slightly past corners
+- Shade with evenly spaced parallel strokes, never scribble
+- Prefer straight strokes to curves`,
+	})
+	RegisterStyle(Style{
+		Name: "manga-lineart",
+		Instructions: `- Clean confident outlines in trace, heavier (doubled strokes) on the outer silhouette
+- Thin interior lines for folds and features
+- Speed lines or radiating lines for energy and emphasis
+- Shade with sparse dashes in shadow areas only; no scribble`,
+	})
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Polyline is one continuous pen-down path in drawing coordinates.
+type Polyline []Vec2
+
+// ParseSVGPaths extracts the geometry of every <path>, <polyline> and
+// <line> element. Path data supports the M, L, H, V and Z commands (and
+// their relative forms), which is what the compiler emits.
+func ParseSVGPaths(svg string) ([]Polyline, error) {
+	dec := xml.NewDecoder(strings.NewReader(svg))
+	dec.Strict = false
+
+	var paths []Polyline
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		el, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch el.Name.Local {
+		case "path":
+			subpaths, err := parsePathData(attr(el, "d"))
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, subpaths...)
+		case "polyline":
+			nums := parseNumbers(attr(el, "points"))
+			var p Polyline
+			for i := 0; i+1 < len(nums); i += 2 {
+				p = append(p, Vec2{nums[i], nums[i+1]})
+			}
+			if len(p) > 0 {
+				paths = append(paths, p)
+			}
+		case "line":
+			n := func(k string) float64 { v, _ := strconv.ParseFloat(attr(el, k), 64); return v }
+			paths = append(paths, Polyline{{n("x1"), n("y1")}, {n("x2"), n("y2")}})
+		}
+	}
+	return paths, nil
+}
+
+func parsePathData(d string) ([]Polyline, error) {
+	var paths []Polyline
+	var cur Polyline
+	var pen, start Vec2
+	cmd := byte(0)
+
+	flush := func() {
+		if len(cur) > 0 {
+			paths = append(paths, cur)
+		}
+		cur = nil
+	}
+
+	toks := tokenizePath(d)
+	for i := 0; i < len(toks); {
+		if c := toks[i][0]; isPathCommand(c) {
+			cmd = c
+			i++
+			if cmd == 'Z' || cmd == 'z' {
+				if len(cur) > 0 {
+					cur = append(cur, start)
+				}
+				pen = start
+				flush()
+				continue
+			}
+		}
+		if cmd == 0 {
+			return nil, fmt.Errorf("path data starts without a command: %q", toks[i])
+		}
+
+		need := 2
+		if cmd == 'H' || cmd == 'h' || cmd == 'V' || cmd == 'v' {
+			need = 1
+		}
+		if i+need > len(toks) {
+			return nil, fmt.Errorf("path data: %c needs %d numbers", cmd, need)
+		}
+		var v [2]float64
+		for j := 0; j < need; j++ {
+			f, err := strconv.ParseFloat(toks[i+j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("path data: %w", err)
+			}
+			v[j] = f
+		}
+		i += need
+
+		switch cmd {
+		case 'M', 'm':
+			flush()
+			if cmd == 'm' {
+				pen = Vec2{pen.X + v[0], pen.Y + v[1]}
+				cmd = 'l'
+			} else {
+				pen = Vec2{v[0], v[1]}
+				cmd = 'L'
+			}
+			start = pen
+		case 'L':
+			pen = Vec2{v[0], v[1]}
+		case 'l':
+			pen = Vec2{pen.X + v[0], pen.Y + v[1]}
+		case 'H':
+			pen.X = v[0]
+		case 'h':
+			pen.X += v[0]
+		case 'V':
+			pen.Y = v[0]
+		case 'v':
+			pen.Y += v[0]
+		default:
+			return nil, fmt.Errorf("path data: unsupported command %c", cmd)
+		}
+		cur = append(cur, pen)
+	}
+	flush()
+	return paths, nil
+}
+
+func tokenizePath(d string) []string {
+	var toks []string
+	var b strings.Builder
+	flush := func() {
+		if b.Len() > 0 {
+			toks = append(toks, b.String())
+			b.Reset()
+		}
+	}
+	for i := 0; i < len(d); i++ {
+		c := d[i]
+		switch {
+		case isPathCommand(c):
+			flush()
+			toks = append(toks, string(c))
+		case c == ' ' || c == ',' || c == '\t' || c == '\n' || c == '\r':
+			flush()
+		case c == '-' && b.Len() > 0 && !strings.HasSuffix(b.String(), "e"):
+			flush()
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	flush()
+	return toks
+}
+
+func isPathCommand(c byte) bool {
+	return strings.IndexByte("MmLlHhVvZzCcSsQqTtAa", c) >= 0
+}
+
+func parseNumbers(s string) []float64 {
+	var nums []float64
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == ',' || r == '\n' || r == '\t' }) {
+		if v, err := strconv.ParseFloat(f, 64); err == nil {
+			nums = append(nums, v)
+		}
+	}
+	return nums
+}
+
+func attr(el xml.StartElement, name string) string {
+	for _, a := range el.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// polylineDistance is the largest distance from any point of a to the
+// nearest segment of b.
+func polylineDistance(a, b Polyline) float64 {
+	worst := 0.0
+	for _, p := range a {
+		best := math.Inf(1)
+		for i := range b {
+			q := b[i]
+			if i+1 < len(b) {
+				best = math.Min(best, segmentDistance(p, q, b[i+1]))
+			} else {
+				best = math.Min(best, math.Hypot(p.X-q.X, p.Y-q.Y))
+			}
+		}
+		worst = math.Max(worst, best)
+	}
+	return worst
+}
+
+func segmentDistance(p, a, b Vec2) float64 {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	l2 := dx*dx + dy*dy
+	if l2 == 0 {
+		return math.Hypot(p.X-a.X, p.Y-a.Y)
+	}
+	t := math.Max(0, math.Min(1, ((p.X-a.X)*dx+(p.Y-a.Y)*dy)/l2))
+	return math.Hypot(p.X-(a.X+t*dx), p.Y-(a.Y+t*dy))
+}
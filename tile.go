@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Tile is one piece of a drawing split to fit a plotter bed.
+type Tile struct {
+	Row, Col int // from 1
+	Origin   Vec2
+	Size     Vec2
+	SVG      string
+}
+
+// Name is the suffix of the tile's files, e.g. _tile_1_2.
+func (t Tile) Name() string {
+	return fmt.Sprintf("_tile_%d_%d", t.Row, t.Col)
+}
+
+// registrationMark is the size of the registration marks, in SVG units,
+// unless the overlap is smaller; they are kept inside it.
+const registrationMark = 5
+
+// TileSVG splits a compiled SVG into tiles no larger than bed, overlapping
+// their neighbours by overlap, to plot one at a time on a bed smaller than
+// the canvas. Each path is clipped to each tile it crosses. Where two
+// tiles overlap, each has the same registration marks, a cross in a
+// circle, to line them up by. It returns no tiles if the canvas fits the
+// bed.
+func TileSVG(svg string, bed Vec2, overlap float64) ([]Tile, error) {
+	if bed.X <= overlap || bed.Y <= overlap {
+		return nil, fmt.Errorf("the bed must be larger than the overlap")
+	}
+	paths, err := ParseSVGPaths(svg)
+	if err != nil {
+		return nil, err
+	}
+	viewBox, _ := splitSVG(svg)
+	v := parseNumbers(viewBox)
+	if len(v) != 4 {
+		return nil, fmt.Errorf("viewBox %q is not four numbers", viewBox)
+	}
+	page := Page{Origin: Vec2{v[0], v[1]}, Size: Vec2{v[2], v[3]}, Pen: strokeWidth(svg)}
+	if page.Size.X <= bed.X && page.Size.Y <= bed.Y {
+		return nil, nil
+	}
+
+	cols, rows := tileCount(page.Size.X, bed.X, overlap), tileCount(page.Size.Y, bed.Y, overlap)
+	step := Vec2{bed.X - overlap, bed.Y - overlap}
+	var tiles []Tile
+	for r := range rows {
+		for c := range cols {
+			origin := Vec2{page.Origin.X + float64(c)*step.X, page.Origin.Y + float64(r)*step.Y}
+			size := Vec2{
+				math.Min(bed.X, page.Origin.X+page.Size.X-origin.X),
+				math.Min(bed.Y, page.Origin.Y+page.Size.Y-origin.Y),
+			}
+			t := Tile{Row: r + 1, Col: c + 1, Origin: origin, Size: size}
+			var clipped []Polyline
+			for _, p := range paths {
+				clipped = append(clipped, clipPolyline(p, origin, Vec2{origin.X + size.X, origin.Y + size.Y})...)
+			}
+			marks := tileMarks(page, step, overlap, cols, rows, r, c)
+			t.SVG = tileSVG(clipped, marks, math.Min(registrationMark, overlap), t, page.Pen)
+			tiles = append(tiles, t)
+		}
+	}
+	return tiles, nil
+}
+
+// tileCount is how many tiles of bed overlapping by overlap cover length.
+func tileCount(length, bed, overlap float64) int {
+	if length <= bed {
+		return 1
+	}
+	return int(math.Ceil((length - overlap) / (bed - overlap)))
+}
+
+// tileMarks are the centres of the registration marks of the tile in row
+// r and column c: one in the middle of each overlap with a neighbour.
+func tileMarks(page Page, step Vec2, overlap float64, cols, rows, r, c int) []Vec2 {
+	// seam is the middle of the overlap after tile i, along one axis.
+	seam := func(origin, step float64, i int) float64 {
+		return origin + float64(i+1)*step + overlap/2
+	}
+	// middle is the middle of tile i, along one axis.
+	middle := func(origin, step, length float64, i int) float64 {
+		lo := origin + float64(i)*step
+		hi := math.Min(lo+step+overlap, origin+length)
+		return (lo + hi) / 2
+	}
+	midX := middle(page.Origin.X, step.X, page.Size.X, c)
+	midY := middle(page.Origin.Y, step.Y, page.Size.Y, r)
+
+	var marks []Vec2
+	if c > 0 {
+		marks = append(marks, Vec2{seam(page.Origin.X, step.X, c-1), midY})
+	}
+	if c < cols-1 {
+		marks = append(marks, Vec2{seam(page.Origin.X, step.X, c), midY})
+	}
+	if r > 0 {
+		marks = append(marks, Vec2{midX, seam(page.Origin.Y, step.Y, r-1)})
+	}
+	if r < rows-1 {
+		marks = append(marks, Vec2{midX, seam(page.Origin.Y, step.Y, r)})
+	}
+	return marks
+}
+
+// tileSVG writes a tile's paths and registration marks of size mark on a
+// canvas of the tile, in mm.
+func tileSVG(paths []Polyline, marks []Vec2, mark float64, t Tile, pen float64) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%gmm" height="%gmm" viewBox="%g %g %g %g">`+"\n",
+		t.Size.X, t.Size.Y, t.Origin.X, t.Origin.Y, t.Size.X, t.Size.Y)
+	fmt.Fprintf(&b, `  <rect x="%g" y="%g" width="%g" height="%g" fill="white"/>`+"\n", t.Origin.X, t.Origin.Y, t.Size.X, t.Size.Y)
+	for _, p := range paths {
+		b.WriteString(`  <path d="` + pathD(p) + `" fill="none" stroke="black" stroke-width="` + fmt.Sprint(pen) + `"/>` + "\n")
+	}
+	// The marks are paths, not circles and lines, so every export and
+	// plotter draws them too.
+	for _, m := range marks {
+		s := mark / 2
+		ring := make(Polyline, 25)
+		for i := range ring {
+			a := 2 * math.Pi * float64(i) / float64(len(ring)-1)
+			ring[i] = Vec2{m.X + 0.6*s*math.Cos(a), m.Y + 0.6*s*math.Sin(a)}
+		}
+		fmt.Fprintf(&b, `  <path d="M %.2f %.2f L %.2f %.2f M %.2f %.2f L %.2f %.2f %s" fill="none" stroke="black" stroke-width="%g"/>`+"\n",
+			m.X-s, m.Y, m.X+s, m.Y, m.X, m.Y-s, m.X, m.Y+s, pathD(ring), pen)
+	}
+	b.WriteString("</svg>\n")
+	return b.String()
+}
+
+// TileMap draws the whole canvas with the outline of every tile over it,
+// labelled row,column, to assemble the plotted tiles by.
+func TileMap(svg string, tiles []Tile) string {
+	viewBox, inner := splitSVG(svg)
+	v := parseNumbers(viewBox)
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="%s">`+"\n", viewBox)
+	fmt.Fprintf(&b, `  <g opacity="0.4">%s</g>`+"\n", inner)
+	font := 12.0
+	if len(v) == 4 {
+		font = math.Max(v[2], v[3]) / 40
+	}
+	for _, t := range tiles {
+		fmt.Fprintf(&b, `  <rect x="%g" y="%g" width="%g" height="%g" fill="none" stroke="blue" stroke-dasharray="%g"/>`+"\n",
+			t.Origin.X, t.Origin.Y, t.Size.X, t.Size.Y, font/2)
+		fmt.Fprintf(&b, `  <text x="%g" y="%g" font-family="sans-serif" font-size="%g" fill="blue">%d,%d</text>`+"\n",
+			t.Origin.X+font/2, t.Origin.Y+font*1.5, font, t.Row, t.Col)
+	}
+	b.WriteString("</svg>\n")
+	return b.String()
+}
+
+func pathD(p Polyline) string {
+	var d strings.Builder
+	for i, pt := range p {
+		if i == 0 {
+			d.WriteString("M ")
+		} else {
+			d.WriteString(" L ")
+		}
+		fmt.Fprintf(&d, "%.2f %.2f", pt.X, pt.Y)
+	}
+	return d.String()
+}
+
+// clipPolyline is the parts of p inside the box from lo to hi.
+func clipPolyline(p Polyline, lo, hi Vec2) []Polyline {
+	inside := func(q Vec2) bool { return q.X >= lo.X && q.X <= hi.X && q.Y >= lo.Y && q.Y <= hi.Y }
+	if len(p) == 1 {
+		if inside(p[0]) {
+			return []Polyline{p}
+		}
+		return nil
+	}
+
+	var out []Polyline
+	var cur Polyline
+	for i := 1; i < len(p); i++ {
+		// A segment that only touches the box leaves nothing to draw.
+		a, b, ok := clipSegment(p[i-1], p[i], lo, hi)
+		if !ok || a == b && p[i-1] != p[i] {
+			continue
+		}
+		if len(cur) == 0 || cur[len(cur)-1] != a {
+			if len(cur) > 0 {
+				out = append(out, cur)
+			}
+			cur = Polyline{a}
+		}
+		cur = append(cur, b)
+	}
+	if len(cur) > 0 {
+		out = append(out, cur)
+	}
+	return out
+}
+
+// clipSegment clips the segment from a to b to the box from lo to hi, by
+// Liang-Barsky, returning false if none of it is inside.
+func clipSegment(a, b, lo, hi Vec2) (Vec2, Vec2, bool) {
+	t0, t1 := 0.0, 1.0
+	dx, dy := b.X-a.X, b.Y-a.Y
+	for _, e := range [4][2]float64{{-dx, a.X - lo.X}, {dx, hi.X - a.X}, {-dy, a.Y - lo.Y}, {dy, hi.Y - a.Y}} {
+		p, q := e[0], e[1]
+		switch {
+		case p == 0:
+			if q < 0 {
+				return a, b, false
+			}
+		case p < 0:
+			t0 = math.Max(t0, q/p)
+		default:
+			t1 = math.Min(t1, q/p)
+		}
+	}
+	if t0 > t1 {
+		return a, b, false
+	}
+	at := func(t float64) Vec2 { return Vec2{a.X + t*dx, a.Y + t*dy} }
+	return at(t0), at(t1), true
+}
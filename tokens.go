@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Tokenizer is implemented by clients that can count a request's input
+// tokens exactly. Decorators pass the count through to the provider.
+type Tokenizer interface {
+	CountTokens(ctx context.Context, system string, messages []Message) (int, error)
+}
+
+// CountTokens counts a request's input tokens with client's tokenizer, or
+// estimates them if it has none.
+func CountTokens(ctx context.Context, client LLMClient, system string, messages []Message) (int, error) {
+	if t, ok := client.(Tokenizer); ok {
+		return t.CountTokens(ctx, system, messages)
+	}
+	return estimateTokens(system, messages), nil
+}
+
+// contextWindows are the input plus output token limits of the hosted
+// default models. Requests to other models are not checked.
+var contextWindows = map[string]int{
+	"claude-sonnet-4-5": 200000,
+	"gpt-4o":            128000,
+}
+
+// minOutputTokens is the smallest output allowance worth sending a
+// request with.
+const minOutputTokens = 4096
+
+// fitContext makes a request fit the model's context window before it is
+// sent, rather than letting it fail or get cut off mid-sketch. A re-ask
+// conversation first loses its earlier failed attempts, keeping the
+// original request and the latest answer; then the output allowance is
+// lowered. A request that still does not fit is an error.
+func fitContext(ctx context.Context, client LLMClient, system string, messages []Message, opts RequestOptions) ([]Message, RequestOptions, error) {
+	model := opts.model(client.Model())
+	window := contextWindows[model]
+	if window == 0 || estimateTokens(system, messages)+opts.maxTokens() < window*9/10 {
+		return messages, opts, nil
+	}
+
+	count := func() int {
+		n, err := CountTokens(ctx, client, system, messages)
+		if err != nil {
+			return estimateTokens(system, messages)
+		}
+		return n
+	}
+	n := count()
+	dropped := 0
+	for n+opts.maxTokens() > window && len(messages) > 3 {
+		messages = append(messages[:1:1], messages[3:]...)
+		dropped++
+		n = count()
+	}
+	if dropped > 0 {
+		printf("warning: dropped %d earlier attempts to fit %s's context window", dropped, model)
+	}
+
+	if n+minOutputTokens > window {
+		return nil, opts, fmt.Errorf("request is %d tokens, too long for %s's %d-token context window", n, model, window)
+	}
+	if n+opts.maxTokens() > window {
+		opts.MaxTokens = window - n
+	}
+	return messages, opts, nil
+}
+
+// CountTokens asks the count_tokens endpoint for the exact input size.
+func (c *AnthropicClient) CountTokens(ctx context.Context, system string, messages []Message) (int, error) {
+	body, headers := c.request(system, messages, RequestOptions{})
+	delete(body, "max_tokens")
+	respBody, err := postJSON(ctx, "https://api.anthropic.com/v1/messages/count_tokens", headers, body, 30*time.Second)
+	if err != nil {
+		return 0, err
+	}
+	var result struct {
+		InputTokens int `json:"input_tokens"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return 0, err
+	}
+	return result.InputTokens, nil
+}
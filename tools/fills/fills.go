@@ -0,0 +1,284 @@
+// Package fills shades closed regions of a sketch with hatching,
+// cross-hatching or stippling, written as SketchLang, so the shading
+// doesn't have to be placed stroke by stroke.
+package fills
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sketch-studio/tools/sketchlang"
+)
+
+// Points and paths are in mm, as SketchLang has them.
+type (
+	Point = sketchlang.Point
+	Path  = sketchlang.Path
+)
+
+// Styles of fill.
+const (
+	Hatch      = "hatch"      // parallel lines
+	CrossHatch = "crosshatch" // parallel lines, and more at right angles to them
+	Stipple    = "stipple"    // dots
+)
+
+// Styles lists the styles of fill.
+var Styles = []string{Hatch, CrossHatch, Stipple}
+
+// Fill is how to shade a region.
+type Fill struct {
+	Style   string
+	Spacing float64 // mm between lines, or between dots on average
+	Angle   float64 // of the lines, in degrees clockwise from horizontal
+	Seed    int64   // for where the dots of a stipple fall
+}
+
+const (
+	// joinTolerance is how far apart, in mm, the ends of two strokes can
+	// be and still join in an outline.
+	joinTolerance = 1.0
+	// maxMarks keeps a small spacing over a large region from writing more
+	// code than a sketch can hold.
+	maxMarks = 4000
+	// stippleJitter is how far a dot may stray from its place on the grid,
+	// as a fraction of the spacing.
+	stippleJitter = 0.4
+)
+
+// Outline joins the strokes of a sketch end to end, each drawn either way
+// round and in any order, into a closed polygon. Each stroke must start
+// within joinTolerance of where another ends, and the last must end near
+// where the first starts.
+func Outline(strokes []Path) (Path, error) {
+	var left []Path
+	for _, s := range strokes {
+		if len(s) > 1 {
+			left = append(left, s)
+		}
+	}
+	if len(left) == 0 {
+		return nil, fmt.Errorf("no strokes to outline")
+	}
+
+	poly := slices.Clone(left[0])
+	left = left[1:]
+	for len(left) > 0 {
+		end := poly[len(poly)-1]
+		best, bestDist, flip := 0, math.Inf(1), false
+		for i, s := range left {
+			if d := distance(end, s[0]); d < bestDist {
+				best, bestDist, flip = i, d, false
+			}
+			if d := distance(end, s[len(s)-1]); d < bestDist {
+				best, bestDist, flip = i, d, true
+			}
+		}
+		if bestDist > joinTolerance {
+			return nil, fmt.Errorf("the outline is open at (%s, %s): no stroke starts within %g mm of it", coord(end.X), coord(end.Y), joinTolerance)
+		}
+		next := slices.Clone(left[best])
+		if flip {
+			slices.Reverse(next)
+		}
+		poly = append(poly, next[1:]...)
+		left = slices.Delete(left, best, best+1)
+	}
+
+	if d := distance(poly[0], poly[len(poly)-1]); d > joinTolerance {
+		return nil, fmt.Errorf("the outline is open: it ends %.1f mm from where it starts", d)
+	}
+	poly = poly[:len(poly)-1]
+	if len(poly) < 3 || math.Abs(area(poly)) < 1e-6 {
+		return nil, fmt.Errorf("the outline encloses nothing")
+	}
+	return poly, nil
+}
+
+// Marks shades poly: each line of a hatch as a path of two points, each
+// dot of a stipple as a path of one. Lines run back and forth, so the pen
+// travels little between them.
+func (f Fill) Marks(poly Path) ([]Path, error) {
+	if f.Spacing <= 0 {
+		return nil, fmt.Errorf("spacing: want more than 0")
+	}
+	switch f.Style {
+	case Hatch:
+		return hatch(poly, f.Spacing, f.Angle)
+	case CrossHatch:
+		lines, err := hatch(poly, f.Spacing, f.Angle)
+		if err != nil {
+			return nil, err
+		}
+		across, err := hatch(poly, f.Spacing, f.Angle+90)
+		if err != nil {
+			return nil, err
+		}
+		if n := len(lines) + len(across); n > maxMarks {
+			return nil, tooMany(n, f.Spacing)
+		}
+		return append(lines, across...), nil
+	case Stipple:
+		return stipple(poly, f.Spacing, f.Seed)
+	}
+	return nil, fmt.Errorf("unknown fill %q (want %s)", f.Style, strings.Join(Styles, ", "))
+}
+
+// Code writes the marks of f over poly as a SketchLang trace of strokes and
+// dots.
+func (f Fill) Code(poly Path) (string, error) {
+	marks, err := f.Marks(poly)
+	if err != nil {
+		return "", err
+	}
+	if len(marks) == 0 {
+		return "", fmt.Errorf("the region is too small for a %s %g mm apart", f.Style, f.Spacing)
+	}
+	point := func(p Point) string { return "(" + coord(p.X) + ", " + coord(p.Y) + ")" }
+	items := make([]string, len(marks))
+	for i, m := range marks {
+		if len(m) == 1 {
+			items[i] = "dot at " + point(m[0])
+		} else {
+			items[i] = "stroke from " + point(m[0]) + " to " + point(m[1])
+		}
+	}
+	return "trace [\n  " + strings.Join(items, ",\n  ") + "\n]", nil
+}
+
+// hatch crosses poly with lines spacing apart at angle degrees, keeping
+// the parts inside it by the even-odd rule.
+func hatch(poly Path, spacing, angle float64) ([]Path, error) {
+	// Turn poly so the lines are horizontal, and turn them back after.
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	turn := func(p Point) Point { return Point{X: p.X*cos + p.Y*sin, Y: -p.X*sin + p.Y*cos} }
+	back := func(p Point) Point { return Point{X: p.X*cos - p.Y*sin, Y: p.X*sin + p.Y*cos} }
+	turned := make(Path, len(poly))
+	for i, p := range poly {
+		turned[i] = turn(p)
+	}
+	lo, hi := bounds(turned)
+	if n := (hi.Y - lo.Y) / spacing; n > maxMarks {
+		return nil, tooMany(int(n), spacing)
+	}
+
+	var lines []Path
+	forward := true
+	// The lines are centred on the region, so a thin one still gets one.
+	rows := math.Floor((hi.Y - lo.Y) / spacing)
+	for y := lo.Y + ((hi.Y-lo.Y)-rows*spacing)/2; y <= hi.Y; y += spacing {
+		var xs []float64
+		for i, a := range turned {
+			b := turned[(i+1)%len(turned)]
+			if (a.Y <= y) != (b.Y <= y) {
+				xs = append(xs, a.X+(y-a.Y)/(b.Y-a.Y)*(b.X-a.X))
+			}
+		}
+		slices.Sort(xs)
+		var row []Path
+		for i := 0; i+1 < len(xs); i += 2 {
+			if xs[i+1]-xs[i] < 1e-6 {
+				continue
+			}
+			row = append(row, Path{back(Point{X: xs[i], Y: y}), back(Point{X: xs[i+1], Y: y})})
+		}
+		if !forward {
+			slices.Reverse(row)
+			for _, l := range row {
+				l[0], l[1] = l[1], l[0]
+			}
+		}
+		if len(row) > 0 {
+			forward = !forward
+		}
+		lines = append(lines, row...)
+		if len(lines) > maxMarks {
+			return nil, tooMany(len(lines), spacing)
+		}
+	}
+	return lines, nil
+}
+
+// stipple scatters dots over poly, one to each cell of a grid spacing
+// apart, strayed from the cell's centre so no rows show.
+func stipple(poly Path, spacing float64, seed int64) ([]Path, error) {
+	lo, hi := bounds(poly)
+	cols, rows := math.Ceil((hi.X-lo.X)/spacing), math.Ceil((hi.Y-lo.Y)/spacing)
+	if cols*rows > maxMarks*4 {
+		return nil, tooMany(int(cols*rows), spacing)
+	}
+	r := rand.New(rand.NewSource(seed))
+	var dots []Path
+	for row := range int(rows) {
+		for i := range int(cols) {
+			// Snake along the rows, as a hatch does.
+			col := i
+			if row%2 == 1 {
+				col = int(cols) - 1 - i
+			}
+			p := Point{
+				X: lo.X + (float64(col)+0.5+(r.Float64()*2-1)*stippleJitter)*spacing,
+				Y: lo.Y + (float64(row)+0.5+(r.Float64()*2-1)*stippleJitter)*spacing,
+			}
+			if inside(poly, p) {
+				dots = append(dots, Path{p})
+			}
+		}
+	}
+	if len(dots) > maxMarks {
+		return nil, tooMany(len(dots), spacing)
+	}
+	return dots, nil
+}
+
+func tooMany(n int, spacing float64) error {
+	return fmt.Errorf("a spacing of %g mm takes %d marks, over the %d a fill can have", spacing, n, maxMarks)
+}
+
+// inside reports whether p is inside poly, by the even-odd rule.
+func inside(poly Path, p Point) bool {
+	in := false
+	for i, a := range poly {
+		b := poly[(i+1)%len(poly)]
+		if (a.Y <= p.Y) != (b.Y <= p.Y) && p.X < a.X+(p.Y-a.Y)/(b.Y-a.Y)*(b.X-a.X) {
+			in = !in
+		}
+	}
+	return in
+}
+
+func bounds(poly Path) (lo, hi Point) {
+	lo, hi = poly[0], poly[0]
+	for _, p := range poly[1:] {
+		lo = Point{X: math.Min(lo.X, p.X), Y: math.Min(lo.Y, p.Y)}
+		hi = Point{X: math.Max(hi.X, p.X), Y: math.Max(hi.Y, p.Y)}
+	}
+	return lo, hi
+}
+
+// area is the signed area of poly, by the shoelace formula.
+func area(poly Path) float64 {
+	sum := 0.0
+	for i, a := range poly {
+		b := poly[(i+1)%len(poly)]
+		sum += a.X*b.Y - b.X*a.Y
+	}
+	return sum / 2
+}
+
+func distance(a, b Point) float64 {
+	return math.Hypot(a.X-b.X, a.Y-b.Y)
+}
+
+// coord formats a coordinate to a hundredth of a mm.
+func coord(v float64) string {
+	v = math.Round(v*100) / 100
+	if v == 0 {
+		v = 0 // not -0
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,180 @@
+package fills
+
+import (
+	"math"
+	"testing"
+
+	"sketch-studio/tools/sketchlang"
+)
+
+// square is 10 mm on a side, with its corner at (20, 20).
+var square = Path{{X: 20, Y: 20}, {X: 30, Y: 20}, {X: 30, Y: 30}, {X: 20, Y: 30}}
+
+// notch is a U: 30 mm wide and 20 high, with a 10 mm slot cut down 10 mm
+// from the top between x 10 and 20.
+var notch = Path{{X: 0, Y: 0}, {X: 10, Y: 0}, {X: 10, Y: 10}, {X: 20, Y: 10}, {X: 20, Y: 0}, {X: 30, Y: 0}, {X: 30, Y: 20}, {X: 0, Y: 20}}
+
+// spacings checks that lines are straight, at angle degrees, and spacing
+// apart from one to the next, and returns where each falls across them.
+func spacings(t *testing.T, lines []Path, spacing, angle float64) []float64 {
+	t.Helper()
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	var offsets []float64
+	for i, l := range lines {
+		if len(l) != 2 {
+			t.Fatalf("line %d has %d points", i+1, len(l))
+		}
+		dx, dy := l[1].X-l[0].X, l[1].Y-l[0].Y
+		if math.Abs(dx*sin-dy*cos) > 1e-9 {
+			t.Errorf("line %d %v is not at %g°", i+1, l, angle)
+		}
+		offsets = append(offsets, -l[0].X*sin+l[0].Y*cos)
+	}
+	for i := 1; i < len(offsets); i++ {
+		if d := offsets[i] - offsets[i-1]; math.Abs(d-spacing) > 1e-9 {
+			t.Errorf("lines %d and %d are %g apart, want %g", i, i+1, d, spacing)
+		}
+	}
+	return offsets
+}
+
+func TestHatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		spacing float64
+		angle   float64
+		lines   int
+	}{
+		{"even", 2.5, 0, 4}, // at 20, 22.5, 25 and 27.5; one at 30 would only touch
+		{"uneven", 3, 0, 4}, // centred: at 20.5, 23.5, 26.5 and 29.5
+		{"vertical", 3, 90, 4},
+		{"diagonal", 2, 45, 8}, // across the 14.1 mm diagonal
+		{"wider than the square", 20, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, err := Fill{Style: Hatch, Spacing: tt.spacing, Angle: tt.angle}.Marks(square)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(lines) != tt.lines {
+				t.Fatalf("%d lines, want %d: %v", len(lines), tt.lines, lines)
+			}
+			spacings(t, lines, tt.spacing, tt.angle)
+			for i, l := range lines {
+				for _, p := range l {
+					if p.X < 20-1e-9 || p.X > 30+1e-9 || p.Y < 20-1e-9 || p.Y > 30+1e-9 {
+						t.Errorf("line %d reaches %v, outside the square", i+1, p)
+					}
+				}
+				// Back and forth.
+				if i > 0 && (l[1].X-l[0].X)*(lines[i-1][1].X-lines[i-1][0].X)+(l[1].Y-l[0].Y)*(lines[i-1][1].Y-lines[i-1][0].Y) > 0 {
+					t.Errorf("lines %d and %d run the same way", i, i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestCrossHatch(t *testing.T) {
+	marks, err := Fill{Style: CrossHatch, Spacing: 3, Angle: 30}.Marks(square)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines, err := Fill{Style: Hatch, Spacing: 3, Angle: 30}.Marks(square)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(marks) <= len(lines) {
+		t.Fatalf("%d marks cross-hatched, %d hatched", len(marks), len(lines))
+	}
+	spacings(t, marks[:len(lines)], 3, 30)
+	spacings(t, marks[len(lines):], 3, 120)
+}
+
+func TestHatchClipped(t *testing.T) {
+	lines, err := Fill{Style: Hatch, Spacing: 6, Angle: 0}.Marks(notch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rows at 1 and 7 cross both arms of the U, and those at 13 and 19 its
+	// base, snaking back and forth.
+	want := []Path{
+		{{X: 0, Y: 1}, {X: 10, Y: 1}},
+		{{X: 20, Y: 1}, {X: 30, Y: 1}},
+		{{X: 30, Y: 7}, {X: 20, Y: 7}},
+		{{X: 10, Y: 7}, {X: 0, Y: 7}},
+		{{X: 0, Y: 13}, {X: 30, Y: 13}},
+		{{X: 30, Y: 19}, {X: 0, Y: 19}},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("Marks() = %v, want %v", lines, want)
+	}
+	for i := range want {
+		for j := range want[i] {
+			if math.Hypot(lines[i][j].X-want[i][j].X, lines[i][j].Y-want[i][j].Y) > 1e-9 {
+				t.Errorf("line %d = %v, want %v", i+1, lines[i], want[i])
+				break
+			}
+		}
+	}
+}
+
+func TestStipple(t *testing.T) {
+	dots, err := Fill{Style: Stipple, Spacing: 2, Seed: 1}.Marks(notch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 500 mm² at one dot per 4 mm², less those of the slot's cells.
+	if len(dots) < 90 || len(dots) > 130 {
+		t.Errorf("%d dots", len(dots))
+	}
+	for _, d := range dots {
+		if len(d) != 1 || !inside(notch, d[0]) {
+			t.Errorf("dot %v is not inside the outline", d)
+		}
+	}
+}
+
+func TestOutline(t *testing.T) {
+	// The square's sides, out of order, some backwards, with a gap under
+	// the join tolerance.
+	strokes := []Path{
+		{{X: 20, Y: 20}, {X: 30, Y: 20}},
+		{{X: 20, Y: 30}, {X: 30, Y: 30}},
+		{{X: 20, Y: 20.5}, {X: 20, Y: 30}},
+		{{X: 30, Y: 20}, {X: 30, Y: 30}},
+	}
+	poly, err := Outline(strokes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(poly) != 4 || math.Abs(area(poly)) < 90 {
+		t.Errorf("Outline() = %v", poly)
+	}
+
+	if _, err := Outline(strokes[:3]); err == nil {
+		t.Error("Outline() of an open outline: no error")
+	}
+	if _, err := Outline([]Path{{{X: 0, Y: 0}, {X: 5, Y: 0}}, {{X: 5, Y: 0}, {X: 0, Y: 0}}}); err == nil {
+		t.Error("Outline() of a line drawn there and back: no error")
+	}
+}
+
+func TestCode(t *testing.T) {
+	for _, style := range Styles {
+		code, err := Fill{Style: style, Spacing: 2}.Code(square)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sketchlang.Validate(code); err != nil {
+			t.Errorf("%s code doesn't check: %v\n%s", style, err, code)
+		}
+	}
+	if _, err := (Fill{Style: "zigzag", Spacing: 2}).Code(square); err == nil {
+		t.Error("Code() of an unknown style: no error")
+	}
+	if _, err := (Fill{Style: Hatch, Spacing: 0.001}).Code(square); err == nil {
+		t.Error("Code() of too many lines: no error")
+	}
+}
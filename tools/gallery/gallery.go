@@ -0,0 +1,144 @@
+// Package gallery indexes the sketches saved under a directory by their
+// manifests, to list and search them without opening each one.
+package gallery
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"sketch-studio/tools/manifest"
+)
+
+// Entry is what the index keeps of one sketch.
+type Entry struct {
+	ID        string    `json:"id"` // its manifest's path from the root, without the suffix
+	Title     string    `json:"title"`
+	Subject   string    `json:"subject,omitempty"` // what was asked for
+	Summary   string    `json:"summary,omitempty"`
+	Style     string    `json:"style,omitempty"`
+	Date      time.Time `json:"date"`
+	Requester string    `json:"requester,omitempty"`
+	Cost      float64   `json:"cost"`
+	Parts     int       `json:"parts,omitempty"`     // of a decomposed request
+	SVG       string    `json:"svg"`                 // path from the root
+	Thumbnail string    `json:"thumbnail,omitempty"` // likewise, of a small PNG
+	Version   int       `json:"version,omitempty"`
+}
+
+// Index is every sketch under a directory, newest first.
+type Index struct {
+	Root    string
+	Entries []Entry
+	// Errors are the manifests that could not be read, which are left
+	// out.
+	Errors []error
+}
+
+// Scan indexes every manifest under root. The parts of a decomposed
+// request are indexed as part of it, not on their own, and the earlier
+// versions of a sketch are left out for the current one.
+func Scan(root string) (*Index, error) {
+	ix := &Index{Root: root}
+	parts := map[string]bool{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && path != root && strings.HasSuffix(d.Name(), manifest.VersionsDir("")) {
+			return fs.SkipDir
+		}
+		if d.IsDir() || !strings.HasSuffix(path, manifest.Suffix) {
+			return nil
+		}
+		m, err := manifest.LoadSketch(path)
+		if err != nil {
+			ix.Errors = append(ix.Errors, err)
+			return nil
+		}
+		for _, p := range m.Parts {
+			parts[filepath.Clean(m.File(p.Manifest))] = true
+		}
+		id, _ := filepath.Rel(root, strings.TrimSuffix(path, manifest.Suffix))
+		svg, _ := filepath.Rel(root, m.File(m.SVG))
+		var thumb string
+		if m.Thumbnail != "" {
+			thumb, _ = filepath.Rel(root, m.File(m.Thumbnail))
+		}
+		ix.Entries = append(ix.Entries, Entry{
+			ID:        filepath.ToSlash(id),
+			Title:     m.Title,
+			Subject:   m.Prompt,
+			Summary:   m.Summary,
+			Style:     m.Style,
+			Date:      m.Time,
+			Requester: m.Requester,
+			Cost:      m.Cost,
+			Parts:     len(m.Parts),
+			SVG:       filepath.ToSlash(svg),
+			Thumbnail: filepath.ToSlash(thumb),
+			Version:   m.Version,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ix.Entries = slices.DeleteFunc(ix.Entries, func(e Entry) bool {
+		return parts[filepath.Join(root, filepath.FromSlash(e.ID)+manifest.Suffix)]
+	})
+	slices.SortStableFunc(ix.Entries, func(a, b Entry) int {
+		return cmp.Or(b.Date.Compare(a.Date), strings.Compare(a.ID, b.ID))
+	})
+	return ix, nil
+}
+
+// List is every sketch in the index, newest first.
+func (ix *Index) List() []Entry {
+	return ix.Entries
+}
+
+// Query narrows a search. Zero fields match everything.
+type Query struct {
+	// Text is words that must all appear, in any case, in the title,
+	// subject or summary.
+	Text      string
+	Style     string // exactly, in any case
+	Requester string
+	Since     time.Time // made at or after
+	Until     time.Time // made before
+	MaxCost   float64   // in dollars
+}
+
+// Search is the sketches matching q, newest first.
+func (ix *Index) Search(q Query) []Entry {
+	words := strings.Fields(strings.ToLower(q.Text))
+	var out []Entry
+	for _, e := range ix.Entries {
+		text := strings.ToLower(e.Title + "\n" + e.Subject + "\n" + e.Summary)
+		switch {
+		case slices.ContainsFunc(words, func(w string) bool { return !strings.Contains(text, w) }),
+			q.Style != "" && !strings.EqualFold(e.Style, q.Style),
+			q.Requester != "" && e.Requester != q.Requester,
+			!q.Since.IsZero() && e.Date.Before(q.Since),
+			!q.Until.IsZero() && !e.Date.Before(q.Until),
+			q.MaxCost > 0 && e.Cost > q.MaxCost:
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// Get loads the full manifest of the sketch with id, as listed in the
+// index.
+func (ix *Index) Get(id string) (*manifest.Sketch, error) {
+	if !slices.ContainsFunc(ix.Entries, func(e Entry) bool { return e.ID == id }) {
+		return nil, fmt.Errorf("no sketch %q in the gallery", id)
+	}
+	return manifest.LoadSketch(filepath.Join(ix.Root, filepath.FromSlash(id)+manifest.Suffix))
+}
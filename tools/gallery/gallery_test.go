@@ -0,0 +1,106 @@
+package gallery
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"sketch-studio/tools/manifest"
+)
+
+// write saves m as the manifest of the sketch saved as name under root.
+func write(t *testing.T, root, name string, m *manifest.Sketch) {
+	t.Helper()
+	path := filepath.Join(root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.Write(manifest.Path(path), m); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScan(t *testing.T) {
+	root := t.TempDir()
+	day := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	write(t, root, "cat", &manifest.Sketch{
+		Title: "Sleeping cat", Prompt: "a cat", Style: "hatching", Requester: "ada",
+		Time: day, Cost: 0.02, SVG: "cat.svg", Thumbnail: "cat.thumb.png", Version: 2,
+	})
+	write(t, root, "pets/dog", &manifest.Sketch{
+		Title: "Dog", Prompt: "a dog on a lawn", Time: day.Add(24 * time.Hour), Cost: 0.05, SVG: "dog.svg",
+		Parts: []manifest.Part{{Description: "the lawn", Manifest: "dog_parts/part_1.sketch.json"}},
+	})
+	// Neither a part nor an earlier version is listed on its own.
+	write(t, root, "pets/dog_parts/part_1", &manifest.Sketch{Title: "Lawn", Time: day, SVG: "part_1.svg"})
+	write(t, root, "cat_versions/v1/cat", &manifest.Sketch{Title: "Cat", Time: day, SVG: "cat.svg", Version: 1})
+	if err := os.WriteFile(filepath.Join(root, "broken"+manifest.Suffix), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ix, err := Scan(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ix.Errors) != 1 {
+		t.Errorf("errors %v, want the broken manifest", ix.Errors)
+	}
+	want := []Entry{
+		{ID: "pets/dog", Title: "Dog", Subject: "a dog on a lawn", Date: day.Add(24 * time.Hour), Cost: 0.05, Parts: 1, SVG: "pets/dog.svg"},
+		{ID: "cat", Title: "Sleeping cat", Subject: "a cat", Style: "hatching", Date: day, Requester: "ada", Cost: 0.02, SVG: "cat.svg", Thumbnail: "cat.thumb.png", Version: 2},
+	}
+	got := ix.List()
+	if len(got) != len(want) {
+		t.Fatalf("List() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i+1, got[i], want[i])
+		}
+	}
+
+	if found := ix.Search(Query{Text: "LAWN dog"}); len(found) != 1 || found[0].ID != "pets/dog" {
+		t.Errorf("Search(lawn dog) = %+v", found)
+	}
+	if found := ix.Search(Query{Style: "Hatching", MaxCost: 0.03}); len(found) != 1 || found[0].ID != "cat" {
+		t.Errorf("Search(hatching) = %+v", found)
+	}
+	if found := ix.Search(Query{Requester: "ada", Since: day.Add(time.Hour)}); len(found) != 0 {
+		t.Errorf("Search(ada since) = %+v", found)
+	}
+
+	m, err := ix.Get("pets/dog")
+	if err != nil || m.Title != "Dog" || m.File(m.SVG) != filepath.Join(root, "pets", "dog.svg") {
+		t.Errorf("Get(pets/dog) = %+v, %v", m, err)
+	}
+	if _, err := ix.Get("pets/dog_parts/part_1"); err == nil {
+		t.Error("Get() of a part: no error")
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+	entries := []Entry{
+		{ID: "cat", Title: "Cat & mouse", SVG: "cat.svg", Thumbnail: "cat.thumb.png"},
+		{ID: "pets/dog", Title: "Dog", SVG: "pets/dog.svg"},
+	}
+	var b strings.Builder
+	if err := WriteHTML(&b, entries); err != nil {
+		t.Fatal(err)
+	}
+	page := b.String()
+	for _, s := range []string{
+		`<a href="cat.svg"><img src="cat.thumb.png" alt="Cat &amp; mouse"`,
+		`<a href="pets/dog.svg"><img src="pets/dog.svg" alt="Dog"`,
+	} {
+		if !strings.Contains(page, s) {
+			t.Errorf("page has no %s:\n%s", s, page)
+		}
+	}
+
+	b.Reset()
+	if err := WriteHTML(&b, nil); err != nil || !strings.Contains(b.String(), "No sketches.") {
+		t.Errorf("empty page: %v\n%s", err, b.String())
+	}
+}
@@ -0,0 +1,23 @@
+package gallery
+
+import (
+	"html/template"
+	"io"
+)
+
+var page = template.Must(template.New("gallery").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>sketch-studio gallery</title>
+<style>body{font-family:sans-serif;margin:1em}figure{display:inline-block;width:220px;margin:0 1em 1em 0;vertical-align:top}img{width:200px;height:200px;object-fit:contain;border:1px solid #ccc}figcaption{font-size:small}</style>
+</head><body>
+{{range .}}<figure><a href="{{.SVG}}"><img src="{{or .Thumbnail .SVG}}" alt="{{.Title}}" loading="lazy"></a>
+<figcaption>{{.Title}}<br>{{.Date.Local.Format "2006-01-02"}}{{with .Style}} &middot; {{.}}{{end}}</figcaption></figure>
+{{else}}<p>No sketches.</p>
+{{end}}</body></html>
+`))
+
+// WriteHTML writes a page showing entries, each as its thumbnail linking
+// to its SVG, or as the SVG itself if it has no thumbnail. Their paths are
+// from the index's root, so the page belongs there.
+func WriteHTML(w io.Writer, entries []Entry) error {
+	return page.Execute(w, entries)
+}
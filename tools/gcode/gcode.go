@@ -0,0 +1,301 @@
+// Package gcode reads the G-code the sketchlang compiler writes for pen
+// plotters, to estimate what plotting it takes and to adjust the pen.
+//
+// Moves are told apart by what they do rather than by a convention for
+// the pen's Z: G1 moves in X and Y draw, G0 moves travel, and a Z move
+// that a drawing move follows lowers the pen. That holds whichever way
+// the machine's Z axis points.
+package gcode
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Speeds are the feed rates, in mm/min, assumed until the program sets
+// its own with F.
+type Speeds struct {
+	Draw   float64 // G1
+	Travel float64 // G0
+}
+
+// DefaultSpeeds are those of a typical hobby pen plotter.
+var DefaultSpeeds = Speeds{Draw: 1500, Travel: 4500}
+
+// Report is what plotting a program takes.
+type Report struct {
+	Lines  int           // of G-code, leaving out comments and blank lines
+	Draw   float64       // pen-down distance, in mm
+	Travel float64       // pen-up distance, including Z moves, in mm
+	Lifts  int           // times the pen is raised after drawing
+	Time   time.Duration // estimated, including dwells
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf("pen-down %.0f mm, travel %.0f mm, %d pen lifts, plot time %s",
+		r.Draw, r.Travel, r.Lifts, r.Time.Round(time.Second))
+}
+
+// Line is one line of a program, parsed.
+type Line struct {
+	Text  string           // as written, without its comment
+	Words map[byte]float64 // the value of each letter, such as 'G' or 'X'
+}
+
+// Has reports whether the line sets letter.
+func (l Line) Has(letter byte) bool {
+	_, ok := l.Words[letter]
+	return ok
+}
+
+// Motion is the G0 or G1 of the line, or -1 for neither.
+func (l Line) Motion() int {
+	if g, ok := l.Words['G']; ok && (g == 0 || g == 1) {
+		return int(g)
+	}
+	return -1
+}
+
+// Parse reads a program's lines. Comments, in parentheses or after a
+// semicolon, are left out of Text, and so are lines with nothing else.
+// A word that doesn't parse is kept in Text but not in Words.
+func Parse(program string) []Line {
+	var lines []Line
+	for _, text := range strings.Split(program, "\n") {
+		if l, ok := parseLine(text); ok {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+func parseLine(text string) (Line, bool) {
+	text = stripComments(text)
+	if text == "" {
+		return Line{}, false
+	}
+	l := Line{Text: text, Words: map[byte]float64{}}
+	for _, word := range splitWords(text) {
+		letter := word[0] &^ 0x20 // upper case
+		v, err := strconv.ParseFloat(word[1:], 64)
+		if err != nil {
+			continue
+		}
+		l.Words[letter] = v
+	}
+	return l, true
+}
+
+func stripComments(text string) string {
+	if i := strings.IndexByte(text, ';'); i >= 0 {
+		text = text[:i]
+	}
+	for {
+		open := strings.IndexByte(text, '(')
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(text[open:], ')')
+		if end < 0 {
+			text = text[:open]
+			break
+		}
+		text = text[:open] + " " + text[open+end+1:]
+	}
+	return strings.TrimSpace(text)
+}
+
+// splitWords splits "G1X10 Y2.5" into G1, X10 and Y2.5.
+func splitWords(text string) []string {
+	var words []string
+	start := -1
+	for i := 0; i <= len(text); i++ {
+		var c byte
+		if i < len(text) {
+			c = text[i]
+		}
+		isLetter := c|0x20 >= 'a' && c|0x20 <= 'z'
+		if i == len(text) || isLetter || c == ' ' || c == '\t' {
+			if start >= 0 && i-start > 1 {
+				words = append(words, text[start:i])
+			}
+			start = -1
+			if isLetter {
+				start = i
+			}
+		}
+	}
+	return words
+}
+
+// Analyze measures program, plotted at the speeds it sets, or at speeds
+// where it sets none. A feed rate on a G0 line is taken as the travel
+// speed, as the compiler writes it.
+func Analyze(program string, speeds Speeds) Report {
+	var r Report
+	var x, y, z float64
+	feed := map[int]float64{0: speeds.Travel, 1: speeds.Draw}
+	motion := 0
+	drew := false // since the last Z move
+	var minutes float64
+	for _, l := range Parse(program) {
+		r.Lines++
+		if g, ok := l.Words['G']; ok && g == 4 {
+			minutes += l.Words['P'] / 60 // seconds
+			continue
+		}
+		if m := l.Motion(); m >= 0 {
+			motion = m
+		} else if l.Has('G') {
+			continue // units, distance mode and the like
+		}
+		if f, ok := l.Words['F']; ok && f > 0 {
+			feed[motion] = f
+		}
+
+		nx, ny, nz := x, y, z
+		if v, ok := l.Words['X']; ok {
+			nx = v
+		}
+		if v, ok := l.Words['Y']; ok {
+			ny = v
+		}
+		if v, ok := l.Words['Z']; ok {
+			nz = v
+		}
+		flat := math.Hypot(nx-x, ny-y)
+		if nz != z {
+			if drew {
+				r.Lifts++
+				drew = false
+			}
+			r.Travel += math.Abs(nz - z)
+			minutes += math.Abs(nz-z) / feed[0]
+		}
+		if flat > 0 {
+			if motion == 1 {
+				r.Draw += flat
+				drew = true
+			} else {
+				r.Travel += flat
+			}
+			minutes += flat / feed[motion]
+		}
+		x, y, z = nx, ny, nz
+	}
+	r.Time = time.Duration(minutes * float64(time.Minute))
+	return r
+}
+
+// move is what a line does: move in X and Y, or in Z alone, with G0 or
+// G1.
+type move struct {
+	flat, z bool
+	motion  int
+}
+
+// moves tells what each line of program does, numbering lines as
+// strings.Split does.
+func moves(program string) []move {
+	text := strings.Split(program, "\n")
+	out := make([]move, len(text))
+	var x, y, z float64
+	motion := 0
+	for i, t := range text {
+		l, ok := parseLine(t)
+		if !ok {
+			continue
+		}
+		if m := l.Motion(); m >= 0 {
+			motion = m
+		} else if l.Has('G') {
+			continue
+		}
+		nx, ny, nz := x, y, z
+		if v, ok := l.Words['X']; ok {
+			nx = v
+		}
+		if v, ok := l.Words['Y']; ok {
+			ny = v
+		}
+		if v, ok := l.Words['Z']; ok {
+			nz = v
+		}
+		out[i] = move{flat: nx != x || ny != y, z: nz != z, motion: motion}
+		out[i].z = out[i].z && !out[i].flat
+		x, y, z = nx, ny, nz
+	}
+	return out
+}
+
+var zWord = regexp.MustCompile(`[Zz][-+]?[0-9]*\.?[0-9]+`)
+
+// PenDown lowers the pen to depth for every path program draws, in place
+// of the depth it was lowering it to: it rewrites each Z move that a
+// drawing move follows.
+func PenDown(program string, depth float64) string {
+	lines := strings.Split(program, "\n")
+	z := "Z" + strconv.FormatFloat(depth, 'f', -1, 64)
+	lowering := -1 // the last Z move since the pen moved in X and Y
+	for i, m := range moves(program) {
+		switch {
+		case m.z:
+			lowering = i
+		case m.flat:
+			if m.motion == 1 && lowering >= 0 {
+				code, comment, commented := strings.Cut(lines[lowering], ";")
+				if loc := zWord.FindStringIndex(code); loc != nil {
+					lines[lowering] = code[:loc[0]] + z + code[loc[1]:]
+					if commented {
+						lines[lowering] += ";" + comment
+					}
+				}
+			}
+			lowering = -1
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Join runs programs one after another, as one program. The setup before
+// the first move in X and Y is kept from the first program only, and what
+// comes after the last Z move, such as a return home, from the last.
+// Programs that move nowhere are left out.
+func Join(programs ...string) string {
+	type span struct {
+		lines       []string
+		first, last int // the first move in X and Y, the last in Z
+	}
+	var spans []span
+	for _, program := range programs {
+		s := span{lines: strings.Split(program, "\n"), first: -1, last: -1}
+		for i, m := range moves(program) {
+			if m.flat && s.first < 0 {
+				s.first = i
+			}
+			if m.z {
+				s.last = i
+			}
+		}
+		if s.first >= 0 {
+			spans = append(spans, s)
+		}
+	}
+
+	parts := make([]string, len(spans))
+	for i, s := range spans {
+		start, end := s.first, len(s.lines)
+		if i == 0 {
+			start = 0
+		}
+		if i < len(spans)-1 && s.last >= 0 {
+			end = s.last + 1
+		}
+		parts[i] = strings.Join(s.lines[start:end], "\n")
+	}
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,142 @@
+package gcode
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// compiled is G-code as the sketchlang compiler writes it: the pen goes
+// down to Z2.2 and up to Z0, with a G0 feed for travel and a G1 feed for
+// drawing.
+const compiled = `; Generated by Sketch DSL for Machine
+G21
+G90
+M5
+G4 P0.5
+G0 F6000
+; 2 paths
+; Path 1
+G0 X10.000 Y0.000
+G0 Z2.2
+G1 F3000
+G1 X40.000 Y0.000
+G1 X40.000 Y40.000
+; Path 2
+G0 Z0
+G0 X10.000 Y40.000
+G0 Z2.2
+G1 X10.000 Y10.000
+G0 Z0
+M5
+G4 P0.3
+G0 X0 Y0
+; End
+`
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		name    string
+		program string
+		speeds  Speeds
+		want    Report
+	}{
+		{
+			name:    "compiled",
+			program: compiled,
+			speeds:  DefaultSpeeds,
+			// Drawing 30+40+30 mm at 3000 mm/min; travelling 10+30 mm,
+			// home from (10, 10), and 4 Z moves of 2.2 mm, at 6000
+			// mm/min; 0.8 s of dwells.
+			want: Report{
+				Lines:  18,
+				Draw:   100,
+				Travel: 40 + math.Sqrt2*10 + 4*2.2,
+				Lifts:  2,
+				Time:   time.Duration(math.Round((100.0/3000+(40+math.Sqrt2*10+4*2.2)/6000)*float64(time.Minute))) + 800*time.Millisecond,
+			},
+		},
+		{
+			name:    "default speeds",
+			program: "G1 X15 Y0\nG0 X15 Y45\n",
+			speeds:  Speeds{Draw: 60, Travel: 180},
+			want:    Report{Lines: 2, Draw: 15, Travel: 45, Time: 30 * time.Second},
+		},
+		{
+			name:    "modal motion and packed words",
+			program: "G1X3Y4\nX6 Y8 (same move)\nG0 Z-1\n",
+			speeds:  DefaultSpeeds,
+			want:    Report{Lines: 3, Draw: 10, Travel: 1, Lifts: 1},
+		},
+		{
+			name:    "pen down negative",
+			program: "G0 Z-1\nG1 X10\nG0 Z0\nG0 X20\nG0 Z-1\nG1 X30\nG0 Z0\n",
+			speeds:  DefaultSpeeds,
+			want:    Report{Lines: 7, Draw: 20, Travel: 14, Lifts: 2},
+		},
+		{
+			name:    "comments only",
+			program: "; nothing\n(at all)\n\n",
+			speeds:  DefaultSpeeds,
+			want:    Report{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Analyze(tt.program, tt.speeds)
+			if tt.want.Time == 0 {
+				got.Time = 0
+			}
+			if got.Lines != tt.want.Lines || got.Lifts != tt.want.Lifts ||
+				math.Abs(got.Draw-tt.want.Draw) > 1e-6 || math.Abs(got.Travel-tt.want.Travel) > 1e-6 ||
+				(got.Time-tt.want.Time).Abs() > time.Millisecond {
+				t.Errorf("Analyze() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	lines := Parse("g1x1.5 y-2 ; draw\n(skip) M5\n\n")
+	if len(lines) != 2 {
+		t.Fatalf("%d lines, want 2", len(lines))
+	}
+	if l := lines[0]; l.Text != "g1x1.5 y-2" || l.Words['G'] != 1 || l.Words['X'] != 1.5 || l.Words['Y'] != -2 || l.Motion() != 1 {
+		t.Errorf("line 1 = %+v", l)
+	}
+	if l := lines[1]; l.Text != "M5" || l.Words['M'] != 5 || l.Motion() != -1 {
+		t.Errorf("line 2 = %+v", l)
+	}
+}
+
+func TestPenDown(t *testing.T) {
+	got := PenDown(compiled, 1.6)
+	want := strings.ReplaceAll(compiled, "G0 Z2.2", "G0 Z1.6")
+	if got != want {
+		t.Errorf("PenDown() =\n%s\nwant\n%s", got, want)
+	}
+
+	// Whichever way Z points, only the moves before drawing change, and
+	// comments stay.
+	got = PenDown("G0 Z0\nG0 Z-1 ; down\nG1 X5\nG0 Z0\nG0 X9\nG0 Z-1\nG0 Z0\n", -0.5)
+	if want := "G0 Z0\nG0 Z-0.5 ; down\nG1 X5\nG0 Z0\nG0 X9\nG0 Z-1\nG0 Z0\n"; got != want {
+		t.Errorf("PenDown() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	first := "G21\nG0 F6000\nG0 X1 Y1\nG0 Z2\nG1 X2 Y1\nG0 Z0\nG0 X0 Y0\n; End\n"
+	second := "G21\nG0 F6000\nG0 X5 Y5\nG0 Z1\nG1 X6 Y5\nG0 Z0\nG0 X0 Y0\n; End\n"
+	got := Join(first, "G21\n; nothing\n", second)
+	want := "G21\nG0 F6000\nG0 X1 Y1\nG0 Z2\nG1 X2 Y1\nG0 Z0\nG0 X5 Y5\nG0 Z1\nG1 X6 Y5\nG0 Z0\nG0 X0 Y0\n; End\n"
+	if got != want {
+		t.Errorf("Join() =\n%s\nwant\n%s", got, want)
+	}
+	if r := Analyze(got, DefaultSpeeds); r.Draw != 2 || r.Lifts != 2 {
+		t.Errorf("joined program draws %v mm with %d lifts, want 2 and 2", r.Draw, r.Lifts)
+	}
+	if got := Join(first); got != first {
+		t.Errorf("Join(one) = %q", got)
+	}
+}
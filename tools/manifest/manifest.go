@@ -0,0 +1,163 @@
+// Package manifest reads and writes sketch manifests: everything a run
+// knows about one sketch, saved as <name>.sketch.json beside its .sketch
+// and .svg, for other tools to read without the model or the compiler.
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Suffix ends the file name of every manifest.
+const Suffix = ".sketch.json"
+
+// Sketch is the manifest of one sketch, or of a composition of parts.
+// Files it names are relative to the manifest's directory.
+type Sketch struct {
+	Title     string    `json:"title"`
+	Summary   string    `json:"summary,omitempty"`
+	Prompt    string    `json:"prompt,omitempty"`
+	Image     string    `json:"image,omitempty"` // reference image traced
+	Style     string    `json:"style,omitempty"`
+	Model     string    `json:"model"`
+	Requester string    `json:"requester,omitempty"` // who ran the request
+	Time      time.Time `json:"time"`                // when it was started
+	Seconds   float64   `json:"seconds"`             // how long it took
+
+	Pos  Point `json:"pos"`
+	Size Point `json:"size"` // the canvas, in mm
+
+	Code      string `json:"code,omitempty"`
+	Sketch    string `json:"sketch,omitempty"` // the .sketch file
+	SVG       string `json:"svg"`
+	Thumbnail string `json:"thumbnail,omitempty"` // a small PNG of the SVG
+
+	Usage          Tokens  `json:"usage"`
+	Cost           float64 `json:"cost"` // in dollars
+	BudgetExceeded bool    `json:"budget_exceeded,omitempty"`
+
+	Phases []Phase   `json:"phases,omitempty"`
+	Final  *Compiled `json:"final,omitempty"` // the saved SVG
+	Lint   []string  `json:"lint,omitempty"`  // warnings about the code
+	Parts  []Part    `json:"parts,omitempty"` // of a decomposed request
+	Dir    string    `json:"-"`               // where LoadSketch found it
+
+	// Version counts the sketches saved under the same name, from 1. The
+	// ones it replaced are kept by VersionPath.
+	Version int `json:"version,omitempty"`
+}
+
+// Point is a position or size in mm.
+type Point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Tokens counts the tokens spent on a sketch.
+type Tokens struct {
+	Input      int `json:"input"` // uncached
+	Output     int `json:"output"`
+	CacheWrite int `json:"cache_write,omitempty"`
+	CacheRead  int `json:"cache_read,omitempty"`
+}
+
+// Phase is one version of the sketch on its way to the final one: the
+// draft, each pass and each critique round, named as on a phase sheet.
+type Phase struct {
+	Name     string   `json:"name"`
+	Seconds  float64  `json:"seconds"` // since the phase before
+	Compiled Compiled `json:"compiled"`
+}
+
+// Compiled measures a version's compiled SVG.
+type Compiled struct {
+	Paths    int     `json:"paths"`
+	Points   int     `json:"points"`
+	Length   float64 `json:"length"` // pen-down, in mm
+	Coverage float64 `json:"coverage"`
+}
+
+// Part is one part of a decomposed request, with its region of the
+// canvas in mm and its own manifest.
+type Part struct {
+	Description string `json:"description"`
+	Pos         Point  `json:"pos"`
+	Size        Point  `json:"size"`
+	Z           int    `json:"z,omitempty"`
+	Manifest    string `json:"manifest"`
+}
+
+// Path is the manifest of the sketch saved as name, without extension.
+func Path(name string) string {
+	return name + Suffix
+}
+
+// VersionsDir is where the earlier versions of the sketch saved as name
+// are kept, each in a directory v<n> of its own.
+func VersionsDir(name string) string {
+	return name + "_versions"
+}
+
+// VersionPath is the manifest of version v of the sketch saved as name,
+// once a later version has replaced it.
+func VersionPath(name string, v int) string {
+	return filepath.Join(VersionsDir(name), fmt.Sprintf("v%d", v), filepath.Base(name)+Suffix)
+}
+
+// Write saves s to path.
+func Write(path string, s *Sketch) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// LoadSketch reads the manifest at path, or of the sketch saved as path
+// without its extension: art, art.sketch or art.svg all load
+// art.sketch.json.
+func LoadSketch(path string) (*Sketch, error) {
+	if !strings.HasSuffix(path, Suffix) {
+		path = Path(trimExt(path))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Sketch
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	s.Dir = filepath.Dir(path)
+	return &s, nil
+}
+
+// LoadVersion reads the manifest of version v of the sketch saved as name,
+// which, like LoadSketch, may end in .sketch or .svg. That is the current
+// manifest if it is version v, and otherwise the one at VersionPath.
+func LoadVersion(name string, v int) (*Sketch, error) {
+	name = trimExt(name)
+	if s, err := LoadSketch(Path(name)); err == nil && max(s.Version, 1) == v {
+		return s, nil
+	}
+	return LoadSketch(VersionPath(name, v))
+}
+
+func trimExt(name string) string {
+	if ext := filepath.Ext(name); ext == ".sketch" || ext == ".svg" {
+		return strings.TrimSuffix(name, ext)
+	}
+	return name
+}
+
+// File is the path of a file the manifest names.
+func (s *Sketch) File(name string) string {
+	if name == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(s.Dir, name)
+}
@@ -0,0 +1,252 @@
+// Package plotter streams G-code to a plotter running GRBL, over a serial
+// port or anything else that reads and writes like one.
+//
+// Lines are sent with GRBL's character-counting flow control: as many as
+// fit in its receive buffer, and another as each "ok" or "error" frees
+// the room of the oldest. That keeps the planner fed without overflowing
+// the buffer, which would drop characters.
+package plotter
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// RXBuffer is the size of GRBL's serial receive buffer on an Arduino.
+const RXBuffer = 128
+
+// Realtime commands, which GRBL acts on as they arrive instead of queueing
+// them with the lines.
+const (
+	feedHold   = "!"
+	cycleStart = "~"
+	softReset  = "\x18"
+)
+
+// ErrAborted is returned by Stream when the plot is aborted.
+var ErrAborted = errors.New("plot aborted")
+
+// LineError is a line GRBL rejected.
+type LineError struct {
+	Line  int    // counting from 1, in the lines streamed
+	Text  string // the line
+	Reply string // such as "error:20"
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d %q: %s", e.Line, e.Text, e.Reply)
+}
+
+// AlarmError is an alarm GRBL raised, such as a hard limit hit. GRBL
+// stops and ignores G-code until it is reset or unlocked with $X.
+type AlarmError struct {
+	Reply string // such as "ALARM:1"
+}
+
+func (e *AlarmError) Error() string {
+	return "plotter alarm: " + e.Reply
+}
+
+// Progress is how far a plot has got.
+type Progress struct {
+	Sent  int // lines sent
+	Done  int // lines GRBL has replied to
+	Total int
+}
+
+// Plotter is a GRBL controller on port. Stream sends it a program, and
+// Pause, Resume and Abort may be called while it runs.
+type Plotter struct {
+	// Progress, if set, is called from Stream each time GRBL replies to a
+	// line.
+	Progress func(Progress)
+	// Buffer is the size of the controller's receive buffer, RXBuffer if
+	// 0.
+	Buffer int
+
+	port    io.ReadWriter
+	replies chan string
+	readErr error // once replies is closed
+
+	mu      sync.Mutex // guards writes to port, paused and aborted
+	paused  bool
+	aborted bool
+	wake    chan struct{}
+}
+
+// New starts reading GRBL's replies from port. Closing port stops it.
+func New(port io.ReadWriter) *Plotter {
+	p := &Plotter{port: port, replies: make(chan string, 16), wake: make(chan struct{}, 1)}
+	go p.read()
+	return p
+}
+
+func (p *Plotter) read() {
+	scanner := bufio.NewScanner(p.port)
+	for scanner.Scan() {
+		if reply := strings.TrimSpace(scanner.Text()); reply != "" {
+			p.replies <- reply
+		}
+	}
+	p.readErr = scanner.Err()
+	if p.readErr == nil {
+		p.readErr = io.EOF
+	}
+	close(p.replies)
+}
+
+// Wait waits for the banner GRBL prints when it starts, such as
+// "Grbl 1.1h ['$' for help]", and returns it. Most boards reset when the
+// port is opened, and drop what is sent before they are ready.
+func (p *Plotter) Wait(ctx context.Context) (string, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case reply, ok := <-p.replies:
+			if !ok {
+				return "", fmt.Errorf("plotter: %w", p.readErr)
+			}
+			if strings.HasPrefix(reply, "Grbl ") {
+				return reply, nil
+			}
+		}
+	}
+}
+
+// Lines reads a G-code program, leaving out comments and blank lines.
+func Lines(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		for {
+			open := strings.IndexByte(line, '(')
+			end := strings.IndexByte(line[max(open, 0):], ')')
+			if open < 0 || end < 0 {
+				break
+			}
+			line = line[:open] + line[open+end+1:]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// Stream sends lines to GRBL and returns once it has replied to them
+// all. A line GRBL rejects stops the plot: no more are sent, and Stream
+// returns a *LineError once the lines already sent are answered. An alarm
+// returns an *AlarmError straight away. If ctx is done the plot is
+// aborted.
+func (p *Plotter) Stream(ctx context.Context, lines []string) error {
+	size := p.Buffer
+	if size == 0 {
+		size = RXBuffer
+	}
+	for i, line := range lines {
+		if len(line)+1 > size {
+			return fmt.Errorf("line %d is longer than the plotter's %d-byte buffer", i+1, size)
+		}
+	}
+
+	var queued []int // the sizes of the lines sent and not yet replied to
+	used, sent, done := 0, 0, 0
+	var failed error
+	for done < len(lines) {
+		if failed != nil && done == sent {
+			return failed
+		}
+
+		p.mu.Lock()
+		for failed == nil && !p.paused && !p.aborted && sent < len(lines) && used+len(lines[sent])+1 <= size {
+			if _, err := io.WriteString(p.port, lines[sent]+"\n"); err != nil {
+				p.mu.Unlock()
+				return fmt.Errorf("plotter: %w", err)
+			}
+			queued = append(queued, len(lines[sent])+1)
+			used += len(lines[sent]) + 1
+			sent++
+		}
+		aborted := p.aborted
+		p.mu.Unlock()
+		if aborted {
+			return ErrAborted
+		}
+
+		select {
+		case <-ctx.Done():
+			p.Abort()
+			return ctx.Err()
+		case <-p.wake:
+		case reply, ok := <-p.replies:
+			if !ok {
+				return fmt.Errorf("plotter: %w", p.readErr)
+			}
+			switch {
+			case reply == "ok", strings.HasPrefix(reply, "error"):
+				if len(queued) == 0 {
+					continue
+				}
+				if reply != "ok" && failed == nil {
+					failed = &LineError{Line: done + 1, Text: lines[done], Reply: reply}
+				}
+				used -= queued[0]
+				queued = queued[1:]
+				done++
+				if p.Progress != nil {
+					p.Progress(Progress{Sent: sent, Done: done, Total: len(lines)})
+				}
+			case strings.HasPrefix(reply, "ALARM"):
+				return &AlarmError{Reply: reply}
+			case strings.HasPrefix(reply, "Grbl "):
+				return errors.New("plotter: the controller reset during the plot")
+			}
+			// Anything else is a message or status report.
+		}
+	}
+	return failed
+}
+
+// Pause stops the plot with a feed hold, decelerating to a stop without
+// losing position, and stops sending lines until Resume.
+func (p *Plotter) Pause() error {
+	return p.realtime(feedHold, func() { p.paused = true })
+}
+
+// Resume carries on after Pause.
+func (p *Plotter) Resume() error {
+	return p.realtime(cycleStart, func() { p.paused = false })
+}
+
+// Abort stops the plot with a soft reset, which halts GRBL at once and
+// clears its buffer, and makes Stream return ErrAborted. If the pen was
+// moving, GRBL may lose its position and need unlocking with $X.
+func (p *Plotter) Abort() error {
+	return p.realtime(softReset, func() { p.aborted = true })
+}
+
+// realtime sends a realtime command, between lines, updates the state
+// Stream follows and wakes it to see the change.
+func (p *Plotter) realtime(cmd string, update func()) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	update()
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+	if _, err := io.WriteString(p.port, cmd); err != nil {
+		return fmt.Errorf("plotter: %w", err)
+	}
+	return nil
+}
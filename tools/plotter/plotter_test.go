@@ -0,0 +1,248 @@
+package plotter
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGRBL answers a Plotter over a pipe as GRBL would: "ok" to each line,
+// after a moment to draw it, or the reply in reject. It records what it
+// receives, and how much was ever waiting in its buffer.
+type fakeGRBL struct {
+	conn   net.Conn
+	reject map[int]string // replies to lines other than "ok", by number from 1
+
+	mu          sync.Mutex
+	received    []string
+	processed   int
+	realtime    string
+	inflight    int
+	maxInflight int
+}
+
+func newFake(t *testing.T, reject map[int]string) (*Plotter, *fakeGRBL) {
+	t.Helper()
+	ours, theirs := net.Pipe()
+	t.Cleanup(func() { ours.Close(); theirs.Close() })
+	g := &fakeGRBL{conn: theirs, reject: reject}
+	go g.run()
+	return New(ours), g
+}
+
+func (g *fakeGRBL) run() {
+	queue := make(chan string, 64)
+	go func() {
+		for line := range queue {
+			time.Sleep(200 * time.Microsecond)
+			g.mu.Lock()
+			g.inflight -= len(line) + 1
+			g.processed++
+			reply := g.reject[g.processed]
+			g.mu.Unlock()
+			if reply == "" {
+				reply = "ok"
+			}
+			fmt.Fprintf(g.conn, "%s\r\n", reply)
+		}
+	}()
+	defer close(queue)
+
+	r := bufio.NewReader(g.conn)
+	var line []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		g.mu.Lock()
+		switch c {
+		case '!', '~', 0x18:
+			g.realtime += string(c)
+		case '\n':
+			g.received = append(g.received, string(line))
+			g.inflight += len(line) + 1
+			g.maxInflight = max(g.maxInflight, g.inflight)
+			queue <- string(line)
+			line = nil
+		default:
+			line = append(line, c)
+		}
+		g.mu.Unlock()
+	}
+}
+
+func (g *fakeGRBL) state() (received []string, realtime string, maxInflight int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return slices.Clone(g.received), g.realtime, g.maxInflight
+}
+
+func program(n int) []string {
+	var lines []string
+	for i := range n {
+		lines = append(lines, fmt.Sprintf("G1 X%d.000 Y%d.000", i, 2*i))
+	}
+	return lines
+}
+
+func TestStream(t *testing.T) {
+	p, g := newFake(t, nil)
+	var last Progress
+	p.Progress = func(pr Progress) { last = pr }
+
+	lines := program(200)
+	if err := p.Stream(context.Background(), lines); err != nil {
+		t.Fatal(err)
+	}
+	received, _, maxInflight := g.state()
+	if !slices.Equal(received, lines) {
+		t.Errorf("GRBL received %d lines, not the %d sent in order", len(received), len(lines))
+	}
+	if maxInflight > RXBuffer {
+		t.Errorf("%d bytes waiting in GRBL's buffer, over its %d", maxInflight, RXBuffer)
+	}
+	if maxInflight <= len(lines[0])+1 {
+		t.Errorf("at most %d bytes waiting: lines were not sent ahead", maxInflight)
+	}
+	if last != (Progress{Sent: 200, Done: 200, Total: 200}) {
+		t.Errorf("last progress = %+v", last)
+	}
+}
+
+func TestStreamSmallBuffer(t *testing.T) {
+	p, g := newFake(t, nil)
+	p.Buffer = 40
+	if err := p.Stream(context.Background(), program(50)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, maxInflight := g.state(); maxInflight > 40 {
+		t.Errorf("%d bytes waiting in a 40-byte buffer", maxInflight)
+	}
+	if err := p.Stream(context.Background(), []string{"G1 X1 Y1 ;" + strings.Repeat("-", 40)}); err == nil {
+		t.Error("a line longer than the buffer was sent")
+	}
+}
+
+func TestStreamLineError(t *testing.T) {
+	p, g := newFake(t, map[int]string{5: "error:20"})
+	lines := program(100)
+	err := p.Stream(context.Background(), lines)
+
+	var lineErr *LineError
+	if !errors.As(err, &lineErr) {
+		t.Fatalf("err = %v, want a LineError", err)
+	}
+	if lineErr.Line != 5 || lineErr.Text != lines[4] || lineErr.Reply != "error:20" {
+		t.Errorf("err = %+v", lineErr)
+	}
+	if received, _, _ := g.state(); len(received) == len(lines) {
+		t.Error("streaming carried on after the error")
+	}
+}
+
+func TestStreamAlarm(t *testing.T) {
+	p, _ := newFake(t, map[int]string{3: "ALARM:1"})
+	var alarm *AlarmError
+	if err := p.Stream(context.Background(), program(20)); !errors.As(err, &alarm) || alarm.Reply != "ALARM:1" {
+		t.Errorf("err = %v, want ALARM:1", err)
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	p, g := newFake(t, nil)
+	paused := make(chan struct{})
+	p.Progress = func(pr Progress) {
+		if pr.Done == 10 {
+			p.Pause()
+			close(paused)
+		}
+	}
+	lines := program(100)
+	result := make(chan error)
+	go func() { result <- p.Stream(context.Background(), lines) }()
+
+	<-paused
+	time.Sleep(20 * time.Millisecond)
+	before, _, _ := g.state()
+	time.Sleep(20 * time.Millisecond)
+	after, realtime, _ := g.state()
+	if len(after) != len(before) || len(after) == len(lines) {
+		t.Errorf("lines were sent while paused: %d, then %d", len(before), len(after))
+	}
+	if realtime != "!" {
+		t.Errorf("realtime commands %q, want a feed hold", realtime)
+	}
+
+	p.Resume()
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+	received, realtime, _ := g.state()
+	if !slices.Equal(received, lines) || realtime != "!~" {
+		t.Errorf("after resuming, %d lines and realtime commands %q", len(received), realtime)
+	}
+}
+
+func TestAbort(t *testing.T) {
+	p, g := newFake(t, nil)
+	p.Progress = func(pr Progress) {
+		if pr.Done == 10 {
+			p.Abort()
+		}
+	}
+	if err := p.Stream(context.Background(), program(100)); !errors.Is(err, ErrAborted) {
+		t.Errorf("err = %v, want ErrAborted", err)
+	}
+	if _, realtime, _ := g.state(); realtime != "\x18" {
+		t.Errorf("realtime commands %q, want a soft reset", realtime)
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	p, g := newFake(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	p.Progress = func(pr Progress) {
+		if pr.Done == 10 {
+			cancel()
+		}
+	}
+	if err := p.Stream(ctx, program(100)); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if _, realtime, _ := g.state(); realtime != "\x18" {
+		t.Errorf("realtime commands %q, want a soft reset", realtime)
+	}
+}
+
+func TestWait(t *testing.T) {
+	ours, theirs := net.Pipe()
+	defer ours.Close()
+	defer theirs.Close()
+	p := New(ours)
+	go fmt.Fprint(theirs, "\r\n[MSG:'$H'|'$X' to unlock]\r\nGrbl 1.1h ['$' for help]\r\n")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	banner, err := p.Wait(ctx)
+	if err != nil || banner != "Grbl 1.1h ['$' for help]" {
+		t.Errorf("Wait() = %q, %v", banner, err)
+	}
+}
+
+func TestLines(t *testing.T) {
+	got, err := Lines(strings.NewReader("; Generated\nG21\n\nG0 X1 Y2 ; travel\n(comment) G1 X3 (more) Y4\n  M5  \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"G21", "G0 X1 Y2", "G1 X3  Y4", "M5"}; !slices.Equal(got, want) {
+		t.Errorf("Lines() = %q, want %q", got, want)
+	}
+}
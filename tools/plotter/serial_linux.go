@@ -0,0 +1,55 @@
+//go:build linux
+
+package plotter
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var baudRates = map[int]uint32{
+	9600:   syscall.B9600,
+	19200:  syscall.B19200,
+	38400:  syscall.B38400,
+	57600:  syscall.B57600,
+	115200: syscall.B115200,
+	230400: syscall.B230400,
+}
+
+// OpenSerial opens the serial port at path, such as /dev/ttyUSB0, raw at
+// baud with 8 data bits, no parity and no flow control, as GRBL expects.
+func OpenSerial(path string, baud int) (*os.File, error) {
+	rate, ok := baudRates[baud]
+	if !ok {
+		return nil, fmt.Errorf("unsupported baud rate %d", baud)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Raw mode: no echo, line editing, signals or translation of
+	// line endings, and reads return as soon as a byte arrives.
+	t := syscall.Termios{Cflag: syscall.CS8 | syscall.CREAD | syscall.CLOCAL | rate}
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+
+	conn, err := f.SyscallConn()
+	if err == nil {
+		ctlErr := conn.Control(func(fd uintptr) {
+			if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCSETS, uintptr(unsafe.Pointer(&t))); errno != 0 {
+				err = errno
+			}
+		})
+		if err == nil {
+			err = ctlErr
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("configure %s: %w", path, err)
+	}
+	return f, nil
+}
@@ -0,0 +1,74 @@
+//go:build linux
+
+package plotter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func ioctl(f *os.File, req uint, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), uintptr(req), uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// pty opens a pseudo-terminal, whose other end stands in for a serial
+// port, and returns its controlling end and the path of the other.
+func pty(t *testing.T) (*os.File, string) {
+	t.Helper()
+	ptmx, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminals: %v", err)
+	}
+	t.Cleanup(func() { ptmx.Close() })
+	var n, unlock uint32
+	if err := ioctl(ptmx, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		t.Skipf("unlock pseudo-terminal: %v", err)
+	}
+	if err := ioctl(ptmx, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		t.Skipf("pseudo-terminal number: %v", err)
+	}
+	return ptmx, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func TestOpenSerial(t *testing.T) {
+	ptmx, path := pty(t)
+	f, err := OpenSerial(path, 115200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var term syscall.Termios
+	if err := ioctl(f, syscall.TCGETS, unsafe.Pointer(&term)); err != nil {
+		t.Fatal(err)
+	}
+	if term.Lflag&(syscall.ICANON|syscall.ECHO) != 0 || term.Iflag&syscall.ICRNL != 0 || term.Oflag&syscall.OPOST != 0 {
+		t.Errorf("port not raw: %+v", term)
+	}
+	if term.Cflag&syscall.CSIZE != syscall.CS8 || term.Cflag&syscall.B115200 != syscall.B115200 {
+		t.Errorf("port not 8 bits at 115200 baud: cflag %#o", term.Cflag)
+	}
+
+	// GRBL's replies come through as sent.
+	p := New(f)
+	go fmt.Fprint(ptmx, "Grbl 1.1h ['$' for help]\r\n")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if banner, err := p.Wait(ctx); err != nil || banner != "Grbl 1.1h ['$' for help]" {
+		t.Errorf("Wait() = %q, %v", banner, err)
+	}
+}
+
+func TestOpenSerialBaud(t *testing.T) {
+	if _, err := OpenSerial("/dev/null", 12345); err == nil {
+		t.Error("opened at an unsupported baud rate")
+	}
+}
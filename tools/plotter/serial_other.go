@@ -0,0 +1,14 @@
+//go:build !linux
+
+package plotter
+
+import (
+	"errors"
+	"os"
+)
+
+// OpenSerial is only implemented for Linux, where the port can be set up
+// with the standard library alone.
+func OpenSerial(path string, baud int) (*os.File, error) {
+	return nil, errors.New("opening serial ports is only supported on Linux")
+}
@@ -0,0 +1,177 @@
+package sketchlang
+
+// Type is a SketchLang value type.
+type Type int
+
+const (
+	Invalid Type = iota // a value whose type could not be worked out
+	Number
+	Vec
+	Sketch
+)
+
+var typeNames = map[string]Type{"number": Number, "vec": Vec, "sketch": Sketch}
+
+func (t Type) String() string {
+	for name, tt := range typeNames {
+		if tt == t {
+			return name
+		}
+	}
+	return "invalid"
+}
+
+// Program is a parsed SketchLang file.
+type Program struct {
+	Stmts    []Stmt
+	Comments []Comment
+}
+
+// Node is any part of the syntax tree.
+type Node interface {
+	Pos() Pos
+}
+
+// Stmt is a let or render statement.
+type Stmt interface {
+	Node
+	stmt()
+}
+
+// Expr is an expression.
+type Expr interface {
+	Node
+	expr()
+}
+
+// Let is "let Name : Type = Value".
+type Let struct {
+	Start   Pos
+	End     Pos // of the last token
+	Name    string
+	NamePos Pos
+	Type    Type
+	Value   Expr
+}
+
+// Render is "trace|draw|scribble Value".
+type Render struct {
+	Start Pos
+	End   Pos    // of the last token
+	Mode  string // trace, draw or scribble
+	Value Expr
+}
+
+// NumberLit is a number literal.
+type NumberLit struct {
+	Start Pos
+	Text  string
+}
+
+// Origin is the vector (0, 0).
+type Origin struct {
+	Start Pos
+}
+
+// Ident is a reference to a let.
+type Ident struct {
+	Start Pos
+	Name  string
+}
+
+// VecLit is "(X, Y)".
+type VecLit struct {
+	Start Pos
+	X, Y  Expr
+}
+
+// Paren is a parenthesized expression.
+type Paren struct {
+	Start Pos
+	X     Expr
+}
+
+// List is "[Items...]".
+type List struct {
+	Start Pos
+	End   Pos // of the ]
+	Items []Expr
+}
+
+// Unary is a negation.
+type Unary struct {
+	Start Pos
+	Op    string
+	X     Expr
+}
+
+// Binary is X Op Y, for Op one of + - * /.
+type Binary struct {
+	Op    string
+	OpPos Pos
+	X, Y  Expr
+}
+
+// Dot is "dot at Point".
+type Dot struct {
+	Start Pos
+	Point Expr
+}
+
+// Dash is "dash at Point".
+type Dash struct {
+	Start Pos
+	Point Expr
+}
+
+// Stroke is "stroke from From to To", with "via [...]" for a spline.
+type Stroke struct {
+	Start    Pos
+	From, To Expr
+	Via      *List // nil for a straight stroke
+}
+
+// Center is "center of Of".
+type Center struct {
+	Start Pos
+	Of    Expr
+}
+
+// Flow is "flow at Point".
+type Flow struct {
+	Start Pos
+	Point Expr
+}
+
+func (n *Let) Pos() Pos       { return n.Start }
+func (n *Render) Pos() Pos    { return n.Start }
+func (n *NumberLit) Pos() Pos { return n.Start }
+func (n *Origin) Pos() Pos    { return n.Start }
+func (n *Ident) Pos() Pos     { return n.Start }
+func (n *VecLit) Pos() Pos    { return n.Start }
+func (n *Paren) Pos() Pos     { return n.Start }
+func (n *List) Pos() Pos      { return n.Start }
+func (n *Unary) Pos() Pos     { return n.Start }
+func (n *Binary) Pos() Pos    { return n.X.Pos() }
+func (n *Dot) Pos() Pos       { return n.Start }
+func (n *Dash) Pos() Pos      { return n.Start }
+func (n *Stroke) Pos() Pos    { return n.Start }
+func (n *Center) Pos() Pos    { return n.Start }
+func (n *Flow) Pos() Pos      { return n.Start }
+
+func (*Let) stmt()    {}
+func (*Render) stmt() {}
+
+func (*NumberLit) expr() {}
+func (*Origin) expr()    {}
+func (*Ident) expr()     {}
+func (*VecLit) expr()    {}
+func (*Paren) expr()     {}
+func (*List) expr()      {}
+func (*Unary) expr()     {}
+func (*Binary) expr()    {}
+func (*Dot) expr()       {}
+func (*Dash) expr()      {}
+func (*Stroke) expr()    {}
+func (*Center) expr()    {}
+func (*Flow) expr()      {}
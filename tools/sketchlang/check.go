@@ -0,0 +1,191 @@
+package sketchlang
+
+import "fmt"
+
+// Check finds the errors in a parsed program that are not syntax errors:
+// names used before they are declared and values of the wrong type.
+func Check(prog *Program) ErrorList {
+	return check(prog).errs
+}
+
+func check(prog *Program) *checker {
+	c := &checker{decls: map[string]*Let{}, bindings: map[*Ident]*Let{}}
+	for _, s := range prog.Stmts {
+		switch s := s.(type) {
+		case *Let:
+			if t := c.typeOf(s.Value); t != Invalid && t != s.Type {
+				c.errorf(s.Value.Pos(), "%s is declared %s but its value is a %s", s.Name, s.Type, t)
+			}
+			c.decls[s.Name] = s
+		case *Render:
+			c.want(s.Value, Sketch, s.Mode)
+		}
+	}
+	return c
+}
+
+// Reassignments finds names declared again with a different value.
+// SketchLang has no reassignment and prompts forbid it, but the compiler
+// lets a later let replace an earlier one, so these are not compile
+// errors: they are usually a section redrawing something already drawn.
+// Repeating a declaration word for word is not reported.
+func Reassignments(prog *Program) ErrorList {
+	var errs ErrorList
+	decls := map[string]*Let{}
+	for _, s := range prog.Stmts {
+		let, ok := s.(*Let)
+		if !ok {
+			continue
+		}
+		if prev, ok := decls[let.Name]; ok && (prev.Type != let.Type || source(prev.Value) != source(let.Value)) {
+			errs = append(errs, &Error{let.NamePos, fmt.Sprintf("%s is already declared on line %d", let.Name, prev.Start.Line), RuleReassignment, "give the new value its own name"})
+		}
+		decls[let.Name] = let
+	}
+	return errs
+}
+
+// Validate parses and checks src, returning every error the compiler
+// would reject it for.
+func Validate(src string) ErrorList {
+	prog, err := Parse(src)
+	if err != nil {
+		return err.(ErrorList)
+	}
+	return Check(prog)
+}
+
+type checker struct {
+	decls    map[string]*Let
+	bindings map[*Ident]*Let // the let each name refers to
+	errs     ErrorList
+}
+
+func (c *checker) errorf(pos Pos, format string, args ...any) {
+	c.errs = append(c.errs, &Error{pos, fmt.Sprintf(format, args...), RuleType, ""})
+}
+
+// want checks that x is a t, as what needs it.
+func (c *checker) want(x Expr, t Type, what string) {
+	if got := c.typeOf(x); got != Invalid && got != t {
+		c.errorf(x.Pos(), "%s takes a %s, not a %s", what, t, got)
+	}
+}
+
+// typeOf works out the type of x, reporting errors inside it. It returns
+// Invalid after an error so that one mistake is reported once.
+func (c *checker) typeOf(x Expr) Type {
+	switch x := x.(type) {
+	case *NumberLit:
+		return Number
+	case *Origin:
+		return Vec
+	case *Ident:
+		d, ok := c.decls[x.Name]
+		if !ok {
+			suggestion := "declare it with let before this line"
+			if near := c.nearest(x.Name); near != "" {
+				suggestion = fmt.Sprintf("did you mean %s?", near)
+			}
+			c.errs = append(c.errs, &Error{x.Start, fmt.Sprintf("%s is not declared", x.Name), RuleUndeclared, suggestion})
+			return Invalid
+		}
+		c.bindings[x] = d
+		return d.Type
+	case *VecLit:
+		c.want(x.X, Number, "a vector component")
+		c.want(x.Y, Number, "a vector component")
+		return Vec
+	case *Paren:
+		return c.typeOf(x.X)
+	case *List:
+		for _, item := range x.Items {
+			c.want(item, Sketch, "a list")
+		}
+		return Sketch
+	case *Unary:
+		t := c.typeOf(x.X)
+		if t == Sketch {
+			c.errorf(x.Start, "cannot negate a sketch")
+			return Invalid
+		}
+		return t
+	case *Binary:
+		return c.binary(x)
+	case *Dot:
+		c.want(x.Point, Vec, "dot at")
+		return Sketch
+	case *Dash:
+		c.want(x.Point, Vec, "dash at")
+		return Sketch
+	case *Stroke:
+		c.want(x.From, Vec, "stroke from")
+		c.want(x.To, Vec, "stroke to")
+		if x.Via != nil {
+			for _, p := range x.Via.Items {
+				c.want(p, Vec, "via")
+			}
+		}
+		return Sketch
+	case *Center:
+		c.want(x.Of, Sketch, "center of")
+		return Vec
+	case *Flow:
+		c.want(x.Point, Vec, "flow at")
+		return Vec
+	}
+	return Invalid
+}
+
+// nearest is the declared name closest to a misspelt name, "" if none is
+// close.
+func (c *checker) nearest(name string) string {
+	best, bestDist := "", len(name)/3+1
+	for d := range c.decls {
+		if dist := editDistance(name, d); dist < bestDist || dist == bestDist && best != "" && d < best {
+			best, bestDist = d, dist
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// binary types arithmetic: numbers with numbers, vectors added to and
+// subtracted from vectors, and vectors scaled by numbers.
+func (c *checker) binary(x *Binary) Type {
+	l, r := c.typeOf(x.X), c.typeOf(x.Y)
+	if l == Invalid || r == Invalid {
+		return Invalid
+	}
+	switch {
+	case l == Number && r == Number:
+		return Number
+	case l == Vec && r == Vec && (x.Op == "+" || x.Op == "-"):
+		return Vec
+	case l == Vec && r == Number && (x.Op == "*" || x.Op == "/"):
+		return Vec
+	case l == Number && r == Vec && x.Op == "*":
+		return Vec
+	}
+	c.errorf(x.OpPos, "cannot use %s between a %s and a %s", x.Op, l, r)
+	return Invalid
+}
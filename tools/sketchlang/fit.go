@@ -0,0 +1,157 @@
+package sketchlang
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Fit scales and moves everything src draws to fit the canvas inside its
+// margin, centred, if any of it falls outside. It returns false, with src
+// as it is, if the drawing already fits or draws nothing. Drawings are
+// never scaled up.
+//
+// The code is rewritten where points are drawn: each point P becomes
+// (P) * k + (dx, dy), and each center of is mapped back, so points worked
+// out from a centroid are not moved twice. The strokes and the centres of
+// dots and dashes are fitted; dots, dashes and the wobble of draw and
+// scribble keep their size. The result is laid out as Format lays it out,
+// statements in place.
+func Fit(src string, canvas Canvas) (string, bool, error) {
+	drawn, err := draw(src)
+	if err != nil {
+		return "", false, err
+	}
+	lo, hi, ok := bounds(drawn)
+	m := canvas.Margin - fitTolerance
+	if !ok || lo.X >= m && lo.Y >= m && hi.X <= canvas.Width-m && hi.Y <= canvas.Height-m {
+		return src, false, nil
+	}
+	m = canvas.Margin
+	w, h := canvas.Width-2*m, canvas.Height-2*m
+	if w <= 0 || h <= 0 {
+		return "", false, errors.New("fit: the margin leaves no room to draw")
+	}
+
+	k := math.Min(1, math.Min(w/math.Max(hi.X-lo.X, 1e-9), h/math.Max(hi.Y-lo.Y, 1e-9)))
+	k = math.Floor(k*1e4) / 1e4
+	f := &fitter{
+		k: k,
+		offset: Point{
+			math.Round((canvas.Width/2-k*(lo.X+hi.X)/2)*100) / 100,
+			math.Round((canvas.Height/2-k*(lo.Y+hi.Y)/2)*100) / 100,
+		},
+	}
+
+	prog, err := Parse(src)
+	if err != nil {
+		return "", false, err
+	}
+	for _, s := range prog.Stmts {
+		switch s := s.(type) {
+		case *Let:
+			s.Value = f.expr(s.Value)
+		case *Render:
+			s.Value = f.expr(s.Value)
+		}
+	}
+	out := layout(prog, prog.Stmts, false)
+	if _, err := draw(out); err != nil {
+		return "", false, fmt.Errorf("fit: rewritten code does not compile: %w", err)
+	}
+	return out, true, nil
+}
+
+// fitTolerance is how far, in mm, a drawing may stray into the margin
+// before Fit moves it, to allow for the rounding of an earlier fit.
+const fitTolerance = 0.01
+
+// bounds is the box around the strokes and the centres of the dots and
+// dashes drawn, false if there are none.
+func bounds(drawn []drawn) (lo, hi Point, ok bool) {
+	lo, hi = Point{math.Inf(1), math.Inf(1)}, Point{math.Inf(-1), math.Inf(-1)}
+	for _, d := range drawn {
+		points := d.path
+		if len(d.points) == 1 {
+			points = d.points
+		}
+		for _, pt := range points {
+			lo = Point{math.Min(lo.X, pt.X), math.Min(lo.Y, pt.Y)}
+			hi = Point{math.Max(hi.X, pt.X), math.Max(hi.Y, pt.Y)}
+			ok = true
+		}
+	}
+	return lo, hi, ok
+}
+
+// fitter rewrites expressions to draw scaled by k and moved by offset.
+type fitter struct {
+	k      float64
+	offset Point
+}
+
+func (f *fitter) expr(x Expr) Expr {
+	switch x := x.(type) {
+	case *Paren:
+		x.X = f.expr(x.X)
+	case *List:
+		for i, item := range x.Items {
+			x.Items[i] = f.expr(item)
+		}
+	case *Unary:
+		x.X = f.expr(x.X)
+	case *Binary:
+		x.X, x.Y = f.expr(x.X), f.expr(x.Y)
+	case *Dot:
+		x.Point = f.point(f.expr(x.Point))
+	case *Dash:
+		x.Point = f.point(f.expr(x.Point))
+	case *Stroke:
+		x.From, x.To = f.point(f.expr(x.From)), f.point(f.expr(x.To))
+		if x.Via != nil {
+			for i, item := range x.Via.Items {
+				x.Via.Items[i] = f.point(f.expr(item))
+			}
+		}
+	case *Center:
+		x.Of = f.expr(x.Of)
+		return f.unfit(x)
+	case *Flow:
+		x.Point = f.expr(x.Point)
+	}
+	return x
+}
+
+// point is p * k + offset.
+func (f *fitter) point(p Expr) Expr {
+	at := p.Pos()
+	if f.k != 1 {
+		p = &Binary{Op: "*", OpPos: at, X: paren(p), Y: numberLit(f.k, at)}
+	}
+	return &Binary{Op: "+", OpPos: at, X: p, Y: &VecLit{Start: at, X: numberLit(f.offset.X, at), Y: numberLit(f.offset.Y, at)}}
+}
+
+// unfit is ((c - offset) / k), taking a centroid of fitted points back to
+// where it was before.
+func (f *fitter) unfit(c *Center) Expr {
+	at := c.Start
+	var x Expr = &Binary{Op: "-", OpPos: at, X: c, Y: &VecLit{Start: at, X: numberLit(f.offset.X, at), Y: numberLit(f.offset.Y, at)}}
+	if f.k != 1 {
+		x = &Binary{Op: "/", OpPos: at, X: &Paren{Start: at, X: x}, Y: numberLit(f.k, at)}
+	}
+	return &Paren{Start: at, X: x}
+}
+
+// paren puts x in parentheses unless it is a single term.
+func paren(x Expr) Expr {
+	switch x.(type) {
+	case *Ident, *VecLit, *Origin, *Paren, *NumberLit:
+		return x
+	}
+	return &Paren{Start: x.Pos(), X: x}
+}
+
+func numberLit(v float64, at Pos) Expr {
+	return &NumberLit{Start: at, Text: strconv.FormatFloat(v, 'f', -1, 64)}
+}
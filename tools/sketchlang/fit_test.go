@@ -0,0 +1,73 @@
+package sketchlang
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFit(t *testing.T) {
+	canvas := Canvas{Width: 100, Height: 100, Margin: 5}
+	tests := []struct {
+		name   string
+		src    string
+		fitted bool
+		lo, hi Point // the box around what the fitted code draws
+	}{
+		{
+			name: "fits",
+			src:  "trace stroke from (10, 10) to (90, 90)\n",
+			lo:   Point{10, 10}, hi: Point{90, 90},
+		},
+		{
+			name:   "too big",
+			src:    "trace stroke from (0, 0) to (180, 90)\n",
+			fitted: true,
+			lo:     Point{5, 27.5}, hi: Point{95, 72.5},
+		},
+		{
+			name:   "off the canvas",
+			src:    "let p : vec = (110, 40)\ntrace [stroke from p to p + (20, 20), dot at p + (10, 0)]\n",
+			fitted: true,
+			lo:     Point{40, 40}, hi: Point{60, 60},
+		},
+		{
+			name:   "centre kept",
+			src:    "let s : sketch = [stroke from (-20, 0) to (20, 0), stroke from (0, -20) to (0, 20)]\ntrace s\ntrace dot at center of s + (0, 10)\n",
+			fitted: true,
+			lo:     Point{30, 30}, hi: Point{70, 70},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, fitted, err := Fit(tt.src, canvas)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fitted != tt.fitted || !fitted && got != tt.src {
+				t.Fatalf("Fit() = %v:\n%s", fitted, got)
+			}
+			if again, refit, err := Fit(got, canvas); err != nil || refit || again != got {
+				t.Errorf("fitted code fitted again: %v, %v", refit, err)
+			}
+			drawn, err := draw(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lo, hi, _ := bounds(drawn)
+			if !near(lo, tt.lo) || !near(hi, tt.hi) {
+				t.Errorf("fitted drawing spans %v to %v, want %v to %v\n%s", lo, hi, tt.lo, tt.hi, got)
+			}
+		})
+	}
+
+	if got, fitted, err := Fit("let a : vec = (500, 500)\n", canvas); err != nil || fitted || got != "let a : vec = (500, 500)\n" {
+		t.Errorf("Fit() of code that draws nothing = %q, %v, %v", got, fitted, err)
+	}
+	if _, _, err := Fit("trace dot at (500, 500)\n", Canvas{Width: 10, Height: 10, Margin: 5}); err == nil {
+		t.Error("Fit() into a canvas that is all margin: no error")
+	}
+}
+
+func near(a, b Point) bool {
+	return math.Abs(a.X-b.X) < 0.01 && math.Abs(a.Y-b.Y) < 0.01
+}
@@ -0,0 +1,368 @@
+package sketchlang
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Format lays out src in one style: single spaces around operators and
+// after commas, lists that spanned lines one item per line indented two
+// spaces, runs of one-line lets aligned on their colons and equals signs,
+// and render statements moved after all the lets. Comments and single
+// blank lines are kept.
+//
+// Render statements stay in place if moving them would change what they
+// draw, which only happens when a name they use is declared again later.
+func Format(src string) (string, error) {
+	prog, err := Parse(src)
+	if err != nil {
+		return "", err
+	}
+	stmts, moved := order(prog)
+	out := layout(prog, stmts, moved)
+
+	// The layout must not change the program.
+	again, err := Parse(out)
+	if err != nil || len(again.Stmts) != len(stmts) || len(again.Comments) != len(prog.Comments) {
+		return "", errors.New("format: formatted code does not match the original")
+	}
+	for i, s := range again.Stmts {
+		if stmtSource(s) != stmtSource(stmts[i]) {
+			return "", fmt.Errorf("format: line %d changed", s.Pos().Line)
+		}
+	}
+	return out, nil
+}
+
+// layout prints stmts, the statements of prog in the order to print them,
+// with the comments of prog. moved separates renders that were moved after
+// the lets from them with a blank line.
+func layout(prog *Program, stmts []Stmt, moved bool) string {
+	p := &printer{}
+	byStmt, trailer := assignComments(prog)
+	headers := letHeaders(stmts, byStmt)
+	for i, s := range stmts {
+		if moved && i > 0 && isRender(s) && !isRender(stmts[i-1]) {
+			p.blank()
+		}
+		p.comments = byStmt[s]
+		switch s := s.(type) {
+		case *Let:
+			p.stmt(s, headers[s], s.Value, s.End)
+		case *Render:
+			p.stmt(s, s.Mode+" ", s.Value, s.End)
+		}
+	}
+	p.comments = trailer
+	p.leading(Pos{Line: 1 << 30}, 0)
+	return p.String()
+}
+
+// order puts render statements after the lets, unless that would make one
+// refer to a later declaration of a name. It reports whether any moved.
+func order(prog *Program) ([]Stmt, bool) {
+	bindings := check(prog).bindings
+	last := map[string]*Let{}
+	var lets, renders []Stmt
+	for _, s := range prog.Stmts {
+		if let, ok := s.(*Let); ok {
+			last[let.Name] = let
+			lets = append(lets, s)
+		} else {
+			renders = append(renders, s)
+		}
+	}
+
+	for _, r := range renders {
+		safe := true
+		inspect(r.(*Render).Value, func(x Expr) {
+			if id, ok := x.(*Ident); ok && bindings[id] != nil && bindings[id] != last[id.Name] {
+				safe = false
+			}
+		})
+		if !safe {
+			return prog.Stmts, false
+		}
+	}
+	ordered := append(lets, renders...)
+	for i, s := range ordered {
+		if s != prog.Stmts[i] {
+			return ordered, true
+		}
+	}
+	return ordered, false
+}
+
+// inspect calls f for x and every expression inside it.
+func inspect(x Expr, f func(Expr)) {
+	f(x)
+	switch x := x.(type) {
+	case *VecLit:
+		inspect(x.X, f)
+		inspect(x.Y, f)
+	case *Paren:
+		inspect(x.X, f)
+	case *List:
+		for _, item := range x.Items {
+			inspect(item, f)
+		}
+	case *Unary:
+		inspect(x.X, f)
+	case *Binary:
+		inspect(x.X, f)
+		inspect(x.Y, f)
+	case *Dot:
+		inspect(x.Point, f)
+	case *Dash:
+		inspect(x.Point, f)
+	case *Stroke:
+		inspect(x.From, f)
+		inspect(x.To, f)
+		if x.Via != nil {
+			inspect(x.Via, f)
+		}
+	case *Center:
+		inspect(x.Of, f)
+	case *Flow:
+		inspect(x.Point, f)
+	}
+}
+
+// assignComments gives each statement the comments from the end of the
+// one before it to the end of its own last line, and returns the comments
+// after the last statement.
+func assignComments(prog *Program) (map[Stmt][]Comment, []Comment) {
+	byStmt := map[Stmt][]Comment{}
+	comments := prog.Comments
+	for _, s := range prog.Stmts {
+		end := stmtEnd(s).Line
+		n := 0
+		for n < len(comments) && comments[n].Start.Line <= end {
+			n++
+		}
+		byStmt[s], comments = comments[:n], comments[n:]
+	}
+	return byStmt, comments
+}
+
+// letHeaders is the "let name : type = " of each let, padded to align
+// with the lets around it that each fit on one line.
+func letHeaders(stmts []Stmt, comments map[Stmt][]Comment) map[*Let]string {
+	headers := map[*Let]string{}
+	var run []*Let
+	flush := func() {
+		name, typ := 0, 0
+		for _, l := range run {
+			name, typ = max(name, len(l.Name)), max(typ, len(l.Type.String()))
+		}
+		for _, l := range run {
+			headers[l] = fmt.Sprintf("let %-*s : %-*s = ", name, l.Name, typ, l.Type)
+		}
+		run = nil
+	}
+
+	for _, s := range stmts {
+		l, ok := s.(*Let)
+		if !ok || l.Start.Line != l.End.Line || len(comments[s]) > 0 && comments[s][0].Start.Line < l.Start.Line {
+			flush()
+		} else if len(run) > 0 && l.Start.Line != run[len(run)-1].End.Line+1 {
+			flush()
+		}
+		if !ok {
+			continue
+		}
+		if l.Start.Line != l.End.Line {
+			headers[l] = fmt.Sprintf("let %s : %s = ", l.Name, l.Type)
+			continue
+		}
+		run = append(run, l)
+	}
+	flush()
+	return headers
+}
+
+type printer struct {
+	lines    []string
+	line     string
+	comments []Comment // yet to print, for the statement being printed
+	last     int       // the source line printed last
+}
+
+func (p *printer) String() string {
+	p.newline(0)
+	for len(p.lines) > 0 && p.lines[len(p.lines)-1] == "" {
+		p.lines = p.lines[:len(p.lines)-1]
+	}
+	return strings.Join(p.lines, "\n") + "\n"
+}
+
+func (p *printer) write(s string) { p.line += s }
+
+// newline ends the line being written, if it has anything on it, and
+// starts one at indent.
+func (p *printer) newline(indent int) {
+	if strings.TrimSpace(p.line) != "" {
+		p.lines = append(p.lines, strings.TrimRight(p.line, " "))
+	}
+	p.line = strings.Repeat("  ", indent)
+}
+
+// blank adds a blank line, unless there is one already.
+func (p *printer) blank() {
+	p.newline(0)
+	if len(p.lines) > 0 && p.lines[len(p.lines)-1] != "" {
+		p.lines = append(p.lines, "")
+	}
+}
+
+// gap keeps a blank line from the source before line.
+func (p *printer) gap(line int) {
+	if p.last > 0 && line > p.last+1 {
+		p.blank()
+	}
+}
+
+// leading prints the comments before pos: those on lines of their own
+// above it, and those ending the line being written.
+func (p *printer) leading(pos Pos, indent int) {
+	for len(p.comments) > 0 && before(p.comments[0].Start, pos) {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		if !c.alone && strings.TrimSpace(p.line) != "" {
+			p.write(" " + c.Text)
+			p.newline(indent)
+			continue
+		}
+		p.newline(indent)
+		if indent == 0 {
+			p.gap(c.Start.Line)
+		}
+		p.write(c.Text)
+		p.newline(indent)
+		p.last = c.Start.Line
+	}
+}
+
+func (p *printer) stmt(s Stmt, header string, value Expr, end Pos) {
+	p.leading(s.Pos(), 0)
+	p.newline(0)
+	p.gap(s.Pos().Line)
+	p.write(header)
+	p.expr(value, 0)
+	p.last = end.Line
+
+	// A comment at the end of the statement's line stays there; any left
+	// inside it, in parentheses across lines, goes after it.
+	for i, c := range p.comments {
+		if i == 0 && !c.alone && c.Start.Line == end.Line {
+			p.write(" " + c.Text)
+		} else {
+			p.newline(0)
+			p.write(c.Text)
+		}
+		p.last = max(p.last, c.Start.Line)
+	}
+	p.comments = nil
+	p.newline(0)
+}
+
+func (p *printer) expr(x Expr, indent int) {
+	switch x := x.(type) {
+	case *VecLit:
+		p.write("(")
+		p.expr(x.X, indent)
+		p.write(", ")
+		p.expr(x.Y, indent)
+		p.write(")")
+	case *Paren:
+		p.write("(")
+		p.expr(x.X, indent)
+		p.write(")")
+	case *List:
+		p.list(x, indent)
+	case *Unary:
+		p.write(x.Op)
+		p.expr(x.X, indent)
+	case *Binary:
+		p.expr(x.X, indent)
+		p.write(" " + x.Op + " ")
+		p.expr(x.Y, indent)
+	case *Dot:
+		p.write("dot at ")
+		p.expr(x.Point, indent)
+	case *Dash:
+		p.write("dash at ")
+		p.expr(x.Point, indent)
+	case *Stroke:
+		p.write("stroke from ")
+		p.expr(x.From, indent)
+		p.write(" to ")
+		p.expr(x.To, indent)
+		if x.Via != nil {
+			p.write(" via ")
+			p.list(x.Via, indent)
+		}
+	case *Center:
+		p.write("center of ")
+		p.expr(x.Of, indent)
+	case *Flow:
+		p.write("flow at ")
+		p.expr(x.Point, indent)
+	default:
+		p.write(source(x))
+	}
+}
+
+// list writes l on one line if it was on one line, and otherwise one item
+// per line with the comments among them.
+func (p *printer) list(l *List, indent int) {
+	multiline := l.Start.Line != l.End.Line || len(p.comments) > 0 && before(p.comments[0].Start, l.End)
+	p.write("[")
+	for i, item := range l.Items {
+		if multiline {
+			p.leading(item.Pos(), indent+1)
+			p.newline(indent + 1)
+		} else if i > 0 {
+			p.write(", ")
+		}
+		p.expr(item, indent+1)
+		if multiline && i < len(l.Items)-1 {
+			p.write(",")
+		}
+	}
+	if multiline {
+		p.leading(l.End, indent+1)
+		p.newline(indent)
+	}
+	p.write("]")
+}
+
+func before(a, b Pos) bool {
+	return a.Line < b.Line || a.Line == b.Line && a.Col < b.Col
+}
+
+func isRender(s Stmt) bool {
+	_, ok := s.(*Render)
+	return ok
+}
+
+func stmtEnd(s Stmt) Pos {
+	switch s := s.(type) {
+	case *Let:
+		return s.End
+	case *Render:
+		return s.End
+	}
+	return s.Pos()
+}
+
+func stmtSource(s Stmt) string {
+	switch s := s.(type) {
+	case *Let:
+		return fmt.Sprintf("let %s : %s = %s", s.Name, s.Type, source(s.Value))
+	case *Render:
+		return s.Mode + " " + source(s.Value)
+	}
+	return ""
+}
@@ -0,0 +1,64 @@
+package sketchlang
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "spacing",
+			src:  "let p:vec=(1,2)+(3 ,4)*2\ntrace   dot at p\n",
+			want: "let p : vec = (1, 2) + (3, 4) * 2\ntrace dot at p\n",
+		},
+		{
+			name: "aligned lets",
+			src:  "let a : number = 1\nlet corner : vec = (a, a)\ntrace dot at corner\n",
+			want: "let a      : number = 1\nlet corner : vec    = (a, a)\ntrace dot at corner\n",
+		},
+		{
+			name: "renders after lets",
+			src:  "# the cat\ntrace dot at (1, 1)\nlet a : vec = (2, 2) # its eye\ntrace dot at a\n",
+			want: "let a : vec = (2, 2) # its eye\n\n# the cat\ntrace dot at (1, 1)\n\ntrace dot at a\n",
+		},
+		{
+			name: "multi-line list",
+			src:  "let s : sketch = [stroke from (0, 0) to (1, 1),\n    dot at (2, 2),   dash at (3, 3)]\nscribble s\n",
+			want: "let s : sketch = [\n  stroke from (0, 0) to (1, 1),\n  dot at (2, 2),\n  dash at (3, 3)\n]\nscribble s\n",
+		},
+		{
+			name: "render kept before redeclaration",
+			src:  "let a : vec = (1, 1)\ntrace dot at a\nlet a : vec = (2, 2)\ntrace dot at a\n",
+			want: "let a : vec = (1, 1)\ntrace dot at a\nlet a : vec = (2, 2)\ntrace dot at a\n",
+		},
+		{
+			name: "blank lines and comments",
+			src:  "\n\n# head\n\n\nlet a : vec = (1, 1)\n\n\n# body\ntrace dot at a # eye\n",
+			want: "# head\n\nlet a : vec = (1, 1)\n\n# body\ntrace dot at a # eye\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Format(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Format() =\n%s\nwant\n%s", got, tt.want)
+			}
+			again, err := Format(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again != got {
+				t.Errorf("Format() is not idempotent:\n%s\nthen\n%s", got, again)
+			}
+		})
+	}
+
+	if _, err := Format("trace dot at (1, 1"); err == nil {
+		t.Error("Format() of code that doesn't parse: no error")
+	}
+}
@@ -0,0 +1,147 @@
+package sketchlang
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Pos is a position in source: a 1-based line, and a 1-based column
+// counted in characters.
+type Pos struct {
+	Line, Col int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Col)
+}
+
+type kind int
+
+const (
+	eof kind = iota
+	newline
+	ident
+	number
+	punct   // one of ( ) [ ] , : = + - * / .
+	illegal // a character SketchLang does not use
+)
+
+type token struct {
+	kind kind
+	text string
+	pos  Pos
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case eof:
+		return "end of file"
+	case newline:
+		return "end of line"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// Comment is a # comment, without the newline that ends it.
+type Comment struct {
+	Start Pos
+	Text  string // including the #
+
+	alone bool // nothing but space before it on its line
+}
+
+// lex splits src into tokens and comments. Newlines inside brackets and
+// parentheses are dropped, since a statement only ends at a newline
+// outside them.
+func lex(src string) ([]token, []Comment) {
+	var tokens []token
+	var comments []Comment
+	line, col, depth := 1, 1, 0
+	tokenLine := 0 // the line of the last token
+
+	for i := 0; i < len(src); {
+		r, size := utf8.DecodeRuneInString(src[i:])
+		pos := Pos{line, col}
+		switch {
+		case r == '\n':
+			if depth == 0 {
+				tokens = append(tokens, token{newline, "\n", pos})
+			}
+			i++
+			line, col = line+1, 1
+			continue
+		case r == ' ' || r == '\t' || r == '\r':
+			i++
+			col++
+			continue
+		case r == '#':
+			end := i
+			for end < len(src) && src[end] != '\n' {
+				end++
+			}
+			comments = append(comments, Comment{pos, src[i:end], tokenLine != line})
+			col += utf8.RuneCountInString(src[i:end])
+			i = end
+			continue
+		case isDigit(r) || r == '.' && i+1 < len(src) && isDigit(rune(src[i+1])):
+			end := scanNumber(src, i)
+			tokens = append(tokens, token{number, src[i:end], pos})
+			col += end - i
+			i = end
+			tokenLine = line
+			continue
+		case r == '_' || unicode.IsLetter(r):
+			end := i
+			for end < len(src) {
+				r, size := utf8.DecodeRuneInString(src[end:])
+				if r != '_' && !unicode.IsLetter(r) && !isDigit(r) {
+					break
+				}
+				end += size
+			}
+			tokens = append(tokens, token{ident, src[i:end], pos})
+			col += utf8.RuneCountInString(src[i:end])
+			i = end
+			tokenLine = line
+			continue
+		case r == '(' || r == '[':
+			depth++
+			tokens = append(tokens, token{punct, string(r), pos})
+		case r == ')' || r == ']':
+			depth = max(depth-1, 0)
+			tokens = append(tokens, token{punct, string(r), pos})
+		case r < utf8.RuneSelf && isPunct(byte(r)):
+			tokens = append(tokens, token{punct, string(r), pos})
+		default:
+			tokens = append(tokens, token{illegal, string(r), pos})
+		}
+		i += size
+		col++
+		tokenLine = line
+	}
+	return append(tokens, token{eof, "", Pos{line, col}}), comments
+}
+
+func scanNumber(src string, i int) int {
+	for i < len(src) && isDigit(rune(src[i])) {
+		i++
+	}
+	if i+1 < len(src) && src[i] == '.' && isDigit(rune(src[i+1])) {
+		i++
+		for i < len(src) && isDigit(rune(src[i])) {
+			i++
+		}
+	}
+	return i
+}
+
+func isDigit(r rune) bool { return '0' <= r && r <= '9' }
+
+func isPunct(c byte) bool {
+	switch c {
+	case ',', ':', '=', '+', '-', '*', '/', '.':
+		return true
+	}
+	return false
+}
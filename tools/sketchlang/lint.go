@@ -0,0 +1,300 @@
+package sketchlang
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Diagnostic is one finding of Lint.
+type Diagnostic struct {
+	Pos        Pos
+	Severity   string // "error" if the compiler would reject the code, else "warning"
+	Rule       string
+	Message    string
+	Suggestion string
+}
+
+// String formats d on one line, in a form that can be quoted to the model
+// as it is.
+func (d Diagnostic) String() string {
+	s := fmt.Sprintf("line %s: [%s] %s", d.Pos, d.Rule, d.Message)
+	if d.Suggestion != "" {
+		s += "; " + d.Suggestion
+	}
+	return s
+}
+
+// The Lint rules. The first four are errors, the rest warnings.
+const (
+	RuleSyntax          = "syntax"
+	RuleDotNotation     = "dot-notation"
+	RuleUndeclared      = "undeclared"
+	RuleType            = "type"
+	RuleReassignment    = "reassignment"
+	RuleDuplicateStroke = "duplicate-stroke"
+	RuleOutOfCanvas     = "out-of-canvas"
+)
+
+// duplicateTolerance is how close, in mm, the points of two strokes must
+// be for one to redraw the other.
+const duplicateTolerance = 0.01
+
+// Canvas is the page a sketch is drawn on, in mm. Points are expected
+// between (Margin, Margin) and (Width-Margin, Height-Margin).
+type Canvas struct {
+	Width, Height float64
+	Margin        float64
+}
+
+// Lint checks src for the mistakes models make in SketchLang: syntax
+// errors, dot notation, undeclared names and type errors, which fail to
+// compile, and reassignment, strokes drawn twice and points off the
+// canvas or in its margin, which compile but are rarely meant. A canvas
+// with no width or height skips that check.
+//
+// Strokes and points are only compared where their coordinates can be
+// worked out without compiling: from numbers, vectors and arithmetic on
+// them, not from center of or flow at.
+func Lint(src string, canvas Canvas) []Diagnostic {
+	prog, err := Parse(src)
+	if err != nil {
+		return diagnostics(err.(ErrorList), "error")
+	}
+	c := check(prog)
+	diags := diagnostics(c.errs, "error")
+	diags = append(diags, diagnostics(Reassignments(prog), "warning")...)
+
+	l := &linter{bindings: c.bindings, values: map[*Let]value{}, walked: map[*Let]bool{}}
+	for _, s := range prog.Stmts {
+		if r, ok := s.(*Render); ok {
+			l.walk(r.Value)
+		}
+	}
+	diags = append(diags, l.duplicates()...)
+	if canvas.Width > 0 && canvas.Height > 0 {
+		diags = append(diags, l.offCanvas(canvas)...)
+	}
+	return diags
+}
+
+func diagnostics(errs ErrorList, severity string) []Diagnostic {
+	var diags []Diagnostic
+	for _, e := range errs {
+		diags = append(diags, Diagnostic{Pos: e.Pos, Severity: severity, Rule: e.rule, Message: e.Msg, Suggestion: e.suggestion})
+	}
+	return diags
+}
+
+// value is a number or vector worked out from the source.
+type value struct {
+	x, y  float64
+	known bool
+}
+
+// mark is a point a rendered sketch puts ink at.
+type mark struct {
+	pos   Pos
+	what  string
+	point value
+}
+
+type linter struct {
+	bindings map[*Ident]*Let
+	values   map[*Let]value
+	walked   map[*Let]bool
+	strokes  []*Stroke
+	marks    []mark
+}
+
+// walk collects the strokes and points of a rendered sketch, following
+// names to their lets, each let once.
+func (l *linter) walk(x Expr) {
+	switch x := x.(type) {
+	case *Ident:
+		if d := l.bindings[x]; d != nil && d.Type == Sketch && !l.walked[d] {
+			l.walked[d] = true
+			l.walk(d.Value)
+		}
+	case *Paren:
+		l.walk(x.X)
+	case *List:
+		for _, item := range x.Items {
+			l.walk(item)
+		}
+	case *Dot:
+		l.marks = append(l.marks, mark{x.Start, "dot", l.eval(x.Point)})
+	case *Dash:
+		l.marks = append(l.marks, mark{x.Start, "dash", l.eval(x.Point)})
+	case *Stroke:
+		l.strokes = append(l.strokes, x)
+		l.marks = append(l.marks, mark{x.From.Pos(), "stroke start", l.eval(x.From)}, mark{x.To.Pos(), "stroke end", l.eval(x.To)})
+		if x.Via != nil {
+			for _, p := range x.Via.Items {
+				l.marks = append(l.marks, mark{p.Pos(), "via point", l.eval(p)})
+			}
+		}
+	}
+}
+
+// eval works out a number or vector, if it does not depend on geometry.
+func (l *linter) eval(x Expr) value {
+	switch x := x.(type) {
+	case *NumberLit:
+		v, err := strconv.ParseFloat(x.Text, 64)
+		return value{v, 0, err == nil}
+	case *Origin:
+		return value{0, 0, true}
+	case *Ident:
+		d := l.bindings[x]
+		if d == nil || d.Type == Sketch {
+			return value{}
+		}
+		v, ok := l.values[d]
+		if !ok {
+			v = l.eval(d.Value)
+			l.values[d] = v
+		}
+		return v
+	case *VecLit:
+		px, py := l.eval(x.X), l.eval(x.Y)
+		return value{px.x, py.x, px.known && py.known}
+	case *Paren:
+		return l.eval(x.X)
+	case *Unary:
+		v := l.eval(x.X)
+		return value{-v.x, -v.y, v.known}
+	case *Binary:
+		a, b := l.eval(x.X), l.eval(x.Y)
+		if !a.known || !b.known {
+			return value{}
+		}
+		// A number is held in x, so scaling a vector uses the number's x.
+		switch x.Op {
+		case "+":
+			return value{a.x + b.x, a.y + b.y, true}
+		case "-":
+			return value{a.x - b.x, a.y - b.y, true}
+		case "*":
+			if l.isNumber(x.X) {
+				return value{a.x * b.x, a.x * b.y, true}
+			}
+			return value{a.x * b.x, a.y * b.x, true}
+		case "/":
+			if b.x == 0 {
+				return value{}
+			}
+			return value{a.x / b.x, a.y / b.x, true}
+		}
+	}
+	return value{}
+}
+
+// isNumber reports whether x is a number rather than a vector.
+func (l *linter) isNumber(x Expr) bool {
+	switch x := x.(type) {
+	case *NumberLit:
+		return true
+	case *Ident:
+		d := l.bindings[x]
+		return d != nil && d.Type == Number
+	case *Paren:
+		return l.isNumber(x.X)
+	case *Unary:
+		return l.isNumber(x.X)
+	case *Binary:
+		return l.isNumber(x.X) && l.isNumber(x.Y)
+	}
+	return false
+}
+
+// points is the from, via and to points of s, nil unless all are known.
+func (l *linter) points(s *Stroke) []value {
+	exprs := []Expr{s.From}
+	if s.Via != nil {
+		exprs = append(exprs, s.Via.Items...)
+	}
+	exprs = append(exprs, s.To)
+
+	points := make([]value, len(exprs))
+	for i, x := range exprs {
+		if points[i] = l.eval(x); !points[i].known {
+			return nil
+		}
+	}
+	return points
+}
+
+func (l *linter) duplicates() []Diagnostic {
+	var diags []Diagnostic
+	var seen []*Stroke
+	var seenPoints [][]value
+	for _, s := range l.strokes {
+		points := l.points(s)
+		if points == nil {
+			continue
+		}
+		for i, prev := range seenPoints {
+			if samePoints(prev, points) || samePoints(prev, reversed(points)) {
+				diags = append(diags, Diagnostic{
+					Pos:        s.Start,
+					Severity:   "warning",
+					Rule:       RuleDuplicateStroke,
+					Message:    "this stroke redraws the one on line " + fmt.Sprint(seen[i].Start.Line),
+					Suggestion: "remove it",
+				})
+				break
+			}
+		}
+		seen = append(seen, s)
+		seenPoints = append(seenPoints, points)
+	}
+	return diags
+}
+
+func (l *linter) offCanvas(c Canvas) []Diagnostic {
+	lo, hiX, hiY := c.Margin, c.Width-c.Margin, c.Height-c.Margin
+	var diags []Diagnostic
+	for _, m := range l.marks {
+		p := m.point
+		if !p.known || p.x >= lo && p.x <= hiX && p.y >= lo && p.y <= hiY {
+			continue
+		}
+		msg := fmt.Sprintf("%s at (%s, %s) is off the %gx%g canvas", m.what, formatNumber(p.x), formatNumber(p.y), c.Width, c.Height)
+		if p.x >= 0 && p.x <= c.Width && p.y >= 0 && p.y <= c.Height {
+			msg = fmt.Sprintf("%s at (%s, %s) is in the %g mm margin", m.what, formatNumber(p.x), formatNumber(p.y), c.Margin)
+		}
+		diags = append(diags, Diagnostic{
+			Pos:        m.pos,
+			Severity:   "warning",
+			Rule:       RuleOutOfCanvas,
+			Message:    msg,
+			Suggestion: fmt.Sprintf("keep x between %g and %g and y between %g and %g", lo, hiX, lo, hiY),
+		})
+	}
+	return diags
+}
+
+func samePoints(a, b []value) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Abs(a[i].x-b[i].x) > duplicateTolerance || math.Abs(a[i].y-b[i].y) > duplicateTolerance {
+			return false
+		}
+	}
+	return true
+}
+
+func reversed(points []value) []value {
+	r := make([]value, len(points))
+	for i, p := range points {
+		r[len(points)-1-i] = p
+	}
+	return r
+}
+
+func formatNumber(v float64) string {
+	return fmt.Sprint(math.Round(v*100) / 100)
+}
@@ -0,0 +1,89 @@
+package sketchlang
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	canvas := Canvas{Width: 100, Height: 100, Margin: 5}
+	tests := []struct {
+		rule     string
+		severity string
+		bad      string // breaks the rule
+		good     string // the same drawing, fixed
+	}{
+		{
+			RuleSyntax, "error",
+			"trace stroke from (10, 10) (20, 20)",
+			"trace stroke from (10, 10) to (20, 20)",
+		},
+		{
+			RuleDotNotation, "error",
+			"let a : vec = (10, 10)\nlet x : number = a.x\ntrace dot at (x, 10)",
+			"let x : number = 10\ntrace dot at (x, 10)",
+		},
+		{
+			RuleUndeclared, "error",
+			"trace dot at corner",
+			"let corner : vec = (10, 10)\ntrace dot at corner",
+		},
+		{
+			RuleType, "error",
+			"let a : number = 10\ntrace dot at (a, a) + a",
+			"let a : number = 10\ntrace dot at (a, a) + (a, a)",
+		},
+		{
+			RuleReassignment, "warning",
+			"let a : vec = (10, 10)\nlet a : vec = (20, 20)\ntrace dot at a",
+			"let a : vec = (10, 10)\nlet b : vec = (20, 20)\ntrace dot at b",
+		},
+		{
+			RuleDuplicateStroke, "warning",
+			"trace [stroke from (10, 10) to (20, 20), stroke from (20, 20) to (10, 10)]",
+			"trace [stroke from (10, 10) to (20, 20), stroke from (20, 20) to (30, 10)]",
+		},
+		{
+			RuleDuplicateStroke, "warning",
+			"let p : vec = (10, 10)\ntrace stroke from p to p + (10, 10)\ndraw stroke from (10, 10) to (20, 20)",
+			"let p : vec = (10, 10)\ntrace stroke from p to p + (10, 10)\ndraw stroke from (10, 10) to (20, 30)",
+		},
+		{
+			RuleOutOfCanvas, "warning",
+			"trace stroke from (10, 10) to (120, 20)",
+			"trace stroke from (10, 10) to (90, 20)",
+		},
+		{
+			RuleOutOfCanvas, "warning",
+			"trace dot at (50, 2)",
+			"trace dot at (50, 6)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			diags := Lint(tt.bad, canvas)
+			if len(diags) != 1 || diags[0].Rule != tt.rule || diags[0].Severity != tt.severity {
+				t.Errorf("Lint(%q) = %q, want one %s %s", tt.bad, diags, tt.severity, tt.rule)
+			}
+			if diags := Lint(tt.good, canvas); len(diags) != 0 {
+				t.Errorf("Lint(%q) = %q, want none", tt.good, diags)
+			}
+		})
+	}
+}
+
+func TestLintCanvas(t *testing.T) {
+	src := "trace dot at (50, 2)\ntrace dot at (50, -2)\ntrace dot at center of [dot at (500, 500)]"
+	diags := Lint(src, Canvas{Width: 100, Height: 100, Margin: 5})
+	if len(diags) != 2 {
+		t.Fatalf("Lint() = %q, want the two points worked out", diags)
+	}
+	if !strings.Contains(diags[0].Message, "margin") || !strings.Contains(diags[1].Message, "off the 100x100 canvas") {
+		t.Errorf("Lint() = %q", diags)
+	}
+
+	// No canvas, no check.
+	if diags := Lint(src, Canvas{}); len(diags) != 0 {
+		t.Errorf("Lint() with no canvas = %q", diags)
+	}
+}
@@ -0,0 +1,400 @@
+package sketchlang
+
+import (
+	"fmt"
+	"math"
+)
+
+// Optimized counts what Optimize changed.
+type Optimized struct {
+	ZeroLength int // strokes removed for ending where they start
+	Duplicates int // dots, dashes and strokes removed for redrawing one before
+	Merged     int // strokes joined onto a collinear stroke they continue
+	ViaPoints  int // via points dropped as within tolerance of the curve
+}
+
+// Changed reports whether Optimize changed anything.
+func (o Optimized) Changed() bool {
+	return o != Optimized{}
+}
+
+func (o Optimized) String() string {
+	return fmt.Sprintf("removed %d zero-length strokes and %d duplicates, merged %d strokes, dropped %d via points",
+		o.ZeroLength, o.Duplicates, o.Merged, o.ViaPoints)
+}
+
+// Optimize rewrites src to plot the same drawing with less pen travel and
+// fewer moves: strokes that go nowhere are removed, dots, dashes and
+// strokes drawn twice in the same mode are drawn once, straight strokes
+// that continue each other in a line are joined, and via points are
+// simplified with Ramer-Douglas-Peucker. Points within tolerance mm count
+// as the same.
+//
+// Only primitives whose points can be worked out without compiling are
+// touched, and only where each is drawn once: in a render statement, or
+// in a sketch let used once and not by center of, whose centroid would
+// move. A list is never emptied. The result is laid out as Format lays
+// it out, statements in place.
+func Optimize(src string, tolerance float64) (string, Optimized, error) {
+	prog, err := Parse(src)
+	if err != nil {
+		return "", Optimized{}, err
+	}
+	c := check(prog)
+	if err := c.errs.err(); err != nil {
+		return "", Optimized{}, err
+	}
+
+	o := &optimizer{
+		linter:    &linter{bindings: c.bindings, values: map[*Let]value{}, walked: map[*Let]bool{}},
+		tolerance: tolerance,
+		refs:      map[*Let]int{},
+		centered:  map[*Let]bool{},
+		left:      map[*List]int{},
+		gone:      map[Node]bool{},
+	}
+	for _, s := range prog.Stmts {
+		switch s := s.(type) {
+		case *Let:
+			o.count(s.Value)
+		case *Render:
+			o.count(s.Value)
+		}
+	}
+	for _, s := range prog.Stmts {
+		if r, ok := s.(*Render); ok {
+			o.collect(r.Value, r, nil, nil)
+		}
+	}
+
+	o.removeZeroLength()
+	o.removeDuplicates()
+	o.merge()
+	o.simplify()
+	if !o.stats.Changed() {
+		return src, o.stats, nil
+	}
+
+	stmts := prog.Stmts[:0]
+	for _, s := range prog.Stmts {
+		if !o.gone[s] {
+			stmts = append(stmts, s)
+		}
+	}
+	prog.Stmts = stmts
+	for _, s := range prog.Stmts {
+		switch s := s.(type) {
+		case *Let:
+			o.prune(s.Value)
+		case *Render:
+			o.prune(s.Value)
+		}
+	}
+	out := layout(prog, prog.Stmts, false)
+	if _, err := draw(out); err != nil {
+		return "", Optimized{}, fmt.Errorf("optimize: rewritten code does not compile: %w", err)
+	}
+	return out, o.stats, nil
+}
+
+// slot is a primitive drawn once, and where to remove it from.
+type slot struct {
+	x      Expr    // the *Dot, *Dash or *Stroke
+	mode   string  // of the render statement drawing it
+	stmt   *Render // the statement, to remove if the primitive is all it draws
+	list   *List   // the list the primitive is an item of, or nil
+	item   Expr    // the item of list it is in: x, in parentheses or by name
+	points []value // the point of a dot or dash; a stroke's from, via and to; nil unless all are known
+	gone   bool
+}
+
+type optimizer struct {
+	*linter
+	tolerance float64
+	refs      map[*Let]int  // the names referring to each let
+	centered  map[*Let]bool // lets inside a center of
+	slots     []*slot
+	left      map[*List]int // items each list will keep
+	gone      map[Node]bool // list items and statements to remove
+	stats     Optimized
+}
+
+// count records the lets x refers to, and the lets in each center of.
+func (o *optimizer) count(x Expr) {
+	inspect(x, func(x Expr) {
+		switch x := x.(type) {
+		case *Ident:
+			if d := o.bindings[x]; d != nil {
+				o.refs[d]++
+			}
+		case *Center:
+			o.center(x.Of)
+		}
+	})
+}
+
+// center marks the sketch lets x draws from as centered.
+func (o *optimizer) center(x Expr) {
+	inspect(x, func(x Expr) {
+		if id, ok := x.(*Ident); ok {
+			if d := o.bindings[id]; d != nil && d.Type == Sketch && !o.centered[d] {
+				o.centered[d] = true
+				o.center(d.Value)
+			}
+		}
+	})
+}
+
+// collect finds the primitives r draws through x, which is item of list,
+// or all of r's value if list is nil.
+func (o *optimizer) collect(x Expr, r *Render, list *List, item Expr) {
+	switch x := x.(type) {
+	case *Paren:
+		o.collect(x.X, r, list, item)
+	case *Ident:
+		if d := o.bindings[x]; d != nil && d.Type == Sketch && o.refs[d] == 1 && !o.centered[d] {
+			o.collect(d.Value, r, list, item)
+		}
+	case *List:
+		o.left[x] = len(x.Items)
+		for _, it := range x.Items {
+			o.collect(it, r, x, it)
+		}
+	case *Dot:
+		o.add(&slot{x: x, points: o.known(x.Point)}, r, list, item)
+	case *Dash:
+		o.add(&slot{x: x, points: o.known(x.Point)}, r, list, item)
+	case *Stroke:
+		o.add(&slot{x: x, points: o.points(x)}, r, list, item)
+	}
+}
+
+func (o *optimizer) add(s *slot, r *Render, list *List, item Expr) {
+	s.mode, s.stmt, s.list, s.item = r.Mode, r, list, item
+	if s.points != nil {
+		o.slots = append(o.slots, s)
+	}
+}
+
+// known is the value of x as a one-point slice, nil if it is not known.
+func (o *optimizer) known(x Expr) []value {
+	if v := o.eval(x); v.known {
+		return []value{v}
+	}
+	return nil
+}
+
+// remove drops s, unless that would empty its list.
+func (o *optimizer) remove(s *slot) bool {
+	switch {
+	case s.list != nil && o.left[s.list] > 1:
+		o.left[s.list]--
+		o.gone[s.item] = true
+	case s.list == nil:
+		o.gone[s.stmt] = true
+	default:
+		return false
+	}
+	s.gone = true
+	return true
+}
+
+func (o *optimizer) removeZeroLength() {
+	for _, s := range o.slots {
+		if _, ok := s.x.(*Stroke); !ok {
+			continue
+		}
+		short := true
+		for _, p := range s.points[1:] {
+			short = short && o.near(p, s.points[0])
+		}
+		if short && o.remove(s) {
+			o.stats.ZeroLength++
+		}
+	}
+}
+
+func (o *optimizer) removeDuplicates() {
+	for i, s := range o.slots {
+		for _, prev := range o.slots[:i] {
+			if s.gone || prev.gone || !o.same(prev, s) {
+				continue
+			}
+			if o.remove(s) || o.remove(prev) {
+				o.stats.Duplicates++
+			}
+		}
+	}
+}
+
+// same reports whether a and b draw the same primitive in the same mode,
+// a stroke in either direction.
+func (o *optimizer) same(a, b *slot) bool {
+	if a.mode != b.mode || fmt.Sprintf("%T", a.x) != fmt.Sprintf("%T", b.x) || len(a.points) != len(b.points) {
+		return false
+	}
+	forward, backward := true, true
+	for i, p := range a.points {
+		forward = forward && o.near(p, b.points[i])
+		backward = backward && o.near(p, b.points[len(b.points)-1-i])
+	}
+	return forward || backward
+}
+
+// merge joins straight strokes that meet end to end in a line, until no
+// two do.
+func (o *optimizer) merge() {
+	for merged := true; merged; {
+		merged = false
+		for _, a := range o.slots {
+			for _, b := range o.slots {
+				if a != b && !a.gone && !b.gone && o.join(a, b) {
+					merged = true
+				}
+			}
+		}
+	}
+}
+
+// join makes a run on through b, if they continue each other, and removes
+// b.
+func (o *optimizer) join(a, b *slot) bool {
+	sa, ok := a.x.(*Stroke)
+	sb, okb := b.x.(*Stroke)
+	if !ok || !okb || sa.Via != nil || sb.Via != nil || a.mode != b.mode {
+		return false
+	}
+	ea := [2]end{{sa.From, a.points[0]}, {sa.To, a.points[1]}}
+	eb := [2]end{{sb.From, b.points[0]}, {sb.To, b.points[1]}}
+	for i := range 2 {
+		for j := range 2 {
+			shared, far, farB := ea[i].p, ea[1-i], eb[1-j]
+			if !o.near(shared, eb[j].p) || !o.between(far.p, shared, farB.p) {
+				continue
+			}
+			if !o.remove(b) {
+				return false
+			}
+			sa.From, sa.To = far.x, o.movable(farB)
+			a.points = []value{far.p, farB.p}
+			o.stats.Merged++
+			return true
+		}
+	}
+	return false
+}
+
+// end is one end of a stroke, and where it is.
+type end struct {
+	x Expr
+	p value
+}
+
+// movable is e's expression, or its value if it refers to names, which
+// may not be declared yet where it moves to.
+func (o *optimizer) movable(e end) Expr {
+	named := false
+	inspect(e.x, func(x Expr) {
+		_, ok := x.(*Ident)
+		named = named || ok
+	})
+	if !named {
+		return e.x
+	}
+	at := e.x.Pos()
+	round := func(v float64) Expr { return numberLit(math.Round(v*1e4)/1e4, at) }
+	return &VecLit{Start: at, X: round(e.p.x), Y: round(e.p.y)}
+}
+
+// between reports whether p lies on the segment from a to c, within
+// tolerance, and strictly inside it, so the strokes do not double back.
+func (o *optimizer) between(a, p, c value) bool {
+	dx, dy := c.x-a.x, c.y-a.y
+	length := math.Hypot(dx, dy)
+	if length <= o.tolerance {
+		return false
+	}
+	t := ((p.x-a.x)*dx + (p.y-a.y)*dy) / (length * length)
+	return t > 0 && t < 1 && math.Abs((p.x-a.x)*dy-(p.y-a.y)*dx)/length <= o.tolerance
+}
+
+// simplify drops the via points of each spline that Ramer-Douglas-Peucker
+// finds within tolerance of the line through the points around them.
+func (o *optimizer) simplify() {
+	for _, s := range o.slots {
+		stroke, ok := s.x.(*Stroke)
+		if !ok || s.gone || stroke.Via == nil {
+			continue
+		}
+		keep := make([]bool, len(s.points))
+		keep[0], keep[len(keep)-1] = true, true
+		o.rdp(s.points, 0, len(s.points)-1, keep)
+
+		var via []Expr
+		var points []value
+		for i, k := range keep {
+			if k && i > 0 && i < len(keep)-1 {
+				via = append(via, stroke.Via.Items[i-1])
+			}
+			if k {
+				points = append(points, s.points[i])
+			}
+		}
+		if dropped := len(stroke.Via.Items) - len(via); dropped > 0 {
+			o.stats.ViaPoints += dropped
+			stroke.Via.Items = via
+			if len(via) == 0 {
+				stroke.Via = nil
+			}
+			s.points = points
+		}
+	}
+}
+
+// rdp marks the points between first and last to keep.
+func (o *optimizer) rdp(points []value, first, last int, keep []bool) {
+	a, c := points[first], points[last]
+	far, farthest := -1, o.tolerance
+	for i := first + 1; i < last; i++ {
+		if d := distanceToSegment(points[i], a, c); d > farthest {
+			far, farthest = i, d
+		}
+	}
+	if far < 0 {
+		return
+	}
+	keep[far] = true
+	o.rdp(points, first, far, keep)
+	o.rdp(points, far, last, keep)
+}
+
+func distanceToSegment(p, a, c value) float64 {
+	dx, dy := c.x-a.x, c.y-a.y
+	l2 := dx*dx + dy*dy
+	t := 0.0
+	if l2 > 0 {
+		t = math.Max(0, math.Min(1, ((p.x-a.x)*dx+(p.y-a.y)*dy)/l2))
+	}
+	return math.Hypot(p.x-a.x-t*dx, p.y-a.y-t*dy)
+}
+
+func (o *optimizer) near(a, b value) bool {
+	return math.Abs(a.x-b.x) <= o.tolerance && math.Abs(a.y-b.y) <= o.tolerance
+}
+
+// prune removes the items marked gone from the lists in x.
+func (o *optimizer) prune(x Expr) {
+	inspect(x, func(x Expr) {
+		l, ok := x.(*List)
+		if !ok {
+			return
+		}
+		items := l.Items[:0]
+		for _, it := range l.Items {
+			if !o.gone[it] {
+				items = append(items, it)
+			}
+		}
+		l.Items = items
+	})
+}
@@ -0,0 +1,100 @@
+package sketchlang
+
+import (
+	"math"
+	"testing"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want Optimized
+	}{
+		{
+			name: "zero length",
+			src:  "trace [stroke from (10, 10) to (10, 10), stroke from (10, 10) to (20, 20)]\n",
+			want: Optimized{ZeroLength: 1},
+		},
+		{
+			name: "duplicates",
+			src:  "trace [dot at (5, 5), dot at (5, 5), stroke from (0, 0) to (9, 0), stroke from (9, 0) to (0, 0)]\n",
+			want: Optimized{Duplicates: 2},
+		},
+		{
+			name: "duplicate statement",
+			src:  "let p : vec = (5, 5)\ntrace dot at p\ntrace dot at (5, 5)\ntrace dash at (1, 1)\n",
+			want: Optimized{Duplicates: 1},
+		},
+		{
+			name: "collinear strokes",
+			src:  "trace [stroke from (0, 0) to (10, 10), stroke from (10, 10) to (20, 20), stroke from (20, 20) to (30, 30)]\n",
+			want: Optimized{Merged: 2},
+		},
+		{
+			name: "via points on a line",
+			src:  "trace stroke from (0, 0) to (40, 0) via [(10, 0), (20, 0.01), (30, 0)]\n",
+			want: Optimized{ViaPoints: 3},
+		},
+		{
+			name: "nothing to do",
+			src:  "trace [stroke from (0, 0) to (10, 0), stroke from (10, 0) to (10, 10)]\nscribble dot at (5, 5)\n",
+		},
+		{
+			name: "sketch used twice",
+			src:  "let s : sketch = [dot at (1, 1), dot at (1, 1)]\ntrace s\ndraw s\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, stats, err := Optimize(tt.src, 0.1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats != tt.want {
+				t.Errorf("Optimize() = %v, want %v", stats, tt.want)
+			}
+			if !stats.Changed() && got != tt.src {
+				t.Errorf("Optimize() changed nothing but rewrote the code:\n%s", got)
+			}
+			before, err := Paths(tt.src, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			after, err := Paths(got, 1)
+			if err != nil {
+				t.Fatalf("optimized code: %v\n%s", err, got)
+			}
+			if d := math.Max(inkDistance(before, after), inkDistance(after, before)); d > 0.1 {
+				t.Errorf("optimized drawing is %.3f mm off the original:\n%s", d, got)
+			}
+		})
+	}
+}
+
+// inkDistance is the furthest any point drawn in a is from the ink of b.
+func inkDistance(a, b []Path) float64 {
+	var far float64
+	for _, p := range a {
+		for _, pt := range p {
+			near := math.Inf(1)
+			for _, q := range b {
+				for i := range q {
+					j := min(i+1, len(q)-1)
+					near = math.Min(near, segmentDistance(pt, q[i], q[j]))
+				}
+			}
+			far = math.Max(far, near)
+		}
+	}
+	return far
+}
+
+func segmentDistance(p, a, b Point) float64 {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	t := 0.0
+	if l := dx*dx + dy*dy; l > 0 {
+		t = math.Max(0, math.Min(1, ((p.X-a.X)*dx+(p.Y-a.Y)*dy)/l))
+	}
+	return math.Hypot(p.X-a.X-t*dx, p.Y-a.Y-t*dy)
+}
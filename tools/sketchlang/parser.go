@@ -0,0 +1,377 @@
+// Package sketchlang parses and checks SketchLang, the drawing language
+// compiled by the external sketchlang tool. It catches syntax errors,
+// undeclared names, reassignment, dot notation and type mismatches in
+// process, so code only goes to the compiler once it can compile, and can
+// render SVG itself where the compiler is not installed.
+package sketchlang
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Error is a problem at a position in the source.
+type Error struct {
+	Pos Pos
+	Msg string
+
+	rule       string // the Lint rule that reports it
+	suggestion string
+}
+
+func (e *Error) Error() string {
+	if e.suggestion != "" {
+		return fmt.Sprintf("line %s: %s; %s", e.Pos, e.Msg, e.suggestion)
+	}
+	return fmt.Sprintf("line %s: %s", e.Pos, e.Msg)
+}
+
+// ErrorList is every problem found in a source, in order.
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// Strings is each error as a line of text.
+func (l ErrorList) Strings() []string {
+	s := make([]string, len(l))
+	for i, e := range l {
+		s[i] = e.Error()
+	}
+	return s
+}
+
+// err returns l as an error, nil if it is empty.
+func (l ErrorList) err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+// keywords cannot be used as names.
+var keywords = map[string]bool{
+	"let": true, "trace": true, "draw": true, "scribble": true,
+	"origin": true, "dot": true, "dash": true, "stroke": true, "from": true,
+	"to": true, "via": true, "center": true, "of": true, "flow": true, "at": true,
+}
+
+// IsName reports whether s can be declared with let: a letter or _, then
+// letters, digits and _, and not a keyword.
+func IsName(s string) bool {
+	if s == "" || keywords[s] {
+		return false
+	}
+	for i, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !isDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+type parser struct {
+	tokens []token
+	i      int
+	errs   ErrorList
+}
+
+// bail aborts the statement being parsed; parse recovers at the next line.
+type bail struct{}
+
+// Parse parses src. On syntax errors it returns the statements that did
+// parse and an ErrorList with one error for each bad statement.
+func Parse(src string) (*Program, error) {
+	tokens, comments := lex(src)
+	p := &parser{tokens: tokens}
+	prog := &Program{Comments: comments}
+	for p.peek().kind != eof {
+		if p.peek().kind == newline {
+			p.next()
+			continue
+		}
+		if s := p.statement(); s != nil {
+			prog.Stmts = append(prog.Stmts, s)
+		}
+	}
+	return prog, p.errs.err()
+}
+
+func (p *parser) peek() token { return p.tokens[p.i] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != eof {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == punct || t.kind == ident) && t.text == text
+}
+
+func (p *parser) fail(pos Pos, format string, args ...any) {
+	p.failWith(pos, RuleSyntax, "", format, args...)
+}
+
+// failWith fails with a rule and suggestion for Lint.
+func (p *parser) failWith(pos Pos, rule, suggestion, format string, args ...any) {
+	p.errs = append(p.errs, &Error{pos, fmt.Sprintf(format, args...), rule, suggestion})
+	panic(bail{})
+}
+
+func (p *parser) unexpected(want string) {
+	t := p.peek()
+	if t.kind == illegal {
+		p.fail(t.pos, "unexpected character %s", t)
+	}
+	p.fail(t.pos, "expected %s, found %s", want, t)
+}
+
+func (p *parser) expect(text string) token {
+	if !p.is(text) {
+		p.unexpected(fmt.Sprintf("%q", text))
+	}
+	return p.next()
+}
+
+// statement parses one statement and the end of its line. After an error
+// it skips to the next line and returns nil.
+func (p *parser) statement() (s Stmt) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(bail); !ok {
+				panic(r)
+			}
+			for k := p.peek().kind; k != newline && k != eof; k = p.peek().kind {
+				p.next()
+			}
+			s = nil
+		}
+	}()
+
+	t := p.peek()
+	switch {
+	case t.kind == ident && t.text == "let":
+		s = p.let()
+	case t.kind == ident && (t.text == "trace" || t.text == "draw" || t.text == "scribble"):
+		p.next()
+		s = &Render{Start: t.pos, Mode: t.text, Value: p.expr()}
+	case t.kind == ident && (t.text == "dot" || t.text == "dash" || t.text == "stroke"):
+		p.failWith(t.pos, RuleSyntax, "render it with trace, draw or scribble", "%s is a sketch, not a statement", t.text)
+	case t.kind == ident && p.tokens[p.i+1].text == "=":
+		p.failWith(t.pos, RuleSyntax, fmt.Sprintf("to declare %s write let %s : type = ...", t.text, t.text), "statements start with let, trace, draw or scribble")
+	default:
+		p.fail(t.pos, "expected let, trace, draw or scribble, found %s", t)
+	}
+	if k := p.peek().kind; k != newline && k != eof {
+		p.unexpected("end of line")
+	}
+	end := p.tokens[p.i-1].pos
+	switch s := s.(type) {
+	case *Let:
+		s.End = end
+	case *Render:
+		s.End = end
+	}
+	return s
+}
+
+func (p *parser) let() *Let {
+	start := p.next().pos
+	name := p.peek()
+	if name.kind != ident || keywords[name.text] {
+		p.unexpected("a name")
+	}
+	p.next()
+	p.expect(":")
+	t := p.peek()
+	typ, ok := typeNames[t.text]
+	if t.kind != ident || !ok {
+		p.unexpected("number, vec or sketch")
+	}
+	p.next()
+	p.expect("=")
+	return &Let{Start: start, Name: name.text, NamePos: name.pos, Type: typ, Value: p.expr()}
+}
+
+// expr parses a sum, the loosest binding expression.
+func (p *parser) expr() Expr {
+	x := p.product()
+	for p.is("+") || p.is("-") {
+		op := p.next()
+		x = &Binary{Op: op.text, OpPos: op.pos, X: x, Y: p.product()}
+	}
+	return x
+}
+
+func (p *parser) product() Expr {
+	x := p.unary()
+	for p.is("*") || p.is("/") {
+		op := p.next()
+		x = &Binary{Op: op.text, OpPos: op.pos, X: x, Y: p.unary()}
+	}
+	return x
+}
+
+func (p *parser) unary() Expr {
+	if p.is("-") {
+		op := p.next()
+		return &Unary{Start: op.pos, Op: op.text, X: p.unary()}
+	}
+	x := p.primary()
+	if p.is(".") {
+		dot := p.next()
+		field := p.peek()
+		if field.kind == ident {
+			p.failWith(dot.pos, RuleDotNotation, "declare the value you need with let", "dot notation is not supported (%s.%s)", source(x), field.text)
+		}
+		p.fail(dot.pos, "unexpected \".\"")
+	}
+	return x
+}
+
+// primary parses an operand. "dot at", "dash at" and "flow at" take a
+// whole expression; "center of" takes an operand, so "center of s + v"
+// offsets the centre.
+func (p *parser) primary() Expr {
+	t := p.peek()
+	switch t.kind {
+	case number:
+		p.next()
+		return &NumberLit{Start: t.pos, Text: t.text}
+	case punct:
+		switch t.text {
+		case "(":
+			return p.paren()
+		case "[":
+			return p.list()
+		}
+	case ident:
+		if !keywords[t.text] {
+			p.next()
+			return &Ident{Start: t.pos, Name: t.text}
+		}
+		switch t.text {
+		case "origin":
+			p.next()
+			return &Origin{Start: t.pos}
+		case "dot":
+			p.next()
+			p.expect("at")
+			return &Dot{Start: t.pos, Point: p.expr()}
+		case "dash":
+			p.next()
+			p.expect("at")
+			return &Dash{Start: t.pos, Point: p.expr()}
+		case "flow":
+			p.next()
+			p.expect("at")
+			return &Flow{Start: t.pos, Point: p.expr()}
+		case "center":
+			p.next()
+			p.expect("of")
+			return &Center{Start: t.pos, Of: p.unary()}
+		case "stroke":
+			return p.stroke()
+		}
+	}
+	p.unexpected("an expression")
+	return nil
+}
+
+func (p *parser) paren() Expr {
+	start := p.next().pos
+	x := p.expr()
+	if p.is(",") {
+		p.next()
+		y := p.expr()
+		p.expect(")")
+		return &VecLit{Start: start, X: x, Y: y}
+	}
+	p.expect(")")
+	return &Paren{Start: start, X: x}
+}
+
+func (p *parser) list() *List {
+	l := &List{Start: p.next().pos}
+	for !p.is("]") {
+		l.Items = append(l.Items, p.expr())
+		if !p.is(",") {
+			break
+		}
+		p.next()
+	}
+	if !p.is("]") {
+		p.unexpected(`"," or "]"`)
+	}
+	l.End = p.next().pos
+	return l
+}
+
+func (p *parser) stroke() *Stroke {
+	s := &Stroke{Start: p.next().pos}
+	p.expect("from")
+	s.From = p.expr()
+	p.expect("to")
+	s.To = p.expr()
+	if p.is("via") {
+		p.next()
+		if !p.is("[") {
+			p.unexpected(`"[" to start the via points`)
+		}
+		s.Via = p.list()
+	}
+	return s
+}
+
+// source writes x back out as text, on one line.
+func source(x Expr) string {
+	switch x := x.(type) {
+	case *NumberLit:
+		return x.Text
+	case *Origin:
+		return "origin"
+	case *Ident:
+		return x.Name
+	case *VecLit:
+		return "(" + source(x.X) + ", " + source(x.Y) + ")"
+	case *Paren:
+		return "(" + source(x.X) + ")"
+	case *List:
+		items := make([]string, len(x.Items))
+		for i, item := range x.Items {
+			items[i] = source(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case *Unary:
+		return x.Op + source(x.X)
+	case *Binary:
+		return source(x.X) + " " + x.Op + " " + source(x.Y)
+	case *Dot:
+		return "dot at " + source(x.Point)
+	case *Dash:
+		return "dash at " + source(x.Point)
+	case *Stroke:
+		s := "stroke from " + source(x.From) + " to " + source(x.To)
+		if x.Via != nil {
+			s += " via " + source(x.Via)
+		}
+		return s
+	case *Center:
+		return "center of " + source(x.Of)
+	case *Flow:
+		return "flow at " + source(x.Point)
+	}
+	return ""
+}
@@ -0,0 +1,75 @@
+package sketchlang
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"number", "let r : number = 2.5"},
+		{"vector arithmetic", "let p : vec = (1, 2) + (3, -4) * 2 / (1 + 1)"},
+		{"origin and negation", "let p : vec = -origin - (1, 1)"},
+		{"dot and dash", "trace [dot at (1, 1), dash at (2, 2)]"},
+		{"stroke via", "let s : sketch = stroke from (0, 0) to (10, 0) via [(5, 5), (7, 2)]"},
+		{"center of", "let c : vec = center of [stroke from (0, 0) to (4, 4)] + (1, 0)"},
+		{"flow", "let f : vec = flow at (3, 4)"},
+		{"nested lists", "scribble [[dot at (1, 1)], [dot at (2, 2)]]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prog, err := Parse(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(prog.Stmts) != 1 {
+				t.Fatalf("%d statements, want 1", len(prog.Stmts))
+			}
+			got := stmtSource(prog.Stmts[0])
+			if got != tt.src {
+				t.Errorf("source = %q, want %q", got, tt.src)
+			}
+			again, err := Parse(got)
+			if err != nil || stmtSource(again.Stmts[0]) != got {
+				t.Errorf("reparsed %q: %v", got, err)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		rule string
+		line int
+	}{
+		{"bare primitive", "dot at (1, 1)", RuleSyntax, 1},
+		{"missing let", "let a : vec = (1, 1)\nb = (2, 2)", RuleSyntax, 2},
+		{"unknown type", "let a : point = (1, 1)", RuleSyntax, 1},
+		{"dot notation", "let a : vec = (1, 1)\nlet x : number = a.x", RuleDotNotation, 2},
+		{"unclosed list", "trace [dot at (1, 1)", RuleSyntax, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.src)
+			var errs ErrorList
+			if !errors.As(err, &errs) || len(errs) != 1 {
+				t.Fatalf("Parse() error = %v, want one error", err)
+			}
+			if errs[0].rule != tt.rule || errs[0].Pos.Line != tt.line {
+				t.Errorf("error %v is %s on line %d, want %s on line %d", errs[0], errs[0].rule, errs[0].Pos.Line, tt.rule, tt.line)
+			}
+		})
+	}
+
+	// The statements around a bad one still parse.
+	prog, err := Parse("let a : vec = (1, 1)\nlet b : vec = (1 1)\ntrace dot at a\n")
+	if err == nil || len(prog.Stmts) != 2 || !strings.HasPrefix(stmtSource(prog.Stmts[1]), "trace") {
+		t.Errorf("Parse() kept %d statements, err %v", len(prog.Stmts), err)
+	}
+}
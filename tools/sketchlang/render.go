@@ -0,0 +1,431 @@
+package sketchlang
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Point is a position in mm.
+type Point struct {
+	X, Y float64
+}
+
+// Path is a polyline, one per primitive drawn.
+type Path []Point
+
+// RenderOptions place a rendering on the page.
+type RenderOptions struct {
+	Pos  Point // where the sketch's origin goes, in mm
+	Size Point // the canvas, in mm
+	Seed int64 // for the wobble of draw and scribble
+}
+
+const (
+	dotRadius     = 0.25 // mm
+	dashLength    = 2    // mm
+	splineSteps   = 16   // points per span of a via spline
+	wobbleStep    = 1    // mm between the points of a wobbled path
+	drawWobble    = 0.3  // mm
+	scribbleNoise = 1.2  // mm
+)
+
+// RenderSVG draws src as an SVG without the sketchlang compiler, in the same
+// form the compiler writes: one path per primitive, in mm, on a white
+// canvas of opts.Size at opts.Pos. Code with errors returns an ErrorList.
+//
+// The rendering follows the spec rather than matching the compiler point
+// for point: splines are Catmull-Rom through their via points, a dash is
+// turned along the flow field, and the noise of draw and scribble comes
+// from opts.Seed.
+func RenderSVG(src string, opts RenderOptions) (string, error) {
+	paths, err := Paths(src, opts.Seed)
+	if err != nil {
+		return "", err
+	}
+	return SVG(paths, opts.Pos, opts.Size), nil
+}
+
+// Paths evaluates src and returns every primitive it renders as a
+// polyline, in the order drawn.
+func Paths(src string, seed int64) ([]Path, error) {
+	drawn, err := draw(src)
+	if err != nil {
+		return nil, err
+	}
+	r := rand.New(rand.NewSource(seed))
+	paths := make([]Path, len(drawn))
+	for i, d := range drawn {
+		switch d.mode {
+		case "draw":
+			paths[i] = wobble(d.path, drawWobble, r)
+		case "scribble":
+			paths[i] = wobble(d.path, scribbleNoise, r)
+		default:
+			paths[i] = d.path
+		}
+	}
+	return paths, nil
+}
+
+// Layer is the part of a program rendered in one mode.
+type Layer struct {
+	Mode string // trace, draw or scribble
+	Src  string // every let, and the render statements in Mode
+}
+
+// Layers splits src into a layer for each mode it renders in, in the
+// order each mode is first used, for plotting a mode at a time. Comments
+// are left out.
+func Layers(src string) ([]Layer, error) {
+	prog, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	if err := check(prog).errs.err(); err != nil {
+		return nil, err
+	}
+
+	var lets []string
+	var layers []Layer
+	renders := map[string][]string{}
+	for _, s := range prog.Stmts {
+		r, ok := s.(*Render)
+		if !ok {
+			lets = append(lets, stmtSource(s))
+			continue
+		}
+		if _, seen := renders[r.Mode]; !seen {
+			layers = append(layers, Layer{Mode: r.Mode})
+		}
+		renders[r.Mode] = append(renders[r.Mode], stmtSource(s))
+	}
+	for i, l := range layers {
+		layers[i].Src = strings.Join(append(slices.Clone(lets), renders[l.Mode]...), "\n") + "\n"
+	}
+	return layers, nil
+}
+
+// Shape evaluates the sketch declared as name in src and returns each of
+// its primitives as a polyline, without the wobble of draw or scribble. A
+// name declared twice has its last value.
+func Shape(src, name string) ([]Path, error) {
+	prog, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	c := check(prog)
+	if err := c.errs.err(); err != nil {
+		return nil, err
+	}
+	let, ok := c.decls[name]
+	if !ok {
+		return nil, fmt.Errorf("%s is not declared", name)
+	}
+	if let.Type != Sketch {
+		return nil, fmt.Errorf("%s is a %s, not a sketch", name, let.Type)
+	}
+
+	e := &evaluator{bindings: c.bindings, values: map[*Let]object{}}
+	v, err := e.evaluate(let.Value)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]Path, len(v.sketch))
+	for i, p := range v.sketch {
+		paths[i] = p.path
+	}
+	return paths, nil
+}
+
+// drawn is a primitive rendered in mode.
+type drawn struct {
+	primitive
+	mode string
+}
+
+// draw evaluates src and returns every primitive it renders, in order,
+// before any wobble.
+func draw(src string) ([]drawn, error) {
+	prog, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	c := check(prog)
+	if err := c.errs.err(); err != nil {
+		return nil, err
+	}
+
+	e := &evaluator{bindings: c.bindings, values: map[*Let]object{}}
+	var out []drawn
+	for _, s := range prog.Stmts {
+		r, ok := s.(*Render)
+		if !ok {
+			continue
+		}
+		v, err := e.evaluate(r.Value)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range v.sketch {
+			out = append(out, drawn{p, r.Mode})
+		}
+	}
+	return out, nil
+}
+
+// SVG writes paths, moved by pos, on a white canvas of size.
+func SVG(paths []Path, pos, size Point) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%gmm" height="%gmm" viewBox="%g %g %g %g">`+"\n",
+		size.X, size.Y, pos.X, pos.Y, size.X, size.Y)
+	b.WriteString(`  <rect width="100%" height="100%" fill="white"/>` + "\n")
+	for _, p := range paths {
+		b.WriteString(`  <path d="`)
+		for i, pt := range p {
+			if i > 0 {
+				b.WriteString(" L ")
+			} else {
+				b.WriteString("M ")
+			}
+			fmt.Fprintf(&b, "%.2f %.2f", pos.X+pt.X, pos.Y+pt.Y)
+		}
+		b.WriteString(`" fill="none" stroke="black" stroke-width="0.3"/>` + "\n")
+	}
+	b.WriteString("</svg>\n")
+	return b.String()
+}
+
+// object is a number, vector or sketch. A number is held in x.
+type object struct {
+	x, y   float64
+	sketch []primitive
+}
+
+// primitive is one dot, dash or stroke, with the points it was given for
+// center of.
+type primitive struct {
+	path   Path
+	points []Point
+}
+
+type evaluator struct {
+	bindings map[*Ident]*Let
+	values   map[*Let]object
+}
+
+// evaluate evaluates x, reporting division by zero as an error.
+func (e *evaluator) evaluate(x Expr) (v object, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			zero, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			err = ErrorList{zero}
+		}
+	}()
+	return e.eval(x), nil
+}
+
+func (e *evaluator) eval(x Expr) object {
+	switch x := x.(type) {
+	case *NumberLit:
+		n, _ := strconv.ParseFloat(x.Text, 64)
+		return object{x: n}
+	case *Origin:
+		return object{}
+	case *Ident:
+		d := e.bindings[x]
+		v, ok := e.values[d]
+		if !ok {
+			v = e.eval(d.Value)
+			e.values[d] = v
+		}
+		return v
+	case *VecLit:
+		return object{x: e.eval(x.X).x, y: e.eval(x.Y).x}
+	case *Paren:
+		return e.eval(x.X)
+	case *List:
+		var v object
+		for _, item := range x.Items {
+			v.sketch = append(v.sketch, e.eval(item).sketch...)
+		}
+		return v
+	case *Unary:
+		v := e.eval(x.X)
+		return object{x: -v.x, y: -v.y}
+	case *Binary:
+		return e.binary(x)
+	case *Dot:
+		p := e.point(x.Point)
+		return object{sketch: []primitive{{circle(p, dotRadius), []Point{p}}}}
+	case *Dash:
+		p := e.point(x.Point)
+		d := flow(p)
+		h := Point{d.X * dashLength / 2, d.Y * dashLength / 2}
+		return object{sketch: []primitive{{Path{{p.X - h.X, p.Y - h.Y}, {p.X + h.X, p.Y + h.Y}}, []Point{p}}}}
+	case *Stroke:
+		points := []Point{e.point(x.From)}
+		if x.Via != nil {
+			for _, item := range x.Via.Items {
+				points = append(points, e.point(item))
+			}
+		}
+		points = append(points, e.point(x.To))
+		path := Path(points)
+		if len(points) > 2 {
+			path = catmullRom(points)
+		}
+		return object{sketch: []primitive{{path, points}}}
+	case *Center:
+		c := centroid(e.eval(x.Of).sketch)
+		return object{x: c.X, y: c.Y}
+	case *Flow:
+		d := flow(e.point(x.Point))
+		return object{x: d.X, y: d.Y}
+	}
+	return object{}
+}
+
+func (e *evaluator) point(x Expr) Point {
+	v := e.eval(x)
+	return Point{v.x, v.y}
+}
+
+// binary follows the typing rules of the checker: a number's value is in
+// x, so scaling a vector uses the number's x.
+func (e *evaluator) binary(x *Binary) object {
+	a, b := e.eval(x.X), e.eval(x.Y)
+	switch x.Op {
+	case "+":
+		return object{x: a.x + b.x, y: a.y + b.y}
+	case "-":
+		return object{x: a.x - b.x, y: a.y - b.y}
+	case "*":
+		if e.isNumber(x.X) {
+			return object{x: a.x * b.x, y: a.x * b.y}
+		}
+		return object{x: a.x * b.x, y: a.y * b.x}
+	}
+	if b.x == 0 {
+		panic(&Error{Pos: x.OpPos, Msg: "division by zero"})
+	}
+	return object{x: a.x / b.x, y: a.y / b.x}
+}
+
+// isNumber reports whether x, which has type-checked, is a number.
+func (e *evaluator) isNumber(x Expr) bool {
+	switch x := x.(type) {
+	case *NumberLit:
+		return true
+	case *Ident:
+		return e.bindings[x].Type == Number
+	case *Paren:
+		return e.isNumber(x.X)
+	case *Unary:
+		return e.isNumber(x.X)
+	case *Binary:
+		return e.isNumber(x.X) && e.isNumber(x.Y)
+	}
+	return false
+}
+
+// flow is the direction of the flow field at p: a unit vector that turns
+// slowly across the canvas.
+func flow(p Point) Point {
+	a := (math.Sin(p.X/20) + math.Cos(p.Y/20)) * math.Pi / 2
+	return Point{math.Cos(a), math.Sin(a)}
+}
+
+// centroid is the mean of the points the primitives of a sketch were
+// given: their dots, dashes and stroke end and via points.
+func centroid(sketch []primitive) Point {
+	var sum Point
+	n := 0
+	for _, p := range sketch {
+		for _, pt := range p.points {
+			sum.X, sum.Y = sum.X+pt.X, sum.Y+pt.Y
+			n++
+		}
+	}
+	if n == 0 {
+		return Point{}
+	}
+	return Point{sum.X / float64(n), sum.Y / float64(n)}
+}
+
+func circle(c Point, r float64) Path {
+	const sides = 8
+	path := make(Path, sides+1)
+	for i := range path {
+		a := 2 * math.Pi * float64(i) / sides
+		path[i] = Point{c.X + r*math.Cos(a), c.Y + r*math.Sin(a)}
+	}
+	return path
+}
+
+// catmullRom is the uniform Catmull-Rom spline through points, with the
+// end points repeated so the curve reaches them.
+func catmullRom(points []Point) Path {
+	at := func(i int) Point { return points[min(max(i, 0), len(points)-1)] }
+	path := Path{points[0]}
+	for i := 0; i < len(points)-1; i++ {
+		p0, p1, p2, p3 := at(i-1), at(i), at(i+1), at(i+2)
+		for s := 1; s <= splineSteps; s++ {
+			t := float64(s) / splineSteps
+			t2, t3 := t*t, t*t*t
+			f := func(a, b, c, d float64) float64 {
+				return 0.5 * (2*b + (c-a)*t + (2*a-5*b+4*c-d)*t2 + (3*b-a-3*c+d)*t3)
+			}
+			path = append(path, Point{f(p0.X, p1.X, p2.X, p3.X), f(p0.Y, p1.Y, p2.Y, p3.Y)})
+		}
+	}
+	return path
+}
+
+// wobble redraws path by hand: resampled every wobbleStep mm, with each
+// point pushed off the line by up to amount mm, in a slow swing with a
+// little jitter on top.
+func wobble(path Path, amount float64, r *rand.Rand) Path {
+	path = resample(path, wobbleStep)
+	phase, freq := r.Float64()*2*math.Pi, 0.2+r.Float64()*0.4
+	out := make(Path, len(path))
+	dist := 0.0
+	for i, p := range path {
+		if i > 0 {
+			dist += math.Hypot(p.X-path[i-1].X, p.Y-path[i-1].Y)
+		}
+		a, b := path[max(i-1, 0)], path[min(i+1, len(path)-1)]
+		dx, dy := b.X-a.X, b.Y-a.Y
+		n := math.Hypot(dx, dy)
+		if n == 0 {
+			out[i] = p
+			continue
+		}
+		off := amount * (0.7*math.Sin(phase+dist*freq) + 0.3*(2*r.Float64()-1))
+		out[i] = Point{p.X - dy/n*off, p.Y + dx/n*off}
+	}
+	return out
+}
+
+// resample adds points along path so none is more than step from the next.
+func resample(path Path, step float64) Path {
+	if len(path) < 2 {
+		return path
+	}
+	out := Path{path[0]}
+	for i := 1; i < len(path); i++ {
+		a, b := path[i-1], path[i]
+		n := int(math.Ceil(math.Hypot(b.X-a.X, b.Y-a.Y) / step))
+		for s := 1; s <= n; s++ {
+			t := float64(s) / float64(n)
+			out = append(out, Point{a.X + (b.X-a.X)*t, a.Y + (b.Y-a.Y)*t})
+		}
+	}
+	return out
+}
@@ -0,0 +1,37 @@
+package sketchlang
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLayers(t *testing.T) {
+	src := `# a cat
+let body : sketch = [stroke from (0, 0) to (10, 0)]
+scribble body
+trace stroke from (0, 0) to (0, 10)
+let tail : sketch = [dot at (5, 5)]
+scribble tail
+`
+	got, err := Layers(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lets := "let body : sketch = [stroke from (0, 0) to (10, 0)]\nlet tail : sketch = [dot at (5, 5)]\n"
+	want := []Layer{
+		{Mode: "scribble", Src: lets + "scribble body\nscribble tail\n"},
+		{Mode: "trace", Src: lets + "trace stroke from (0, 0) to (0, 10)\n"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Layers() = %q, want %q", got, want)
+	}
+	for _, l := range got {
+		if err := Validate(l.Src); err != nil {
+			t.Errorf("%s layer: %v", l.Mode, err)
+		}
+	}
+
+	if _, err := Layers("trace nothing\n"); err == nil {
+		t.Error("Layers() of code that doesn't check: no error")
+	}
+}
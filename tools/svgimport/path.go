@@ -0,0 +1,343 @@
+package svgimport
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// parsePath reads SVG path data into segments: every command, absolute
+// or relative, with curves and arcs sampled at curveSteps points.
+func parsePath(d string) ([]segment, error) {
+	s := &scanner{src: d}
+	var segments []segment
+	var pen, start, ctrl Point // ctrl is the last control point, for S and T
+	var prev byte
+	line := func(to Point) {
+		segments = append(segments, segment{points: Path{pen, to}})
+		pen = to
+	}
+	curve := func(to Point, at func(t float64) Point) {
+		points := make(Path, curveSteps+1)
+		for i := range points {
+			points[i] = at(float64(i) / curveSteps)
+		}
+		points[0], points[curveSteps] = pen, to
+		segments = append(segments, segment{points: points, curve: true})
+		pen = to
+	}
+
+	var cmd byte
+	for {
+		s.skip()
+		if s.done() {
+			return segments, nil
+		}
+		if c := s.src[s.i]; strings.IndexByte("MmLlHhVvCcSsQqTtAaZz", c) >= 0 {
+			cmd = c
+			s.i++
+		} else if cmd == 0 || cmd == 'Z' || cmd == 'z' {
+			return nil, fmt.Errorf("path data: want a command at %q", s.rest())
+		}
+
+		rel := cmd >= 'a'
+		at := func(x, y float64) Point {
+			if rel {
+				return Point{X: pen.X + x, Y: pen.Y + y}
+			}
+			return Point{X: x, Y: y}
+		}
+		var err error
+		switch cmd {
+		case 'M', 'm':
+			var x, y float64
+			if x, y, err = s.pair(); err != nil {
+				return nil, err
+			}
+			pen = at(x, y)
+			start = pen
+			// Numbers after a move are lines.
+			cmd = map[byte]byte{'M': 'L', 'm': 'l'}[cmd]
+		case 'L', 'l':
+			var x, y float64
+			if x, y, err = s.pair(); err != nil {
+				return nil, err
+			}
+			line(at(x, y))
+		case 'H', 'h':
+			var x float64
+			if x, err = s.number(); err != nil {
+				return nil, err
+			}
+			if rel {
+				x += pen.X
+			}
+			line(Point{X: x, Y: pen.Y})
+		case 'V', 'v':
+			var y float64
+			if y, err = s.number(); err != nil {
+				return nil, err
+			}
+			if rel {
+				y += pen.Y
+			}
+			line(Point{X: pen.X, Y: y})
+		case 'C', 'c', 'S', 's':
+			var c1 Point
+			if cmd == 'C' || cmd == 'c' {
+				var x, y float64
+				if x, y, err = s.pair(); err != nil {
+					return nil, err
+				}
+				c1 = at(x, y)
+			} else {
+				c1 = pen
+				if strings.IndexByte("CcSs", prev) >= 0 {
+					c1 = Point{X: 2*pen.X - ctrl.X, Y: 2*pen.Y - ctrl.Y}
+				}
+			}
+			nums, err := s.numbers(4)
+			if err != nil {
+				return nil, err
+			}
+			c2, to := at(nums[0], nums[1]), at(nums[2], nums[3])
+			p0 := pen
+			curve(to, func(t float64) Point {
+				u := 1 - t
+				return Point{
+					X: u*u*u*p0.X + 3*u*u*t*c1.X + 3*u*t*t*c2.X + t*t*t*to.X,
+					Y: u*u*u*p0.Y + 3*u*u*t*c1.Y + 3*u*t*t*c2.Y + t*t*t*to.Y,
+				}
+			})
+			ctrl = c2
+		case 'Q', 'q', 'T', 't':
+			var c Point
+			if cmd == 'Q' || cmd == 'q' {
+				var x, y float64
+				if x, y, err = s.pair(); err != nil {
+					return nil, err
+				}
+				c = at(x, y)
+			} else {
+				c = pen
+				if strings.IndexByte("QqTt", prev) >= 0 {
+					c = Point{X: 2*pen.X - ctrl.X, Y: 2*pen.Y - ctrl.Y}
+				}
+			}
+			var x, y float64
+			if x, y, err = s.pair(); err != nil {
+				return nil, err
+			}
+			to, p0 := at(x, y), pen
+			curve(to, func(t float64) Point {
+				u := 1 - t
+				return Point{X: u*u*p0.X + 2*u*t*c.X + t*t*to.X, Y: u*u*p0.Y + 2*u*t*c.Y + t*t*to.Y}
+			})
+			ctrl = c
+		case 'A', 'a':
+			nums, err := s.numbers(3)
+			if err != nil {
+				return nil, err
+			}
+			large, err := s.flag()
+			if err != nil {
+				return nil, err
+			}
+			sweep, err := s.flag()
+			if err != nil {
+				return nil, err
+			}
+			var x, y float64
+			if x, y, err = s.pair(); err != nil {
+				return nil, err
+			}
+			to := at(x, y)
+			if along := arc(pen, to, nums[0], nums[1], nums[2], large, sweep); along != nil {
+				curve(to, along)
+			} else {
+				line(to)
+			}
+		case 'Z', 'z':
+			if pen != start {
+				line(start)
+			}
+			pen = start
+		}
+		prev = cmd
+	}
+}
+
+// arc is the point at t of the elliptical arc from a to b, worked out from
+// the endpoint form of SVG arcs to a centre and angles. It is nil where
+// the arc is a straight line: no radius, or the same ends.
+func arc(a, b Point, rx, ry, rotation float64, large, sweep bool) func(t float64) Point {
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 || a == b {
+		return nil
+	}
+	sin, cos := math.Sincos(rotation * math.Pi / 180)
+	dx, dy := (a.X-b.X)/2, (a.Y-b.Y)/2
+	x1, y1 := cos*dx+sin*dy, -sin*dx+cos*dy
+	// Radii too small to reach are scaled up until they just do.
+	if l := x1*x1/(rx*rx) + y1*y1/(ry*ry); l > 1 {
+		rx, ry = rx*math.Sqrt(l), ry*math.Sqrt(l)
+	}
+	num := rx*rx*ry*ry - rx*rx*y1*y1 - ry*ry*x1*x1
+	den := rx*rx*y1*y1 + ry*ry*x1*x1
+	k := math.Sqrt(math.Max(0, num/den))
+	if large == sweep {
+		k = -k
+	}
+	cx1, cy1 := k*rx*y1/ry, -k*ry*x1/rx
+	cx, cy := cos*cx1-sin*cy1+(a.X+b.X)/2, sin*cx1+cos*cy1+(a.Y+b.Y)/2
+
+	angle := func(ux, uy, vx, vy float64) float64 { return math.Atan2(ux*vy-uy*vx, ux*vx+uy*vy) }
+	start := angle(1, 0, (x1-cx1)/rx, (y1-cy1)/ry)
+	delta := angle((x1-cx1)/rx, (y1-cy1)/ry, (-x1-cx1)/rx, (-y1-cy1)/ry)
+	if !sweep && delta > 0 {
+		delta -= 2 * math.Pi
+	} else if sweep && delta < 0 {
+		delta += 2 * math.Pi
+	}
+	return func(t float64) Point {
+		s, c := math.Sincos(start + t*delta)
+		return Point{X: cx + rx*cos*c - ry*sin*s, Y: cy + rx*sin*c + ry*cos*s}
+	}
+}
+
+var numberPattern = regexp.MustCompile(`^[-+]?(\d+\.?\d*|\.\d+)([eE][-+]?\d+)?`)
+
+// scanner reads the numbers of path data, which may run together, as in
+// "1.5.5" or "1-2".
+type scanner struct {
+	src string
+	i   int
+}
+
+func (s *scanner) skip() {
+	for s.i < len(s.src) && strings.IndexByte(" \t\r\n,", s.src[s.i]) >= 0 {
+		s.i++
+	}
+}
+
+func (s *scanner) done() bool {
+	return s.i >= len(s.src)
+}
+
+func (s *scanner) number() (float64, error) {
+	s.skip()
+	m := numberPattern.FindString(s.src[s.i:])
+	if m == "" {
+		return 0, fmt.Errorf("path data: want a number at %q", s.rest())
+	}
+	s.i += len(m)
+	return strconv.ParseFloat(m, 64)
+}
+
+func (s *scanner) numbers(n int) ([]float64, error) {
+	out := make([]float64, n)
+	for i := range out {
+		v, err := s.number()
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+func (s *scanner) pair() (float64, float64, error) {
+	v, err := s.numbers(2)
+	if err != nil {
+		return 0, 0, err
+	}
+	return v[0], v[1], nil
+}
+
+// flag reads an arc flag, a single 0 or 1 that needs nothing after it.
+func (s *scanner) flag() (bool, error) {
+	s.skip()
+	if s.done() || (s.src[s.i] != '0' && s.src[s.i] != '1') {
+		return false, fmt.Errorf("path data: want an arc flag at %q", s.rest())
+	}
+	s.i++
+	return s.src[s.i-1] == '1', nil
+}
+
+func (s *scanner) rest() string {
+	rest := s.src[s.i:]
+	if len(rest) > 20 {
+		rest = rest[:20] + "..."
+	}
+	return rest
+}
+
+// matrix is an affine transform [a b c d e f], taking (x, y) to
+// (ax + cy + e, bx + dy + f) as SVG has it.
+type matrix [6]float64
+
+var identity = matrix{1, 0, 0, 1, 0, 0}
+
+// times is m followed, inside it, by n.
+func (m matrix) times(n matrix) matrix {
+	return matrix{
+		m[0]*n[0] + m[2]*n[1], m[1]*n[0] + m[3]*n[1],
+		m[0]*n[2] + m[2]*n[3], m[1]*n[2] + m[3]*n[3],
+		m[0]*n[4] + m[2]*n[5] + m[4], m[1]*n[4] + m[3]*n[5] + m[5],
+	}
+}
+
+func (m matrix) apply(p Point) Point {
+	return Point{X: m[0]*p.X + m[2]*p.Y + m[4], Y: m[1]*p.X + m[3]*p.Y + m[5]}
+}
+
+var transformPattern = regexp.MustCompile(`(\w+)\s*\(([^)]*)\)`)
+
+// parseTransform reads a transform attribute: matrix, translate, scale,
+// rotate, skewX and skewY, applied right to left.
+func parseTransform(s string) (matrix, error) {
+	m := identity
+	for _, t := range transformPattern.FindAllStringSubmatch(s, -1) {
+		sc := &scanner{src: t[2]}
+		var v []float64
+		for sc.skip(); !sc.done(); sc.skip() {
+			n, err := sc.number()
+			if err != nil {
+				return m, fmt.Errorf("transform %s: %w", t[0], err)
+			}
+			v = append(v, n)
+		}
+		arg := func(i int, def float64) float64 {
+			if i < len(v) {
+				return v[i]
+			}
+			return def
+		}
+		var n matrix
+		switch t[1] {
+		case "matrix":
+			if len(v) != 6 {
+				return m, fmt.Errorf("transform %s: want 6 numbers", t[0])
+			}
+			n = matrix(v)
+		case "translate":
+			n = matrix{1, 0, 0, 1, arg(0, 0), arg(1, 0)}
+		case "scale":
+			n = matrix{arg(0, 1), 0, 0, arg(1, arg(0, 1)), 0, 0}
+		case "rotate":
+			sin, cos := math.Sincos(arg(0, 0) * math.Pi / 180)
+			cx, cy := arg(1, 0), arg(2, 0)
+			n = matrix{1, 0, 0, 1, cx, cy}.times(matrix{cos, sin, -sin, cos, 0, 0}).times(matrix{1, 0, 0, 1, -cx, -cy})
+		case "skewX":
+			n = matrix{1, 0, math.Tan(arg(0, 0) * math.Pi / 180), 1, 0, 0}
+		case "skewY":
+			n = matrix{1, math.Tan(arg(0, 0) * math.Pi / 180), 0, 1, 0, 0}
+		default:
+			return m, fmt.Errorf("unknown transform %q", t[1])
+		}
+		m = m.times(n)
+	}
+	return m, nil
+}
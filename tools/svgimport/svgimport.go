@@ -0,0 +1,364 @@
+// Package svgimport converts the outlines of SVG vector art to SketchLang,
+// so drawings made elsewhere can be restyled and built on like sketches.
+package svgimport
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sketch-studio/tools/sketchlang"
+)
+
+// Points and paths are in mm once placed on the canvas.
+type (
+	Point = sketchlang.Point
+	Path  = sketchlang.Path
+)
+
+// Options place the imported drawing on the canvas.
+type Options struct {
+	Size   Point   // the canvas, in mm
+	Margin float64 // mm to keep blank on every side
+}
+
+// Imported counts what Import converted.
+type Imported struct {
+	Shapes  int            // elements converted, each a let
+	Lines   int            // straight strokes
+	Curves  int            // strokes through via points
+	Skipped map[string]int // elements with no outline to import, by name
+}
+
+func (i Imported) String() string {
+	s := fmt.Sprintf("%d shapes, %d straight strokes, %d curves", i.Shapes, i.Lines, i.Curves)
+	var skipped []string
+	for name, n := range i.Skipped {
+		skipped = append(skipped, fmt.Sprintf("%d <%s>", n, name))
+	}
+	if len(skipped) > 0 {
+		slices.Sort(skipped)
+		s += "; skipped " + strings.Join(skipped, ", ")
+	}
+	return s
+}
+
+const (
+	// curveSteps is the points each curve is sampled at before it is
+	// fitted with via points.
+	curveSteps = 32
+	// viaSpacing is roughly how far apart, in mm, the via points of a
+	// curve are, between 1 and maxVia of them.
+	viaSpacing = 5.0
+	maxVia     = 8
+	// minLength is the shortest stroke kept, in mm.
+	minLength = 0.05
+)
+
+// Import converts every <path>, <line>, <polyline>, <polygon>, <rect>,
+// <circle> and <ellipse> of svg, after its transforms, to SketchLang. The
+// drawing is scaled to fit the canvas inside the margin, and centred.
+// Straight segments become strokes, and each Bézier curve or arc a stroke
+// through via points sampled along it, which SketchLang draws as a
+// spline. Each element is a sketch of its own, named for its id where it
+// has a usable one, and all of them are traced in document order. Fills,
+// text and images are not imported.
+func Import(svg string, opts Options) (string, Imported, error) {
+	shapes, skipped, err := parse(svg)
+	if err != nil {
+		return "", Imported{}, err
+	}
+	if len(shapes) == 0 {
+		return "", Imported{}, errors.New("the SVG has no outlines to import")
+	}
+
+	// Scale the drawing's bounds into the canvas.
+	first := true
+	var lo, hi Point
+	for _, sh := range shapes {
+		for _, seg := range sh.segments {
+			for _, p := range seg.points {
+				if first {
+					lo, hi, first = p, p, false
+				}
+				lo = Point{X: math.Min(lo.X, p.X), Y: math.Min(lo.Y, p.Y)}
+				hi = Point{X: math.Max(hi.X, p.X), Y: math.Max(hi.Y, p.Y)}
+			}
+		}
+	}
+	room := Point{X: opts.Size.X - 2*opts.Margin, Y: opts.Size.Y - 2*opts.Margin}
+	if room.X <= 0 || room.Y <= 0 {
+		return "", Imported{}, errors.New("the margin leaves no room on the canvas")
+	}
+	scale := math.Inf(1)
+	if w := hi.X - lo.X; w > 0 {
+		scale = room.X / w
+	}
+	if h := hi.Y - lo.Y; h > 0 {
+		scale = math.Min(scale, room.Y/h)
+	}
+	if math.IsInf(scale, 1) {
+		return "", Imported{}, errors.New("the SVG's outlines are all one point")
+	}
+	offset := Point{
+		X: opts.Margin + (room.X-(hi.X-lo.X)*scale)/2,
+		Y: opts.Margin + (room.Y-(hi.Y-lo.Y)*scale)/2,
+	}
+	place := func(p Point) Point {
+		return Point{X: (p.X-lo.X)*scale + offset.X, Y: (p.Y-lo.Y)*scale + offset.Y}
+	}
+
+	stats := Imported{Skipped: skipped}
+	var b strings.Builder
+	var names []string
+	used := map[string]bool{}
+	for _, sh := range shapes {
+		var items []string
+		for _, seg := range sh.segments {
+			placed := make(Path, len(seg.points))
+			for i, p := range seg.points {
+				placed[i] = place(p)
+			}
+			if length(placed) < minLength {
+				continue
+			}
+			a, z := placed[0], placed[len(placed)-1]
+			if !seg.curve {
+				items = append(items, "stroke from "+point(a)+" to "+point(z))
+				stats.Lines++
+				continue
+			}
+			var via []string
+			for _, p := range viaPoints(placed) {
+				via = append(via, point(p))
+			}
+			items = append(items, "stroke from "+point(a)+" to "+point(z)+" via ["+strings.Join(via, ", ")+"]")
+			stats.Curves++
+		}
+		if len(items) == 0 {
+			continue
+		}
+		name := sh.name
+		if !sketchlang.IsName(name) || used[name] {
+			name = fmt.Sprintf("%s_%d", sh.element, len(names)+1)
+		}
+		used[name] = true
+		names = append(names, name)
+		fmt.Fprintf(&b, "let %s : sketch = [\n  %s\n]\n", name, strings.Join(items, ",\n  "))
+	}
+	if len(names) == 0 {
+		return "", Imported{}, errors.New("the SVG has no outlines to import")
+	}
+	stats.Shapes = len(names)
+	fmt.Fprintf(&b, "\ntrace [%s]\n", strings.Join(names, ", "))
+	return b.String(), stats, nil
+}
+
+// viaPoints picks points spaced evenly along path, between its ends, for a
+// spline through them to follow it.
+func viaPoints(path Path) []Point {
+	total := length(path)
+	n := min(max(int(total/viaSpacing), 1), maxVia)
+	var via []Point
+	walked, next := 0.0, 1
+	for i := 1; i < len(path) && next <= n; i++ {
+		step := distance(path[i-1], path[i])
+		for next <= n && walked+step >= total*float64(next)/float64(n+1) {
+			t := 0.0
+			if step > 0 {
+				t = (total*float64(next)/float64(n+1) - walked) / step
+			}
+			via = append(via, Point{X: path[i-1].X + t*(path[i].X-path[i-1].X), Y: path[i-1].Y + t*(path[i].Y-path[i-1].Y)})
+			next++
+		}
+		walked += step
+	}
+	return via
+}
+
+// shape is the outline of one element, in the SVG's user units after its
+// transforms.
+type shape struct {
+	element  string // path, rect, ...
+	name     string // its id
+	segments []segment
+}
+
+// segment is a straight line, as its two ends, or a curve, sampled.
+type segment struct {
+	points Path
+	curve  bool
+}
+
+// containers hold elements that are not drawn where they are.
+var containers = map[string]bool{"defs": true, "clipPath": true, "mask": true, "symbol": true, "marker": true, "pattern": true, "metadata": true, "title": true, "desc": true, "style": true}
+
+// outlineless are drawn elements that have no outline to import.
+var outlineless = map[string]bool{"text": true, "image": true, "use": true, "foreignObject": true}
+
+func parse(svg string) ([]shape, map[string]int, error) {
+	dec := xml.NewDecoder(strings.NewReader(svg))
+	dec.Strict = false
+
+	var shapes []shape
+	skipped := map[string]int{}
+	transforms := []matrix{identity}
+	hidden := 0 // depth inside a container or a hidden element
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return shapes, skipped, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		switch el := tok.(type) {
+		case xml.StartElement:
+			name := el.Name.Local
+			if hidden > 0 || containers[name] || attr(el, "display") == "none" || attr(el, "visibility") == "hidden" {
+				hidden++
+				continue
+			}
+			m, err := parseTransform(attr(el, "transform"))
+			if err != nil {
+				return nil, nil, err
+			}
+			m = transforms[len(transforms)-1].times(m)
+			transforms = append(transforms, m)
+			if outlineless[name] {
+				skipped[name]++
+				continue
+			}
+			d := pathData(el)
+			if d == "" {
+				continue
+			}
+			segments, err := parsePath(d)
+			if err != nil {
+				return nil, nil, fmt.Errorf("<%s>: %w", name, err)
+			}
+			for _, s := range segments {
+				for i, p := range s.points {
+					s.points[i] = m.apply(p)
+				}
+			}
+			if len(segments) > 0 {
+				shapes = append(shapes, shape{element: name, name: attr(el, "id"), segments: segments})
+			}
+		case xml.EndElement:
+			if hidden > 0 {
+				hidden--
+				continue
+			}
+			transforms = transforms[:len(transforms)-1]
+		}
+	}
+}
+
+// pathData is the outline of a drawn element as path data, "" for one
+// that is not drawn, such as a group.
+func pathData(el xml.StartElement) string {
+	num := func(name string) float64 {
+		v, _ := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(attr(el, name)), "px"), 64)
+		return v
+	}
+	f := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+	switch el.Name.Local {
+	case "path":
+		return attr(el, "d")
+	case "line":
+		return "M" + f(num("x1")) + " " + f(num("y1")) + " L" + f(num("x2")) + " " + f(num("y2"))
+	case "polyline", "polygon":
+		points := strings.TrimSpace(attr(el, "points"))
+		if points == "" {
+			return ""
+		}
+		d := "M" + points
+		if el.Name.Local == "polygon" {
+			d += " Z"
+		}
+		return d
+	case "rect":
+		x, y, w, h := num("x"), num("y"), num("width"), num("height")
+		if w <= 0 || h <= 0 {
+			return ""
+		}
+		rx, ry := num("rx"), num("ry")
+		if attr(el, "rx") == "" {
+			rx = ry
+		}
+		if attr(el, "ry") == "" {
+			ry = rx
+		}
+		rx, ry = math.Min(rx, w/2), math.Min(ry, h/2)
+		if rx <= 0 || ry <= 0 {
+			return fmt.Sprintf("M%s %s H%s V%s H%s Z", f(x), f(y), f(x+w), f(y+h), f(x))
+		}
+		arc := "A" + f(rx) + " " + f(ry) + " 0 0 1 "
+		return fmt.Sprintf("M%s %s H%s %s%s %s V%s %s%s %s H%s %s%s %s V%s %s%s %s Z",
+			f(x+rx), f(y), f(x+w-rx), arc, f(x+w), f(y+ry), f(y+h-ry), arc, f(x+w-rx), f(y+h),
+			f(x+rx), arc, f(x), f(y+h-ry), f(y+ry), arc, f(x+rx), f(y))
+	case "circle", "ellipse":
+		cx, cy := num("cx"), num("cy")
+		rx, ry := num("rx"), num("ry")
+		if el.Name.Local == "circle" {
+			rx, ry = num("r"), num("r")
+		}
+		if rx <= 0 || ry <= 0 {
+			return ""
+		}
+		arc := "A" + f(rx) + " " + f(ry) + " 0 0 1 "
+		return fmt.Sprintf("M%s %s %s%s %s %s%s %s", f(cx-rx), f(cy), arc, f(cx+rx), f(cy), arc, f(cx-rx), f(cy))
+	}
+	return ""
+}
+
+// attr is the value of an attribute of el, or of the property of its
+// style attribute with the same name.
+func attr(el xml.StartElement, name string) string {
+	style := ""
+	for _, a := range el.Attr {
+		switch a.Name.Local {
+		case name:
+			return a.Value
+		case "style":
+			style = a.Value
+		}
+	}
+	for _, decl := range strings.Split(style, ";") {
+		if k, v, ok := strings.Cut(decl, ":"); ok && strings.TrimSpace(k) == name {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+func point(p Point) string {
+	return "(" + coord(p.X) + ", " + coord(p.Y) + ")"
+}
+
+// coord formats a coordinate to a hundredth of a mm.
+func coord(v float64) string {
+	v = math.Round(v*100) / 100
+	if v == 0 {
+		v = 0 // not -0
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func length(p Path) float64 {
+	total := 0.0
+	for i := 1; i < len(p); i++ {
+		total += distance(p[i-1], p[i])
+	}
+	return total
+}
+
+func distance(a, b Point) float64 {
+	return math.Hypot(a.X-b.X, a.Y-b.Y)
+}
@@ -0,0 +1,145 @@
+package svgimport
+
+import (
+	"reflect"
+	"testing"
+
+	"sketch-studio/tools/sketchlang"
+)
+
+func TestImport(t *testing.T) {
+	// The fixtures span 100 by 100 user units, so on a 100 mm canvas
+	// with no margin their points stay where they are.
+	opts := Options{Size: Point{X: 100, Y: 100}}
+	tests := []struct {
+		name  string
+		svg   string
+		want  string
+		stats Imported
+	}{
+		{
+			name: "path",
+			svg:  `<svg xmlns="http://www.w3.org/2000/svg"><path id="box" d="M0 0 H100 v100 L0 100 z"/></svg>`,
+			want: `let box : sketch = [
+  stroke from (0, 0) to (100, 0),
+  stroke from (100, 0) to (100, 100),
+  stroke from (100, 100) to (0, 100),
+  stroke from (0, 100) to (0, 0)
+]
+
+trace [box]
+`,
+			stats: Imported{Shapes: 1, Lines: 4},
+		},
+		{
+			name: "polyline",
+			svg:  `<svg><polyline points="0,100 50,0 100,100"/><polygon id="2bad" points="40,60 60,60 50,50"/></svg>`,
+			want: `let polyline_1 : sketch = [
+  stroke from (0, 100) to (50, 0),
+  stroke from (50, 0) to (100, 100)
+]
+let polygon_2 : sketch = [
+  stroke from (40, 60) to (60, 60),
+  stroke from (60, 60) to (50, 50),
+  stroke from (50, 50) to (40, 60)
+]
+
+trace [polyline_1, polygon_2]
+`,
+			stats: Imported{Shapes: 2, Lines: 5},
+		},
+		{
+			name: "transform",
+			svg: `<svg>
+  <line id="top" x1="0" y1="0" x2="100" y2="0"/>
+  <g transform="translate(0 50)">
+    <line id="bottom" x1="0" y1="0" x2="50" y2="25" transform="scale(2)"/>
+  </g>
+  <g transform="rotate(90)"><text>label</text></g>
+  <defs><path id="unused" d="M500 500 L600 600"/></defs>
+</svg>`,
+			want: `let top : sketch = [
+  stroke from (0, 0) to (100, 0)
+]
+let bottom : sketch = [
+  stroke from (0, 50) to (100, 100)
+]
+
+trace [top, bottom]
+`,
+			stats: Imported{Shapes: 2, Lines: 2, Skipped: map[string]int{"text": 1}},
+		},
+		{
+			name: "scaled to fit",
+			svg:  `<svg><line x1="0" y1="0" x2="10" y2="5"/></svg>`,
+			want: `let line_1 : sketch = [
+  stroke from (0, 25) to (100, 75)
+]
+
+trace [line_1]
+`,
+			stats: Imported{Shapes: 1, Lines: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, stats, err := Import(tt.svg, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Import() =\n%s\nwant\n%s", got, tt.want)
+			}
+			if tt.stats.Skipped == nil {
+				tt.stats.Skipped = map[string]int{}
+			}
+			if !reflect.DeepEqual(stats, tt.stats) {
+				t.Errorf("Import() counted %+v, want %+v", stats, tt.stats)
+			}
+			if err := sketchlang.Validate(got); err != nil {
+				t.Errorf("imported code doesn't check: %v", err)
+			}
+		})
+	}
+}
+
+func TestImportCurve(t *testing.T) {
+	svg := `<svg><path id="wave" d="M0 50 C 25 0, 75 100, 100 50"/><circle id="ring" cx="50" cy="50" r="10"/></svg>`
+	got, stats, err := Import(svg, Options{Size: Point{X: 100, Y: 100}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Shapes != 2 || stats.Lines != 0 || stats.Curves != 3 {
+		t.Errorf("Import() counted %+v, want the wave and the ring's two arcs as curves", stats)
+	}
+	paths, err := sketchlang.Shape(got, "wave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The spline through the via points follows the curve, which passes
+	// through its middle at (50, 50).
+	wave := paths[0]
+	if a, z := wave[0], wave[len(wave)-1]; a != (Point{X: 0, Y: 50}) || z != (Point{X: 100, Y: 50}) {
+		t.Errorf("wave runs from %v to %v", a, z)
+	}
+	mid := wave[len(wave)/2]
+	if mid.X < 40 || mid.X > 60 || mid.Y < 45 || mid.Y > 55 {
+		t.Errorf("wave passes through %v at its middle, want near (50, 50)\n%s", mid, got)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	for _, svg := range []string{
+		`<svg><text>only words</text></svg>`,
+		`<svg><circle cx="5" cy="5" r="0"/></svg>`,
+		`<svg><line x1="5" y1="5" x2="5" y2="5"/></svg>`,
+		`<svg><path d="M0 0 Q"/></svg>`,
+	} {
+		if _, _, err := Import(svg, Options{Size: Point{X: 100, Y: 100}}); err == nil {
+			t.Errorf("Import(%s): no error", svg)
+		}
+	}
+	if _, _, err := Import(`<svg><line x2="10" y2="10"/></svg>`, Options{Size: Point{X: 10, Y: 10}, Margin: 5}); err == nil {
+		t.Error("Import() onto a canvas that is all margin: no error")
+	}
+}
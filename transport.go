@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// HTTPOptions configure how providers reach their APIs, for networks
+// where the defaults do not work, such as behind a corporate proxy.
+type HTTPOptions struct {
+	Proxy   string        // proxy URL; "" uses HTTPS_PROXY and friends
+	CACert  string        // PEM file of root certificates to trust besides the system's
+	Pins    []string      // base64 SHA-256 hashes of accepted public keys, optionally "sha256/"-prefixed
+	Timeout time.Duration // replaces each provider's request timeout; 0 keeps them
+}
+
+// requestTimeout is HTTPOptions.Timeout, applied by postJSON and
+// streamLines.
+var requestTimeout time.Duration
+
+// SetHTTPClient makes every provider send its requests with c.
+func SetHTTPClient(c *http.Client) {
+	httpClient = c
+}
+
+// ConfigureHTTP rebuilds the shared client's transport from o.
+func ConfigureHTTP(o HTTPOptions) error {
+	t := httpClient.Transport.(*http.Transport).Clone()
+	if o.Proxy != "" {
+		u, err := url.Parse(o.Proxy)
+		if err != nil {
+			return fmt.Errorf("proxy: %w", err)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+
+	if o.CACert != "" || len(o.Pins) > 0 {
+		t.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if o.CACert != "" {
+		pem, err := os.ReadFile(o.CACert)
+		if err != nil {
+			return err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: no PEM certificates found", o.CACert)
+		}
+		t.TLSClientConfig.RootCAs = pool
+	}
+	if len(o.Pins) > 0 {
+		var pins []string
+		for _, p := range o.Pins {
+			pins = append(pins, strings.TrimPrefix(strings.TrimSpace(p), "sha256/"))
+		}
+		t.TLSClientConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, cert := range cs.PeerCertificates {
+				sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				if slices.Contains(pins, base64.StdEncoding.EncodeToString(sum[:])) {
+					return nil
+				}
+			}
+			return errors.New("no certificate matches a pinned key")
+		}
+	}
+
+	requestTimeout = o.Timeout
+	SetHTTPClient(&http.Client{Transport: t})
+	return nil
+}
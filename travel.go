@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// twoOptPasses caps the improvement passes of OrderPaths, each of which
+// tries every pair of cuts.
+const twoOptPasses = 20
+
+// Travel is the pen-up travel of a drawing before and after OrderPaths, in
+// SVG units.
+type Travel struct {
+	Before, After float64
+}
+
+// Saved is the fraction of the travel OrderPaths cut.
+func (t Travel) Saved() float64 {
+	if t.Before == 0 {
+		return 0
+	}
+	return 1 - t.After/t.Before
+}
+
+func (t Travel) String() string {
+	return fmt.Sprintf("pen-up travel %.0f -> %.0f (%.0f%% less)", t.Before, t.After, t.Saved()*100)
+}
+
+// OrderPaths reorders the <path> elements directly inside the root of svg
+// so the pen travels less between them: the shorter of the order drawn
+// and a nearest-neighbour tour from the first path, each improved with
+// 2-opt, drawing each path in whichever direction suits. A path is reversed by rewriting its d attribute, which
+// only happens to paths of one subpath. The other elements stay where
+// they are, and the drawing is the same, since every path is drawn in the
+// same ink.
+//
+// The order only depends on the geometry, so the same drawing is always
+// put in the same order. If the tour is no shorter, svg is returned as it
+// is.
+func OrderPaths(svg string) (string, Travel, error) {
+	units, err := pathElements(svg)
+	if err != nil {
+		return "", Travel{}, err
+	}
+	before := tourLength(units)
+	t := Travel{Before: before, After: before}
+	if len(units) < 3 {
+		return svg, t, nil
+	}
+
+	// Code tends to draw neighbouring strokes one after another, so the
+	// order drawn can be a better start than the nearest neighbour's.
+	tour := nearestNeighbour(units)
+	twoOpt(tour)
+	drawn := append([]pathUnit(nil), units...)
+	twoOpt(drawn)
+	if tourLength(drawn) < tourLength(tour) {
+		tour = drawn
+	}
+	if after := tourLength(tour); after < before-1e-9 {
+		t.After = after
+	} else {
+		return svg, t, nil
+	}
+
+	var b strings.Builder
+	last := 0
+	for i, u := range units {
+		b.WriteString(svg[last:u.start])
+		b.WriteString(tour[i].element(svg))
+		last = u.end
+	}
+	b.WriteString(svg[last:])
+	return b.String(), t, nil
+}
+
+// pathUnit is a <path> element of an SVG, and which way to draw it.
+type pathUnit struct {
+	start, end  int      // the span of the element in the SVG
+	first, last Vec2     // where the pen goes down and comes up, as drawn
+	points      Polyline // the path, if it is one subpath and so can be reversed
+	reversed    bool
+}
+
+func (u pathUnit) from() Vec2 {
+	if u.reversed {
+		return u.last
+	}
+	return u.first
+}
+
+func (u pathUnit) to() Vec2 {
+	if u.reversed {
+		return u.first
+	}
+	return u.last
+}
+
+// flip reverses u, if it can be.
+func (u *pathUnit) flip() {
+	if u.points != nil {
+		u.reversed = !u.reversed
+	}
+}
+
+var pathData = regexp.MustCompile(`(\sd\s*=\s*)("[^"]*"|'[^']*')`)
+
+// element is the markup of u in svg, with its path data reversed if u is.
+func (u pathUnit) element(svg string) string {
+	el := svg[u.start:u.end]
+	if !u.reversed {
+		return el
+	}
+	var d strings.Builder
+	for i := len(u.points) - 1; i >= 0; i-- {
+		if i == len(u.points)-1 {
+			d.WriteString("M ")
+		} else {
+			d.WriteString(" L ")
+		}
+		p := u.points[i]
+		d.WriteString(strconv.FormatFloat(p.X, 'f', -1, 64) + " " + strconv.FormatFloat(p.Y, 'f', -1, 64))
+	}
+	return pathData.ReplaceAllString(el, `${1}"`+d.String()+`"`)
+}
+
+// pathElements finds the <path> elements directly inside the root of svg
+// that draw something.
+func pathElements(svg string) ([]pathUnit, error) {
+	dec := xml.NewDecoder(strings.NewReader(svg))
+	dec.Strict = false
+
+	var units []pathUnit
+	depth := 0
+	var open *pathUnit
+	for {
+		offset := int(dec.InputOffset())
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return units, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch el := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if depth != 2 || el.Name.Local != "path" {
+				continue
+			}
+			subpaths, err := parsePathData(attr(el, "d"))
+			if err != nil {
+				return nil, err
+			}
+			if len(subpaths) == 0 {
+				continue
+			}
+			u := pathUnit{start: offset, first: subpaths[0][0], last: subpaths[len(subpaths)-1][len(subpaths[len(subpaths)-1])-1]}
+			if len(subpaths) == 1 {
+				u.points = subpaths[0]
+			}
+			open = &u
+		case xml.EndElement:
+			depth--
+			if open != nil && depth == 1 {
+				open.end = int(dec.InputOffset())
+				units = append(units, *open)
+				open = nil
+			}
+		}
+	}
+}
+
+// tourLength is the pen-up travel between the paths of tour, in order.
+func tourLength(tour []pathUnit) float64 {
+	total := 0.0
+	for i := 1; i < len(tour); i++ {
+		total += distance(tour[i-1].to(), tour[i].from())
+	}
+	return total
+}
+
+// nearestNeighbour starts with the first path and goes each time to the
+// nearest end of a path not drawn yet.
+func nearestNeighbour(units []pathUnit) []pathUnit {
+	left := append([]pathUnit(nil), units...)
+	tour := []pathUnit{left[0]}
+	left = left[1:]
+	for len(left) > 0 {
+		at := tour[len(tour)-1].to()
+		best, bestDist, flip := 0, math.Inf(1), false
+		for i, u := range left {
+			if d := distance(at, u.from()); d < bestDist {
+				best, bestDist, flip = i, d, false
+			}
+			if u.points != nil {
+				if d := distance(at, u.to()); d < bestDist {
+					best, bestDist, flip = i, d, true
+				}
+			}
+		}
+		next := left[best]
+		if flip {
+			next.flip()
+		}
+		tour = append(tour, next)
+		left = append(left[:best], left[best+1:]...)
+	}
+	return tour
+}
+
+// twoOpt reverses runs of tour, drawing each path in the run the other
+// way, while that shortens it. A run may be a single path, turned round. Runs with a path that can't be reversed
+// are left alone.
+func twoOpt(tour []pathUnit) {
+	n := len(tour)
+	reversible := func(i, j int) bool {
+		for k := i; k <= j; k++ {
+			if tour[k].points == nil && tour[k].first != tour[k].last {
+				return false
+			}
+		}
+		return true
+	}
+	for range twoOptPasses {
+		improved := false
+		for i := 0; i < n; i++ {
+			for j := i; j < n; j++ {
+				// Reversing tour[i:j+1] replaces the moves into i and out
+				// of j with moves into j's end and out of i's start.
+				old, changed := 0.0, 0.0
+				if i > 0 {
+					old += distance(tour[i-1].to(), tour[i].from())
+					changed += distance(tour[i-1].to(), tour[j].to())
+				}
+				if j < n-1 {
+					old += distance(tour[j].to(), tour[j+1].from())
+					changed += distance(tour[i].from(), tour[j+1].from())
+				}
+				if changed >= old-1e-9 || !reversible(i, j) {
+					continue
+				}
+				for a, b := i, j; a < b; a, b = a+1, b-1 {
+					tour[a], tour[b] = tour[b], tour[a]
+				}
+				for k := i; k <= j; k++ {
+					tour[k].flip()
+				}
+				improved = true
+			}
+		}
+		if !improved {
+			return
+		}
+	}
+}
+
+func distance(a, b Vec2) float64 {
+	return math.Hypot(a.X-b.X, a.Y-b.Y)
+}
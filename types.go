@@ -7,10 +7,28 @@ import (
 
 type Vec2 struct{ X, Y float64 }
 
+// SketchRequest is one sketch to generate.
+type SketchRequest struct {
+    Prompt      string
+    Image       string   // reference image to trace, optional
+    Output      string   // output name without extension; "" derives it from the title
+    Constraints []string // e.g. "no text", "max 300 strokes"; see ParseConstraints
+    Caption     string   // text to letter under the drawing, optional
+    Pos         Vec2
+    Size        Vec2
+    Style       string    // styles for this sketch, as -style lists them; "" keeps the run's
+    Requester   string    // who asked for it, for the manifest; "" for the OS user
+    Pens        PenDepths // pen depths for this sketch's G-code; nil keeps -pen's
+}
+
 type SketchResult struct {
     Code    string
     Title   string
     Summary string
+    Usage   Usage   // tokens spent producing this sketch
+    Cost    float64 // in dollars; 0 for local models
+
+    BudgetExceeded bool // later passes were skipped to stay within -max-cost or -max-tokens
 }
 
 type Logger struct {
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Usage counts the tokens of one or more requests.
+type Usage struct {
+	InputTokens      int // uncached input
+	OutputTokens     int
+	CacheWriteTokens int
+	CacheReadTokens  int
+}
+
+func (u Usage) add(v Usage) Usage {
+	return Usage{
+		InputTokens:      u.InputTokens + v.InputTokens,
+		OutputTokens:     u.OutputTokens + v.OutputTokens,
+		CacheWriteTokens: u.CacheWriteTokens + v.CacheWriteTokens,
+		CacheReadTokens:  u.CacheReadTokens + v.CacheReadTokens,
+	}
+}
+
+func (u Usage) sub(v Usage) Usage {
+	return u.add(Usage{-v.InputTokens, -v.OutputTokens, -v.CacheWriteTokens, -v.CacheReadTokens})
+}
+
+// modelPrice is in dollars per million tokens.
+type modelPrice struct {
+	input, output, cacheWrite, cacheRead float64
+}
+
+// modelPrices covers the hosted default models. Anything else, including
+// local models, is counted as free.
+var modelPrices = map[string]modelPrice{
+	"claude-sonnet-4-5": {input: 3, output: 15, cacheWrite: 3.75, cacheRead: 0.30},
+	"gpt-4o":            {input: 2.50, output: 10, cacheWrite: 2.50, cacheRead: 1.25},
+}
+
+// Cost prices u at model's rates. Usage recorded under a model name
+// ending in batchSuffix is billed at half price.
+func (u Usage) Cost(model string) float64 {
+	base, batch := strings.CutSuffix(model, batchSuffix)
+	p := modelPrices[base]
+	cost := (float64(u.InputTokens)*p.input +
+		float64(u.OutputTokens)*p.output +
+		float64(u.CacheWriteTokens)*p.cacheWrite +
+		float64(u.CacheReadTokens)*p.cacheRead) / 1e6
+	if batch {
+		cost /= 2
+	}
+	return cost
+}
+
+// UsageTracker totals token usage per model and phase across every
+// request of a run. Provider clients report into it; callers mark the
+// phase (generate, finish, ...) the requests belong to.
+type UsageTracker struct {
+	mu     sync.Mutex
+	phase  string
+	totals map[usageKey]Usage
+}
+
+type usageKey struct {
+	model, phase string
+}
+
+func NewUsageTracker() *UsageTracker {
+	return &UsageTracker{phase: "generate", totals: map[usageKey]Usage{}}
+}
+
+// SetPhase attributes the following requests to phase.
+func (t *UsageTracker) SetPhase(phase string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.phase = phase
+}
+
+// Record adds one request's usage.
+func (t *UsageTracker) Record(model string, u Usage) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	k := usageKey{model, t.phase}
+	t.totals[k] = t.totals[k].add(u)
+}
+
+// Total sums usage and cost over every model and phase.
+func (t *UsageTracker) Total() (Usage, float64) {
+	if t == nil {
+		return Usage{}, 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var total Usage
+	var cost float64
+	for k, u := range t.totals {
+		total = total.add(u)
+		cost += u.Cost(k.model)
+	}
+	return total, cost
+}
+
+// Report writes one line per model and phase, then the total.
+func (t *UsageTracker) Report(w io.Writer) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	totals := maps.Clone(t.totals)
+	t.mu.Unlock()
+	if len(totals) == 0 {
+		return
+	}
+
+	keys := slices.SortedFunc(maps.Keys(totals), func(a, b usageKey) int {
+		return cmp.Or(strings.Compare(a.model, b.model), strings.Compare(a.phase, b.phase))
+	})
+	var total Usage
+	var cost float64
+	for _, k := range keys {
+		u := totals[k]
+		total, cost = total.add(u), cost+u.Cost(k.model)
+		fmt.Fprintf(w, "  %-18s %-9s %s\n", k.model, k.phase, formatUsage(u, u.Cost(k.model)))
+	}
+	fmt.Fprintf(w, "  %-28s %s\n", "total", formatUsage(total, cost))
+}
+
+func formatUsage(u Usage, cost float64) string {
+	s := fmt.Sprintf("%d in, %d out", u.InputTokens, u.OutputTokens)
+	if u.CacheWriteTokens > 0 || u.CacheReadTokens > 0 {
+		s += fmt.Sprintf(", %d cache write, %d cache read", u.CacheWriteTokens, u.CacheReadTokens)
+	}
+	return s + fmt.Sprintf(", $%.4f", cost)
+}
+
+// usageSink is embedded by provider clients to report into a tracker.
+type usageSink struct {
+	usage *UsageTracker
+}
+
+// TrackUsage sends the client's token usage to t.
+func (s *usageSink) TrackUsage(t *UsageTracker) {
+	s.usage = t
+}
+
+// UsageReporter is implemented by clients that report token usage.
+type UsageReporter interface {
+	TrackUsage(t *UsageTracker)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// variationDirections steer parallel drafts of the same request apart.
+// Drafts beyond the last direction start over from the first.
+var variationDirections = []string{
+	"bold and minimal: few confident lines and a strong silhouette",
+	"dense and detailed: rich texture and shading throughout",
+	"loose and gestural: energetic draw and scribble lines",
+	"precise and geometric: clean trace lines and careful construction",
+	"atmospheric: light, shadow and depth over outline",
+	"stylized: simplified shapes and exaggerated proportions",
+}
+
+// VariationPrompt asks for draft i (from 0) of n of description, each in
+// a different approach.
+func VariationPrompt(description string, i, n int) string {
+	return fmt.Sprintf(`%s
+
+VARIATION %d of %d: this is one of several drafts of the same request.
+Take this approach: %s.`, description, i+1, n, variationDirections[i%len(variationDirections)])
+}
+
+// Variations generates n drafts of description at once, each with its own
+// VariationPrompt. A draft that fails is nil, with its error at the same
+// index in errs.
+func Variations(ctx context.Context, n int, description string, generate func(ctx context.Context, prompt string) (*SketchResult, error)) (drafts []*SketchResult, errs []error) {
+	drafts, errs = make([]*SketchResult, n), make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			drafts[i], errs[i] = generate(ctx, VariationPrompt(description, i, n))
+		}()
+	}
+	wg.Wait()
+	return drafts, errs
+}
+
+// PickVariation shows the model renderings of several drafts of
+// description and returns the index of the one it judges best.
+func PickVariation(ctx context.Context, client LLMClient, system, description string, renderings []Image, log *Logger) (int, error) {
+	prompt := fmt.Sprintf(`The %d images are renderings of candidate sketches for the request
+below, numbered 1 to %d in order.
+
+REQUEST: %s
+
+Judge them on how well they match the request, how recognizable the
+subject is, composition and line quality. Reply with only the number of the
+best one in a <best> tag, e.g. <best>2</best>.`, len(renderings), len(renderings), description)
+
+	messages := []Message{{Role: "user", Content: prompt, Images: renderings}}
+	content, err := complete(ctx, client, system, messages, judgeOptions)
+	if err != nil {
+		return 0, err
+	}
+	choice, err := findTag(content, "best")
+	if err != nil {
+		return 0, fmt.Errorf("pick: %w", err)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(choice))
+	if err != nil || n < 1 || n > len(renderings) {
+		return 0, fmt.Errorf("pick: no variation %q", choice)
+	}
+	log.Info("picked variation %d of %d", n, len(renderings))
+	return n - 1, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CompareSVG checks that two renders have the same paths in the same order,
+// each within tol of its counterpart in both directions. It returns a
+// description of the first difference, or "" when they match.
+func CompareSVG(golden, got string, tol float64) (string, error) {
+	want, err := ParseSVGPaths(golden)
+	if err != nil {
+		return "", fmt.Errorf("golden: %w", err)
+	}
+	have, err := ParseSVGPaths(got)
+	if err != nil {
+		return "", fmt.Errorf("recompiled: %w", err)
+	}
+
+	if len(want) != len(have) {
+		return fmt.Sprintf("%d paths, golden has %d", len(have), len(want)), nil
+	}
+	for i := range want {
+		d := math.Max(polylineDistance(want[i], have[i]), polylineDistance(have[i], want[i]))
+		if d > tol {
+			return fmt.Sprintf("path %d is %.2f off (tolerance %g)", i+1, d, tol), nil
+		}
+	}
+	return "", nil
+}
+
+// verify recompiles every <name>.sketch in dir that has a <name>.svg beside
+// it and compares the result against that SVG, put in the order
+// OrderPaths gives it first if order is set. It returns false if any
+// sketch fails to compile or no longer matches.
+func verify(dir string, pos, size Vec2, tol float64, order bool, log *Logger) bool {
+	sketches, err := filepath.Glob(filepath.Join(dir, "*.sketch"))
+	if err != nil || len(sketches) == 0 {
+		fmt.Printf("no .sketch files in %s\n", dir)
+		return false
+	}
+
+	passed := true
+	var jobs []CompileJob
+	var goldens []string
+	for _, path := range sketches {
+		name := strings.TrimSuffix(filepath.Base(path), ".sketch")
+		golden, err := os.ReadFile(strings.TrimSuffix(path, ".sketch") + ".svg")
+		if err != nil {
+			log.Debug("skipping %s: no golden SVG", name)
+			continue
+		}
+		code, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Printf("FAIL %s: %v\n", name, err)
+			passed = false
+			continue
+		}
+		jobs = append(jobs, CompileJob{Code: string(code), Name: name, Pos: pos, Size: size})
+		goldens = append(goldens, string(golden))
+	}
+
+	svgs, errs := CompileMany(context.Background(), jobs, log)
+	for i, job := range jobs {
+		name, svg := job.Name, svgs[i]
+		if err := errs[i]; err != nil {
+			fmt.Printf("FAIL %s: %v\n", name, strings.TrimSpace(err.Error()))
+			passed = false
+			continue
+		}
+
+		if order {
+			// SVG that OrderPaths can't read fails the comparison too.
+			if ordered, _, err := OrderPaths(svg); err == nil {
+				svg = ordered
+			}
+		}
+		diff, err := CompareSVG(goldens[i], svg, tol)
+		switch {
+		case err != nil:
+			fmt.Printf("FAIL %s: %v\n", name, err)
+			passed = false
+		case diff != "":
+			fmt.Printf("DIFF %s: %s\n", name, diff)
+			passed = false
+		default:
+			fmt.Printf("ok   %s\n", name)
+		}
+	}
+	return passed
+}